@@ -0,0 +1,240 @@
+// Package client is a typed Go client for the http api described in openapi/openapi.json. Requests
+// and responses use the types of the models package, so they stay in step with the server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"todos/models"
+	"todos/utilities"
+)
+
+const refreshCookie = "refresh-token"
+
+type Tokens struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// OnTokens is called whenever the client obtains new tokens, by logging in or by refreshing an
+	// expired access token on its own, so that callers can keep them
+	OnTokens func(Tokens)
+	mu       sync.Mutex
+	tokens   Tokens
+}
+
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+func (c *Client) SetTokens(tokens Tokens) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens = tokens
+}
+
+func (c *Client) Tokens() Tokens {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens
+}
+
+// Error is a response with an error status, carrying the message of the server's models.ErrorResponse
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsStatus reports whether err is an Error with the given status code
+func IsStatus(err error, status int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	body        []byte
+	contentType string
+	public      bool
+	// statuses above 399 that carry a regular response body instead of an error
+	accept []int
+}
+
+func jsonRequest(method string, path string, body any) (*request, error) {
+	req := &request{method: method, path: path}
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		req.body = raw
+		req.contentType = "application/json"
+	}
+	return req, nil
+}
+
+// send performs req, refreshing the tokens and retrying once when an authenticated request is
+// rejected with 401. Error statuses are returned as *Error with the body already closed.
+func (c *Client) send(ctx context.Context, req *request) (*http.Response, error) {
+	tokens := c.Tokens()
+	resp, err := c.sendOnce(ctx, req, tokens.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && !req.public && tokens.RefreshToken != "" {
+		resp.Body.Close()
+		if err = c.refreshAfter(ctx, tokens); err != nil {
+			return nil, err
+		}
+		if resp, err = c.sendOnce(ctx, req, c.Tokens().AccessToken); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode >= http.StatusBadRequest && !slices.Contains(req.accept, resp.StatusCode) {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+	return resp, nil
+}
+
+func (c *Client) sendOnce(ctx context.Context, req *request, accessToken string) (*http.Response, error) {
+	target := c.BaseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range req.header {
+		httpReq.Header[key] = values
+	}
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	if !req.public && accessToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return c.HTTPClient.Do(httpReq)
+}
+
+func readError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	errorResponse := new(models.ErrorResponse)
+	if json.Unmarshal(raw, errorResponse) != nil || errorResponse.Message == "" {
+		errorResponse.Message = strings.TrimSpace(string(raw))
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: errorResponse.Message}
+}
+
+// call sends req and decodes a JSON response into out, which may be nil
+func (c *Client) call(ctx context.Context, req *request, out any) (*http.Response, error) {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("error while decoding response: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+// Login exchanges a username and password for tokens, which the client keeps using from then on
+func (c *Client) Login(ctx context.Context, username string, password string) (Tokens, error) {
+	req, err := jsonRequest(http.MethodPost, "/users/login", &models.LoginUser{UserName: username, Password: password})
+	if err != nil {
+		return Tokens{}, err
+	}
+	req.public = true
+	return c.obtainTokens(ctx, req)
+}
+
+// Refresh trades the refresh token for a new pair of tokens. Requests do this on their own when
+// the access token has expired.
+func (c *Client) Refresh(ctx context.Context) (Tokens, error) {
+	return c.refresh(ctx, c.Tokens().RefreshToken)
+}
+
+// refreshAfter refreshes the tokens a request was rejected with, unless a concurrent request
+// already replaced them
+func (c *Client) refreshAfter(ctx context.Context, rejected Tokens) error {
+	c.mu.Lock()
+	current := c.tokens
+	c.mu.Unlock()
+	if current.AccessToken != rejected.AccessToken {
+		return nil
+	}
+	_, err := c.refresh(ctx, rejected.RefreshToken)
+	return err
+}
+
+func (c *Client) refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	if refreshToken == "" {
+		return Tokens{}, errors.New("no refresh token, log in again")
+	}
+	req := &request{method: http.MethodPost, path: "/users/refresh", public: true, header: http.Header{}}
+	req.header.Set("Cookie", (&http.Cookie{Name: refreshCookie, Value: refreshToken}).String())
+	return c.obtainTokens(ctx, req)
+}
+
+func (c *Client) obtainTokens(ctx context.Context, req *request) (Tokens, error) {
+	response := map[string]string{}
+	resp, err := c.call(ctx, req, &response)
+	if err != nil {
+		return Tokens{}, err
+	}
+	tokens := Tokens{AccessToken: response["token"]}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == refreshCookie {
+			tokens.RefreshToken = cookie.Value
+		}
+	}
+	c.SetTokens(tokens)
+	if c.OnTokens != nil {
+		c.OnTokens(tokens)
+	}
+	return tokens, nil
+}
+
+func ifMatch(req *request, id string, version int) {
+	if version > 0 {
+		if req.header == nil {
+			req.header = http.Header{}
+		}
+		req.header.Set("If-Match", utilities.VersionETag(id, version))
+	}
+}
+
+func pathId(prefix string, ids ...string) string {
+	path := prefix
+	for _, id := range ids {
+		path += "/" + url.PathEscape(id)
+	}
+	return path
+}
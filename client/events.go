@@ -0,0 +1,67 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"todos/events"
+)
+
+// StreamEvents follows the server-sent /events stream and calls handle for every event, until ctx
+// is done or the connection drops. lastEventId resumes after an event seen earlier; the id of the
+// last event received is returned for the next call. A "reset" event means events were missed and
+// anything derived from them has to be reloaded.
+func (c *Client) StreamEvents(ctx context.Context, lastEventId string, handle func(*events.Event)) (string, error) {
+	req := &request{method: http.MethodGet, path: "/events", header: http.Header{"Accept": {"text/event-stream"}}}
+	if lastEventId != "" {
+		req.header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return lastEventId, err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	var id, eventType string
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if data.Len() > 0 {
+				event := new(events.Event)
+				if json.Unmarshal([]byte(data.String()), event) == nil {
+					if event.Type == "" {
+						event.Type = eventType
+					}
+					if id != "" {
+						lastEventId = id
+					}
+					handle(event)
+				}
+			}
+			id, eventType = "", ""
+			data.Reset()
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			eventType = value
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		}
+	}
+	if ctx.Err() != nil {
+		return lastEventId, ctx.Err()
+	}
+	return lastEventId, scanner.Err()
+}
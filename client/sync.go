@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"todos/models"
+)
+
+// GetSyncChanges returns the changes since a previous response's token, or all todos for an empty
+// since. Keep calling with the returned token while HasMore is set.
+func (c *Client) GetSyncChanges(ctx context.Context, since string, limit int) (*models.SyncResponse, error) {
+	values := url.Values{}
+	if since != "" {
+		values.Set("since", since)
+	}
+	if limit > 0 {
+		values.Set("limit", strconv.Itoa(limit))
+	}
+	changes := new(models.SyncResponse)
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: "/sync", query: values}, changes)
+	return changes, err
+}
+
+func (c *Client) PushSyncChanges(ctx context.Context, push *models.SyncPushRequest) (*models.SyncPushResponse, error) {
+	req, err := jsonRequest(http.MethodPost, "/sync", push)
+	if err != nil {
+		return nil, err
+	}
+	results := new(models.SyncPushResponse)
+	_, err = c.call(ctx, req, results)
+	return results, err
+}
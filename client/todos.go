@@ -0,0 +1,243 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"todos/jsonpatch"
+	"todos/models"
+	"todos/pagination"
+)
+
+type ListOptions struct {
+	// Status and Label narrow the list down; Status takes the names models.ParseStatus accepts
+	Status string
+	Label  string
+	Limit  int
+	// Cursor is the NextCursor or PrevCursor of a previous page
+	Cursor       string
+	IncludeTotal bool
+}
+
+func (o *ListOptions) values() url.Values {
+	values := url.Values{}
+	if o == nil {
+		return values
+	}
+	if o.Status != "" {
+		values.Set("status", o.Status)
+	}
+	if o.Label != "" {
+		values.Set("label", o.Label)
+	}
+	if o.Limit > 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Cursor != "" {
+		values.Set("cursor", o.Cursor)
+	}
+	if o.IncludeTotal {
+		values.Set("include_total", "true")
+	}
+	return values
+}
+
+type SearchOptions struct {
+	Query string
+	// Mode is fulltext, the default, or fuzzy
+	Mode string
+	// NoPrefix matches the last word of Query only as a whole word
+	NoPrefix bool
+	// Scope limits the search to some of models.SearchFields; comments cannot be searched
+	Scope  []string
+	Limit  int
+	Cursor string
+}
+
+func (c *Client) ListTodos(ctx context.Context, options *ListOptions) (*pagination.Page[*models.GetTodoResponse], error) {
+	page := new(pagination.Page[*models.GetTodoResponse])
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: "/todos/", query: options.values(), header: envelopeHeader()}, page)
+	return page, err
+}
+
+func (c *Client) SearchTodos(ctx context.Context, options SearchOptions) (*pagination.Page[*models.SearchResult], error) {
+	values := (&ListOptions{Limit: options.Limit, Cursor: options.Cursor}).values()
+	values.Set("query", options.Query)
+	if options.Mode != "" {
+		values.Set("mode", options.Mode)
+	}
+	if options.NoPrefix {
+		values.Set("prefix", "false")
+	}
+	if len(options.Scope) > 0 {
+		values.Set("scope", strings.Join(options.Scope, ","))
+	}
+	page := new(pagination.Page[*models.SearchResult])
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: "/todos/search", query: values, header: envelopeHeader()}, page)
+	return page, err
+}
+
+// envelopeHeader asks for a page envelope even when no paging options are set
+func envelopeHeader() http.Header {
+	return http.Header{"Accept": {pagination.EnvelopeMediaType}}
+}
+
+func (c *Client) GetTodo(ctx context.Context, id string) (*models.GetTodoResponse, error) {
+	todo := new(models.GetTodoResponse)
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: pathId("/todos", id)}, todo)
+	return todo, err
+}
+
+func (c *Client) CreateTodo(ctx context.Context, todo *models.Todo) (*models.CreateResponse, error) {
+	req, err := jsonRequest(http.MethodPost, "/todos/", todo)
+	if err != nil {
+		return nil, err
+	}
+	created := new(models.CreateResponse)
+	_, err = c.call(ctx, req, created)
+	return created, err
+}
+
+func (c *Client) QuickAddTodo(ctx context.Context, quickAdd *models.QuickAddRequest) (*models.QuickAddResponse, error) {
+	req, err := jsonRequest(http.MethodPost, "/todos/quick", quickAdd)
+	if err != nil {
+		return nil, err
+	}
+	created := new(models.QuickAddResponse)
+	_, err = c.call(ctx, req, created)
+	return created, err
+}
+
+// PutTodo creates or replaces the todo at a client generated id and reports whether it was created.
+// A version above zero only replaces the todo if it still has that version.
+func (c *Client) PutTodo(ctx context.Context, id string, todo *models.Todo, version int) (*models.GetTodoResponse, bool, error) {
+	req, err := jsonRequest(http.MethodPut, pathId("/todos", id), todo)
+	if err != nil {
+		return nil, false, err
+	}
+	ifMatch(req, id, version)
+	stored := new(models.GetTodoResponse)
+	resp, err := c.call(ctx, req, stored)
+	if err != nil {
+		return nil, false, err
+	}
+	return stored, resp.StatusCode == http.StatusCreated, nil
+}
+
+// UpdateTodo applies a JSON merge patch of models.TodoDocument fields; a nil value clears dueAt or
+// parentId. A version above zero only updates the todo if it still has that version.
+func (c *Client) UpdateTodo(ctx context.Context, id string, patch map[string]any, version int) (*models.GetTodoResponse, error) {
+	req, err := jsonRequest(http.MethodPatch, pathId("/todos", id), patch)
+	if err != nil {
+		return nil, err
+	}
+	req.contentType = jsonpatch.MergePatchContentType
+	ifMatch(req, id, version)
+	updated := new(models.GetTodoResponse)
+	_, err = c.call(ctx, req, updated)
+	return updated, err
+}
+
+// PatchTodo applies RFC 6902 JSON Patch operations to the todo
+func (c *Client) PatchTodo(ctx context.Context, id string, operations []jsonpatch.Operation, version int) (*models.GetTodoResponse, error) {
+	req, err := jsonRequest(http.MethodPatch, pathId("/todos", id), operations)
+	if err != nil {
+		return nil, err
+	}
+	req.contentType = jsonpatch.JSONPatchContentType
+	ifMatch(req, id, version)
+	updated := new(models.GetTodoResponse)
+	_, err = c.call(ctx, req, updated)
+	return updated, err
+}
+
+func (c *Client) DeleteTodo(ctx context.Context, id string, version int) error {
+	req := &request{method: http.MethodDelete, path: pathId("/todos", id)}
+	ifMatch(req, id, version)
+	_, err := c.call(ctx, req, nil)
+	return err
+}
+
+type ExportOptions struct {
+	// Format is one of the formats package's export formats, json by default
+	Format string
+	Status string
+	// Root limits the export to one todo and its subtasks
+	Root string
+}
+
+// ExportTodos streams the user's todos in the requested format; the caller closes the reader
+func (c *Client) ExportTodos(ctx context.Context, options ExportOptions) (io.ReadCloser, error) {
+	values := url.Values{}
+	for key, value := range map[string]string{"format": options.Format, "status": options.Status, "root": options.Root} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	resp, err := c.send(ctx, &request{method: http.MethodGet, path: "/todos/export", query: values})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ImportTodos uploads a file of todos. The format is detected from fileName when empty. When some
+// rows are invalid nothing is imported, and the report listing them is returned with the error.
+func (c *Client) ImportTodos(ctx context.Context, format string, fileName string, file io.Reader, dryRun bool) (*models.ImportReport, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(part, file); err != nil {
+		return nil, err
+	}
+	if err = form.Close(); err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	if format != "" {
+		values.Set("format", format)
+	}
+	if dryRun {
+		values.Set("dry_run", "true")
+	}
+	req := &request{
+		method:      http.MethodPost,
+		path:        "/todos/import",
+		query:       values,
+		body:        body.Bytes(),
+		contentType: form.FormDataContentType(),
+		accept:      []int{http.StatusUnprocessableEntity},
+	}
+	report := new(models.ImportReport)
+	resp, err := c.call(ctx, req, report)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return report, &Error{StatusCode: resp.StatusCode, Message: strconv.Itoa(len(report.Errors)) + " rows are invalid, nothing was imported"}
+	}
+	return report, nil
+}
+
+func (c *Client) ListAttachments(ctx context.Context, todoId string) ([]*models.Attachment, error) {
+	var attachments []*models.Attachment
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: pathId("/todos", todoId, "attachments")}, &attachments)
+	return attachments, err
+}
+
+// DownloadAttachment returns the attachment's content; the caller closes the reader
+func (c *Client) DownloadAttachment(ctx context.Context, todoId string, attachmentId string) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, &request{method: http.MethodGet, path: pathId("/todos", todoId, "attachments", attachmentId)})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"todos/models"
+)
+
+func (c *Client) Signup(ctx context.Context, signup *models.SignupRequest) (*models.CreateResponse, error) {
+	req, err := jsonRequest(http.MethodPost, "/users/signup", signup)
+	if err != nil {
+		return nil, err
+	}
+	req.public = true
+	created := new(models.CreateResponse)
+	_, err = c.call(ctx, req, created)
+	return created, err
+}
+
+func (c *Client) ForgotPassword(ctx context.Context, email string) error {
+	req, err := jsonRequest(http.MethodPost, "/users/forgot-password", &models.ForgotPasswordRequest{Email: email})
+	if err != nil {
+		return err
+	}
+	req.public = true
+	_, err = c.call(ctx, req, nil)
+	return err
+}
+
+// UpdatePassword sets a new password with the token from a password reset link
+func (c *Client) UpdatePassword(ctx context.Context, token string, newPassword string) error {
+	req, err := jsonRequest(http.MethodPatch, "/users/update-password", &models.UpdatePasswordRequest{NewPassword: newPassword})
+	if err != nil {
+		return err
+	}
+	req.public = true
+	req.query = url.Values{"token": {token}}
+	_, err = c.call(ctx, req, nil)
+	return err
+}
+
+func (c *Client) GetSettings(ctx context.Context) (*models.UserSettings, error) {
+	settings := new(models.UserSettings)
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: "/users/settings"}, settings)
+	return settings, err
+}
+
+func (c *Client) UpdateSettings(ctx context.Context, settings *models.UserSettings) (*models.UserSettings, error) {
+	req, err := jsonRequest(http.MethodPatch, "/users/settings", settings)
+	if err != nil {
+		return nil, err
+	}
+	updated := new(models.UserSettings)
+	_, err = c.call(ctx, req, updated)
+	return updated, err
+}
+
+// RotateIngestKey replaces the user's ingest token; the new token is only ever returned here
+func (c *Client) RotateIngestKey(ctx context.Context) (*models.IngestKeyResponse, error) {
+	key := new(models.IngestKeyResponse)
+	_, err := c.call(ctx, &request{method: http.MethodPost, path: "/users/ingest-key"}, key)
+	return key, err
+}
+
+func (c *Client) RevokeIngestKey(ctx context.Context) error {
+	_, err := c.call(ctx, &request{method: http.MethodDelete, path: "/users/ingest-key"}, nil)
+	return err
+}
+
+// Ingest creates a todo for the user the ingest token belongs to, without logging in
+func (c *Client) Ingest(ctx context.Context, token string, ingest *models.IngestRequest) (*models.CreateResponse, error) {
+	req, err := jsonRequest(http.MethodPost, "/ingest", ingest)
+	if err != nil {
+		return nil, err
+	}
+	req.public = true
+	req.header = http.Header{"X-Ingest-Token": {token}}
+	created := new(models.CreateResponse)
+	_, err = c.call(ctx, req, created)
+	return created, err
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"todos/events"
+	"todos/models"
+)
+
+func (c *Client) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	var hooks []*models.Webhook
+	_, err := c.call(ctx, &request{method: http.MethodGet, path: "/webhooks/"}, &hooks)
+	return hooks, err
+}
+
+// CreateWebhook registers a receiver; the returned webhook carries the signing secret, which is not shown again
+func (c *Client) CreateWebhook(ctx context.Context, webhook *models.WebhookRequest) (*models.Webhook, error) {
+	req, err := jsonRequest(http.MethodPost, "/webhooks/", webhook)
+	if err != nil {
+		return nil, err
+	}
+	created := new(models.Webhook)
+	_, err = c.call(ctx, req, created)
+	return created, err
+}
+
+func (c *Client) DeleteWebhook(ctx context.Context, id string) error {
+	_, err := c.call(ctx, &request{method: http.MethodDelete, path: pathId("/webhooks", id)}, nil)
+	return err
+}
+
+func (c *Client) EnableWebhook(ctx context.Context, id string) (*models.Webhook, error) {
+	return c.setWebhookActive(ctx, id, "enable")
+}
+
+func (c *Client) DisableWebhook(ctx context.Context, id string) (*models.Webhook, error) {
+	return c.setWebhookActive(ctx, id, "disable")
+}
+
+func (c *Client) setWebhookActive(ctx context.Context, id string, action string) (*models.Webhook, error) {
+	hook := new(models.Webhook)
+	_, err := c.call(ctx, &request{method: http.MethodPost, path: pathId("/webhooks", id, action)}, hook)
+	return hook, err
+}
+
+func (c *Client) PingWebhook(ctx context.Context, id string) (*events.Event, error) {
+	event := new(events.Event)
+	_, err := c.call(ctx, &request{method: http.MethodPost, path: pathId("/webhooks", id, "ping")}, event)
+	return event, err
+}
+
+func (c *Client) ListWebhookDeliveries(ctx context.Context, id string, limit int) ([]*models.WebhookDelivery, error) {
+	req := &request{method: http.MethodGet, path: pathId("/webhooks", id, "deliveries")}
+	if limit > 0 {
+		req.query = url.Values{"limit": {strconv.Itoa(limit)}}
+	}
+	var deliveries []*models.WebhookDelivery
+	_, err := c.call(ctx, req, &deliveries)
+	return deliveries, err
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"todos/client"
+	"todos/models"
+)
+
+func runLogin(app *app, args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	username := flags.String("u", app.config.Username, "username")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from standard input")
+	if _, err := parseFlags(flags, "login", args); err != nil {
+		return err
+	}
+	stdin := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		fmt.Fprint(os.Stderr, "Username: ")
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		*username = strings.TrimSpace(line)
+	}
+	password, err := readPassword(stdin, *passwordStdin)
+	if err != nil {
+		return err
+	}
+	if _, err = app.client.Login(app.ctx, *username, password); err != nil {
+		return err
+	}
+	app.config.Username = *username
+	if err = app.config.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "logged in to %s as %s\n", app.config.Server, *username)
+	return nil
+}
+
+func readPassword(stdin *bufio.Reader, fromStdin bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !fromStdin && isTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		restore, err := noEcho(fd)
+		if err != nil {
+			return "", err
+		}
+		defer fmt.Fprintln(os.Stderr)
+		defer restore()
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password given")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runLogout(app *app, args []string) error {
+	app.config.AccessToken, app.config.RefreshToken = "", ""
+	return app.config.save()
+}
+
+// labelsFlag is a comma separated list of labels
+type labelsFlag []string
+
+func (l *labelsFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *labelsFlag) Set(value string) error {
+	*l = nil
+	for _, label := range strings.Split(value, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			*l = append(*l, label)
+		}
+	}
+	return nil
+}
+
+var dueLayouts = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+
+// parseDue reads an absolute time, taken in local time unless it carries an offset
+func parseDue(value string) (time.Time, error) {
+	for _, layout := range dueLayouts {
+		if due, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return due, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot read due time %q, use 2006-01-02, 2006-01-02 15:04 or RFC 3339", value)
+}
+
+func runAdd(app *app, args []string) error {
+	flags := flag.NewFlagSet("add", flag.ContinueOnError)
+	plain := flags.Bool("plain", false, "take the text literally as the name")
+	description := flags.String("d", "", "description")
+	priority := flags.String("p", "", "priority: none, low, medium or high")
+	due := flags.String("due", "", "due time")
+	var labels labelsFlag
+	flags.Var(&labels, "l", "comma separated labels")
+	words, err := parseFlags(flags, "add", args)
+	if err != nil {
+		return err
+	}
+	text := strings.Join(words, " ")
+	if strings.TrimSpace(text) == "" {
+		return errors.New("nothing to add, pass the todo's text")
+	}
+	// the server reads dates, #labels and !priority out of the text unless it is told otherwise
+	explicit := *plain
+	flags.Visit(func(*flag.Flag) { explicit = true })
+	if !explicit {
+		quick, err := app.client.QuickAddTodo(app.ctx, &models.QuickAddRequest{Text: text, Timezone: localZone()})
+		if err != nil {
+			return err
+		}
+		return app.printCreated(quick.Id, quick)
+	}
+	todo := &models.Todo{Name: text, Description: *description, Labels: labels}
+	if todo.Priority, err = models.ParsePriority(*priority); err != nil {
+		return err
+	}
+	if *due != "" {
+		dueAt, err := parseDue(*due)
+		if err != nil {
+			return err
+		}
+		todo.DueAt = &dueAt
+	}
+	created, err := app.client.CreateTodo(app.ctx, todo)
+	if err != nil {
+		return err
+	}
+	return app.printCreated(created.Id, created)
+}
+
+// localZone is the IANA name of the local time zone if it is known, so quick add reads "tomorrow 9am" the way the user means it
+func localZone() string {
+	if zone := os.Getenv("TZ"); zone != "" {
+		return zone
+	}
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		if i := strings.Index(target, "zoneinfo/"); i >= 0 {
+			return target[i+len("zoneinfo/"):]
+		}
+	}
+	return ""
+}
+
+func runList(app *app, args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ContinueOnError)
+	status := flags.String("status", "", "only todos with this status: pending, in_progress or completed")
+	label := flags.String("label", "", "only todos with this label")
+	limit := flags.Int("n", 20, "number of todos to show")
+	all := flags.Bool("all", false, "show every todo")
+	if _, err := parseFlags(flags, "ls", args); err != nil {
+		return err
+	}
+	if *status != "" {
+		if _, err := models.ParseStatus(*status); err != nil {
+			return err
+		}
+	}
+	options := &client.ListOptions{Status: *status, Label: *label, Limit: min(*limit, 100)}
+	todos := []*models.GetTodoResponse{}
+	for *all || len(todos) < *limit {
+		page, err := app.client.ListTodos(app.ctx, options)
+		if err != nil {
+			return err
+		}
+		todos = append(todos, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+	if !*all && len(todos) > *limit {
+		todos = todos[:*limit]
+	}
+	return app.printTodos(todos)
+}
+
+func runShow(app *app, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: todo show id")
+	}
+	id, err := app.resolveId(args[0])
+	if err != nil {
+		return err
+	}
+	todo, err := app.client.GetTodo(app.ctx, id)
+	if err != nil {
+		return err
+	}
+	return app.printTodo(todo)
+}
+
+func runDone(app *app, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: todo done id...")
+	}
+	for _, arg := range args {
+		id, err := app.resolveId(arg)
+		if err != nil {
+			return err
+		}
+		todo, err := app.client.UpdateTodo(app.ctx, id, map[string]any{"status": models.Completed}, 0)
+		if err != nil {
+			return err
+		}
+		if app.output == "json" {
+			if err = app.printJSON(todo); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintf(app.stdout, "completed %s %s\n", shortId(todo.Id), todo.Name)
+	}
+	return nil
+}
+
+func runEdit(app *app, args []string) error {
+	flags := flag.NewFlagSet("edit", flag.ContinueOnError)
+	name := flags.String("name", "", "new name")
+	description := flags.String("d", "", "new description")
+	status := flags.String("status", "", "new status: pending, in_progress or completed")
+	priority := flags.String("p", "", "new priority: none, low, medium or high")
+	due := flags.String("due", "", "new due time, none removes it")
+	recurrence := flags.String("recurrence", "", "new recurrence rule, none removes it")
+	parent := flags.String("parent", "", "id of the new parent todo, none makes it a top level todo")
+	var labels labelsFlag
+	flags.Var(&labels, "l", "comma separated labels replacing the current ones")
+	positional, err := parseFlags(flags, "edit", args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: todo edit [flags] id")
+	}
+	patch := map[string]any{}
+	var failed error
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			patch["name"] = *name
+		case "d":
+			patch["description"] = *description
+		case "status":
+			value, err := models.ParseStatus(*status)
+			failed = errors.Join(failed, err)
+			patch["status"] = value
+		case "p":
+			value, err := models.ParsePriority(*priority)
+			failed = errors.Join(failed, err)
+			patch["priority"] = value
+		case "l":
+			patch["labels"] = append([]string{}, labels...)
+		case "due":
+			patch["dueAt"] = nil
+			if *due != "none" {
+				value, err := parseDue(*due)
+				failed = errors.Join(failed, err)
+				patch["dueAt"] = value
+			}
+		case "recurrence":
+			patch["recurrence"] = ""
+			if *recurrence != "none" {
+				patch["recurrence"] = *recurrence
+			}
+		case "parent":
+			patch["parentId"] = nil
+			if *parent != "none" {
+				value, err := app.resolveId(*parent)
+				failed = errors.Join(failed, err)
+				patch["parentId"] = value
+			}
+		}
+	})
+	if failed != nil {
+		return failed
+	}
+	if len(patch) == 0 {
+		return errors.New("nothing to change, pass at least one flag")
+	}
+	id, err := app.resolveId(positional[0])
+	if err != nil {
+		return err
+	}
+	todo, err := app.client.UpdateTodo(app.ctx, id, patch, 0)
+	if err != nil {
+		return err
+	}
+	return app.printTodo(todo)
+}
+
+func runRemove(app *app, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: todo rm id...")
+	}
+	for _, arg := range args {
+		id, err := app.resolveId(arg)
+		if err != nil {
+			return err
+		}
+		if err = app.client.DeleteTodo(app.ctx, id, 0); err != nil {
+			return err
+		}
+		if app.output == "table" {
+			fmt.Fprintf(app.stdout, "deleted %s\n", shortId(id))
+		}
+	}
+	return nil
+}
+
+func runSearch(app *app, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	fuzzy := flags.Bool("fuzzy", false, "tolerate typos")
+	scope := flags.String("scope", "", "comma separated fields to search: name, description, labels")
+	limit := flags.Int("n", 20, "number of results to show")
+	words, err := parseFlags(flags, "search", args)
+	if err != nil {
+		return err
+	}
+	options := client.SearchOptions{Query: strings.Join(words, " "), Limit: min(*limit, 100)}
+	if strings.TrimSpace(options.Query) == "" {
+		return errors.New("usage: todo search [flags] query...")
+	}
+	if *fuzzy {
+		options.Mode = "fuzzy"
+	}
+	if *scope != "" {
+		options.Scope = strings.Split(*scope, ",")
+	}
+	page, err := app.client.SearchTodos(app.ctx, options)
+	if err != nil {
+		return err
+	}
+	results := page.Items
+	if len(results) > *limit {
+		results = results[:*limit]
+	}
+	if app.output == "json" {
+		return app.printJSON(results)
+	}
+	todos := make([]*models.GetTodoResponse, 0, len(results))
+	for _, result := range results {
+		todos = append(todos, &result.GetTodoResponse)
+	}
+	return app.printTodos(todos)
+}
+
+// resolveId accepts a full todo id or a prefix of one that matches a single todo
+func (app *app) resolveId(prefix string) (string, error) {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if len(prefix) == 36 {
+		return prefix, nil
+	}
+	if len(prefix) < 4 {
+		return "", fmt.Errorf("id %q is too short, give at least 4 characters", prefix)
+	}
+	var matches []string
+	options := &client.ListOptions{Limit: 100}
+	for {
+		page, err := app.client.ListTodos(app.ctx, options)
+		if err != nil {
+			return "", err
+		}
+		for _, todo := range page.Items {
+			if strings.HasPrefix(todo.Id, prefix) {
+				matches = append(matches, todo.Id)
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("there is no todo with an id starting with %s", prefix)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("id %s is ambiguous, it matches %s", prefix, strings.Join(matches, ", "))
+}
@@ -0,0 +1,166 @@
+// Command todo manages todos from the terminal through the http api.
+//
+//	todo login -u alice
+//	todo add Pay rent tomorrow 9am #home !high
+//	todo ls -status pending
+//	todo done 3f2a
+//	todo tui
+//
+// Todos can be named by any unique prefix of their id. Run todo help for every command.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"todos/client"
+)
+
+const defaultServer = "http://localhost:8080"
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(app *app, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"login", "login [-u username] [-password-stdin]", "log in and remember the tokens", runLogin},
+		{"logout", "logout", "forget the stored tokens", runLogout},
+		{"add", "add [-plain] [-d description] [-p priority] [-l labels] [-due time] text...", "create a todo, reading dates, #labels and !priority from the text unless -plain", runAdd},
+		{"ls", "ls [-status status] [-label label] [-n limit] [-all]", "list todos, newest first", runList},
+		{"show", "show id", "show one todo", runShow},
+		{"done", "done id...", "mark todos completed", runDone},
+		{"edit", "edit [-name name] [-d description] [-status status] [-p priority] [-l labels] [-due time|none] [-recurrence rule] [-parent id|none] id", "change a todo", runEdit},
+		{"rm", "rm id...", "delete todos and their subtasks", runRemove},
+		{"search", "search [-fuzzy] [-scope fields] [-n limit] query...", "search todos", runSearch},
+		{"tui", "tui", "browse and change todos in a full screen terminal view", runTUI},
+	}
+}
+
+type app struct {
+	ctx    context.Context
+	config *Config
+	client *client.Client
+	output string
+	stdout io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := run(ctx, os.Args[1:])
+	var badUsage usageError
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return
+	case errors.As(err, &badUsage):
+		// the flag package has already explained the problem
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "todo: %s\n", err.Error())
+		if client.IsStatus(err, http.StatusUnauthorized) {
+			fmt.Fprintln(os.Stderr, "todo: run todo login to sign in again")
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("todo", flag.ContinueOnError)
+	flags.Usage = func() { usage(flags.Output()) }
+	server := flags.String("server", os.Getenv("TODO_SERVER"), "api base url, remembered by login")
+	output := flags.String("o", "table", "output format, table or json")
+	if err := flags.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %q, use table or json", *output)
+	}
+	if flags.NArg() == 0 || flags.Arg(0) == "help" {
+		usage(os.Stdout)
+		return nil
+	}
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		config.Server = *server
+	}
+	if config.Server == "" {
+		config.Server = defaultServer
+	}
+	app := &app{ctx: ctx, config: config, client: client.New(config.Server), output: *output, stdout: os.Stdout}
+	app.client.SetTokens(client.Tokens{AccessToken: config.AccessToken, RefreshToken: config.RefreshToken})
+	// refreshed tokens replace the stored ones at once, the old refresh token no longer works
+	app.client.OnTokens = func(tokens client.Tokens) {
+		config.AccessToken, config.RefreshToken = tokens.AccessToken, tokens.RefreshToken
+		if err := config.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "todo: cannot save tokens: %s\n", err.Error())
+		}
+	}
+	for _, cmd := range commands {
+		if cmd.name == flags.Arg(0) {
+			return cmd.run(app, flags.Args()[1:])
+		}
+	}
+	return fmt.Errorf("unknown command %q, run todo help", flags.Arg(0))
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: todo [-server url] [-o table|json] command [flags] [args]")
+	fmt.Fprintln(w)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "run todo command -h for the flags of a command")
+}
+
+type usageError struct {
+	error
+}
+
+func flagError(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return usageError{err}
+}
+
+// parseFlags parses a command's flags, which may be mixed with its arguments
+func parseFlags(flags *flag.FlagSet, cmd string, args []string) ([]string, error) {
+	flags.Usage = func() {
+		for _, c := range commands {
+			if c.name == cmd {
+				fmt.Fprintf(flags.Output(), "usage: todo %s\n", c.usage)
+			}
+		}
+		flags.PrintDefaults()
+	}
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, flagError(err)
+		}
+		rest := flags.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
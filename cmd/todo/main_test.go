@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"todos/client"
+)
+
+func TestRefreshAndRetry(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		rw.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/users/refresh":
+			refreshes++
+			if cookie, err := r.Cookie("refresh-token"); err != nil || cookie.Value != "stale-refresh" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				rw.Write([]byte(`{"message":"invalid refresh token","status":401}`))
+				return
+			}
+			http.SetCookie(rw, &http.Cookie{Name: "refresh-token", Value: "fresh-refresh", HttpOnly: true})
+			rw.Write([]byte(`{"token":"fresh-access"}`))
+		case "/todos/":
+			seen = append(seen, r.Header.Get("Authorization"))
+			if r.Header.Get("Authorization") != "Bearer fresh-access" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				rw.Write([]byte(`{"message":"token is already expired","status":401}`))
+				return
+			}
+			rw.Write([]byte(`{"items":[{"id":"3f2a0000-0000-4000-8000-000000000000","name":"pay rent","labels":[],"version":1}]}`))
+		default:
+			http.NotFound(rw, r)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("TODO_CONFIG", path)
+	stale := &Config{Server: server.URL, Username: "alice", AccessToken: "stale-access", RefreshToken: "stale-refresh", path: path}
+	if err := stale.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(context.Background(), []string{"-o", "json", "ls"}); err != nil {
+		t.Fatalf("ls with an expired access token: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if refreshes != 1 {
+		t.Errorf("refreshed %d times, want once", refreshes)
+	}
+	if len(seen) != 2 || seen[0] != "Bearer stale-access" || seen[1] != "Bearer fresh-access" {
+		t.Errorf("the list was requested with %q, want the stale token then the refreshed one", seen)
+	}
+	saved, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessToken != "fresh-access" || saved.RefreshToken != "fresh-refresh" || saved.Server != server.URL {
+		t.Errorf("saved config is %+v, want the refreshed tokens", saved)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("config file mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+}
+
+func TestRefreshRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rw.Write([]byte(`{"message":"token is already expired","status":401}`))
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("TODO_CONFIG", path)
+	stale := &Config{Server: server.URL, AccessToken: "stale-access", RefreshToken: "revoked", path: path}
+	if err := stale.save(); err != nil {
+		t.Fatal(err)
+	}
+	// the refresh failing ends the command with the 401, which main turns into a hint to log in
+	if err := run(context.Background(), []string{"ls"}); !client.IsStatus(err, http.StatusUnauthorized) {
+		t.Fatalf("ls with a revoked refresh token: %v, want the 401", err)
+	}
+	saved, _ := loadConfig()
+	if saved.RefreshToken != "revoked" {
+		t.Errorf("a failed refresh replaced the stored refresh token with %q", saved.RefreshToken)
+	}
+}
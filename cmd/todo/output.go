@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"todos/models"
+)
+
+func (app *app) printJSON(v any) error {
+	encoder := json.NewEncoder(app.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func shortId(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func formatDue(due *time.Time) string {
+	if due == nil {
+		return ""
+	}
+	return due.Local().Format("2006-01-02 15:04")
+}
+
+func (app *app) printTodos(todos []*models.GetTodoResponse) error {
+	if app.output == "json" {
+		return app.printJSON(todos)
+	}
+	table := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tSTATUS\tPRIORITY\tDUE\tLABELS\tNAME")
+	for _, todo := range todos {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", shortId(todo.Id), todo.TaskStatus, todo.Priority, formatDue(todo.DueAt), strings.Join(todo.Labels, ","), todo.Name)
+	}
+	return table.Flush()
+}
+
+func (app *app) printTodo(todo *models.GetTodoResponse) error {
+	if app.output == "json" {
+		return app.printJSON(todo)
+	}
+	table := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
+	rows := [][2]string{
+		{"id", todo.Id},
+		{"name", todo.Name},
+		{"status", todo.TaskStatus.String()},
+		{"priority", todo.Priority.String()},
+		{"due", formatDue(todo.DueAt)},
+		{"labels", strings.Join(todo.Labels, ", ")},
+		{"recurrence", todo.Recurrence},
+		{"created", todo.CreatedAt.Local().Format("2006-01-02 15:04")},
+		{"version", fmt.Sprint(todo.Version)},
+	}
+	if todo.ParentId != nil {
+		rows = append(rows[:2], append([][2]string{{"parent", *todo.ParentId}}, rows[2:]...)...)
+	}
+	for _, row := range rows {
+		if row[1] != "" {
+			fmt.Fprintf(table, "%s\t%s\n", row[0], row[1])
+		}
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	if todo.Description != "" {
+		fmt.Fprintf(app.stdout, "\n%s\n", todo.Description)
+	}
+	return nil
+}
+
+func (app *app) printCreated(id string, response any) error {
+	if app.output == "json" {
+		return app.printJSON(response)
+	}
+	_, err := fmt.Fprintf(app.stdout, "created %s\n", shortId(id))
+	return err
+}
@@ -0,0 +1,28 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var resizeSignal os.Signal
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+var errNoTerminal = errors.New("terminal control is not supported on this platform")
+
+func noEcho(fd int) (func(), error) {
+	return nil, errNoTerminal
+}
+
+func rawMode(fd int) (func(), error) {
+	return nil, errNoTerminal
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errNoTerminal
+}
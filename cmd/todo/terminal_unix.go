@@ -0,0 +1,60 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// resizeSignal is delivered when the terminal window changes size
+var resizeSignal os.Signal = unix.SIGWINCH
+
+func isTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, getTermios)
+	return err == nil
+}
+
+// noEcho stops the terminal on fd from echoing input and returns a function restoring it
+func noEcho(fd int) (func(), error) {
+	old, err := unix.IoctlGetTermios(fd, getTermios)
+	if err != nil {
+		return nil, err
+	}
+	mode := *old
+	mode.Lflag &^= unix.ECHO
+	if err = unix.IoctlSetTermios(fd, setTermios, &mode); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, setTermios, old) }, nil
+}
+
+// rawMode passes every key on fd through unbuffered, without echo, line editing or signal keys, and
+// stops output newlines being translated, until the returned function restores the previous mode
+func rawMode(fd int) (func(), error) {
+	old, err := unix.IoctlGetTermios(fd, getTermios)
+	if err != nil {
+		return nil, err
+	}
+	mode := *old
+	mode.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	mode.Oflag &^= unix.OPOST
+	mode.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	mode.Cflag &^= unix.CSIZE | unix.PARENB
+	mode.Cflag |= unix.CS8
+	mode.Cc[unix.VMIN] = 1
+	mode.Cc[unix.VTIME] = 0
+	if err = unix.IoctlSetTermios(fd, setTermios, &mode); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, setTermios, old) }, nil
+}
+
+func terminalSize(fd int) (width int, height int, err error) {
+	size, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(size.Col), int(size.Row), nil
+}
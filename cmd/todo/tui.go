@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+	"todos/client"
+	"todos/events"
+	"todos/models"
+	"unicode/utf8"
+)
+
+const (
+	// with the event stream down the list is reloaded this often instead
+	tuiPollInterval = 30 * time.Second
+	tuiRetryDelay   = 5 * time.Second
+	tuiMaxTodos     = 500
+	tuiHelp         = "j/k move  a add  x done  s start  e edit  p priority  d delete  / search  f filter  r reload  q quit"
+)
+
+var tuiFilters = []struct {
+	name   string
+	status string
+}{{"all", ""}, {"pending", "pending"}, {"in progress", "in_progress"}, {"completed", "completed"}}
+
+type tui struct {
+	app    *app
+	out    *bufio.Writer
+	width  int
+	height int
+	todos  []*models.GetTodoResponse
+	// selected is an index into todos, offset the first one on screen
+	selected int
+	offset   int
+	filter   int
+	query    string
+	message  string
+	prompt   *tuiPrompt
+	confirm  func()
+	// generation changes with the filter or search, so results of an outdated load are dropped
+	generation    int
+	loading       bool
+	reloadPending bool
+	loaded        chan tuiLoad
+	live          bool
+}
+
+type tuiLoad struct {
+	generation int
+	todos      []*models.GetTodoResponse
+	err        error
+}
+
+// tuiPrompt is the line editor at the bottom of the screen
+type tuiPrompt struct {
+	label  string
+	text   []rune
+	submit func(text string)
+	change func(text string)
+	cancel func()
+}
+
+type key struct {
+	r    rune
+	name string
+}
+
+func runTUI(app *app, args []string) error {
+	flags := flag.NewFlagSet("tui", flag.ContinueOnError)
+	if _, err := parseFlags(flags, "tui", args); err != nil {
+		return err
+	}
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !isTerminal(in) || !isTerminal(out) {
+		return errors.New("tui needs a terminal")
+	}
+	t := &tui{app: app, out: bufio.NewWriter(os.Stdout), loaded: make(chan tuiLoad, 1)}
+	// a session that cannot load anything should fail here rather than inside the full screen view
+	first := t.fetch(t.generation, "", "")
+	if first.err != nil {
+		return first.err
+	}
+	restore, err := rawMode(in)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		t.out.WriteString("\x1b[?25h\x1b[?1049l")
+		t.out.Flush()
+	}()
+
+	ctx, cancel := context.WithCancel(app.ctx)
+	defer cancel()
+	keys := make(chan key, 16)
+	go readKeys(os.Stdin, keys)
+	resize := make(chan os.Signal, 1)
+	if resizeSignal != nil {
+		signal.Notify(resize, resizeSignal)
+		defer signal.Stop(resize)
+	}
+	changes := make(chan struct{}, 1)
+	liveness := make(chan bool, 1)
+	go t.follow(ctx, changes, liveness)
+	poll := time.NewTicker(tuiPollInterval)
+	defer poll.Stop()
+
+	t.resize(out)
+	t.apply(first)
+	for {
+		t.draw()
+		select {
+		case <-ctx.Done():
+			return nil
+		case k, ok := <-keys:
+			if !ok || t.handle(k) {
+				return nil
+			}
+		case load := <-t.loaded:
+			t.apply(load)
+		case <-changes:
+			t.reload()
+		case t.live = <-liveness:
+		case <-resize:
+			t.resize(out)
+		case <-poll.C:
+			if !t.live {
+				t.reload()
+			}
+		}
+	}
+}
+
+// follow reloads the list whenever the event stream reports a change, reconnecting after failures
+func (t *tui) follow(ctx context.Context, changes chan<- struct{}, liveness chan<- bool) {
+	lastEventId := ""
+	for ctx.Err() == nil {
+		lastEventId, _ = t.app.client.StreamEvents(ctx, lastEventId, func(event *events.Event) {
+			if event.Type == events.PresenceChanged {
+				return
+			}
+			select {
+			case liveness <- true:
+			default:
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		})
+		select {
+		case liveness <- false:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-time.After(tuiRetryDelay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (t *tui) reload() {
+	if t.loading {
+		t.reloadPending = true
+		return
+	}
+	t.loading = true
+	generation, status, query := t.generation, tuiFilters[t.filter].status, t.query
+	go func() { t.loaded <- t.fetch(generation, status, query) }()
+}
+
+func (t *tui) fetch(generation int, status string, query string) tuiLoad {
+	if query != "" {
+		page, err := t.app.client.SearchTodos(t.app.ctx, client.SearchOptions{Query: query, Limit: 100})
+		if err != nil {
+			return tuiLoad{generation: generation, err: err}
+		}
+		todos := []*models.GetTodoResponse{}
+		for _, result := range page.Items {
+			if status == "" || result.TaskStatus.String() == status {
+				todos = append(todos, &result.GetTodoResponse)
+			}
+		}
+		return tuiLoad{generation: generation, todos: todos}
+	}
+	options := &client.ListOptions{Status: status, Limit: 100}
+	todos := []*models.GetTodoResponse{}
+	for len(todos) < tuiMaxTodos {
+		page, err := t.app.client.ListTodos(t.app.ctx, options)
+		if err != nil {
+			return tuiLoad{generation: generation, err: err}
+		}
+		todos = append(todos, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+	return tuiLoad{generation: generation, todos: todos}
+}
+
+// apply shows loaded todos, keeping the same todo selected if it is still there
+func (t *tui) apply(load tuiLoad) {
+	t.loading = false
+	switch {
+	case load.generation != t.generation:
+		t.reloadPending = true
+	case load.err != nil:
+		t.message = errorMessage(load.err)
+	default:
+		selectedId := ""
+		if todo := t.current(); todo != nil {
+			selectedId = todo.Id
+		}
+		t.todos = load.todos
+		if i := slices.IndexFunc(t.todos, func(todo *models.GetTodoResponse) bool { return todo.Id == selectedId }); i >= 0 {
+			t.selected = i
+		}
+		t.selected = max(min(t.selected, len(t.todos)-1), 0)
+	}
+	if t.reloadPending {
+		t.reloadPending = false
+		t.reload()
+	}
+}
+
+func (t *tui) current() *models.GetTodoResponse {
+	if t.selected < 0 || t.selected >= len(t.todos) {
+		return nil
+	}
+	return t.todos[t.selected]
+}
+
+func errorMessage(err error) string {
+	if client.IsStatus(err, http.StatusUnauthorized) {
+		return "the session has expired, quit and run todo login"
+	}
+	if client.IsStatus(err, http.StatusPreconditionFailed) {
+		return "the todo was changed elsewhere, reloaded it"
+	}
+	return err.Error()
+}
+
+func (t *tui) resize(fd int) {
+	width, height, err := terminalSize(fd)
+	if err != nil || width < 20 || height < 6 {
+		width, height = max(width, 80), max(height, 24)
+	}
+	t.width, t.height = width, height
+}
+
+func (t *tui) listHeight() int {
+	return max(t.height-4, 1)
+}
+
+// handle reacts to a key and reports whether the user asked to quit
+func (t *tui) handle(k key) bool {
+	if k.name == "ctrl-c" {
+		return true
+	}
+	if t.prompt != nil {
+		t.edit(k)
+		return false
+	}
+	if t.confirm != nil {
+		confirm := t.confirm
+		t.confirm = nil
+		t.message = ""
+		if k.r == 'y' || k.r == 'Y' {
+			confirm()
+		}
+		return false
+	}
+	t.message = ""
+	switch {
+	case k.r == 'q':
+		return true
+	case k.r == 'j' || k.name == "down":
+		t.move(1)
+	case k.r == 'k' || k.name == "up":
+		t.move(-1)
+	case k.name == "pgdn":
+		t.move(t.listHeight())
+	case k.name == "pgup":
+		t.move(-t.listHeight())
+	case k.r == 'g' || k.name == "home":
+		t.move(-len(t.todos))
+	case k.r == 'G' || k.name == "end":
+		t.move(len(t.todos))
+	case k.r == 'r':
+		t.reload()
+	case k.r == 'f':
+		t.filter = (t.filter + 1) % len(tuiFilters)
+		t.generation++
+		t.reload()
+	case k.r == '/':
+		t.search()
+	case k.name == "esc" && t.query != "":
+		t.query = ""
+		t.generation++
+		t.reload()
+	case k.r == 'a':
+		t.prompt = &tuiPrompt{label: "add: ", submit: t.add}
+	case k.name == "enter":
+		if todo := t.current(); todo != nil {
+			t.message = todo.Description
+			if t.message == "" {
+				t.message = "no description"
+			}
+		}
+	}
+	todo := t.current()
+	if todo == nil {
+		return false
+	}
+	switch {
+	case k.r == 'x' || k.r == ' ':
+		status := models.Completed
+		if todo.TaskStatus == models.Completed {
+			status = models.Pending
+		}
+		t.update(todo, map[string]any{"status": status})
+	case k.r == 's':
+		status := models.InProgess
+		if todo.TaskStatus == models.InProgess {
+			status = models.Pending
+		}
+		t.update(todo, map[string]any{"status": status})
+	case k.r == 'p':
+		t.update(todo, map[string]any{"priority": (todo.Priority + 1) % (models.HighPriority + 1)})
+	case k.r == 'e':
+		t.prompt = &tuiPrompt{label: "name: ", text: []rune(todo.Name), submit: func(name string) {
+			if strings.TrimSpace(name) != "" && name != todo.Name {
+				t.update(todo, map[string]any{"name": name})
+			}
+		}}
+	case k.r == 'd':
+		t.message = fmt.Sprintf("delete %q and its subtasks? y/n", todo.Name)
+		t.confirm = func() {
+			if err := t.app.client.DeleteTodo(t.app.ctx, todo.Id, todo.Version); err != nil {
+				t.message = errorMessage(err)
+			}
+			t.reload()
+		}
+	}
+	return false
+}
+
+func (t *tui) move(delta int) {
+	t.selected = max(min(t.selected+delta, len(t.todos)-1), 0)
+}
+
+// search filters the list while the query is typed; escape goes back to the previous one
+func (t *tui) search() {
+	previous := t.query
+	setQuery := func(query string) {
+		if query = strings.TrimSpace(query); query != t.query {
+			t.query = query
+			t.generation++
+			t.reload()
+		}
+	}
+	t.prompt = &tuiPrompt{label: "search: ", text: []rune(t.query), submit: setQuery, change: setQuery, cancel: func() { setQuery(previous) }}
+}
+
+func (t *tui) add(text string) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	created, err := t.app.client.QuickAddTodo(t.app.ctx, &models.QuickAddRequest{Text: text, Timezone: localZone()})
+	if err != nil {
+		t.message = errorMessage(err)
+		return
+	}
+	t.message = "created " + shortId(created.Id)
+	t.reload()
+}
+
+// update patches the todo as it was displayed, so a change made elsewhere in the meantime is not overwritten
+func (t *tui) update(todo *models.GetTodoResponse, patch map[string]any) {
+	if _, err := t.app.client.UpdateTodo(t.app.ctx, todo.Id, patch, todo.Version); err != nil {
+		t.message = errorMessage(err)
+	}
+	t.reload()
+}
+
+func (t *tui) edit(k key) {
+	p := t.prompt
+	switch k.name {
+	case "enter":
+		t.prompt = nil
+		p.submit(string(p.text))
+		return
+	case "esc":
+		t.prompt = nil
+		if p.cancel != nil {
+			p.cancel()
+		}
+		return
+	case "backspace":
+		if len(p.text) > 0 {
+			p.text = p.text[:len(p.text)-1]
+		}
+	case "ctrl-u":
+		p.text = nil
+	case "":
+		p.text = append(p.text, k.r)
+	default:
+		return
+	}
+	if p.change != nil {
+		p.change(string(p.text))
+	}
+}
+
+func (t *tui) draw() {
+	out := t.out
+	out.WriteString("\x1b[H")
+	line := func(text string, style string) {
+		text = fit(text, t.width)
+		if style != "" {
+			out.WriteString(style + text + "\x1b[0m")
+		} else {
+			out.WriteString(text)
+		}
+		out.WriteString("\x1b[K\r\n")
+	}
+
+	mode := "polling"
+	if t.live {
+		mode = "live"
+	}
+	title := fmt.Sprintf(" todos  %s  filter: %s", t.app.config.Server, tuiFilters[t.filter].name)
+	if t.app.config.Username != "" {
+		title = fmt.Sprintf(" todos  %s@%s  filter: %s", t.app.config.Username, t.app.config.Server, tuiFilters[t.filter].name)
+	}
+	if t.query != "" {
+		title += fmt.Sprintf("  search: %s", t.query)
+	}
+	title += fmt.Sprintf("  %d shown  %s", len(t.todos), mode)
+	if t.loading {
+		title += "  loading"
+	}
+	line(pad(title, t.width), "\x1b[7m")
+	line(fmt.Sprintf("  %-8s  %-11s  %-8s  %-16s  %-16s  %s", "ID", "STATUS", "PRIORITY", "DUE", "LABELS", "NAME"), "\x1b[1m")
+
+	rows := t.listHeight()
+	if t.selected < t.offset {
+		t.offset = t.selected
+	}
+	if t.selected >= t.offset+rows {
+		t.offset = t.selected - rows + 1
+	}
+	t.offset = max(min(t.offset, len(t.todos)-rows), 0)
+	for i := t.offset; i < t.offset+rows; i++ {
+		if i >= len(t.todos) {
+			if i == 0 {
+				line("  nothing here, press a to add a todo", "\x1b[2m")
+			} else {
+				line("", "")
+			}
+			continue
+		}
+		todo := t.todos[i]
+		row := fmt.Sprintf("  %-8s  %-11s  %-8s  %-16s  %-16s  %s", shortId(todo.Id), todo.TaskStatus, todo.Priority, formatDue(todo.DueAt), fit(strings.Join(todo.Labels, ","), 16), todo.Name)
+		style := ""
+		if todo.TaskStatus == models.Completed {
+			style = "\x1b[2m"
+		}
+		if i == t.selected {
+			row = pad(row, t.width)
+			style = "\x1b[7m"
+		}
+		line(row, style)
+	}
+
+	if t.prompt != nil {
+		line(t.prompt.label+string(t.prompt.text), "")
+	} else {
+		line(t.message, "\x1b[1m")
+	}
+	out.WriteString("\x1b[2m" + fit(tuiHelp, t.width) + "\x1b[0m\x1b[K")
+	if t.prompt != nil {
+		column := min(utf8.RuneCountInString(t.prompt.label)+len(t.prompt.text)+1, t.width)
+		fmt.Fprintf(out, "\x1b[%d;%dH\x1b[?25h", t.height-1, column)
+	} else {
+		out.WriteString("\x1b[?25l")
+	}
+	out.Flush()
+}
+
+// fit cuts text to width columns, counting every rune as one column
+func fit(text string, width int) string {
+	if utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	if width < 1 {
+		return ""
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func pad(text string, width int) string {
+	return text + strings.Repeat(" ", max(width-utf8.RuneCountInString(text), 0))
+}
+
+func readKeys(r io.Reader, keys chan<- key) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, k := range parseKeys(buf[:n]) {
+			keys <- k
+		}
+	}
+}
+
+var escapeKeys = map[string]string{
+	"A": "up", "B": "down", "C": "right", "D": "left", "H": "home", "F": "end",
+	"1~": "home", "7~": "home", "4~": "end", "8~": "end", "5~": "pgup", "6~": "pgdn", "3~": "delete",
+}
+
+// parseKeys splits one read from a raw terminal into keys, decoding the escape sequences of
+// cursor keys; an escape byte on its own is the escape key
+func parseKeys(input []byte) []key {
+	var keys []key
+	for len(input) > 0 {
+		b := input[0]
+		switch {
+		case b == 0x1b:
+			if len(input) > 2 && (input[1] == '[' || input[1] == 'O') {
+				end := 2
+				for end < len(input) && (input[end] < 0x40 || input[end] > 0x7e) {
+					end++
+				}
+				if end == len(input) {
+					return keys
+				}
+				if name, ok := escapeKeys[string(input[2:end+1])]; ok {
+					keys = append(keys, key{name: name})
+				}
+				input = input[end+1:]
+				continue
+			}
+			keys = append(keys, key{name: "esc"})
+		case b == '\r' || b == '\n':
+			keys = append(keys, key{name: "enter"})
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, key{name: "backspace"})
+		case b == 0x03:
+			keys = append(keys, key{name: "ctrl-c"})
+		case b == 0x15:
+			keys = append(keys, key{name: "ctrl-u"})
+		case b < 0x20:
+		default:
+			r, size := utf8.DecodeRune(input)
+			keys = append(keys, key{r: r})
+			input = input[size:]
+			continue
+		}
+		input = input[1:]
+	}
+	return keys
+}
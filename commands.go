@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"todos/config"
+	"todos/formats"
+	"todos/migrations"
+	"todos/models"
+	"todos/repository"
+	"todos/server"
+	"todos/utilities"
+	validateapp "todos/validator"
+)
+
+func runServe(admin *admin, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := noArgs(flags, "serve", args); err != nil {
+		return err
+	}
+	server.StartServer()
+	return nil
+}
+
+func runMigrate(admin *admin, args []string) error {
+	action, args, err := subcommand("migrate", args, "up", "down", "status")
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	var to, steps int
+	switch action {
+	case "up":
+		flags.IntVar(&to, "to", 0, "stop after this version instead of applying every pending migration")
+	case "down":
+		flags.IntVar(&steps, "steps", 1, "number of migrations to revert")
+	}
+	if err := noArgs(flags, "migrate", args); err != nil {
+		return err
+	}
+	if driver := config.LoadConfiguration().Store.Driver; driver != "postgres" {
+		return fmt.Errorf("the %s store creates its own schema, migrations are only for postgres", driver)
+	}
+	switch action {
+	case "up":
+		applied, err := migrations.Up(admin.ctx, admin.database(), to)
+		for _, migration := range applied {
+			fmt.Fprintf(admin.stdout, "applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(admin.stdout, "schema is up to date")
+		}
+		return err
+	case "down":
+		reverted, err := migrations.Down(admin.ctx, admin.database(), steps)
+		for _, migration := range reverted {
+			fmt.Fprintf(admin.stdout, "reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	}
+	list, err := migrations.List(admin.ctx, admin.database())
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(admin.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, status := range list {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Local().Format(time.DateTime)
+		}
+		if status.Unknown {
+			applied += " (not in this build)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	return tw.Flush()
+}
+
+func runUser(admin *admin, args []string) error {
+	action, args, err := subcommand("user", args, "create", "disable", "enable", "reset-password")
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("user "+action, flag.ContinueOnError)
+	var email string
+	var passwordStdin bool
+	if action == "create" {
+		flags.StringVar(&email, "email", "", "email address, required")
+	}
+	if action == "create" || action == "reset-password" {
+		flags.BoolVar(&passwordStdin, "password-stdin", false, "read the password from the first line of stdin instead of generating one")
+	}
+	positional, err := parseFlags(flags, "user", args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return usageError{}
+	}
+	username := positional[0]
+	switch action {
+	case "create":
+		password, generated, err := newPassword(admin, passwordStdin)
+		if err != nil {
+			return err
+		}
+		signup := &models.SignupRequest{UserName: username, Email: email, Password: password}
+		if err := validateapp.ValidateStruct(signup); err != nil {
+			return err
+		}
+		hashPassword, err := utilities.HashPassword(password)
+		if err != nil {
+			return err
+		}
+		stores, err := admin.stores()
+		if err != nil {
+			return err
+		}
+		user := &models.User{UserName: username, Email: email, HashedPassword: hashPassword}
+		if err := stores.Users.CreateUser(admin.ctx, user); err != nil {
+			return fmt.Errorf("creating user %s: %w", username, err)
+		}
+		fmt.Fprintf(admin.stdout, "created user %s\n", username)
+		if generated {
+			fmt.Fprintf(admin.stdout, "password: %s\n", password)
+		}
+		return nil
+	}
+	user, err := lookupUser(admin, username)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "disable", "enable":
+		if err := admin.opened.Users.SetUserDisabled(admin.ctx, user.Id, action == "disable"); err != nil {
+			return err
+		}
+		fmt.Fprintf(admin.stdout, "%sd user %s\n", action, username)
+		return nil
+	}
+	password, generated, err := newPassword(admin, passwordStdin)
+	if err != nil {
+		return err
+	}
+	if err := validateapp.ValidateVar(password, "min=8"); err != nil {
+		return errors.New("password must be at least 8 characters")
+	}
+	hashPassword, err := utilities.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := admin.opened.Users.SetUserPassword(admin.ctx, user.Id, hashPassword); err != nil {
+		return err
+	}
+	fmt.Fprintf(admin.stdout, "reset the password of %s and signed them out\n", username)
+	if generated {
+		fmt.Fprintf(admin.stdout, "password: %s\n", password)
+	}
+	return nil
+}
+
+func runTokens(admin *admin, args []string) error {
+	_, args, err := subcommand("tokens", args, "revoke")
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("tokens revoke", flag.ContinueOnError)
+	username := flags.String("user", "", "user whose refresh tokens are revoked, required")
+	if err := noArgs(flags, "tokens", args); err != nil {
+		return err
+	}
+	user, err := requiredUser(admin, flags, *username)
+	if err != nil {
+		return err
+	}
+	count, err := admin.opened.Tokens.RevokeRefreshTokens(admin.ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	// access tokens are not stored, they keep working until they expire
+	fmt.Fprintf(admin.stdout, "revoked %d refresh tokens of %s\n", count, user.UserName)
+	return nil
+}
+
+func runPurgeTrash(admin *admin, args []string) error {
+	flags := flag.NewFlagSet("purge-trash", flag.ContinueOnError)
+	olderThan := flags.Duration("older-than", 30*24*time.Hour, "only purge todos deleted at least this long ago; syncing clients that are offline longer miss the deletion")
+	username := flags.String("user", "", "only purge this user's todos")
+	dryRun := flags.Bool("dry-run", false, "count the todos that would be purged without removing them")
+	if err := noArgs(flags, "purge-trash", args); err != nil {
+		return err
+	}
+	stores, err := admin.stores()
+	if err != nil {
+		return err
+	}
+	userId := ""
+	if *username != "" {
+		user, err := lookupUser(admin, *username)
+		if err != nil {
+			return err
+		}
+		userId = user.Id
+	}
+	cutoff := time.Now().Add(-*olderThan)
+	if *dryRun {
+		count, err := stores.Todos.CountDeletedTodos(admin.ctx, userId, cutoff)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(admin.stdout, "would purge %d todos deleted before %s\n", count, cutoff.Format(time.RFC3339))
+		return nil
+	}
+	count, err := stores.Todos.PurgeDeletedTodos(admin.ctx, userId, cutoff)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(admin.stdout, "purged %d todos deleted before %s\n", count, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+func runExport(admin *admin, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	username := flags.String("user", "", "user whose todos are exported, required")
+	format := flags.String("format", formats.JSON, "json, ndjson, csv or markdown")
+	status := flags.String("status", "", "only export todos with this status")
+	root := flags.String("root", "", "only export this todo and its subtasks")
+	output := flags.String("o", "", "file to write instead of stdout")
+	if err := noArgs(flags, "export", args); err != nil {
+		return err
+	}
+	filter := repository.TodoFilter{RootId: *root}
+	if *status != "" {
+		parsed, err := models.ParseStatus(*status)
+		if err != nil {
+			return err
+		}
+		filter.Status = &parsed
+	}
+	if _, err := formats.NewEncoder(*format, io.Discard); err != nil {
+		return err
+	}
+	user, err := requiredUser(admin, flags, *username)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = admin.stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+	encoder, _ := formats.NewEncoder(*format, buffered)
+	if err := encoder.Begin(); err != nil {
+		return err
+	}
+	count := 0
+	err = admin.opened.Todos.StreamTodos(admin.ctx, user.Id, filter, func(todo *models.GetTodoResponse) error {
+		count++
+		return encoder.Encode(todo)
+	})
+	if err != nil {
+		return fmt.Errorf("exporting todos of %s after %d rows: %w", user.UserName, count, err)
+	}
+	if err := encoder.End(); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d todos of %s\n", count, user.UserName)
+	return nil
+}
+
+func noArgs(flags *flag.FlagSet, cmd string, args []string) error {
+	positional, err := parseFlags(flags, cmd, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		fmt.Fprintf(os.Stderr, "todos: unexpected argument %q\n", positional[0])
+		flags.Usage()
+		return usageError{}
+	}
+	return nil
+}
+
+// lookupUser opens the store if no command did yet, the commands that find a user through it rely on that
+func lookupUser(admin *admin, username string) (*models.User, error) {
+	stores, err := admin.stores()
+	if err != nil {
+		return nil, err
+	}
+	user, err := stores.Users.FetchUserWithUserID(admin.ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("user %s: %w", username, err)
+	}
+	return user, nil
+}
+
+func requiredUser(admin *admin, flags *flag.FlagSet, username string) (*models.User, error) {
+	if username == "" {
+		fmt.Fprintln(os.Stderr, "todos: -user is required")
+		flags.Usage()
+		return nil, usageError{}
+	}
+	return lookupUser(admin, username)
+}
+
+// newPassword reads a password from stdin when asked to, and otherwise generates one to hand to the user
+func newPassword(admin *admin, fromStdin bool) (string, bool, error) {
+	if !fromStdin {
+		random := make([]byte, 12)
+		if _, err := rand.Read(random); err != nil {
+			return "", false, err
+		}
+		return base64.RawURLEncoding.EncodeToString(random), true, nil
+	}
+	line, err := bufio.NewReader(admin.stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", false, err
+	}
+	return strings.TrimRight(line, "\r\n"), false, nil
+}
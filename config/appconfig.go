@@ -26,6 +26,16 @@ type DBconfig struct {
 	User     string `env:"USER"`
 	Password string `env:"PASSWORD"`
 	DBName   string `env:"NAME"`
+	// applies pending schema migrations when the server starts
+	AutoMigrate bool `env:"AUTO_MIGRATE" envDefault:"false"`
+}
+
+type StoreConfig struct {
+	// postgres, sqlite or memory. Sync, webhooks, ingest and attachments need postgres and answer 501
+	// on the other stores, which also ignore Idempotency-Key headers
+	Driver string `env:"DRIVER" envDefault:"postgres"`
+	// file the sqlite store keeps its data in
+	Path string `env:"PATH" envDefault:"todos.db"`
 }
 
 type AuthConfig struct {
@@ -37,10 +47,16 @@ type AuthConfig struct {
 type AppConfig struct {
 	AuthConfig     AuthConfig
 	FrontEndConfig FrontEndConfig
-	Mail           mail.Mail `envPrefix:"MAIL_"`
-	DBconfig       DBconfig  `envPrefix:"DB_"`
-	Host           string    `env:"APP_HOST"`
-	Port           int       `env:"APP_PORT"`
+	Mail           mail.Mail         `envPrefix:"MAIL_"`
+	DBconfig       DBconfig          `envPrefix:"DB_"`
+	Store          StoreConfig       `envPrefix:"STORE_"`
+	Idempotency    IdempotencyConfig `envPrefix:"IDEMPOTENCY_"`
+	Webhooks       WebhookConfig     `envPrefix:"WEBHOOK_"`
+	Inbound        InboundConfig     `envPrefix:"INBOUND_"`
+	GraphQL        GraphQLConfig     `envPrefix:"GRAPHQL_"`
+	GRPC           GRPCConfig        `envPrefix:"GRPC_"`
+	Host           string            `env:"APP_HOST"`
+	Port           int               `env:"APP_PORT"`
 }
 
 type FrontEndConfig struct {
@@ -48,9 +64,48 @@ type FrontEndConfig struct {
 	ResetPath      string `env:"FRONTEND_RESET_PATH"`
 }
 
+type IdempotencyConfig struct {
+	// how long a stored response is replayed for retries with the same Idempotency-Key
+	TTL time.Duration `env:"TTL" envDefault:"24h"`
+	// how often keys older than TTL are deleted
+	PurgeInterval time.Duration `env:"PURGE_INTERVAL" envDefault:"1h"`
+}
+
+type WebhookConfig struct {
+	MaxAttempts int `env:"MAX_ATTEMPTS" envDefault:"8"`
+	// consecutive failed attempts after which a webhook is switched off
+	DisableAfter int           `env:"DISABLE_AFTER" envDefault:"15"`
+	Timeout      time.Duration `env:"TIMEOUT" envDefault:"10s"`
+	PollInterval time.Duration `env:"POLL_INTERVAL" envDefault:"5s"`
+	// lets webhooks point at loopback and private networks, for local development and tests
+	AllowPrivateTargets bool `env:"ALLOW_PRIVATE_TARGETS" envDefault:"false"`
+}
+
+type InboundConfig struct {
+	// address the email-to-todo SMTP receiver listens on, it is not started when empty
+	SMTPAddr       string `env:"SMTP_ADDR"`
+	Domain         string `env:"DOMAIN"`
+	MaxMessageSize int64  `env:"MAX_MESSAGE_SIZE" envDefault:"10485760"`
+	// sessions served at once, further connections are turned away until one ends
+	MaxConnections int `env:"MAX_CONNECTIONS" envDefault:"100"`
+}
+
+type GraphQLConfig struct {
+	// queries nested deeper than MaxDepth or estimated above MaxComplexity fields are rejected unexecuted
+	MaxDepth      int `env:"MAX_DEPTH" envDefault:"8"`
+	MaxComplexity int `env:"MAX_COMPLEXITY" envDefault:"2000"`
+}
+
+type GRPCConfig struct {
+	// port the gRPC server listens on next to the http one, 0 turns it off
+	Port       int  `env:"PORT" envDefault:"9090"`
+	Reflection bool `env:"REFLECTION" envDefault:"true"`
+}
+
 func DBinit(dbconfig *DBconfig) (*sql.DB, error) {
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", dbconfig.DBHost, dbconfig.DBPort, dbconfig.User, dbconfig.Password, dbconfig.DBName)
-	fmt.Println(psqlInfo)
+	// stdout belongs to the admin commands, export writes the todos there
+	log.Printf("connecting to database %s at %s:%d", dbconfig.DBName, dbconfig.DBHost, dbconfig.DBPort)
 	db, err := sql.Open("postgres", psqlInfo)
 	if err != nil {
 		panic("failed to create database connection!!!")
@@ -0,0 +1,253 @@
+package events
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"todos/models"
+)
+
+const (
+	TodoCreated   = "todo.created"
+	TodoUpdated   = "todo.updated"
+	TodoCompleted = "todo.completed"
+	TodoDeleted   = "todo.deleted"
+	TodosImported = "todos.imported"
+	// CommentAdded is reserved for comments on todos; webhooks can subscribe to it, nothing publishes it yet
+	CommentAdded = "comment.added"
+	// presence events are transient: they carry no id and are not kept in the log
+	PresenceChanged = "presence.changed"
+
+	DefaultLogSize    = 1000
+	DefaultBufferSize = 64
+)
+
+// TodoTypes are the event types that describe changes to todos, as opposed to connection state
+var TodoTypes = []string{TodoCreated, TodoUpdated, TodoCompleted, TodoDeleted, TodosImported}
+
+// WebhookTypes are the event types webhooks can subscribe to
+var WebhookTypes = append(slices.Clone(TodoTypes), CommentAdded)
+
+type Event struct {
+	Id     string                  `json:"id"`
+	Type   string                  `json:"type"`
+	UserId string                  `json:"-"`
+	TodoId string                  `json:"todoId,omitempty"`
+	Todo   *models.GetTodoResponse `json:"todo,omitempty"`
+	Count  int                     `json:"count,omitempty"`
+	// Presence lists everyone currently connected for the user, on PresenceChanged events
+	Presence []Presence `json:"presence,omitempty"`
+	At       time.Time  `json:"at"`
+	seq      uint64
+}
+
+type Presence struct {
+	ConnectionId string    `json:"connectionId"`
+	Client       string    `json:"client,omitempty"`
+	TodoId       string    `json:"todoId,omitempty"`
+	State        string    `json:"state"`
+	Since        time.Time `json:"since"`
+}
+
+// Subscription receives a user's events on C. C is closed when the subscriber falls so far behind that
+// its buffer fills up, or when the hub shuts down; clients then reconnect and resume from the log.
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	userId string
+	hub    *Hub
+	closed bool
+	lagged bool
+}
+
+// Hub fans events out to the subscribers of the user they belong to and keeps the last events in a
+// bounded log so that a reconnecting client can catch up. Event ids start with the hub's start time,
+// ids handed out by an earlier process are recognised as unknown rather than mistaken for recent ones.
+type Hub struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	log         []Event
+	next        int
+	bufferSize  int
+	subscribers map[string]map[*Subscription]struct{}
+	presence    map[string]map[string]Presence
+	listeners   []func(Event)
+}
+
+func NewHub(logSize int, bufferSize int) *Hub {
+	return &Hub{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		log:         make([]Event, 0, logSize),
+		bufferSize:  bufferSize,
+		subscribers: make(map[string]map[*Subscription]struct{}),
+		presence:    make(map[string]map[string]Presence),
+	}
+}
+
+// Listen registers fn to be called with every published event of every user, after subscribers got it.
+// Listeners run on the publishing goroutine and must not block.
+func (h *Hub) Listen(fn func(Event)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listeners = append(h.listeners, fn)
+}
+
+func (h *Hub) Publish(event Event) Event {
+	event, listeners := h.publish(event)
+	for _, listener := range listeners {
+		listener(event)
+	}
+	return event
+}
+
+func (h *Hub) publish(event Event) (Event, []func(Event)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	event.seq = h.seq
+	event.Id = fmt.Sprintf("%s-%d", h.epoch, h.seq)
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	if len(h.log) < cap(h.log) {
+		h.log = append(h.log, event)
+	} else if cap(h.log) > 0 {
+		h.log[h.next] = event
+		h.next = (h.next + 1) % cap(h.log)
+	}
+	h.sendLocked(event)
+	return event, h.listeners
+}
+
+func (h *Hub) sendLocked(event Event) {
+	for subscription := range h.subscribers[event.UserId] {
+		select {
+		case subscription.ch <- event:
+		default:
+			subscription.lagged = true
+			h.closeLocked(subscription)
+		}
+	}
+}
+
+// SetPresence records what a connection is looking at and tells the user's other connections.
+func (h *Hub) SetPresence(userId string, presence Presence) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.presence[userId] == nil {
+		h.presence[userId] = make(map[string]Presence)
+	}
+	if presence.Since.IsZero() {
+		presence.Since = time.Now()
+	}
+	h.presence[userId][presence.ConnectionId] = presence
+	h.sendPresenceLocked(userId)
+}
+
+func (h *Hub) ClearPresence(userId string, connectionId string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.presence[userId][connectionId]; !ok {
+		return
+	}
+	delete(h.presence[userId], connectionId)
+	if len(h.presence[userId]) == 0 {
+		delete(h.presence, userId)
+	}
+	h.sendPresenceLocked(userId)
+}
+
+func (h *Hub) sendPresenceLocked(userId string) {
+	presence := make([]Presence, 0, len(h.presence[userId]))
+	for _, entry := range h.presence[userId] {
+		presence = append(presence, entry)
+	}
+	sort.Slice(presence, func(i, j int) bool {
+		return presence[i].ConnectionId < presence[j].ConnectionId
+	})
+	h.sendLocked(Event{Type: PresenceChanged, UserId: userId, Presence: presence, At: time.Now()})
+}
+
+// Subscribe starts delivering the user's events. With a lastEventId it also returns the logged events
+// after it; complete is false when that id is unknown or already dropped from the log, in which case
+// the client has missed events and has to reload its state.
+func (h *Hub) Subscribe(userId string, lastEventId string) (subscription *Subscription, backlog []Event, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan Event, h.bufferSize)
+	subscription = &Subscription{C: ch, ch: ch, userId: userId, hub: h}
+	if h.subscribers[userId] == nil {
+		h.subscribers[userId] = make(map[*Subscription]struct{})
+	}
+	h.subscribers[userId][subscription] = struct{}{}
+	if lastEventId == "" {
+		return subscription, nil, true
+	}
+	after, ok := h.parseId(lastEventId)
+	if !ok {
+		return subscription, nil, false
+	}
+	ordered := append(append([]Event{}, h.log[h.next:]...), h.log[:h.next]...)
+	// the log is complete from its oldest entry on, anything before that may be lost
+	complete = after == h.seq || (len(ordered) > 0 && after+1 >= ordered[0].seq)
+	for _, event := range ordered {
+		if event.seq > after && event.UserId == userId {
+			backlog = append(backlog, event)
+		}
+	}
+	return subscription, backlog, complete
+}
+
+func (h *Hub) parseId(id string) (uint64, bool) {
+	epoch, seq, found := strings.Cut(id, "-")
+	if !found || epoch != h.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil || n > h.seq {
+		return 0, false
+	}
+	return n, true
+}
+
+// Lagged reports whether C was closed because the subscriber fell behind, as opposed to a shutdown.
+// It is only meaningful once C has been closed.
+func (s *Subscription) Lagged() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.lagged
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.closeLocked(s)
+}
+
+func (h *Hub) closeLocked(s *Subscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.ch)
+	delete(h.subscribers[s.userId], s)
+	if len(h.subscribers[s.userId]) == 0 {
+		delete(h.subscribers, s.userId)
+	}
+}
+
+// Close ends every subscription, used on shutdown so streaming handlers return.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subscriptions := range h.subscribers {
+		for subscription := range subscriptions {
+			h.closeLocked(subscription)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package formats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"todos/models"
+)
+
+const (
+	CSV      = "csv"
+	JSON     = "json"
+	NDJSON   = "ndjson"
+	Markdown = "markdown"
+)
+
+var csvHeader = []string{"id", "parentId", "name", "description", "status", "priority", "labels", "recurrence", "dueAt", "createdAt"}
+
+// labels are joined into a single spreadsheet cell
+const csvLabelSeparator = ";"
+
+type Encoder interface {
+	Begin() error
+	Encode(todo *models.GetTodoResponse) error
+	End() error
+}
+
+func NewEncoder(format string, w io.Writer) (Encoder, error) {
+	switch format {
+	case CSV:
+		return &csvEncoder{w: csv.NewWriter(w)}, nil
+	case JSON:
+		return &jsonEncoder{w: w, enc: json.NewEncoder(w)}, nil
+	case NDJSON:
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}, nil
+	case Markdown:
+		return &markdownEncoder{w: w}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format: %q", format)
+}
+
+func Extension(format string) string {
+	if format == Markdown {
+		return "md"
+	}
+	return format
+}
+
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case NDJSON:
+		return "application/x-ndjson"
+	case Markdown:
+		return "text/markdown; charset=utf-8"
+	}
+	return "application/json"
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) Begin() error {
+	return e.w.Write(csvHeader)
+}
+
+func (e *csvEncoder) Encode(todo *models.GetTodoResponse) error {
+	record := []string{
+		todo.Id,
+		"",
+		todo.Name,
+		todo.Description,
+		todo.TaskStatus.String(),
+		todo.Priority.String(),
+		strings.Join(todo.Labels, csvLabelSeparator),
+		todo.Recurrence,
+		"",
+		todo.CreatedAt.Format(time.RFC3339),
+	}
+	if todo.ParentId != nil {
+		record[1] = *todo.ParentId
+	}
+	if todo.DueAt != nil {
+		record[8] = todo.DueAt.Format(time.RFC3339)
+	}
+	return e.w.Write(record)
+}
+
+// End flushes what the csv writer buffered; rows reach w in its own chunks until then
+func (e *csvEncoder) End() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonEncoder struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func (e *jsonEncoder) Begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonEncoder) Encode(todo *models.GetTodoResponse) error {
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.count++
+	return e.enc.Encode(todo)
+}
+
+func (e *jsonEncoder) End() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) Begin() error {
+	return nil
+}
+
+func (e *ndjsonEncoder) Encode(todo *models.GetTodoResponse) error {
+	return e.enc.Encode(todo)
+}
+
+func (e *ndjsonEncoder) End() error {
+	return nil
+}
@@ -0,0 +1,124 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"todos/models"
+)
+
+func exportFixture() []*models.GetTodoResponse {
+	parent := "11111111-1111-1111-1111-111111111111"
+	due := time.Date(2026, 10, 23, 17, 0, 0, 0, time.UTC)
+	return []*models.GetTodoResponse{
+		{
+			Id:          parent,
+			Name:        "pay rent",
+			Description: "before the 25th, \"landlord\" asked",
+			TaskStatus:  models.InProgess,
+			Priority:    models.HighPriority,
+			Labels:      []string{"home", "money"},
+			Recurrence:  "monthly",
+			DueAt:       &due,
+			CreatedAt:   time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC),
+			Version:     3,
+		},
+		{
+			Id:         "22222222-2222-2222-2222-222222222222",
+			ParentId:   &parent,
+			Name:       "transfer, then check",
+			TaskStatus: models.Completed,
+			Labels:     []string{},
+			CreatedAt:  time.Date(2026, 10, 2, 8, 0, 0, 0, time.UTC),
+			Version:    1,
+		},
+	}
+}
+
+func export(t *testing.T, format string, todos []*models.GetTodoResponse) string {
+	t.Helper()
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = encoder.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	for _, todo := range todos {
+		if err = encoder.Encode(todo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = encoder.End(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestExportCSV(t *testing.T) {
+	records, err := csv.NewReader(strings.NewReader(export(t, CSV, exportFixture()))).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid csv: %v", err)
+	}
+	want := [][]string{
+		csvHeader,
+		{"11111111-1111-1111-1111-111111111111", "", "pay rent", "before the 25th, \"landlord\" asked", "in_progress", "high", "home;money", "monthly", "2026-10-23T17:00:00Z", "2026-10-01T09:30:00Z"},
+		{"22222222-2222-2222-2222-222222222222", "11111111-1111-1111-1111-111111111111", "transfer, then check", "", "completed", "none", "", "", "", "2026-10-02T08:00:00Z"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("csv export =\n%q\nwant\n%q", records, want)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	for _, format := range []string{JSON, NDJSON} {
+		t.Run(format, func(t *testing.T) {
+			out := export(t, format, exportFixture())
+			var got []*models.GetTodoResponse
+			if format == JSON {
+				if err := json.Unmarshal([]byte(out), &got); err != nil {
+					t.Fatalf("export is not a json array: %v\n%s", err, out)
+				}
+			} else {
+				lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+				for _, line := range lines {
+					todo := new(models.GetTodoResponse)
+					if err := json.Unmarshal([]byte(line), todo); err != nil {
+						t.Fatalf("line %q is not a json object: %v", line, err)
+					}
+					got = append(got, todo)
+				}
+			}
+			if want := exportFixture(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s export decoded to %+v, want %+v", format, got, want)
+			}
+		})
+	}
+}
+
+func TestExportEmpty(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{CSV, strings.Join(csvHeader, ",") + "\n"},
+		{JSON, "[]\n"},
+		{NDJSON, ""},
+	}
+	for _, test := range tests {
+		if got := export(t, test.format, nil); got != test.want {
+			t.Errorf("empty %s export = %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestNewEncoderUnknownFormat(t *testing.T) {
+	if _, err := NewEncoder("xml", &bytes.Buffer{}); err == nil {
+		t.Fatal("NewEncoder accepted an unknown format")
+	}
+}
@@ -0,0 +1,453 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"todos/models"
+)
+
+const (
+	TodoTxt = "todotxt"
+	Todoist = "todoist"
+	Trello  = "trello"
+)
+
+type Record struct {
+	Row  int
+	Todo *models.Todo
+	Err  error
+}
+
+func Decode(format string, r io.Reader) ([]Record, error) {
+	switch format {
+	case CSV:
+		return decodeCSV(r)
+	case JSON:
+		return decodeJSON(r)
+	case NDJSON:
+		return decodeNDJSON(r)
+	case Markdown:
+		return decodeMarkdown(r)
+	case TodoTxt:
+		return decodeTodoTxt(r)
+	case Todoist:
+		return decodeTodoist(r)
+	case Trello:
+		return decodeTrello(r)
+	}
+	return nil, fmt.Errorf("unsupported import format: %q", format)
+}
+
+func DetectFormat(filename string, contentType string) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".csv":
+		return CSV
+	case ".ndjson", ".jsonl":
+		return NDJSON
+	case ".json":
+		return JSON
+	case ".md", ".markdown":
+		return Markdown
+	case ".txt":
+		return TodoTxt
+	}
+	switch {
+	case strings.HasPrefix(contentType, "text/csv"):
+		return CSV
+	case strings.HasPrefix(contentType, "application/x-ndjson"):
+		return NDJSON
+	case strings.HasPrefix(contentType, "application/json"):
+		return JSON
+	case strings.HasPrefix(contentType, "text/markdown"):
+		return Markdown
+	case strings.HasPrefix(contentType, "text/plain"):
+		return TodoTxt
+	}
+	return ""
+}
+
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp: %q", value)
+}
+
+func decodeCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading csv header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("csv header must contain a name column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[strings.ToLower(name)]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	var records []Record
+	var ids, parentIds []string
+	// row numbers are 1-based and count the header, so they match what a spreadsheet shows
+	row := 1
+	for {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		row++
+		if err != nil {
+			records = append(records, Record{Row: row, Err: err})
+			ids = append(ids, "")
+			parentIds = append(parentIds, "")
+			continue
+		}
+		todo := &models.Todo{
+			Name:        field(fields, "name"),
+			Description: field(fields, "description"),
+			Recurrence:  field(fields, "recurrence"),
+		}
+		rec := Record{Row: row, Todo: todo}
+		if status := field(fields, "status"); status != "" {
+			todo.TaskStatus, rec.Err = models.ParseStatus(status)
+		}
+		if rec.Err == nil {
+			todo.Priority, rec.Err = models.ParsePriority(field(fields, "priority"))
+		}
+		for _, label := range strings.Split(field(fields, "labels"), csvLabelSeparator) {
+			if label = strings.TrimSpace(label); label != "" {
+				todo.Labels = append(todo.Labels, label)
+			}
+		}
+		if dueAt := field(fields, "dueAt"); dueAt != "" && rec.Err == nil {
+			var due time.Time
+			if due, rec.Err = parseTime(dueAt); rec.Err == nil {
+				todo.DueAt = &due
+			}
+		}
+		if createdAt := field(fields, "createdAt"); createdAt != "" && rec.Err == nil {
+			todo.CreatedAt, rec.Err = parseTime(createdAt)
+		}
+		records = append(records, rec)
+		ids = append(ids, field(fields, "id"))
+		parentIds = append(parentIds, field(fields, "parentId"))
+	}
+	return nest(records, ids, parentIds), nil
+}
+
+// nest rebuilds the subtask tree of one of our own exports from the ids it carries
+func nest(records []Record, ids []string, parentIds []string) []Record {
+	byId := make(map[string]*models.Todo)
+	for i, rec := range records {
+		if ids[i] != "" && rec.Todo != nil {
+			byId[ids[i]] = rec.Todo
+		}
+	}
+	roots := records[:0]
+	for i, rec := range records {
+		parent, ok := byId[parentIds[i]]
+		if parentIds[i] == "" || !ok || rec.Err != nil {
+			roots = append(roots, rec)
+			continue
+		}
+		parent.Subtasks = append(parent.Subtasks, rec.Todo)
+	}
+	return roots
+}
+
+func decodeJSON(r io.Reader) ([]Record, error) {
+	reader := bufio.NewReader(r)
+	decoder := json.NewDecoder(reader)
+	var records []Record
+	first, err := firstNonSpace(reader)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if first == '[' {
+		if _, err = decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+	var ids, parentIds []string
+	row := 0
+	for decoder.More() {
+		row++
+		// items are read raw first so one with the wrong shape is reported on its own, only broken
+		// json syntax leaves nothing to resume from
+		var raw json.RawMessage
+		if err = decoder.Decode(&raw); err != nil {
+			return records, fmt.Errorf("malformed json at item %d: %w", row, err)
+		}
+		rec, id, parentId := decodeJSONItem(row, raw)
+		records = append(records, rec)
+		ids = append(ids, id)
+		parentIds = append(parentIds, parentId)
+	}
+	return nest(records, ids, parentIds), nil
+}
+
+// decodeNDJSON reads one item per line, so a malformed line is a row error like any other
+func decodeNDJSON(r io.Reader) ([]Record, error) {
+	reader := bufio.NewReader(r)
+	var records []Record
+	var ids, parentIds []string
+	row := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			row++
+			if trimmed := strings.TrimSpace(string(line)); trimmed != "" {
+				rec, id, parentId := decodeJSONItem(row, []byte(trimmed))
+				records = append(records, rec)
+				ids = append(ids, id)
+				parentIds = append(parentIds, parentId)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return records, err
+		}
+	}
+	return nest(records, ids, parentIds), nil
+}
+
+func decodeJSONItem(row int, raw []byte) (Record, string, string) {
+	var todo models.GetTodoResponse
+	if err := json.Unmarshal(raw, &todo); err != nil {
+		return Record{Row: row, Err: fmt.Errorf("malformed json: %w", err)}, "", ""
+	}
+	parentId := ""
+	if todo.ParentId != nil {
+		parentId = *todo.ParentId
+	}
+	return Record{Row: row, Todo: &models.Todo{
+		Name:        todo.Name,
+		Description: todo.Description,
+		TaskStatus:  todo.TaskStatus,
+		Priority:    todo.Priority,
+		Labels:      todo.Labels,
+		Recurrence:  todo.Recurrence,
+		DueAt:       todo.DueAt,
+		CreatedAt:   todo.CreatedAt,
+	}}, todo.Id, parentId
+}
+
+func firstNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b)) {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+var todoTxtDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+func decodeTodoTxt(r io.Reader) ([]Record, error) {
+	scanner := bufio.NewScanner(r)
+	var records []Record
+	row := 0
+	for scanner.Scan() {
+		row++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		records = append(records, Record{Row: row, Todo: parseTodoTxtLine(line)})
+	}
+	return records, scanner.Err()
+}
+
+func parseTodoTxtLine(line string) *models.Todo {
+	todo := new(models.Todo)
+	fields := strings.Fields(line)
+	if len(fields) > 0 && fields[0] == "x" {
+		todo.TaskStatus = models.Completed
+		fields = fields[1:]
+		// a completed task may carry a completion date before the creation date
+		if len(fields) > 1 && todoTxtDate.MatchString(fields[0]) && todoTxtDate.MatchString(fields[1]) {
+			fields = fields[1:]
+		}
+	}
+	if len(fields) > 0 && len(fields[0]) == 3 && fields[0][0] == '(' && fields[0][2] == ')' {
+		todo.Priority = todoTxtPriority(fields[0][1])
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && todoTxtDate.MatchString(fields[0]) {
+		todo.CreatedAt, _ = time.Parse("2006-01-02", fields[0])
+		fields = fields[1:]
+	}
+	var name []string
+	for _, field := range fields {
+		switch {
+		case len(field) > 1 && (field[0] == '+' || field[0] == '@'):
+			todo.Labels = append(todo.Labels, field[1:])
+		case strings.HasPrefix(field, "due:"):
+			if due, err := time.Parse("2006-01-02", strings.TrimPrefix(field, "due:")); err == nil {
+				todo.DueAt = &due
+				continue
+			}
+			name = append(name, field)
+		case strings.HasPrefix(field, "pri:") && len(field) == 5:
+			// completed tasks keep their priority in a pri: tag
+			todo.Priority = todoTxtPriority(field[4])
+		default:
+			name = append(name, field)
+		}
+	}
+	todo.Name = strings.Join(name, " ")
+	return todo
+}
+
+// todo.txt has priorities A-Z, only the first three map onto ours
+func todoTxtPriority(letter byte) models.Priority {
+	switch letter {
+	case 'A':
+		return models.HighPriority
+	case 'B':
+		return models.MediumPriority
+	case 'C':
+		return models.LowPriority
+	}
+	return models.NoPriority
+}
+
+type todoistTask struct {
+	Content     string   `json:"content"`
+	Description string   `json:"description"`
+	Labels      []string `json:"labels"`
+	Priority    int      `json:"priority"`
+	Due         *struct {
+		Date string `json:"date"`
+	} `json:"due"`
+	IsCompleted bool   `json:"is_completed"`
+	Checked     bool   `json:"checked"`
+	CreatedAt   string `json:"created_at"`
+	AddedAt     string `json:"added_at"`
+}
+
+func decodeTodoist(r io.Reader) ([]Record, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []todoistTask
+	if err = json.Unmarshal(body, &tasks); err != nil {
+		// full account backups wrap the tasks in an "items" array
+		var backup struct {
+			Items []todoistTask `json:"items"`
+		}
+		if err = json.Unmarshal(body, &backup); err != nil {
+			return nil, fmt.Errorf("malformed todoist export: %w", err)
+		}
+		tasks = backup.Items
+	}
+	records := make([]Record, 0, len(tasks))
+	for i, task := range tasks {
+		todo := &models.Todo{Name: task.Content, Description: task.Description, Labels: task.Labels}
+		rec := Record{Row: i + 1, Todo: todo}
+		if task.IsCompleted || task.Checked {
+			todo.TaskStatus = models.Completed
+		}
+		// todoist counts priority from 1 (normal) to 4 (urgent)
+		if task.Priority > 1 && task.Priority <= 4 {
+			todo.Priority = models.Priority(task.Priority - 1)
+		}
+		if task.Due != nil && task.Due.Date != "" {
+			var due time.Time
+			if due, rec.Err = parseTime(task.Due.Date); rec.Err == nil {
+				todo.DueAt = &due
+			}
+		}
+		createdAt := task.CreatedAt
+		if createdAt == "" {
+			createdAt = task.AddedAt
+		}
+		if createdAt != "" && rec.Err == nil {
+			todo.CreatedAt, rec.Err = parseTime(createdAt)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+type trelloBoard struct {
+	Lists []struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"lists"`
+	Cards []struct {
+		Name        string `json:"name"`
+		Desc        string `json:"desc"`
+		Closed      bool   `json:"closed"`
+		Due         string `json:"due"`
+		DueComplete bool   `json:"dueComplete"`
+		IdList      string `json:"idList"`
+		Labels      []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+	} `json:"cards"`
+}
+
+func decodeTrello(r io.Reader) ([]Record, error) {
+	var board trelloBoard
+	if err := json.NewDecoder(r).Decode(&board); err != nil {
+		return nil, fmt.Errorf("malformed trello export: %w", err)
+	}
+	listNames := make(map[string]string)
+	for _, list := range board.Lists {
+		listNames[list.Id] = strings.ToLower(list.Name)
+	}
+	records := make([]Record, 0, len(board.Cards))
+	for i, card := range board.Cards {
+		todo := &models.Todo{Name: card.Name, Description: card.Desc}
+		switch list := listNames[card.IdList]; {
+		case card.Closed || card.DueComplete || list == "done" || list == "completed":
+			todo.TaskStatus = models.Completed
+		case list == "doing" || list == "in progress":
+			todo.TaskStatus = models.InProgess
+		}
+		for _, label := range card.Labels {
+			if label.Name != "" {
+				todo.Labels = append(todo.Labels, label.Name)
+			}
+		}
+		rec := Record{Row: i + 1, Todo: todo}
+		if card.Due != "" {
+			var due time.Time
+			if due, rec.Err = parseTime(card.Due); rec.Err == nil {
+				todo.DueAt = &due
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
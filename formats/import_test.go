@@ -0,0 +1,281 @@
+package formats
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"todos/models"
+)
+
+func date(value string) *time.Time {
+	parsed, err := parseTime(value)
+	if err != nil {
+		panic(err)
+	}
+	return &parsed
+}
+
+func decode(t *testing.T, format string, input string) []Record {
+	t.Helper()
+	records, err := Decode(format, strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("decoding %s: %v", format, err)
+	}
+	return records
+}
+
+// todos returns the decoded todos and fails on the first row error
+func todos(t *testing.T, records []Record) []*models.Todo {
+	t.Helper()
+	var todos []*models.Todo
+	for _, record := range records {
+		if record.Err != nil {
+			t.Fatalf("row %d: %v", record.Row, record.Err)
+		}
+		todos = append(todos, record.Todo)
+	}
+	return todos
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   []*models.Todo
+	}{
+		{
+			name:   "csv export with a subtask",
+			format: CSV,
+			input: "id,parentId,name,description,status,priority,labels,recurrence,dueAt,createdAt\n" +
+				"a,,pay rent,\"monthly, by transfer\",in_progress,high,home;money,monthly,2026-10-23T17:00:00Z,2026-10-01T09:30:00Z\n" +
+				"b,a,check the transfer,,completed,,,,,2026-10-02\n",
+			want: []*models.Todo{{
+				Name: "pay rent", Description: "monthly, by transfer", TaskStatus: models.InProgess, Priority: models.HighPriority,
+				Labels: []string{"home", "money"}, Recurrence: "monthly", DueAt: date("2026-10-23T17:00:00Z"), CreatedAt: *date("2026-10-01T09:30:00Z"),
+				Subtasks: []*models.Todo{{Name: "check the transfer", TaskStatus: models.Completed, CreatedAt: *date("2026-10-02")}},
+			}},
+		},
+		{
+			name:   "csv with only a name column",
+			format: CSV,
+			input:  "Name\nwater plants\n",
+			want:   []*models.Todo{{Name: "water plants"}},
+		},
+		{
+			name:   "json array",
+			format: JSON,
+			input: `[{"id":"a","name":"pay rent","status":1,"priority":3,"labels":["home"],"dueAt":"2026-10-23T17:00:00Z","createdAt":"2026-10-01T09:30:00Z"},
+				{"id":"b","parentId":"a","name":"check the transfer","status":2,"labels":[],"createdAt":"2026-10-02T08:00:00Z"}]`,
+			want: []*models.Todo{{
+				Name: "pay rent", TaskStatus: models.InProgess, Priority: models.HighPriority, Labels: []string{"home"},
+				DueAt: date("2026-10-23T17:00:00Z"), CreatedAt: *date("2026-10-01T09:30:00Z"),
+				Subtasks: []*models.Todo{{Name: "check the transfer", TaskStatus: models.Completed, Labels: []string{}, CreatedAt: *date("2026-10-02T08:00:00Z")}},
+			}},
+		},
+		{
+			name:   "ndjson",
+			format: NDJSON,
+			input:  "{\"name\":\"pay rent\",\"labels\":[\"home\"]}\n\n{\"name\":\"water plants\",\"status\":2}\n",
+			want:   []*models.Todo{{Name: "pay rent", Labels: []string{"home"}}, {Name: "water plants", TaskStatus: models.Completed}},
+		},
+		{
+			name:   "todo.txt",
+			format: TodoTxt,
+			input: "(A) 2026-10-01 pay rent +home @bank due:2026-10-23\n" +
+				"x 2026-10-05 2026-10-02 water plants pri:B\n" +
+				"\n" +
+				"call mum due:someday\n",
+			want: []*models.Todo{
+				{Name: "pay rent", Priority: models.HighPriority, Labels: []string{"home", "bank"}, DueAt: date("2026-10-23"), CreatedAt: *date("2026-10-01")},
+				{Name: "water plants", TaskStatus: models.Completed, Priority: models.MediumPriority, CreatedAt: *date("2026-10-02")},
+				{Name: "call mum due:someday"},
+			},
+		},
+		{
+			name:   "todoist task list",
+			format: Todoist,
+			input: `[{"content":"pay rent","description":"by transfer","labels":["home"],"priority":4,"due":{"date":"2026-10-23"},"created_at":"2026-10-01T09:30:00Z"},
+				{"content":"water plants","priority":1,"checked":true,"added_at":"2026-10-02T08:00:00Z"}]`,
+			want: []*models.Todo{
+				{Name: "pay rent", Description: "by transfer", Labels: []string{"home"}, Priority: models.HighPriority, DueAt: date("2026-10-23"), CreatedAt: *date("2026-10-01T09:30:00Z")},
+				{Name: "water plants", TaskStatus: models.Completed, CreatedAt: *date("2026-10-02T08:00:00Z")},
+			},
+		},
+		{
+			name:   "todoist backup",
+			format: Todoist,
+			input:  `{"items":[{"content":"pay rent","priority":2}]}`,
+			want:   []*models.Todo{{Name: "pay rent", Priority: models.LowPriority}},
+		},
+		{
+			name:   "trello board",
+			format: Trello,
+			input: `{"lists":[{"id":"l1","name":"To Do"},{"id":"l2","name":"Doing"},{"id":"l3","name":"Done"}],
+				"cards":[
+					{"name":"pay rent","desc":"by transfer","idList":"l1","due":"2026-10-23T17:00:00.000Z","labels":[{"name":"home"},{"name":""}]},
+					{"name":"water plants","idList":"l2"},
+					{"name":"call mum","idList":"l3"},
+					{"name":"old card","idList":"l1","closed":true}
+				]}`,
+			want: []*models.Todo{
+				{Name: "pay rent", Description: "by transfer", Labels: []string{"home"}, DueAt: date("2026-10-23T17:00:00Z")},
+				{Name: "water plants", TaskStatus: models.InProgess},
+				{Name: "call mum", TaskStatus: models.Completed},
+				{Name: "old card", TaskStatus: models.Completed},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := todos(t, decode(t, test.format, test.input))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("decoded\n%s\nwant\n%s", describe(got), describe(test.want))
+			}
+		})
+	}
+}
+
+// describe prints todos with their subtasks and dates, which %+v shows as pointers
+func describe(todos []*models.Todo) string {
+	var b strings.Builder
+	var walk func(todos []*models.Todo, indent string)
+	walk = func(todos []*models.Todo, indent string) {
+		for _, todo := range todos {
+			due := "-"
+			if todo.DueAt != nil {
+				due = todo.DueAt.Format(time.RFC3339)
+			}
+			b.WriteString(indent)
+			b.WriteString(strings.Join([]string{todo.Name, todo.Description, todo.TaskStatus.String(), todo.Priority.String(),
+				strings.Join(todo.Labels, ";"), todo.Recurrence, due, todo.CreatedAt.Format(time.RFC3339)}, " | "))
+			b.WriteString("\n")
+			walk(todo.Subtasks, indent+"  ")
+		}
+	}
+	walk(todos, "")
+	return b.String()
+}
+
+func TestDecodeRowErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		// rows that fail, the others decode
+		rows []int
+	}{
+		{
+			name:   "csv status, priority and dates",
+			format: CSV,
+			input:  "name,status,priority,dueAt,createdAt\nok,,,,\nbad status,sleeping,,,\nbad priority,,urgent,,\nbad due,,,next week,\nbad created,,,,yesterday\n",
+			rows:   []int{3, 4, 5, 6},
+		},
+		{
+			name:   "csv quoting",
+			format: CSV,
+			input:  "name,description\nok,\"fine\"\nbroken,\"unterminated\n",
+			rows:   []int{3},
+		},
+		{
+			name:   "json items of the wrong shape",
+			format: JSON,
+			input:  `[{"name":"ok"},{"name":42},"just a string",{"name":"also ok","labels":"home"},{"name":"last"}]`,
+			rows:   []int{2, 3, 4},
+		},
+		{
+			name:   "ndjson lines",
+			format: NDJSON,
+			input:  "{\"name\":\"ok\"}\n{\"name\":\n{\"name\":\"after the broken line\"}\n",
+			rows:   []int{2},
+		},
+		{
+			name:   "todoist due date",
+			format: Todoist,
+			input:  `[{"content":"ok"},{"content":"bad","due":{"date":"soon"}}]`,
+			rows:   []int{2},
+		},
+		{
+			name:   "trello due date",
+			format: Trello,
+			input:  `{"cards":[{"name":"bad","due":"soon"},{"name":"ok"}]}`,
+			rows:   []int{1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var failed []int
+			for _, record := range decode(t, test.format, test.input) {
+				if record.Err != nil {
+					failed = append(failed, record.Row)
+				} else if record.Todo == nil {
+					t.Fatalf("row %d has neither a todo nor an error", record.Row)
+				}
+			}
+			if !reflect.DeepEqual(failed, test.rows) {
+				t.Fatalf("failed rows = %v, want %v", failed, test.rows)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{"csv without a name column", CSV, "title,status\nwater plants,pending\n"},
+		{"broken json syntax", JSON, `[{"name":"ok"},{"name":`},
+		{"todoist that is not json", Todoist, `content: pay rent`},
+		{"trello that is not json", Trello, `[`},
+		{"unknown format", "xml", `<todo/>`},
+	}
+	for _, test := range tests {
+		if _, err := Decode(test.format, strings.NewReader(test.input)); err == nil {
+			t.Errorf("%s: decoded without an error", test.name)
+		}
+	}
+}
+
+func TestDecodeJSONKeepsRowsBeforeBrokenSyntax(t *testing.T) {
+	records, err := Decode(JSON, strings.NewReader(`[{"name":"first"},{"name":"second"},{"name":`))
+	if err == nil || !strings.Contains(err.Error(), "item 3") {
+		t.Fatalf("error = %v, want one naming item 3", err)
+	}
+	if len(records) != 2 || records[1].Todo.Name != "second" {
+		t.Fatalf("records before the broken item = %+v", records)
+	}
+}
+
+func TestDecodeEmpty(t *testing.T) {
+	for _, format := range []string{CSV, JSON, NDJSON, TodoTxt} {
+		if records, err := Decode(format, strings.NewReader("")); err != nil || len(records) != 0 {
+			t.Errorf("empty %s import = %v, %v", format, records, err)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		filename    string
+		contentType string
+		want        string
+	}{
+		{"todos.CSV", "", CSV},
+		{"todos.jsonl", "", NDJSON},
+		{"todos.json", "text/plain", JSON},
+		{"todos.md", "", Markdown},
+		{"todo.txt", "", TodoTxt},
+		{"", "application/json; charset=utf-8", JSON},
+		{"", "application/x-ndjson", NDJSON},
+		{"upload", "text/plain", TodoTxt},
+		{"upload", "application/octet-stream", ""},
+	}
+	for _, test := range tests {
+		if got := DetectFormat(test.filename, test.contentType); got != test.want {
+			t.Errorf("DetectFormat(%q, %q) = %q, want %q", test.filename, test.contentType, got, test.want)
+		}
+	}
+}
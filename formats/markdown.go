@@ -0,0 +1,290 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"todos/models"
+)
+
+const (
+	markdownIndent     = "  "
+	inProgressNote     = "(in progress)"
+	markdownDateLayout = "2006-01-02"
+)
+
+// markdown needs the whole tree before it can nest subtasks, so unlike the other encoders it buffers until End
+type markdownEncoder struct {
+	w     io.Writer
+	todos []*models.GetTodoResponse
+}
+
+func (e *markdownEncoder) Begin() error {
+	return nil
+}
+
+func (e *markdownEncoder) Encode(todo *models.GetTodoResponse) error {
+	e.todos = append(e.todos, todo)
+	return nil
+}
+
+func (e *markdownEncoder) End() error {
+	return WriteMarkdown(e.w, e.todos)
+}
+
+func WriteMarkdown(w io.Writer, todos []*models.GetTodoResponse) error {
+	ids := make(map[string]bool, len(todos))
+	for _, todo := range todos {
+		ids[todo.Id] = true
+	}
+	children := make(map[string][]*models.GetTodoResponse)
+	var roots []*models.GetTodoResponse
+	for _, todo := range todos {
+		// a subtask whose parent was filtered out is promoted to the top level
+		if todo.ParentId != nil && ids[*todo.ParentId] {
+			children[*todo.ParentId] = append(children[*todo.ParentId], todo)
+			continue
+		}
+		roots = append(roots, todo)
+	}
+	bw := bufio.NewWriter(w)
+	var write func(todos []*models.GetTodoResponse, depth int)
+	write = func(todos []*models.GetTodoResponse, depth int) {
+		sort.SliceStable(todos, func(i, j int) bool {
+			return todos[i].CreatedAt.Before(todos[j].CreatedAt)
+		})
+		for _, todo := range todos {
+			writeMarkdownItem(bw, todo, depth)
+			write(children[todo.Id], depth+1)
+		}
+	}
+	write(roots, 0)
+	return bw.Flush()
+}
+
+func writeMarkdownItem(w *bufio.Writer, todo *models.GetTodoResponse, depth int) {
+	indent := strings.Repeat(markdownIndent, depth)
+	check := " "
+	if todo.TaskStatus == models.Completed {
+		check = "x"
+	}
+	fmt.Fprintf(w, "%s- [%s] %s", indent, check, markdownEscaper.Replace(strings.TrimSpace(todo.Name)))
+	if todo.TaskStatus == models.InProgess {
+		fmt.Fprintf(w, " %s", inProgressNote)
+	}
+	if todo.Priority != models.NoPriority {
+		fmt.Fprintf(w, " (priority: %s)", todo.Priority)
+	}
+	if len(todo.Labels) > 0 {
+		labels := make([]string, len(todo.Labels))
+		for i, label := range todo.Labels {
+			labels[i] = labelEscaper.Replace(label)
+		}
+		fmt.Fprintf(w, " (labels: %s)", strings.Join(labels, ", "))
+	}
+	if todo.Recurrence != "" {
+		fmt.Fprintf(w, " (repeat: %s)", markdownEscaper.Replace(todo.Recurrence))
+	}
+	if todo.DueAt != nil {
+		fmt.Fprintf(w, " (due: %s)", formatDue(*todo.DueAt))
+	}
+	w.WriteString("\n")
+	description := strings.TrimRight(todo.Description, "\n ")
+	if description == "" {
+		return
+	}
+	for _, line := range strings.Split(description, "\n") {
+		if strings.TrimSpace(line) == "" {
+			w.WriteString("\n")
+			continue
+		}
+		// a line that would read back as an item, or as such an escape, is escaped itself
+		if rest := strings.TrimLeft(line, " \t"); strings.HasPrefix(rest, `\`) || markdownItem.MatchString(line) {
+			line = line[:len(line)-len(rest)] + `\` + rest
+		}
+		fmt.Fprintf(w, "%s%s%s\n", indent, markdownIndent, line)
+	}
+}
+
+func formatDue(due time.Time) string {
+	due = due.UTC()
+	if due.Hour() == 0 && due.Minute() == 0 && due.Second() == 0 {
+		return due.Format(markdownDateLayout)
+	}
+	return due.Format(time.RFC3339)
+}
+
+var (
+	markdownItem  = regexp.MustCompile(`^(\s*)[-*+] \[([ xX])\] (.*)$`)
+	markdownNotes = []string{"due", "priority", "labels", "repeat"}
+	// names and notes escape the characters that would otherwise read back as a note, labels also
+	// their separator; a backslash before anything else is kept as it is
+	markdownEscaper = strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`)
+	labelEscaper    = strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`, ",", `\,`)
+)
+
+// escaped reports whether the byte at i follows an odd run of backslashes
+func escaped(text string, i int) bool {
+	n := 0
+	for j := i - 1; j >= 0 && text[j] == '\\'; j-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+func unescapeMarkdown(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) && strings.IndexByte(`\(),`, text[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
+
+// splitLabels splits a labels note on its unescaped commas
+func splitLabels(value string) []string {
+	var labels []string
+	start := 0
+	for i := 0; i <= len(value); i++ {
+		if i < len(value) && (value[i] != ',' || escaped(value, i)) {
+			continue
+		}
+		if label := strings.TrimSpace(unescapeMarkdown(value[start:i])); label != "" {
+			labels = append(labels, label)
+		}
+		start = i + 1
+	}
+	return labels
+}
+
+// cutMarkdownNote takes a "(key: value)" note off the end of text. Parentheses that are escaped do not
+// open or close a note, so a name ending in something note shaped stays part of the name.
+func cutMarkdownNote(text string) (rest string, key string, value string, ok bool) {
+	end := len(text) - 1
+	if end < 0 || text[end] != ')' || escaped(text, end) {
+		return text, "", "", false
+	}
+	open := strings.LastIndexFunc(text[:end], func(r rune) bool { return r == '(' || r == ')' })
+	for open >= 0 && escaped(text, open) {
+		open = strings.LastIndexFunc(text[:open], func(r rune) bool { return r == '(' || r == ')' })
+	}
+	if open < 0 || text[open] != '(' {
+		return text, "", "", false
+	}
+	key, value, ok = strings.Cut(text[open+1:end], ": ")
+	if !ok || !slices.Contains(markdownNotes, key) || strings.TrimSpace(value) == "" {
+		return text, "", "", false
+	}
+	return strings.TrimSpace(text[:open]), key, value, true
+}
+
+type markdownNode struct {
+	indent int
+	todo   *models.Todo
+}
+
+func decodeMarkdown(r io.Reader) ([]Record, error) {
+	scanner := bufio.NewScanner(r)
+	var records []Record
+	var stack []markdownNode
+	descriptions := make(map[*models.Todo][]string)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.ReplaceAll(scanner.Text(), "\t", "    ")
+		indent := len(text) - len(strings.TrimLeft(text, " "))
+		match := markdownItem.FindStringSubmatch(text)
+		if match == nil {
+			// everything indented under an item belongs to its description, other prose is ignored
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if strings.TrimSpace(text) == "" {
+					descriptions[top.todo] = append(descriptions[top.todo], "")
+				} else if indent > top.indent {
+					strip := min(indent, top.indent+len(markdownIndent))
+					content := text[strip:]
+					if rest := strings.TrimLeft(content, " "); strings.HasPrefix(rest, `\`) {
+						content = content[:len(content)-len(rest)] + rest[1:]
+					}
+					descriptions[top.todo] = append(descriptions[top.todo], content)
+				}
+			}
+			continue
+		}
+		todo, err := parseMarkdownItem(match[2], match[3])
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1].todo
+			parent.Subtasks = append(parent.Subtasks, todo)
+			if err != nil {
+				records = append(records, Record{Row: line, Err: err})
+			}
+		} else {
+			records = append(records, Record{Row: line, Todo: todo, Err: err})
+		}
+		stack = append(stack, markdownNode{indent: indent, todo: todo})
+	}
+	for todo, lines := range descriptions {
+		todo.Description = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	}
+	return records, scanner.Err()
+}
+
+func parseMarkdownItem(check string, text string) (*models.Todo, error) {
+	todo := new(models.Todo)
+	if check != " " {
+		todo.TaskStatus = models.Completed
+	}
+	text = strings.TrimSpace(text)
+	var err error
+	for {
+		if rest, key, value, ok := cutMarkdownNote(text); ok {
+			if noteErr := applyMarkdownNote(todo, key, strings.TrimSpace(value)); noteErr != nil {
+				err = noteErr
+			}
+			text = rest
+			continue
+		}
+		if strings.HasSuffix(text, inProgressNote) && !escaped(text, len(text)-len(inProgressNote)) {
+			if todo.TaskStatus != models.Completed {
+				todo.TaskStatus = models.InProgess
+			}
+			text = strings.TrimSpace(strings.TrimSuffix(text, inProgressNote))
+			continue
+		}
+		break
+	}
+	todo.Name = unescapeMarkdown(text)
+	return todo, err
+}
+
+func applyMarkdownNote(todo *models.Todo, key string, value string) error {
+	switch key {
+	case "due":
+		due, err := parseTime(value)
+		if err != nil {
+			return err
+		}
+		todo.DueAt = &due
+	case "priority":
+		priority, err := models.ParsePriority(value)
+		if err != nil {
+			return err
+		}
+		todo.Priority = priority
+	case "labels":
+		todo.Labels = splitLabels(value)
+	case "repeat":
+		todo.Recurrence = unescapeMarkdown(value)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package formats
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"todos/models"
+)
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	due := time.Date(2026, 10, 23, 17, 30, 0, 0, time.UTC)
+	day := time.Date(2026, 10, 24, 0, 0, 0, 0, time.UTC)
+	parent := "11111111-1111-1111-1111-111111111111"
+	created := func(minute int) time.Time { return time.Date(2026, 10, 1, 9, minute, 0, 0, time.UTC) }
+	exported := []*models.GetTodoResponse{
+		{Id: parent, Name: "pay rent", Description: "by transfer\n\nto the usual account", TaskStatus: models.InProgess,
+			Priority: models.HighPriority, Labels: []string{"home", "money"}, Recurrence: "monthly", DueAt: &due, CreatedAt: created(0)},
+		{Id: "a", ParentId: &parent, Name: "check the transfer", TaskStatus: models.Completed, DueAt: &day, CreatedAt: created(1)},
+		{Id: "b", Name: "read the notes (labels: x)", CreatedAt: created(2)},
+		{Id: "c", Name: "ship it (due: friday)", CreatedAt: created(3)},
+		{Id: "d", Name: "sort out (priority: high)", Priority: models.LowPriority, CreatedAt: created(4)},
+		{Id: "e", Name: "not a rule (repeat: daily)", CreatedAt: created(5)},
+		{Id: "f", Name: "half done (in progress)", CreatedAt: created(6)},
+		{Id: "g", Name: `back\slash \(kept\)`, Labels: []string{"a, b", "c)", "(d", `e\`}, CreatedAt: created(7)},
+		{Id: "h", Name: "checklist in the notes", Description: "steps:\n- [ ] not a subtask\n  * [x] nor this\n\\ leading backslash\n  indented", CreatedAt: created(8)},
+		{Id: "i", Name: "odd rule", Recurrence: "every (other) day", CreatedAt: created(9)},
+	}
+	var out strings.Builder
+	if err := WriteMarkdown(&out, exported); err != nil {
+		t.Fatal(err)
+	}
+	records, err := Decode(Markdown, strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := todos(t, records)
+	// the root with a subtask comes back with it nested, the rest are roots in export order
+	want := []*models.GetTodoResponse{exported[0]}
+	want = append(want, exported[2:]...)
+	if len(imported) != len(want) {
+		t.Fatalf("imported %d todos, want %d from\n%s", len(imported), len(want), out.String())
+	}
+	for i, todo := range imported {
+		compareMarkdownTodo(t, todo, want[i], out.String())
+	}
+	if len(imported[0].Subtasks) != 1 {
+		t.Fatalf("pay rent came back with %d subtasks, want 1", len(imported[0].Subtasks))
+	}
+	compareMarkdownTodo(t, imported[0].Subtasks[0], exported[1], out.String())
+}
+
+func compareMarkdownTodo(t *testing.T, got *models.Todo, want *models.GetTodoResponse, markdown string) {
+	t.Helper()
+	name := want.Name
+	fail := func(field string, got any, want any) {
+		t.Errorf("%q: %s = %q, want %q in\n%s", name, field, got, want, markdown)
+	}
+	if got.Name != want.Name {
+		fail("name", got.Name, want.Name)
+	}
+	if got.Description != want.Description {
+		fail("description", got.Description, want.Description)
+	}
+	if got.TaskStatus != want.TaskStatus {
+		fail("status", got.TaskStatus.String(), want.TaskStatus.String())
+	}
+	if got.Priority != want.Priority {
+		fail("priority", got.Priority.String(), want.Priority.String())
+	}
+	if !slices.Equal(got.Labels, want.Labels) {
+		fail("labels", got.Labels, want.Labels)
+	}
+	if got.Recurrence != want.Recurrence {
+		fail("recurrence", got.Recurrence, want.Recurrence)
+	}
+	if (got.DueAt == nil) != (want.DueAt == nil) || got.DueAt != nil && !got.DueAt.Equal(*want.DueAt) {
+		fail("due", got.DueAt, want.DueAt)
+	}
+}
+
+func TestMarkdownImport(t *testing.T) {
+	input := "# Groceries\n\n" +
+		"- [ ] milk (labels: dairy, fridge) (due: 2026-10-23)\n" +
+		"* [X] bread (priority: medium)\n" +
+		"    - [ ] sourdough\n" +
+		"      from the bakery\n" +
+		"+ [ ] eggs (when: today)\n" +
+		"- [ ] cheese (due: someday)\n"
+	records := decode(t, Markdown, input)
+	if len(records) != 4 {
+		t.Fatalf("decoded %d records, want 4", len(records))
+	}
+	milk, bread, eggs := records[0].Todo, records[1].Todo, records[2].Todo
+	if milk.Name != "milk" || !slices.Equal(milk.Labels, []string{"dairy", "fridge"}) || milk.DueAt == nil {
+		t.Errorf("milk = %+v", milk)
+	}
+	if bread.TaskStatus != models.Completed || bread.Priority != models.MediumPriority || len(bread.Subtasks) != 1 ||
+		bread.Subtasks[0].Description != "from the bakery" {
+		t.Errorf("bread = %+v", bread)
+	}
+	// only the notes the exporter writes are read, anything else stays in the name
+	if eggs.Name != "eggs (when: today)" {
+		t.Errorf("eggs = %q", eggs.Name)
+	}
+	if records[3].Err == nil {
+		t.Error("an invalid due date was not reported")
+	}
+}
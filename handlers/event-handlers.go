@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"todos/events"
+	"todos/models"
+	"todos/utilities"
+)
+
+const (
+	eventRetry     = 3 * time.Second
+	eventHeartbeat = 25 * time.Second
+	// sent when events were missed, clients reload their todos before relying on the stream again
+	eventReset = "reset"
+)
+
+// IssueTicket hands out a ticket that authenticates one /events, /ws or /graphql connection in place of the
+// access token, for browsers that cannot set the Authorization header on those connections.
+func (th *TodoHandler) IssueTicket(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	user_id := r.Context().Value("userId").(string)
+	ticket, expiresAt, err := th.Tickets.Issue(user_id)
+	if err != nil {
+		utilities.WriteError("error while issuing ticket", rw, http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	utilities.WriteResponse(rw, models.TicketResponse{Ticket: ticket, ExpiresAt: expiresAt})
+}
+
+// StreamEvents sends the user's todo events as Server-Sent Events. A reconnecting EventSource sends
+// Last-Event-ID and gets the events it missed from the hub's log, or a reset event if they are gone.
+func (th *TodoHandler) StreamEvents(rw http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		utilities.WriteError("streaming is not supported", rw, http.StatusInternalServerError)
+		return
+	}
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+	user_id := r.Context().Value("userId").(string)
+	subscription, backlog, complete := th.Events.Subscribe(user_id, lastEventId)
+	defer subscription.Close()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprintf(rw, "retry: %d\n\n", eventRetry.Milliseconds())
+	if !complete {
+		fmt.Fprintf(rw, "event: %s\ndata: {}\n\n", eventReset)
+	}
+	for _, event := range backlog {
+		if writeEvent(rw, event) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			// a comment line keeps proxies from closing an idle connection
+			if _, err := fmt.Fprint(rw, ": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+			if writeEvent(rw, event) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(rw http.ResponseWriter, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	// an empty id line would reset the client's Last-Event-ID, so transient events go without one
+	if event.Id != "" {
+		if _, err = fmt.Fprintf(rw, "id: %s\n", event.Id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
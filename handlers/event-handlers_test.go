@@ -0,0 +1,56 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+	"todos/events"
+	"todos/models"
+	"todos/router/routertest"
+)
+
+func TestDeletePublishesSubtasks(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	user, token := s.SignUp(t, "alice")
+	root := createTodo(t, s, token, &models.Todo{Name: "move"})
+	pack := createSubtask(t, s, token, root.Id, "pack")
+	createSubtask(t, s, token, pack.Id, "buy boxes")
+	subscription, _, _ := s.Events.Subscribe(user.Id, "")
+	defer subscription.Close()
+	s.Call(t, http.MethodDelete, "/todos/"+root.Id, token, nil, http.StatusNoContent, nil)
+
+	var deleted []string
+	timeout := time.After(5 * time.Second)
+	for len(deleted) < 3 {
+		select {
+		case event := <-subscription.C:
+			if event.Type == events.TodoDeleted {
+				deleted = append(deleted, event.TodoId)
+			}
+		case <-timeout:
+			t.Fatalf("got deleted events for %v, want the root and both subtasks", deleted)
+		}
+	}
+	if !slices.Contains(deleted, root.Id) {
+		t.Errorf("no deleted event for the root %s in %v", root.Id, deleted)
+	}
+}
+
+func TestTickets(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	s.Call(t, http.MethodPost, "/events/ticket", "", nil, http.StatusUnauthorized, nil)
+	var ticket models.TicketResponse
+	s.Call(t, http.MethodPost, "/events/ticket", token, nil, http.StatusCreated, &ticket)
+	if ticket.Ticket == "" || !ticket.ExpiresAt.After(time.Now()) {
+		t.Fatalf("ticket is %+v", ticket)
+	}
+
+	query := "/graphql?query=" + url.QueryEscape("{ me { username } }")
+	s.Call(t, http.MethodGet, query+"&ticket="+ticket.Ticket, "", nil, http.StatusOK, nil)
+	s.Call(t, http.MethodGet, query+"&ticket="+ticket.Ticket, "", nil, http.StatusUnauthorized, nil)
+	s.Call(t, http.MethodGet, query+"&access_token="+token, "", nil, http.StatusUnauthorized, nil)
+	s.Call(t, http.MethodGet, query, token, nil, http.StatusOK, nil)
+}
@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+	"todos/utilities"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	maxGraphQLRequest = 1 << 20
+	// the graphql-transport-ws protocol, as spoken by graphql-ws and Apollo clients
+	graphQLSubprotocol   = "graphql-transport-ws"
+	graphQLInitTimeout   = 10 * time.Second
+	graphQLMaxOperations = 32
+)
+
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// GraphQL serves queries and mutations over HTTP and, when the request is a WebSocket upgrade,
+// subscriptions as well. GET requests may only run queries.
+func (th *TodoHandler) GraphQL(rw http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		th.graphQLSocket(rw, r)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	request, err := readGraphQLRequest(rw, r)
+	if err != nil {
+		utilities.WriteError(err.Error(), rw, http.StatusBadRequest)
+		return
+	}
+	operation, errs := th.prepareGraphQL(request)
+	switch {
+	case len(errs) > 0:
+	case operation == ast.OperationTypeSubscription:
+		errs = gqlerrors.FormatErrors(fmt.Errorf("subscriptions are only served over a WebSocket"))
+	case operation == ast.OperationTypeMutation && r.Method != http.MethodPost:
+		rw.Header().Set("Allow", http.MethodPost)
+		utilities.WriteError("mutations must be sent with POST", rw, http.StatusMethodNotAllowed)
+		return
+	}
+	if len(errs) > 0 {
+		utilities.WriteResponse(rw, &graphql.Result{Errors: errs})
+		return
+	}
+	utilities.WriteResponse(rw, th.executeGraphQL(r.Context(), request))
+}
+
+func readGraphQLRequest(rw http.ResponseWriter, r *http.Request) (*graphQLRequest, error) {
+	request := new(graphQLRequest)
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		request.Query = query.Get("query")
+		request.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				return nil, fmt.Errorf("malformed variables: %s", err.Error())
+			}
+		}
+		return request, nil
+	}
+	body := http.MaxBytesReader(rw, r.Body, maxGraphQLRequest)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/graphql" {
+		query, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading request: %s", err.Error())
+		}
+		request.Query = string(query)
+		return request, nil
+	}
+	if err := json.NewDecoder(body).Decode(request); err != nil {
+		return nil, fmt.Errorf("error while decoding request: %s", err.Error())
+	}
+	return request, nil
+}
+
+// prepareGraphQL parses and validates a request and holds it against the depth and complexity
+// limits before anything is resolved. It returns the type of the operation to run.
+func (th *TodoHandler) prepareGraphQL(request *graphQLRequest) (string, []gqlerrors.FormattedError) {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(request.Query),
+		Name: "GraphQL request",
+	})})
+	if err != nil {
+		return "", gqlerrors.FormatErrors(err)
+	}
+	validation := graphql.ValidateDocument(&th.graphQL, document, nil)
+	if !validation.IsValid {
+		return "", validation.Errors
+	}
+	operation := findOperation(document, request.OperationName)
+	if operation == nil {
+		return "", gqlerrors.FormatErrors(fmt.Errorf("unknown operation %q", request.OperationName))
+	}
+	err = checkQueryLimits(&th.graphQL, document, operation, request.Variables, th.GraphQLConfig.MaxDepth, th.GraphQLConfig.MaxComplexity)
+	if err != nil {
+		return "", gqlerrors.FormatErrors(err)
+	}
+	return operation.Operation, nil
+}
+
+func findOperation(document *ast.Document, operationName string) *ast.OperationDefinition {
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if ok && (operationName == "" || (operation.Name != nil && operation.Name.Value == operationName)) {
+			return operation
+		}
+	}
+	return nil
+}
+
+func (th *TodoHandler) executeGraphQL(ctx context.Context, request *graphQLRequest) *graphql.Result {
+	return graphql.Do(graphql.Params{
+		Schema:         th.graphQL,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        ctx,
+	})
+}
+
+// graphql-transport-ws messages
+type graphQLMessage struct {
+	Id      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// close codes defined by the graphql-transport-ws protocol
+const (
+	graphQLBadRequest       = 4400
+	graphQLUnauthorized     = 4401
+	graphQLInitTimedOut     = 4408
+	graphQLDuplicateId      = 4409
+	graphQLTooManyInitCalls = 4429
+)
+
+type graphQLSession struct {
+	th         *TodoHandler
+	conn       *websocket.Conn
+	ctx        context.Context
+	writeMu    sync.Mutex
+	mu         sync.Mutex
+	operations map[string]*graphQLOperation
+}
+
+type graphQLOperation struct {
+	cancel context.CancelFunc
+}
+
+// graphQLSocket runs a graphql-transport-ws session. Authentication happens on the upgrade request,
+// like /ws, so the connection_init payload is not inspected.
+func (th *TodoHandler) graphQLSocket(rw http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{graphQLSubprotocol}, CheckOrigin: th.checkOrigin}
+	conn, err := upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	session := &graphQLSession{th: th, conn: conn, ctx: ctx, operations: map[string]*graphQLOperation{}}
+	defer func() {
+		cancel()
+		conn.Close()
+	}()
+	if conn.Subprotocol() != graphQLSubprotocol {
+		session.close(graphQLBadRequest, "unsupported subprotocol, use "+graphQLSubprotocol)
+		return
+	}
+	session.run()
+}
+
+func (s *graphQLSession) run() {
+	s.conn.SetReadLimit(wsMaxMessage)
+	s.conn.SetReadDeadline(time.Now().Add(graphQLInitTimeout))
+	initialised := false
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if !initialised {
+				s.close(graphQLInitTimedOut, "connection initialisation timeout")
+			}
+			return
+		}
+		message := new(graphQLMessage)
+		if err = json.Unmarshal(data, message); err != nil {
+			s.close(graphQLBadRequest, "invalid message")
+			return
+		}
+		switch message.Type {
+		case "connection_init":
+			if initialised {
+				s.close(graphQLTooManyInitCalls, "too many initialisation requests")
+				return
+			}
+			initialised = true
+			s.keepAlive()
+			s.write(graphQLMessage{Type: "connection_ack"})
+		case "ping":
+			s.write(graphQLMessage{Type: "pong"})
+		case "pong":
+		case "subscribe":
+			if !initialised {
+				s.close(graphQLUnauthorized, "unauthorized")
+				return
+			}
+			request := new(graphQLRequest)
+			if message.Id == "" || json.Unmarshal(message.Payload, request) != nil {
+				s.close(graphQLBadRequest, "invalid subscribe message")
+				return
+			}
+			if !s.start(message.Id, request) {
+				return
+			}
+		case "complete":
+			s.stop(message.Id)
+		default:
+			s.close(graphQLBadRequest, fmt.Sprintf("unknown message type %q", message.Type))
+			return
+		}
+	}
+}
+
+// keepAlive replaces the initialisation deadline with ping based liveness checks
+func (s *graphQLSession) keepAlive() {
+	s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	go func() {
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ping.C:
+				if s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)) != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (s *graphQLSession) start(id string, request *graphQLRequest) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.operations[id]; exists {
+		s.close(graphQLDuplicateId, fmt.Sprintf("subscriber for %s already exists", id))
+		return false
+	}
+	if len(s.operations) >= graphQLMaxOperations {
+		s.sendErrors(id, gqlerrors.FormatErrors(fmt.Errorf("at most %d operations may run on a connection", graphQLMaxOperations)))
+		return true
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	operation := &graphQLOperation{cancel: cancel}
+	s.operations[id] = operation
+	go s.execute(ctx, id, operation, request)
+	return true
+}
+
+// stop cancels an operation the client completed
+func (s *graphQLSession) stop(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if operation, ok := s.operations[id]; ok {
+		operation.cancel()
+		delete(s.operations, id)
+	}
+}
+
+// finish forgets an operation that ended on its own, unless the client has already reused its id
+func (s *graphQLSession) finish(id string, operation *graphQLOperation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	operation.cancel()
+	if s.operations[id] == operation {
+		delete(s.operations, id)
+	}
+}
+
+func (s *graphQLSession) execute(ctx context.Context, id string, operation *graphQLOperation, request *graphQLRequest) {
+	defer s.finish(id, operation)
+	operationType, errs := s.th.prepareGraphQL(request)
+	if len(errs) > 0 {
+		s.sendErrors(id, errs)
+		return
+	}
+	if operationType != ast.OperationTypeSubscription {
+		result := s.th.executeGraphQL(ctx, request)
+		if ctx.Err() == nil {
+			s.next(id, result)
+			s.write(graphQLMessage{Id: id, Type: "complete"})
+		}
+		return
+	}
+	results := graphql.Subscribe(graphql.Params{
+		Schema:         s.th.graphQL,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        ctx,
+	})
+	// the channel has to be drained until graphql closes it, even once nothing is sent any more
+	for result := range results {
+		if ctx.Err() == nil {
+			s.next(id, result)
+		}
+	}
+	if ctx.Err() == nil {
+		s.write(graphQLMessage{Id: id, Type: "complete"})
+	}
+}
+
+func (s *graphQLSession) next(id string, result *graphql.Result) {
+	payload, _ := json.Marshal(result)
+	s.write(graphQLMessage{Id: id, Type: "next", Payload: payload})
+}
+
+func (s *graphQLSession) sendErrors(id string, errs []gqlerrors.FormattedError) {
+	payload, _ := json.Marshal(errs)
+	s.write(graphQLMessage{Id: id, Type: "error", Payload: payload})
+}
+
+func (s *graphQLSession) write(message graphQLMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return s.conn.WriteJSON(message)
+}
+
+func (s *graphQLSession) close(code int, reason string) {
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+}
@@ -0,0 +1,113 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"todos/models"
+	"todos/router/routertest"
+)
+
+type graphQLResult struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// graphQL posts query to /graphql and returns the result
+func graphQL(t *testing.T, s *routertest.Server, token string, query string, variables map[string]any) *graphQLResult {
+	t.Helper()
+	result := new(graphQLResult)
+	s.Call(t, http.MethodPost, "/graphql", token, map[string]any{"query": query, "variables": variables}, http.StatusOK, result)
+	return result
+}
+
+// rejected reports whether the result failed with an error containing message and resolved nothing
+func (r *graphQLResult) rejected(message string) bool {
+	return len(r.Errors) > 0 && strings.Contains(r.Errors[0].Message, message) && (len(r.Data) == 0 || string(r.Data) == "null")
+}
+
+func TestGraphQLDepthLimit(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	todo := createTodo(t, s, token, &models.Todo{Name: "pay rent"})
+	// the router allows a depth of 8: todo, six parents and id fit, a seventh parent does not
+	nested := func(parents int) string {
+		return `{ todo(id: "` + todo.Id + `") { ` + strings.Repeat("parent { ", parents) + "id" + strings.Repeat(" }", parents) + " } }"
+	}
+	if result := graphQL(t, s, token, nested(6), nil); len(result.Errors) != 0 {
+		t.Fatalf("a query at the depth limit failed: %+v", result.Errors)
+	}
+	if result := graphQL(t, s, token, nested(7), nil); !result.rejected("nested 9 levels deep") {
+		t.Fatalf("a query over the depth limit = %s %+v", result.Data, result.Errors)
+	}
+}
+
+func TestGraphQLComplexityLimit(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	// each node costs 111 with two levels of subtasks, so first: 10 stays under the router's 2000
+	// and first: 20 goes over it, whether first is written in the query or passed as a variable
+	const literal = `{ todos(first: %d) { nodes { subtasks { subtasks { name } } } } }`
+	const variable = `query ($first: Int) { todos(first: $first) { nodes { subtasks { subtasks { name } } } } }`
+	if result := graphQL(t, s, token, fmt.Sprintf(literal, 10), nil); len(result.Errors) != 0 {
+		t.Fatalf("first: 10 failed: %+v", result.Errors)
+	}
+	if result := graphQL(t, s, token, fmt.Sprintf(literal, 20), nil); !result.rejected("complexity 2222 exceeds") {
+		t.Fatalf("first: 20 = %s %+v", result.Data, result.Errors)
+	}
+	if result := graphQL(t, s, token, variable, map[string]any{"first": 10}); len(result.Errors) != 0 {
+		t.Fatalf("$first = 10 failed: %+v", result.Errors)
+	}
+	if result := graphQL(t, s, token, variable, map[string]any{"first": 20}); !result.rejected("complexity 2222 exceeds") {
+		t.Fatalf("$first = 20 = %s %+v", result.Data, result.Errors)
+	}
+	// without a first argument the default page size is assumed
+	if result := graphQL(t, s, token, variable, nil); len(result.Errors) != 0 {
+		t.Fatalf("the default page size failed: %+v", result.Errors)
+	}
+}
+
+func TestGraphQLFragmentCycle(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	query := `{ todos { nodes { ...a } } }
+		fragment a on Todo { name subtasks { ...b } }
+		fragment b on Todo { id parent { ...a } }`
+	if result := graphQL(t, s, token, query, nil); !result.rejected(`Cannot spread fragment "a" within itself`) {
+		t.Fatalf("a cyclic fragment spread = %s %+v", result.Data, result.Errors)
+	}
+}
+
+func TestGraphQLOperationTransport(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	mutation := `mutation { createTodo(input: {name: "pay rent"}) { id } }`
+	response := s.Call(t, http.MethodGet, "/graphql?query="+url.QueryEscape(mutation), token, nil, http.StatusMethodNotAllowed, nil)
+	if allow := response.Header.Get("Allow"); allow != http.MethodPost {
+		t.Errorf("Allow is %q, want POST", allow)
+	}
+	if todos := listTodos(t, s, token); len(todos) != 0 {
+		t.Fatalf("a mutation sent with GET created %d todos", len(todos))
+	}
+	if result := graphQL(t, s, token, mutation, nil); len(result.Errors) != 0 {
+		t.Fatalf("the mutation sent with POST failed: %+v", result.Errors)
+	}
+
+	subscription := `subscription { todoEvents { type } }`
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		var result graphQLResult
+		if method == http.MethodGet {
+			s.Call(t, method, "/graphql?query="+url.QueryEscape(subscription), token, nil, http.StatusOK, &result)
+		} else {
+			s.Call(t, method, "/graphql", token, map[string]string{"query": subscription}, http.StatusOK, &result)
+		}
+		if !result.rejected("only served over a WebSocket") {
+			t.Errorf("a subscription sent with %s = %s %+v", method, result.Data, result.Errors)
+		}
+	}
+}
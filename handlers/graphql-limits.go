@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"todos/pagination"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// unpaginatedListSize is the number of items assumed for list fields without a first argument,
+// such as subtasks, when estimating a query's cost
+const unpaginatedListSize = pagination.DefaultLimit
+
+type queryCost struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]any
+}
+
+// checkQueryLimits rejects an operation nested deeper than maxDepth or expected to resolve more
+// than maxComplexity fields. Every field costs one, and the fields below a list are counted once
+// per item it may return: its first argument, or unpaginatedListSize. Introspection is not counted.
+func checkQueryLimits(schema *graphql.Schema, document *ast.Document, operation *ast.OperationDefinition, variables map[string]any, maxDepth int, maxComplexity int) error {
+	cost := &queryCost{schema: schema, fragments: map[string]*ast.FragmentDefinition{}, variables: variables}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			cost.fragments[fragment.Name.Value] = fragment
+		}
+	}
+	var root *graphql.Object
+	switch operation.Operation {
+	case ast.OperationTypeMutation:
+		root = schema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = schema.SubscriptionType()
+	default:
+		root = schema.QueryType()
+	}
+	depth, complexity := cost.selections(root, operation.SelectionSet, 0, map[string]bool{})
+	if depth > maxDepth {
+		return fmt.Errorf("query is nested %d levels deep, the limit is %d", depth, maxDepth)
+	}
+	if complexity > maxComplexity {
+		return fmt.Errorf("query complexity %d exceeds the limit of %d", complexity, maxComplexity)
+	}
+	return nil
+}
+
+// selections returns the depth and complexity of a selection set on parent. pageSize is the first
+// argument of a connection whose nodes list is below, visiting holds the fragments being expanded,
+// so that a cyclic spread is not followed forever.
+func (c *queryCost) selections(parent graphql.Type, set *ast.SelectionSet, pageSize int, visiting map[string]bool) (int, int) {
+	if set == nil {
+		return 0, 0
+	}
+	maxDepth, complexity := 0, 0
+	for _, selection := range set.Selections {
+		var depth, cost int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			depth, cost = c.field(parent, selection, pageSize, visiting)
+		case *ast.InlineFragment:
+			typ := parent
+			if selection.TypeCondition != nil {
+				typ = c.schema.Type(selection.TypeCondition.Name.Value)
+			}
+			depth, cost = c.selections(typ, selection.SelectionSet, pageSize, visiting)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, ok := c.fragments[name]
+			if !ok || visiting[name] {
+				continue
+			}
+			visiting[name] = true
+			depth, cost = c.selections(c.schema.Type(fragment.TypeCondition.Name.Value), fragment.SelectionSet, pageSize, visiting)
+			delete(visiting, name)
+		}
+		maxDepth = max(maxDepth, depth)
+		complexity += cost
+	}
+	return maxDepth, complexity
+}
+
+func (c *queryCost) field(parent graphql.Type, field *ast.Field, pageSize int, visiting map[string]bool) (int, int) {
+	if strings.HasPrefix(field.Name.Value, "__") {
+		return 0, 0
+	}
+	var definition *graphql.FieldDefinition
+	switch parent := parent.(type) {
+	case *graphql.Object:
+		definition = parent.Fields()[field.Name.Value]
+	case *graphql.Interface:
+		definition = parent.Fields()[field.Name.Value]
+	}
+	if definition == nil {
+		return 1, 1
+	}
+	childPageSize := 0
+	for _, argument := range definition.Args {
+		if argument.Name() == "first" {
+			childPageSize = c.argument(field, "first")
+		}
+	}
+	typ, list := unwrap(definition.Type)
+	items := 1
+	if list && field.SelectionSet != nil {
+		switch {
+		case childPageSize > 0:
+			items, childPageSize = childPageSize, 0
+		case pageSize > 0:
+			items = pageSize
+		default:
+			items = unpaginatedListSize
+		}
+	}
+	depth, cost := c.selections(typ, field.SelectionSet, childPageSize, visiting)
+	return depth + 1, 1 + items*cost
+}
+
+// unwrap strips the non-null and list wrappers off typ and reports whether it was a list
+func unwrap(typ graphql.Type) (graphql.Type, bool) {
+	list := false
+	for {
+		switch wrapped := typ.(type) {
+		case *graphql.NonNull:
+			typ = wrapped.OfType
+		case *graphql.List:
+			typ, list = wrapped.OfType, true
+		default:
+			return typ, list
+		}
+	}
+}
+
+// argument resolves an integer argument given literally or through a variable, clamped the way
+// pagination.ParseRequest clamps limit; an omitted argument counts as the default page size
+func (c *queryCost) argument(field *ast.Field, name string) int {
+	n := pagination.DefaultLimit
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != name {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			n, _ = strconv.Atoi(value.Value)
+		case *ast.Variable:
+			switch v := c.variables[value.Name.Value].(type) {
+			case float64:
+				n = int(v)
+			case int:
+				n = v
+			}
+		}
+	}
+	return min(max(n, 1), pagination.MaxLimit)
+}
@@ -0,0 +1,588 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"todos/events"
+	"todos/jsonpatch"
+	"todos/models"
+	"todos/pagination"
+	"todos/quickadd"
+	"todos/repository"
+	validateapp "todos/validator"
+
+	"github.com/graphql-go/graphql"
+)
+
+// graphQLError carries the HTTP status the REST API would answer with, so clients can tell a
+// missing todo from a version conflict without parsing messages.
+type graphQLError struct {
+	status int
+	err    error
+}
+
+func (e *graphQLError) Error() string {
+	return e.err.Error()
+}
+
+func (e *graphQLError) Extensions() map[string]any {
+	return map[string]any{
+		"code":   strings.ToUpper(strings.ReplaceAll(http.StatusText(e.status), " ", "_")),
+		"status": e.status,
+	}
+}
+
+func gqlError(status int, format string, args ...any) error {
+	return &graphQLError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// todoConnection is a page of todos; the total is only counted when a client selects it
+type todoConnection struct {
+	todos  []*models.GetTodoResponse
+	page   *pagination.Request
+	more   bool
+	filter repository.TodoFilter
+	userId string
+}
+
+func todoCursor(todo *models.GetTodoResponse) string {
+	return (&pagination.Cursor{CreatedAt: todo.CreatedAt, Id: todo.Id}).Encode()
+}
+
+func graphQLUserId(ctx context.Context) string {
+	return ctx.Value("userId").(string)
+}
+
+// fields of a todo updateTodo can unset, by the name they have in a merge patch
+var clearableFieldType = graphql.NewEnum(graphql.EnumConfig{
+	Name: "ClearableTodoField",
+	Values: graphql.EnumValueConfigMap{
+		"DESCRIPTION": {Value: "description"},
+		"LABELS":      {Value: "labels"},
+		"RECURRENCE":  {Value: "recurrence"},
+		"DUE_AT":      {Value: "dueAt"},
+		"PARENT_ID":   {Value: "parentId"},
+	},
+})
+
+var statusType = graphql.NewEnum(graphql.EnumConfig{
+	Name: "Status",
+	Values: graphql.EnumValueConfigMap{
+		"PENDING":     {Value: models.Pending},
+		"IN_PROGRESS": {Value: models.InProgess},
+		"COMPLETED":   {Value: models.Completed},
+	},
+})
+
+var priorityType = graphql.NewEnum(graphql.EnumConfig{
+	Name: "Priority",
+	Values: graphql.EnumValueConfigMap{
+		"NONE":   {Value: models.NoPriority},
+		"LOW":    {Value: models.LowPriority},
+		"MEDIUM": {Value: models.MediumPriority},
+		"HIGH":   {Value: models.HighPriority},
+	},
+})
+
+var searchModeType = graphql.NewEnum(graphql.EnumConfig{
+	Name: "SearchMode",
+	Values: graphql.EnumValueConfigMap{
+		"FULLTEXT": {Value: repository.SearchModeFullText},
+		"FUZZY":    {Value: repository.SearchModeFuzzy},
+	},
+})
+
+var attachmentType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Attachment",
+	Fields: graphql.Fields{
+		"id":          {Type: graphql.NewNonNull(graphql.ID)},
+		"filename":    {Type: graphql.NewNonNull(graphql.String)},
+		"contentType": {Type: graphql.NewNonNull(graphql.String)},
+		"size":        {Type: graphql.NewNonNull(graphql.Int)},
+		"createdAt":   {Type: graphql.NewNonNull(graphql.DateTime)},
+	},
+})
+
+var settingsType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Settings",
+	Fields: graphql.Fields{
+		"searchLanguage": {Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+var pageInfoType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "PageInfo",
+	Fields: graphql.Fields{
+		"hasNextPage":     {Type: graphql.NewNonNull(graphql.Boolean)},
+		"hasPreviousPage": {Type: graphql.NewNonNull(graphql.Boolean)},
+		"startCursor":     {Type: graphql.String},
+		"endCursor":       {Type: graphql.String},
+	},
+})
+
+func todoInputFields(required bool) graphql.InputObjectConfigFieldMap {
+	name := graphql.Input(graphql.String)
+	if required {
+		name = graphql.NewNonNull(graphql.String)
+	}
+	return graphql.InputObjectConfigFieldMap{
+		"name":        {Type: name},
+		"description": {Type: graphql.String},
+		"status":      {Type: statusType},
+		"priority":    {Type: priorityType},
+		"labels":      {Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+		"recurrence":  {Type: graphql.String},
+		"dueAt":       {Type: graphql.DateTime},
+	}
+}
+
+var todoInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:   "TodoInput",
+	Fields: todoInputFields(true),
+})
+
+var todoPatchType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "TodoPatch",
+	Fields: func() graphql.InputObjectConfigFieldMap {
+		fields := todoInputFields(false)
+		fields["parentId"] = &graphql.InputObjectFieldConfig{Type: graphql.ID}
+		return fields
+	}(),
+})
+
+// decodeInput converts a GraphQL input object into v through its JSON form, so the REST request
+// models and their validation apply unchanged
+func decodeInput(input any, v any) error {
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func pageArgument(args map[string]any) (*pagination.Request, error) {
+	page := &pagination.Request{Limit: pagination.DefaultLimit}
+	if first, ok := args["first"].(int); ok {
+		if first < 1 {
+			return nil, gqlError(http.StatusBadRequest, "first must be positive")
+		}
+		page.Limit = min(first, pagination.MaxLimit)
+	}
+	if after, ok := args["after"].(string); ok && after != "" {
+		cursor, err := pagination.DecodeCursor(after)
+		if err != nil {
+			return nil, gqlError(http.StatusBadRequest, "%s", err.Error())
+		}
+		page.Cursor = cursor
+	}
+	return page, nil
+}
+
+func versionsArgument(args map[string]any) []int {
+	if version, ok := args["version"].(int); ok {
+		return []int{version}
+	}
+	return nil
+}
+
+// graphQLSchema builds the schema served at /graphql. Resolvers go through the same repository
+// calls, validation and event publishing as the REST handlers.
+func (th *TodoHandler) graphQLSchema() (graphql.Schema, error) {
+	var todoType *graphql.Object
+	todoType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Todo",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":          {Type: graphql.NewNonNull(graphql.ID)},
+				"parentId":    {Type: graphql.ID},
+				"name":        {Type: graphql.NewNonNull(graphql.String)},
+				"description": {Type: graphql.NewNonNull(graphql.String)},
+				"status":      {Type: graphql.NewNonNull(statusType)},
+				"priority":    {Type: graphql.NewNonNull(priorityType)},
+				"labels":      {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+				"recurrence":  {Type: graphql.String},
+				"dueAt":       {Type: graphql.DateTime},
+				"createdAt":   {Type: graphql.NewNonNull(graphql.DateTime)},
+				"version":     {Type: graphql.NewNonNull(graphql.Int)},
+				"parent": {
+					Type: todoType,
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						todo := p.Source.(*models.GetTodoResponse)
+						if todo.ParentId == nil {
+							return nil, nil
+						}
+						return th.Todos.GetTodoByID(p.Context, *todo.ParentId, graphQLUserId(p.Context))
+					},
+				},
+				"subtasks": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(todoType))),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						return th.Todos.ListSubtasks(p.Context, p.Source.(*models.GetTodoResponse).Id, graphQLUserId(p.Context))
+					},
+				},
+				"attachments": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(attachmentType))),
+					Resolve: func(p graphql.ResolveParams) (any, error) {
+						// only the postgres store keeps attachments
+						if th.DB == nil {
+							return []*models.Attachment{}, nil
+						}
+						return repository.ListAttachments(p.Context, th.DB, p.Source.(*models.GetTodoResponse).Id, graphQLUserId(p.Context))
+					},
+				},
+			}
+		}),
+	})
+
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TodoConnection",
+		Fields: graphql.Fields{
+			"nodes": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(todoType))),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source.(*todoConnection).todos, nil
+				},
+			},
+			"pageInfo": {
+				Type: graphql.NewNonNull(pageInfoType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					connection := p.Source.(*todoConnection)
+					hasNext, hasPrev := connection.page.Neighbours(connection.more)
+					info := map[string]any{"hasNextPage": hasNext, "hasPreviousPage": hasPrev}
+					if len(connection.todos) > 0 {
+						info["startCursor"] = todoCursor(connection.todos[0])
+						info["endCursor"] = todoCursor(connection.todos[len(connection.todos)-1])
+					}
+					return info, nil
+				},
+			},
+			"totalCount": {
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					connection := p.Source.(*todoConnection)
+					return th.Todos.CountTodos(p.Context, connection.userId, connection.filter)
+				},
+			},
+		},
+	})
+
+	todosField := &graphql.Field{
+		Type: graphql.NewNonNull(connectionType),
+		Args: graphql.FieldConfigArgument{
+			"first":  {Type: graphql.Int, DefaultValue: pagination.DefaultLimit},
+			"after":  {Type: graphql.String},
+			"status": {Type: statusType},
+			"label":  {Type: graphql.String},
+		},
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			page, err := pageArgument(p.Args)
+			if err != nil {
+				return nil, err
+			}
+			filter := repository.TodoFilter{}
+			if status, ok := p.Args["status"].(models.Status); ok {
+				filter.Status = &status
+			}
+			if label, ok := p.Args["label"].(string); ok {
+				filter.Label = label
+			}
+			userId := graphQLUserId(p.Context)
+			todos, more, err := th.Todos.ListTodos(p.Context, page, userId, filter)
+			if err != nil {
+				return nil, err
+			}
+			return &todoConnection{todos: todos, page: page, more: more, filter: filter, userId: userId}, nil
+		},
+	}
+
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":       {Type: graphql.NewNonNull(graphql.ID)},
+			"username": {Type: graphql.NewNonNull(graphql.String)},
+			"email":    {Type: graphql.NewNonNull(graphql.String)},
+			"joinedAt": {
+				Type: graphql.NewNonNull(graphql.DateTime),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source.(*models.User).CreatedAt, nil
+				},
+			},
+			"settings": {
+				Type: graphql.NewNonNull(settingsType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return th.Users.GetUserSettings(p.Context, p.Source.(*models.User).Id)
+				},
+			},
+			"todos": todosField,
+		},
+	})
+
+	searchResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SearchResult",
+		Fields: graphql.Fields{
+			"todo": {
+				Type: graphql.NewNonNull(todoType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return &p.Source.(*models.SearchResult).GetTodoResponse, nil
+				},
+			},
+			"rank":          {Type: graphql.NewNonNull(graphql.Float)},
+			"matchedFields": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+		},
+	})
+
+	eventType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TodoEvent",
+		Fields: graphql.Fields{
+			"id":     {Type: graphql.NewNonNull(graphql.ID)},
+			"type":   {Type: graphql.NewNonNull(graphql.String)},
+			"todoId": {Type: graphql.ID},
+			"todo": {
+				Type: todoType,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if todo := p.Source.(events.Event).Todo; todo != nil {
+						return todo, nil
+					}
+					return nil, nil
+				},
+			},
+			"count": {Type: graphql.Int},
+			"at":    {Type: graphql.NewNonNull(graphql.DateTime)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": {
+				Type: graphql.NewNonNull(userType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return th.Users.FetchUserByID(p.Context, graphQLUserId(p.Context))
+				},
+			},
+			"todo": {
+				Type: todoType,
+				Args: graphql.FieldConfigArgument{
+					"id": {Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id := p.Args["id"].(string)
+					if err := validateapp.ValidateVar(id, "uuid"); err != nil {
+						return nil, nil
+					}
+					return th.Todos.GetTodoByID(p.Context, id, graphQLUserId(p.Context))
+				},
+			},
+			"todos": todosField,
+			"search": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(searchResultType))),
+				Args: graphql.FieldConfigArgument{
+					"text":  {Type: graphql.NewNonNull(graphql.String)},
+					"mode":  {Type: searchModeType, DefaultValue: repository.SearchModeFullText},
+					"first": {Type: graphql.Int, DefaultValue: pagination.DefaultLimit},
+					"after": {Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					text := p.Args["text"].(string)
+					if strings.TrimSpace(text) == "" {
+						return nil, gqlError(http.StatusBadRequest, "text must not be empty")
+					}
+					page, err := pageArgument(p.Args)
+					if err != nil {
+						return nil, err
+					}
+					mode, _ := p.Args["mode"].(string)
+					options := repository.SearchOptions{Text: text, Mode: mode, Prefix: true, Page: page}
+					results, _, err := th.Todos.SearchTodo(p.Context, options, graphQLUserId(p.Context))
+					return results, err
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createTodo": {
+				Type: graphql.NewNonNull(todoType),
+				Args: graphql.FieldConfigArgument{
+					"input": {Type: graphql.NewNonNull(todoInputType)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					todo := new(models.Todo)
+					if err := decodeInput(p.Args["input"], todo); err != nil {
+						return nil, gqlError(http.StatusBadRequest, "error while reading the input: %s", err.Error())
+					}
+					return th.graphQLCreate(p.Context, todo)
+				},
+			},
+			"quickAddTodo": {
+				Type: graphql.NewNonNull(todoType),
+				Args: graphql.FieldConfigArgument{
+					"text":     {Type: graphql.NewNonNull(graphql.String)},
+					"timezone": {Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					location := time.UTC
+					if timezone, ok := p.Args["timezone"].(string); ok && timezone != "" {
+						var err error
+						if location, err = time.LoadLocation(timezone); err != nil {
+							return nil, gqlError(http.StatusBadRequest, "unknown timezone %s", timezone)
+						}
+					}
+					result, err := quickadd.Parse(p.Args["text"].(string), time.Now().In(location))
+					if err != nil {
+						return nil, gqlError(http.StatusBadRequest, "error while parsing the input: %s", err.Error())
+					}
+					return th.graphQLCreate(p.Context, result.Todo)
+				},
+			},
+			"updateTodo": {
+				Type: graphql.NewNonNull(todoType),
+				Args: graphql.FieldConfigArgument{
+					"id":      {Type: graphql.NewNonNull(graphql.ID)},
+					"input":   {Type: graphql.NewNonNull(todoPatchType)},
+					"unset":   {Type: graphql.NewList(graphql.NewNonNull(clearableFieldType))},
+					"version": {Type: graphql.Int},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					// GraphQL drops null input fields, so clearing a field is asked for through unset
+					patch := p.Args["input"].(map[string]any)
+					unset, _ := p.Args["unset"].([]any)
+					for _, field := range unset {
+						patch[field.(string)] = nil
+					}
+					body, err := json.Marshal(patch)
+					if err != nil {
+						return nil, gqlError(http.StatusBadRequest, "error while reading the input: %s", err.Error())
+					}
+					updated, status, err := th.patchTodo(p.Context, p.Args["id"].(string), graphQLUserId(p.Context), body, jsonpatch.MergePatch, versionsArgument(p.Args))
+					if err != nil {
+						return nil, &graphQLError{status: status, err: err}
+					}
+					return updated, nil
+				},
+			},
+			"deleteTodo": {
+				Type: graphql.NewNonNull(graphql.ID),
+				Args: graphql.FieldConfigArgument{
+					"id":      {Type: graphql.NewNonNull(graphql.ID)},
+					"version": {Type: graphql.Int},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id := p.Args["id"].(string)
+					userId := graphQLUserId(p.Context)
+					deleted, err := th.Todos.DeleteTodo(p.Context, id, userId, versionsArgument(p.Args))
+					switch {
+					case errors.Is(err, repository.ErrTodoNotFound):
+						return nil, gqlError(http.StatusNotFound, "There is no todo with Id: %s", id)
+					case errors.Is(err, repository.ErrVersionMismatch):
+						return nil, gqlError(http.StatusPreconditionFailed, "%s", err.Error())
+					case err != nil:
+						return nil, err
+					}
+					th.publishDeleted(userId, deleted)
+					return id, nil
+				},
+			},
+			"updateSettings": {
+				Type: graphql.NewNonNull(settingsType),
+				Args: graphql.FieldConfigArgument{
+					"searchLanguage": {Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					settings := &models.UserSettings{SearchLanguage: p.Args["searchLanguage"].(string)}
+					err := th.Users.UpdateSearchLanguage(p.Context, graphQLUserId(p.Context), settings.SearchLanguage)
+					if errors.Is(err, repository.ErrUnknownSearchLanguage) {
+						return nil, gqlError(http.StatusBadRequest, "%s: %s", err.Error(), settings.SearchLanguage)
+					}
+					return settings, err
+				},
+			},
+		},
+	})
+
+	subscription := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"todoEvents": {
+				Type:        graphql.NewNonNull(eventType),
+				Description: "Changes to the user's todos as they happen, optionally limited to some event types.",
+				Args: graphql.FieldConfigArgument{
+					"types": {Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+				},
+				Subscribe: func(p graphql.ResolveParams) (any, error) {
+					types := events.TodoTypes
+					if requested, ok := p.Args["types"].([]any); ok {
+						types = nil
+						for _, eventType := range requested {
+							if !slices.Contains(events.TodoTypes, eventType.(string)) {
+								return nil, gqlError(http.StatusBadRequest, "unknown event type %s", eventType)
+							}
+							types = append(types, eventType.(string))
+						}
+					}
+					return th.graphQLEvents(p.Context, types), nil
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:        query,
+		Mutation:     mutation,
+		Subscription: subscription,
+	})
+}
+
+func (th *TodoHandler) graphQLCreate(ctx context.Context, todo *models.Todo) (*models.GetTodoResponse, error) {
+	if err := validateapp.ValidateStruct(todo); err != nil {
+		return nil, gqlError(http.StatusBadRequest, "error while validating the input: %s", err.Error())
+	}
+	userId := graphQLUserId(ctx)
+	created, err := th.Todos.CreateTodo(ctx, todo, userId)
+	if err != nil {
+		return nil, err
+	}
+	th.publish(events.TodoCreated, userId, created)
+	return created, nil
+}
+
+// graphQLEvents feeds the user's hub events of the given types to a subscription until ctx ends or
+// the hub drops the subscriber; the client then subscribes again and refetches what it shows.
+func (th *TodoHandler) graphQLEvents(ctx context.Context, types []string) chan any {
+	subscription, _, _ := th.Events.Subscribe(graphQLUserId(ctx), "")
+	out := make(chan any)
+	go func() {
+		defer close(out)
+		defer subscription.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-subscription.C:
+				if !ok {
+					return
+				}
+				if !slices.Contains(types, event.Type) {
+					continue
+				}
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"todos/events"
+	"todos/jsonpatch"
+	"todos/models"
+	"todos/pagination"
+	"todos/repository"
+	"todos/todospb"
+	validateapp "todos/validator"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RegisterGRPC adds the todo and user services to server. They share the repository calls,
+// validation and event publishing of the REST handlers.
+func (th *TodoHandler) RegisterGRPC(server *grpc.Server) {
+	todospb.RegisterTodoServiceServer(server, &todoService{th: th})
+	todospb.RegisterUserServiceServer(server, &userService{th: th})
+}
+
+type todoService struct {
+	todospb.UnimplementedTodoServiceServer
+	th *TodoHandler
+}
+
+type userService struct {
+	todospb.UnimplementedUserServiceServer
+	th *TodoHandler
+}
+
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:           codes.InvalidArgument,
+	http.StatusNotFound:             codes.NotFound,
+	http.StatusConflict:             codes.Aborted,
+	http.StatusPreconditionFailed:   codes.Aborted,
+	http.StatusUnsupportedMediaType: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:  codes.InvalidArgument,
+}
+
+// grpcError turns an error the REST handlers would answer with httpStatus into a gRPC status
+func grpcError(httpStatus int, err error) error {
+	code, ok := grpcCodes[httpStatus]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+func grpcUserId(ctx context.Context) string {
+	return ctx.Value("userId").(string)
+}
+
+func todoMessage(todo *models.GetTodoResponse) *todospb.Todo {
+	message := &todospb.Todo{
+		Id:          todo.Id,
+		Name:        todo.Name,
+		Description: todo.Description,
+		Status:      todospb.Status(todo.TaskStatus),
+		Priority:    todospb.Priority(todo.Priority),
+		Labels:      todo.Labels,
+		Recurrence:  todo.Recurrence,
+		CreateTime:  timestamppb.New(todo.CreatedAt),
+		Version:     int32(todo.Version),
+	}
+	if todo.ParentId != nil {
+		message.ParentId = *todo.ParentId
+	}
+	if todo.DueAt != nil {
+		message.DueAt = timestamppb.New(*todo.DueAt)
+	}
+	return message
+}
+
+func grpcPage(pageSize int32, pageToken string) (*pagination.Request, error) {
+	if pageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+	page := &pagination.Request{Limit: pagination.DefaultLimit}
+	if pageSize > 0 {
+		page.Limit = min(int(pageSize), pagination.MaxLimit)
+	}
+	if pageToken != "" {
+		cursor, err := pagination.DecodeCursor(pageToken)
+		if err != nil || cursor.Backward {
+			return nil, status.Error(codes.InvalidArgument, "malformed page_token")
+		}
+		page.Cursor = cursor
+	}
+	return page, nil
+}
+
+func (s *todoService) CreateTodo(ctx context.Context, req *todospb.CreateTodoRequest) (*todospb.Todo, error) {
+	message := req.GetTodo()
+	if message == nil {
+		return nil, status.Error(codes.InvalidArgument, "todo is required")
+	}
+	todo := &models.Todo{
+		Name:        message.Name,
+		Description: message.Description,
+		TaskStatus:  models.Status(message.Status),
+		Priority:    models.Priority(message.Priority),
+		Labels:      message.Labels,
+		Recurrence:  message.Recurrence,
+	}
+	if message.DueAt != nil {
+		dueAt := message.DueAt.AsTime()
+		todo.DueAt = &dueAt
+	}
+	if err := validateapp.ValidateStruct(todo); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "error while validating the input: %s", err.Error())
+	}
+	userId := grpcUserId(ctx)
+	created, err := s.th.Todos.CreateTodo(ctx, todo, userId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error while creating task, at Database layer: %s", err.Error())
+	}
+	s.th.publish(events.TodoCreated, userId, created)
+	return todoMessage(created), nil
+}
+
+func (s *todoService) GetTodo(ctx context.Context, req *todospb.GetTodoRequest) (*todospb.Todo, error) {
+	if err := validateapp.ValidateVar(req.Id, "uuid"); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "todo id must be a uuid: %s", req.Id)
+	}
+	todo, err := s.th.Todos.GetTodoByID(ctx, req.Id, grpcUserId(ctx))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error while fetching todo: %s", err.Error())
+	}
+	if todo == nil {
+		return nil, status.Errorf(codes.NotFound, "There is no todo with Id: %s", req.Id)
+	}
+	return todoMessage(todo), nil
+}
+
+func (s *todoService) ListTodos(ctx context.Context, req *todospb.ListTodosRequest) (*todospb.ListTodosResponse, error) {
+	page, err := grpcPage(req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	filter := repository.TodoFilter{Label: req.Label}
+	if req.Status != nil {
+		todoStatus := models.Status(*req.Status)
+		filter.Status = &todoStatus
+	}
+	todos, more, err := s.th.Todos.ListTodos(ctx, page, grpcUserId(ctx), filter)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Error fetching the todos %s", err.Error())
+	}
+	response := &todospb.ListTodosResponse{}
+	for _, todo := range todos {
+		response.Todos = append(response.Todos, todoMessage(todo))
+	}
+	if more {
+		response.NextPageToken = todoCursor(todos[len(todos)-1])
+	}
+	return response, nil
+}
+
+func (s *todoService) SearchTodos(ctx context.Context, req *todospb.SearchTodosRequest) (*todospb.SearchTodosResponse, error) {
+	if req.Query == "" {
+		return nil, status.Error(codes.InvalidArgument, "query must not be empty")
+	}
+	page, err := grpcPage(req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	options := repository.SearchOptions{Text: req.Query, Mode: repository.SearchModeFullText, Prefix: true, Page: page}
+	if req.Fuzzy {
+		options.Mode = repository.SearchModeFuzzy
+	}
+	results, more, err := s.th.Todos.SearchTodo(ctx, options, grpcUserId(ctx))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Error searching the todos %s", err.Error())
+	}
+	response := &todospb.SearchTodosResponse{}
+	for _, result := range results {
+		response.Todos = append(response.Todos, todoMessage(&result.GetTodoResponse))
+	}
+	if more {
+		last := results[len(results)-1]
+		response.NextPageToken = (&pagination.Cursor{Rank: &last.Rank, CreatedAt: last.CreatedAt, Id: last.Id}).Encode()
+	}
+	return response, nil
+}
+
+// UpdateTodo turns the masked fields into a merge patch, so it behaves exactly like PATCH /todos/{id}
+func (s *todoService) UpdateTodo(ctx context.Context, req *todospb.UpdateTodoRequest) (*todospb.Todo, error) {
+	message := req.GetTodo()
+	if message == nil || message.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "todo with an id is required")
+	}
+	patch := map[string]any{}
+	for _, path := range req.GetUpdateMask().GetPaths() {
+		switch path {
+		case "name":
+			patch["name"] = message.Name
+		case "description":
+			patch["description"] = message.Description
+		case "status":
+			patch["status"] = int(message.Status)
+		case "priority":
+			patch["priority"] = int(message.Priority)
+		case "labels":
+			patch["labels"] = append([]string{}, message.Labels...)
+		case "recurrence":
+			patch["recurrence"] = message.Recurrence
+		case "due_at":
+			patch["dueAt"] = nil
+			if message.DueAt != nil {
+				patch["dueAt"] = message.DueAt.AsTime()
+			}
+		case "parent_id":
+			patch["parentId"] = nil
+			if message.ParentId != "" {
+				patch["parentId"] = message.ParentId
+			}
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "field %q cannot be updated", path)
+		}
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	var versions []int
+	if req.Version != nil {
+		versions = []int{int(*req.Version)}
+	}
+	updated, httpStatus, err := s.th.patchTodo(ctx, message.Id, grpcUserId(ctx), body, jsonpatch.MergePatch, versions)
+	if err != nil {
+		return nil, grpcError(httpStatus, err)
+	}
+	return todoMessage(updated), nil
+}
+
+func (s *todoService) DeleteTodo(ctx context.Context, req *todospb.DeleteTodoRequest) (*emptypb.Empty, error) {
+	var versions []int
+	if req.Version != nil {
+		versions = []int{int(*req.Version)}
+	}
+	userId := grpcUserId(ctx)
+	deleted, err := s.th.Todos.DeleteTodo(ctx, req.Id, userId, versions)
+	switch {
+	case errors.Is(err, repository.ErrTodoNotFound):
+		return nil, status.Errorf(codes.NotFound, "There is no todo with Id: %s", req.Id)
+	case errors.Is(err, repository.ErrVersionMismatch):
+		return nil, status.Error(codes.Aborted, err.Error())
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "error while deleting task, at Database layer: %s", err.Error())
+	}
+	s.th.publishDeleted(userId, deleted)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *userService) GetCurrentUser(ctx context.Context, _ *emptypb.Empty) (*todospb.User, error) {
+	user, err := s.th.Users.FetchUserByID(ctx, grpcUserId(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &todospb.User{Id: user.Id, Username: user.UserName, Email: user.Email, CreateTime: timestamppb.New(user.CreatedAt)}, nil
+}
+
+func (s *userService) GetSettings(ctx context.Context, _ *emptypb.Empty) (*todospb.Settings, error) {
+	settings, err := s.th.Users.GetUserSettings(ctx, grpcUserId(ctx))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error while fetching settings: %s", err.Error())
+	}
+	return &todospb.Settings{SearchLanguage: settings.SearchLanguage}, nil
+}
+
+func (s *userService) UpdateSettings(ctx context.Context, req *todospb.Settings) (*todospb.Settings, error) {
+	settings := &models.UserSettings{SearchLanguage: req.SearchLanguage}
+	if err := validateapp.ValidateStruct(settings); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "error while validating the input: %s", err.Error())
+	}
+	err := s.th.Users.UpdateSearchLanguage(ctx, grpcUserId(ctx), settings.SearchLanguage)
+	if errors.Is(err, repository.ErrUnknownSearchLanguage) {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("%s: %s", err.Error(), settings.SearchLanguage))
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error while updating settings: %s", err.Error())
+	}
+	return req, nil
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"todos/events"
+	"todos/inbound"
+	"todos/models"
+	"todos/repository"
+	"todos/utilities"
+	validateapp "todos/validator"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ingestTokenHeader = "X-Ingest-Token"
+	maxIngestSize     = 1 << 20
+)
+
+// Ingest creates a todo from a payload posted by an external system. It is authenticated by the
+// user's ingest token, taken from the URL or the X-Ingest-Token header, instead of a JWT.
+func (th *TodoHandler) Ingest(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	token := mux.Vars(r)["token"]
+	if token == "" {
+		token = r.Header.Get(ingestTokenHeader)
+	}
+	if token == "" {
+		utilities.WriteError("missing ingest token", rw, http.StatusUnauthorized)
+		return
+	}
+	user_id, err := repository.FetchUserIdByIngestKey(r.Context(), th.DB, inbound.HashToken(token))
+	if errors.Is(err, repository.ErrUnknownIngestKey) {
+		utilities.WriteError(err.Error(), rw, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while checking ingest token: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	request := new(models.IngestRequest)
+	if err = json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxIngestSize)).Decode(request); err != nil {
+		utilities.WriteError(fmt.Sprintf("error while decoding request: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	if err = validateapp.ValidateStruct(request); err != nil {
+		utilities.WriteError(fmt.Sprintf("error while validating the input: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	todo := &models.Todo{Name: request.Title, Description: request.Body, Labels: request.Labels, Priority: request.Priority, DueAt: request.DueAt}
+	if request.Source != "" {
+		todo.Labels = append(todo.Labels, request.Source)
+	}
+	created, err := th.Todos.CreateTodo(r.Context(), todo, user_id)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while creating task, at Database layer: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	th.publish(events.TodoCreated, user_id, created)
+	rw.WriteHeader(http.StatusCreated)
+	utilities.WriteResponse(rw, models.CreateResponse{Message: "Todo created successfully", Id: created.Id})
+}
+
+// RotateIngestKey issues a new ingest token, replacing the old one along with its URL and email address.
+func (th *TodoHandler) RotateIngestKey(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	token, err := inbound.NewToken()
+	if err != nil {
+		utilities.WriteError("error while generating ingest token", rw, http.StatusInternalServerError)
+		return
+	}
+	user_id := r.Context().Value("userId").(string)
+	if err = repository.SaveIngestKey(r.Context(), th.DB, user_id, inbound.HashToken(token)); err != nil {
+		utilities.WriteError(fmt.Sprintf("error while saving ingest token: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	utilities.WriteResponse(rw, models.IngestKeyResponse{
+		Token: token,
+		URL:   "/ingest/" + token,
+		Email: inbound.Address(token, th.InboundConfig.Domain),
+	})
+}
+
+func (th *TodoHandler) RevokeIngestKey(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	user_id := r.Context().Value("userId").(string)
+	if err := repository.DeleteIngestKey(r.Context(), th.DB, user_id); err != nil {
+		utilities.WriteError(fmt.Sprintf("error while deleting ingest token: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (th *TodoHandler) ListAttachments(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	user_id := r.Context().Value("userId").(string)
+	attachments, err := repository.ListAttachments(r.Context(), th.DB, id, user_id)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while fetching attachments: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	utilities.WriteResponse(rw, attachments)
+}
+
+func (th *TodoHandler) DownloadAttachment(rw http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	vars := mux.Vars(r)
+	user_id := r.Context().Value("userId").(string)
+	attachment, err := repository.GetAttachment(r.Context(), th.DB, vars["attachmentId"], vars["id"], user_id)
+	if errors.Is(err, repository.ErrAttachmentNotFound) {
+		utilities.WriteError(fmt.Sprintf("There is no attachment with Id: %s", vars["attachmentId"]), rw, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while fetching attachment: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	// attachments come from arbitrary senders, so they are always downloaded rather than rendered
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	rw.Header().Set("Content-Length", strconv.Itoa(len(attachment.Data)))
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.Write(attachment.Data)
+}
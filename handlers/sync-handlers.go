@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"todos/events"
+	"todos/models"
+	"todos/repository"
+	"todos/utilities"
+	validateapp "todos/validator"
+)
+
+const (
+	defaultSyncLimit = 500
+	maxSyncLimit     = 1000
+)
+
+// GetSync returns every todo change since the since token. Clients start without a token, apply the
+// changes and keep calling with the returned token until hasMore is false. A token that is malformed or
+// stale is answered with a full sync flagged resync, rather than an error the client cannot recover from.
+func (th *TodoHandler) GetSync(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	queryMap := r.URL.Query()
+	var token *repository.SyncToken
+	resync := false
+	if since := queryMap.Get("since"); since != "" {
+		decoded, err := repository.DecodeSyncToken(since)
+		resync = err != nil
+		token = decoded
+	}
+	limit := defaultSyncLimit
+	if value := queryMap.Get("limit"); value != "" {
+		limitInt, err := strconv.Atoi(value)
+		if err != nil || limitInt < 1 {
+			utilities.WriteError(fmt.Sprintf("Invalid limit passed %s", value), rw, http.StatusBadRequest)
+			return
+		}
+		limit = min(limitInt, maxSyncLimit)
+	}
+	user_id := r.Context().Value("userId").(string)
+	changes, next, more, err := repository.GetSyncChanges(r.Context(), th.DB, user_id, token, limit)
+	if errors.Is(err, repository.ErrStaleSyncToken) {
+		resync = true
+		changes, next, more, err = repository.GetSyncChanges(r.Context(), th.DB, user_id, nil, limit)
+	}
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while fetching changes: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	utilities.WriteResponse(rw, models.SyncResponse{Changes: changes, Token: next.Encode(), HasMore: more, Resync: resync})
+}
+
+// PushSync applies a batch of offline changes in order. Each change succeeds or fails on its own and
+// the response reports, per todo, which fields were taken and which conflicted with newer server values.
+func (th *TodoHandler) PushSync(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	request := new(models.SyncPushRequest)
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		utilities.WriteError(fmt.Sprintf("error while decoding request: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	if err := validateapp.ValidateStruct(request); err != nil {
+		utilities.WriteError(fmt.Sprintf("error while validating the input: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	user_id := r.Context().Value("userId").(string)
+	response := models.SyncPushResponse{Results: make([]*models.SyncPushResult, 0, len(request.Changes))}
+	for _, change := range request.Changes {
+		result, err := repository.ApplySyncChange(r.Context(), th.DB, change, user_id)
+		if err != nil {
+			utilities.WriteError(fmt.Sprintf("error while applying change to %s: %s", change.Id, err.Error()), rw, http.StatusInternalServerError)
+			return
+		}
+		response.Results = append(response.Results, result)
+		th.publishSyncResult(result, user_id)
+	}
+	utilities.WriteResponse(rw, response)
+}
+
+func (th *TodoHandler) publishSyncResult(result *models.SyncPushResult, user_id string) {
+	if result.Status != models.SyncApplied && result.Status != models.SyncPartial {
+		return
+	}
+	switch {
+	case slices.Contains(result.Applied, models.SyncDeletedField):
+		th.publishDeleted(user_id, result.Deleted)
+	case result.Todo != nil && len(result.Applied) > 0:
+		eventType := events.TodoUpdated
+		if result.Todo.Version == 1 {
+			eventType = events.TodoCreated
+		} else if result.Todo.TaskStatus == models.Completed && slices.Contains(result.Applied, "status") {
+			eventType = events.TodoCompleted
+		}
+		th.publish(eventType, user_id, result.Todo)
+	}
+}
@@ -1,34 +1,68 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 	"todos/config"
+	"todos/events"
+	"todos/jsonpatch"
 	"todos/mail"
+	"todos/middleware"
 	"todos/models"
+	"todos/pagination"
+	"todos/quickadd"
 	"todos/repository"
 	"todos/utilities"
 	validateapp "todos/validator"
 
 	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
 )
 
 type TodoHandler struct {
+	Todos  repository.TodoStore
+	Users  repository.UserStore
+	Tokens repository.TokenStore
+	// DB is nil unless the stores are postgres, the only backend with sync, webhooks, ingest and attachments
 	DB             *sql.DB
 	TokenConfig    *config.AuthConfig
 	MailConfig     *mail.Mail
 	FrontEndConfig *config.FrontEndConfig
+	InboundConfig  *config.InboundConfig
+	GraphQLConfig  *config.GraphQLConfig
+	Events         *events.Hub
+	Tickets        *middleware.Tickets
+	graphQL        graphql.Schema
 }
 
-func NewTodoHandler(DB *sql.DB, authConfig *config.AuthConfig, mailConfig *mail.Mail, frontEndConfig *config.FrontEndConfig) *TodoHandler {
+func NewTodoHandler(stores *repository.Stores, authConfig *config.AuthConfig, mailConfig *mail.Mail, frontEndConfig *config.FrontEndConfig, inboundConfig *config.InboundConfig, graphQLConfig *config.GraphQLConfig, hub *events.Hub) *TodoHandler {
 	todoHandler := new(TodoHandler)
-	todoHandler.DB = DB
+	todoHandler.Todos = stores.Todos
+	todoHandler.Users = stores.Users
+	todoHandler.Tokens = stores.Tokens
+	todoHandler.DB = stores.DB
 	todoHandler.TokenConfig = authConfig
 	todoHandler.MailConfig = mailConfig
 	todoHandler.FrontEndConfig = frontEndConfig
+	todoHandler.InboundConfig = inboundConfig
+	todoHandler.GraphQLConfig = graphQLConfig
+	todoHandler.Events = hub
+	todoHandler.Tickets = middleware.NewTickets(middleware.TicketTTL)
+	schema, err := todoHandler.graphQLSchema()
+	if err != nil {
+		panic(fmt.Sprintf("invalid graphql schema: %s", err.Error()))
+	}
+	todoHandler.graphQL = schema
 	return todoHandler
 }
 
@@ -38,33 +72,40 @@ func (th *TodoHandler) ListAllTodos(rw http.ResponseWriter, r *http.Request) {
 		rw.WriteHeader(http.StatusOK)
 		return
 	}
+	page, err := pagination.ParseRequest(r.URL.Query())
+	if err != nil {
+		utilities.WriteError(err.Error(), rw, http.StatusBadRequest)
+		return
+	}
 	queryMap := r.URL.Query()
-	page := queryMap.Get("page")
-	limit := queryMap.Get("limit")
-	limitInt := 10
-	pageInt := 0
-	offset := 0
-	if len(page) != 0 && len(limit) != 0 {
-		limitInt, err := strconv.Atoi(limit)
+	filter := repository.TodoFilter{Label: queryMap.Get("label")}
+	if status := queryMap.Get("status"); status != "" {
+		parsed, err := models.ParseStatus(status)
 		if err != nil {
-			utilities.WriteError(fmt.Sprintf("Invalid limit passed %s", err.Error()), rw, http.StatusInternalServerError)
+			utilities.WriteError(err.Error(), rw, http.StatusBadRequest)
 			return
 		}
-		pageInt, err = strconv.Atoi(page)
+		filter.Status = &parsed
+	}
+	userId := r.Context().Value("userId").(string)
+	ctx := r.Context()
+	var total *int
+	if page.IncludeTotal || page.OffsetMode {
+		count, err := th.Todos.CountTodos(ctx, userId, filter)
 		if err != nil {
-			utilities.WriteError(fmt.Sprintf("Invalid lpage passed %s", err.Error()), rw, http.StatusInternalServerError)
+			utilities.WriteError(fmt.Sprintf("Error counting the todos %s", err.Error()), rw, http.StatusInternalServerError)
 			return
 		}
-		offset = (pageInt - 1) * limitInt
+		total = &count
 	}
-	userId := r.Context().Value("userId").(string)
-	ctx := r.Context()
-	todos, err := repository.GetAllTodos(ctx, th.DB, offset, limitInt, userId)
+	todos, more, err := th.Todos.ListTodos(ctx, page, userId, filter)
 	if err != nil {
 		utilities.WriteError(fmt.Sprintf("Error fetching the todos %s", err.Error()), rw, http.StatusInternalServerError)
 		return
 	}
-	err = utilities.WriteResponse(rw, todos)
+	err = writePage(rw, r, page, todos, more, total, func(todo *models.GetTodoResponse) *pagination.Cursor {
+		return &pagination.Cursor{CreatedAt: todo.CreatedAt, Id: todo.Id}
+	})
 	if err != nil {
 		utilities.WriteError(fmt.Sprintf("Error writing the todos in response %s", err.Error()), rw, http.StatusInternalServerError)
 		return
@@ -72,6 +113,70 @@ func (th *TodoHandler) ListAllTodos(rw http.ResponseWriter, r *http.Request) {
 
 }
 
+// writePage answers offset requests, and first pages asked for without paging parameters, with
+// the bare array older clients expect and everything else with a page envelope; all of them get
+// Link headers for the neighbouring pages.
+func writePage[T any](rw http.ResponseWriter, r *http.Request, page *pagination.Request, items []T, more bool, total *int, cursorOf func(T) *pagination.Cursor) error {
+	var links []string
+	if page.OffsetMode {
+		if total != nil {
+			rw.Header().Set("X-Total-Count", strconv.Itoa(*total))
+		}
+		pageNumber := page.Offset/page.Limit + 1
+		pageLink := func(rel string, number int) string {
+			return pagination.Link(r, rel, map[string]string{"page": strconv.Itoa(number), "offset": "", "limit": strconv.Itoa(page.Limit)})
+		}
+		links = append(links, pageLink("first", 1))
+		if pageNumber > 1 {
+			links = append(links, pageLink("prev", pageNumber-1))
+		}
+		if more {
+			links = append(links, pageLink("next", pageNumber+1))
+		}
+		if total != nil && *total > 0 {
+			links = append(links, pageLink("last", (*total+page.Limit-1)/page.Limit))
+		}
+		pagination.SetLinks(rw, links)
+		return writeCacheable(rw, r, items)
+	}
+
+	response := pagination.Page[T]{Items: items, Total: total}
+	hasNext, hasPrev := page.Neighbours(more)
+	if len(items) > 0 {
+		if hasNext {
+			response.NextCursor = cursorOf(items[len(items)-1]).Encode()
+			links = append(links, pagination.Link(r, "next", map[string]string{"cursor": response.NextCursor}))
+		}
+		if hasPrev {
+			cursor := cursorOf(items[0])
+			cursor.Backward = true
+			response.PrevCursor = cursor.Encode()
+			links = append(links, pagination.Link(r, "prev", map[string]string{"cursor": response.PrevCursor}))
+		}
+	}
+	pagination.SetLinks(rw, links)
+	if !page.Envelope && !pagination.WantsEnvelope(r.Header.Get("Accept")) {
+		return writeCacheable(rw, r, items)
+	}
+	return writeCacheable(rw, r, response)
+}
+
+// writeCacheable tags a response with an ETag of its body and answers a matching If-None-Match with 304
+func writeCacheable(rw http.ResponseWriter, r *http.Request, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	etag := utilities.ContentETag(body)
+	rw.Header().Set("ETag", etag)
+	if utilities.NoneMatch(r.Header.Get("If-None-Match"), etag) {
+		rw.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	_, err = rw.Write(append(body, '\n'))
+	return err
+}
+
 func (th *TodoHandler) FetchTodoByID(rw http.ResponseWriter, r *http.Request) {
 	rw.Header().Set("Content-Type", "application/json")
 	if r.Method == http.MethodOptions {
@@ -81,8 +186,14 @@ func (th *TodoHandler) FetchTodoByID(rw http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 	user_id := r.Context().Value("userId").(string)
-	todo, _ := repository.GetTodoByID(r.Context(), th.DB, id, user_id)
+	todo, _ := th.Todos.GetTodoByID(r.Context(), id, user_id)
 	if todo != nil {
+		etag := utilities.VersionETag(todo.Id, todo.Version)
+		rw.Header().Set("ETag", etag)
+		if utilities.NoneMatch(r.Header.Get("If-None-Match"), etag) {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
 		json.NewEncoder(rw).Encode(todo)
 	} else {
 		errorMessage := fmt.Sprintf("There is no todo with Id: %s", id)
@@ -111,17 +222,165 @@ func (th *TodoHandler) CreateTask(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user_id := r.Context().Value("userId").(string)
-	err = repository.CreateTodo(r.Context(), th.DB, v, user_id)
+	created, err := th.Todos.CreateTodo(r.Context(), v, user_id)
 	if err != nil {
 		utilities.WriteError(fmt.Sprintf("error while creating task, at Database layer: %s", err.Error()), rw, http.StatusInternalServerError)
 		return
 	}
+	th.publish(events.TodoCreated, user_id, created)
+	rw.Header().Set("Location", todoLocation(created.Id))
 	rw.WriteHeader(http.StatusCreated)
 	response := models.CreateResponse{
 		Message: "Todo created successfully",
+		Id:      created.Id,
+	}
+	utilities.WriteResponse(rw, response)
+
+}
+
+func (th *TodoHandler) QuickAddTask(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	request := new(models.QuickAddRequest)
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		utilities.WriteError("error while reading quick add request.", rw, http.StatusBadRequest)
+		return
+	}
+	err = validateapp.ValidateStruct(request)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while validating the input: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	location := time.UTC
+	if request.Timezone != "" {
+		location, err = time.LoadLocation(request.Timezone)
+		if err != nil {
+			utilities.WriteError(fmt.Sprintf("unknown timezone %s", request.Timezone), rw, http.StatusBadRequest)
+			return
+		}
+	}
+	result, err := quickadd.Parse(request.Text, time.Now().In(location))
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while parsing the input: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	err = validateapp.ValidateStruct(result.Todo)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while validating the input: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	user_id := r.Context().Value("userId").(string)
+	created, err := th.Todos.CreateTodo(r.Context(), result.Todo, user_id)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while creating task, at Database layer: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	th.publish(events.TodoCreated, user_id, created)
+	rw.Header().Set("Location", todoLocation(created.Id))
+	rw.WriteHeader(http.StatusCreated)
+	response := models.QuickAddResponse{
+		Message:    "Todo created successfully",
+		Id:         created.Id,
+		Todo:       result.Todo,
+		Understood: result.Understood,
 	}
 	utilities.WriteResponse(rw, response)
+}
 
+func (th *TodoHandler) publish(eventType string, user_id string, todo *models.GetTodoResponse) {
+	th.Events.Publish(events.Event{Type: eventType, UserId: user_id, TodoId: todo.Id, Todo: todo})
+}
+
+// publishDeleted sends one deleted event per id, so subscribers also drop the subtasks a delete took with it
+func (th *TodoHandler) publishDeleted(user_id string, ids []string) {
+	for _, id := range ids {
+		th.Events.Publish(events.Event{Type: events.TodoDeleted, UserId: user_id, TodoId: id})
+	}
+}
+
+// updateEvent singles out updates that complete a todo, which is what most subscribers care about
+func updateEvent(before *models.GetTodoResponse, after *models.GetTodoResponse) string {
+	if after.TaskStatus == models.Completed && (before == nil || before.TaskStatus != models.Completed) {
+		return events.TodoCompleted
+	}
+	return events.TodoUpdated
+}
+
+func todoLocation(id string) string {
+	return "/todos/" + id
+}
+
+// PutTask creates or replaces the todo at a client generated id, so offline clients can push the
+// same item repeatedly without creating duplicates. If-Match limits it to a replace and
+// If-None-Match: * to a create.
+func (th *TodoHandler) PutTask(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if err := validateapp.ValidateVar(id, "uuid"); err != nil {
+		utilities.WriteError(fmt.Sprintf("todo id must be a uuid: %s", id), rw, http.StatusBadRequest)
+		return
+	}
+	v := new(models.Todo)
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while decoding request: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	err = validateapp.ValidateStruct(v)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while validating the input: %s", err.Error()), rw, http.StatusUnprocessableEntity)
+		return
+	}
+	if len(v.Subtasks) > 0 {
+		utilities.WriteError("subtasks cannot be replaced through PUT, put each subtask separately", rw, http.StatusUnprocessableEntity)
+		return
+	}
+	versions, conditional, err := ifMatch(r, id)
+	if err != nil {
+		utilities.WriteError(err.Error(), rw, http.StatusBadRequest)
+		return
+	}
+	condition := repository.PutAny
+	switch {
+	case conditional:
+		condition = repository.PutReplaceOnly
+	case strings.TrimSpace(r.Header.Get("If-None-Match")) == "*":
+		condition = repository.PutCreateOnly
+	}
+	user_id := r.Context().Value("userId").(string)
+	todo, created, err := th.Todos.PutTodo(r.Context(), id, v, user_id, condition, versions)
+	if errors.Is(err, repository.ErrTodoIdTaken) {
+		utilities.WriteError(err.Error(), rw, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, repository.ErrVersionMismatch) && condition == repository.PutCreateOnly {
+		utilities.WriteError(fmt.Sprintf("todo %s already exists", id), rw, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		writeTodoWriteError(rw, id, "error while saving task, at Database layer", err, conditional)
+		return
+	}
+	if created {
+		th.publish(events.TodoCreated, user_id, todo)
+	} else {
+		th.publish(events.TodoUpdated, user_id, todo)
+	}
+	rw.Header().Set("ETag", utilities.VersionETag(todo.Id, todo.Version))
+	if created {
+		rw.Header().Set("Location", todoLocation(id))
+		rw.WriteHeader(http.StatusCreated)
+	}
+	utilities.WriteResponse(rw, todo)
 }
 
 func (th *TodoHandler) DeleteTask(rw http.ResponseWriter, r *http.Request) {
@@ -132,37 +391,183 @@ func (th *TodoHandler) DeleteTask(rw http.ResponseWriter, r *http.Request) {
 	}
 	vars := mux.Vars(r)
 	id := vars["id"]
+	versions, conditional, err := ifMatch(r, id)
+	if err != nil {
+		utilities.WriteError(err.Error(), rw, http.StatusBadRequest)
+		return
+	}
 	user_id := r.Context().Value("userId").(string)
-	err := repository.DeleteTodo(r.Context(), th.DB, id, user_id)
+	deleted, err := th.Todos.DeleteTodo(r.Context(), id, user_id, versions)
 	if err != nil {
-		utilities.WriteError(fmt.Sprintf("error while deleting task, at Database layer: %s", err.Error()), rw, http.StatusInternalServerError)
+		writeTodoWriteError(rw, id, "error while deleting task, at Database layer", err, conditional)
 		return
 	}
+	th.publishDeleted(user_id, deleted)
 	rw.WriteHeader(http.StatusNoContent)
 }
 
+// updateRetries bounds how often an update without If-Match is re-applied after losing a race to
+// another writer, since the patch has to be evaluated against the row it actually replaces.
+const updateRetries = 3
+
 func (th *TodoHandler) UpdateTask(rw http.ResponseWriter, r *http.Request) {
 	rw.Header().Set("Content-Type", "application/json")
 	if r.Method == http.MethodOptions {
 		rw.WriteHeader(http.StatusOK)
 		return
 	}
-	params := make(map[string]interface{})
 	vars := mux.Vars(r)
 	id := vars["id"]
-	body := r.Body
-	err := json.NewDecoder(body).Decode(&params)
+	applyPatch, err := patchFunc(r)
 	if err != nil {
-		utilities.WriteError(fmt.Sprintf("error while decoding request: %s", err.Error()), rw, http.StatusInternalServerError)
+		utilities.WriteError(err.Error(), rw, http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, maxPatchSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		utilities.WriteError(fmt.Sprintf("patch is larger than %d bytes", tooLarge.Limit), rw, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while reading request: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	versions, conditional, err := ifMatch(r, id)
+	if err != nil {
+		utilities.WriteError(err.Error(), rw, http.StatusBadRequest)
 		return
 	}
 	user_id := r.Context().Value("userId").(string)
-	err = repository.UpdateTodo(r.Context(), th.DB, params, id, user_id)
+	updated, status, err := th.patchTodo(r.Context(), id, user_id, body, applyPatch, versions)
+	if status == http.StatusNotFound && conditional {
+		status = http.StatusPreconditionFailed
+	}
 	if err != nil {
-		utilities.WriteError(fmt.Sprintf("error while updating database: %s", err.Error()), rw, http.StatusInternalServerError)
+		utilities.WriteError(err.Error(), rw, status)
 		return
 	}
-	utilities.WriteResponse(rw, params)
+	rw.Header().Set("ETag", utilities.VersionETag(updated.Id, updated.Version))
+	utilities.WriteResponse(rw, updated)
+}
+
+// patchTodo applies a patch to the stored todo and publishes the change. It is shared by every API
+// that edits todos; on failure it returns the HTTP status that describes the error.
+func (th *TodoHandler) patchTodo(ctx context.Context, id string, user_id string, body []byte, applyPatch func(doc any, patch []byte) (any, error), versions []int) (*models.GetTodoResponse, int, error) {
+	for attempt := 0; ; attempt++ {
+		current, err := th.Todos.GetTodoByID(ctx, id, user_id)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("error while fetching todo: %w", err)
+		}
+		if current == nil {
+			return nil, http.StatusNotFound, fmt.Errorf("There is no todo with Id: %s", id)
+		}
+		doc, status, err := patchDocument(current.Document(), body, applyPatch)
+		if err != nil {
+			return nil, status, err
+		}
+		guard := versions
+		if guard == nil {
+			guard = []int{current.Version}
+		}
+		updated, err := th.Todos.UpdateTodo(ctx, doc, id, user_id, guard)
+		switch {
+		case errors.Is(err, repository.ErrVersionMismatch) && versions == nil && attempt < updateRetries:
+			continue
+		case errors.Is(err, repository.ErrInvalidParent):
+			return nil, http.StatusUnprocessableEntity, err
+		case errors.Is(err, repository.ErrTodoNotFound):
+			return nil, http.StatusNotFound, fmt.Errorf("There is no todo with Id: %s", id)
+		case errors.Is(err, repository.ErrVersionMismatch):
+			return nil, http.StatusPreconditionFailed, fmt.Errorf("%s, fetch it again and retry", err.Error())
+		case err != nil:
+			return nil, http.StatusInternalServerError, fmt.Errorf("error while updating database: %w", err)
+		}
+		th.publish(updateEvent(current, updated), user_id, updated)
+		return updated, http.StatusOK, nil
+	}
+}
+
+const maxPatchSize = 1 << 20
+
+// patchFunc picks the patch format from the Content-Type; plain JSON is treated as a merge patch
+// so existing clients sending partial objects keep working.
+func patchFunc(r *http.Request) (func(doc any, patch []byte) (any, error), error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Type %s", contentType)
+	}
+	switch mediaType {
+	case "application/json", jsonpatch.MergePatchContentType:
+		return jsonpatch.MergePatch, nil
+	case jsonpatch.JSONPatchContentType:
+		return func(doc any, patch []byte) (any, error) {
+			operations, err := jsonpatch.ParseOperations(patch)
+			if err != nil {
+				return nil, err
+			}
+			return jsonpatch.Apply(doc, operations)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported Content-Type %s, use %s or %s", mediaType, jsonpatch.MergePatchContentType, jsonpatch.JSONPatchContentType)
+}
+
+// patchDocument applies the patch to the JSON form of doc and decodes the result back strictly, so
+// a patch touching anything outside models.TodoDocument is rejected rather than ignored.
+func patchDocument(doc *models.TodoDocument, patch []byte, applyPatch func(doc any, patch []byte) (any, error)) (*models.TodoDocument, int, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	var generic any
+	if err = json.Unmarshal(raw, &generic); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	patched, err := applyPatch(generic, patch)
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return nil, http.StatusConflict, err
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	raw, err = json.Marshal(patched)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	result, err := models.DecodeTodoDocument(raw)
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("patched todo is invalid: %s", err.Error())
+	}
+	if err = validateapp.ValidateStruct(result); err != nil {
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("error while validating the input: %s", err.Error())
+	}
+	return result, 0, nil
+}
+
+// ifMatch reads the If-Match header for todo id. conditional reports whether one was sent at all,
+// since even * only matches a todo that exists: a missing todo then fails the precondition rather
+// than not being found (RFC 9110 13.1.1).
+func ifMatch(r *http.Request, id string) (versions []int, conditional bool, err error) {
+	header := r.Header.Get("If-Match")
+	versions, err = utilities.IfMatchVersions(header, id)
+	return versions, strings.TrimSpace(header) != "", err
+}
+
+func writeTodoWriteError(rw http.ResponseWriter, id string, message string, err error, conditional bool) {
+	switch {
+	case errors.Is(err, repository.ErrTodoNotFound) && conditional:
+		utilities.WriteError(fmt.Sprintf("There is no todo with Id: %s to match If-Match", id), rw, http.StatusPreconditionFailed)
+	case errors.Is(err, repository.ErrTodoNotFound):
+		utilities.WriteError(fmt.Sprintf("There is no todo with Id: %s", id), rw, http.StatusNotFound)
+	case errors.Is(err, repository.ErrVersionMismatch):
+		utilities.WriteError(fmt.Sprintf("%s, fetch it again and retry", err.Error()), rw, http.StatusPreconditionFailed)
+	default:
+		utilities.WriteError(fmt.Sprintf("%s: %s", message, err.Error()), rw, http.StatusInternalServerError)
+	}
 }
 
 func (th *TodoHandler) SearchTask(rw http.ResponseWriter, r *http.Request) {
@@ -173,31 +578,75 @@ func (th *TodoHandler) SearchTask(rw http.ResponseWriter, r *http.Request) {
 	}
 	queryMap := r.URL.Query()
 	searchParam := queryMap.Get("query")
-	page := queryMap.Get("page")
-	limit := queryMap.Get("limit")
-	limitInt := 10
-	pageInt := 0
-	offset := 0
-	if len(page) != 0 && len(limit) != 0 {
-		limitInt, err := strconv.Atoi(limit)
-		if err != nil {
-			utilities.WriteError(fmt.Sprintf("Invalid limit passed %s", err.Error()), rw, http.StatusInternalServerError)
-			return
-		}
-		pageInt, err = strconv.Atoi(page)
+	page, err := pagination.ParseRequest(queryMap)
+	if err != nil {
+		utilities.WriteError(err.Error(), rw, http.StatusBadRequest)
+		return
+	}
+	prefix := true
+	if value := queryMap.Get("prefix"); value != "" {
+		parsed, err := strconv.ParseBool(value)
 		if err != nil {
-			utilities.WriteError(fmt.Sprintf("Invalid lpage passed %s", err.Error()), rw, http.StatusInternalServerError)
+			utilities.WriteError(fmt.Sprintf("Invalid prefix passed %s", err.Error()), rw, http.StatusBadRequest)
 			return
 		}
-		offset = (pageInt - 1) * limitInt
+		prefix = parsed
+	}
+	if strings.TrimSpace(searchParam) == "" {
+		utilities.WriteError("query must not be empty", rw, http.StatusBadRequest)
+		return
+	}
+	mode := queryMap.Get("mode")
+	if mode == "" {
+		mode = repository.SearchModeFullText
+	}
+	if mode != repository.SearchModeFullText && mode != repository.SearchModeFuzzy {
+		utilities.WriteError(fmt.Sprintf("Invalid mode passed %s, use fulltext or fuzzy", mode), rw, http.StatusBadRequest)
+		return
+	}
+	fields, err := parseSearchScope(queryMap.Get("scope"))
+	if err != nil {
+		utilities.WriteError(err.Error(), rw, http.StatusBadRequest)
+		return
+	}
+	options := repository.SearchOptions{
+		Text:   searchParam,
+		Mode:   mode,
+		Prefix: prefix,
+		Fields: fields,
+		Page:   page,
 	}
 	user_id := r.Context().Value("userId").(string)
-	todo, err := repository.SearchTodo(r.Context(), th.DB, searchParam, limitInt, offset, user_id)
-	if todo != nil {
-		json.NewEncoder(rw).Encode(todo)
-	} else {
-		errorMessage := fmt.Sprintf("There is no todo with text: %s : %s", searchParam, err.Error())
-		utilities.WriteError(errorMessage, rw, http.StatusNotFound)
+	results, more, err := th.Todos.SearchTodo(r.Context(), options, user_id)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("Error searching the todos %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	// search totals would need the whole ranked query twice, so only listing offers them
+	err = writePage(rw, r, page, results, more, nil, func(result *models.SearchResult) *pagination.Cursor {
+		rank := result.Rank
+		return &pagination.Cursor{Rank: &rank, CreatedAt: result.CreatedAt, Id: result.Id}
+	})
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("Error writing the todos in response %s", err.Error()), rw, http.StatusInternalServerError)
 		return
 	}
 }
+
+func parseSearchScope(scope string) ([]string, error) {
+	if scope == "" || scope == "all" {
+		return nil, nil
+	}
+	var fields []string
+	for _, field := range strings.Split(scope, ",") {
+		field = strings.TrimSpace(field)
+		if field == models.SearchScopeComments {
+			return nil, fmt.Errorf("Searching comments is not supported, todos have no comments yet; use a comma separated list of %s", strings.Join(models.SearchFields, ", "))
+		}
+		if !slices.Contains(models.SearchFields, field) {
+			return nil, fmt.Errorf("Invalid scope passed %s, use a comma separated list of %s", field, strings.Join(models.SearchFields, ", "))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
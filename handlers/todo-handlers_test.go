@@ -0,0 +1,252 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"todos/jsonpatch"
+	"todos/models"
+	"todos/pagination"
+	"todos/router/routertest"
+	"todos/utilities"
+)
+
+// createTodo creates todo through the api and returns it as the api reports it
+func createTodo(t *testing.T, s *routertest.Server, token string, todo *models.Todo) *models.GetTodoResponse {
+	t.Helper()
+	var created models.CreateResponse
+	response := s.Call(t, http.MethodPost, "/todos/", token, todo, http.StatusCreated, &created)
+	if location := response.Header.Get("Location"); !strings.HasSuffix(location, "/todos/"+created.Id) {
+		t.Errorf("Location is %q, want the new todo", location)
+	}
+	var fetched models.GetTodoResponse
+	s.Call(t, http.MethodGet, "/todos/"+created.Id, token, nil, http.StatusOK, &fetched)
+	return &fetched
+}
+
+// createSubtask creates a todo and moves it under parentId
+func createSubtask(t *testing.T, s *routertest.Server, token string, parentId string, name string) *models.GetTodoResponse {
+	t.Helper()
+	todo := createTodo(t, s, token, &models.Todo{Name: name})
+	var moved models.GetTodoResponse
+	s.Call(t, http.MethodPatch, "/todos/"+todo.Id, token, map[string]string{"parentId": parentId}, http.StatusOK, &moved)
+	return &moved
+}
+
+func TestCreateAndFetch(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	todo := createTodo(t, s, token, &models.Todo{Name: "pay rent", Description: "before the 5th", TaskStatus: models.InProgess, Priority: models.HighPriority, Labels: []string{"home"}})
+	if todo.Name != "pay rent" || todo.TaskStatus != models.InProgess || todo.Priority != models.HighPriority || todo.Version != 1 {
+		t.Errorf("fetched %+v", todo)
+	}
+
+	s.Call(t, http.MethodPost, "/todos/", token, &models.Todo{Name: "bad", TaskStatus: 7}, http.StatusBadRequest, nil)
+	s.Call(t, http.MethodPost, "/todos/", token, &models.Todo{Description: "no name"}, http.StatusBadRequest, nil)
+	s.Call(t, http.MethodGet, "/todos/"+strings.Repeat("0", 8)+"-0000-4000-8000-000000000000", token, nil, http.StatusNotFound, nil)
+
+	var todos []models.GetTodoResponse
+	s.Call(t, http.MethodGet, "/todos/", token, nil, http.StatusOK, &todos)
+	if len(todos) != 1 || todos[0].Id != todo.Id {
+		t.Errorf("list is %+v, want the one todo", todos)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	todo := createTodo(t, s, token, &models.Todo{Name: "draft"})
+	etag := utilities.VersionETag(todo.Id, todo.Version)
+
+	get := s.Request(t, http.MethodGet, "/todos/"+todo.Id, token, nil)
+	get.Header.Set("If-None-Match", etag)
+	if response, _ := s.Do(t, get); response.StatusCode != http.StatusNotModified || response.Header.Get("ETag") != etag {
+		t.Errorf("If-None-Match with the current ETag answered %d with ETag %q", response.StatusCode, response.Header.Get("ETag"))
+	}
+
+	patch := s.Request(t, http.MethodPatch, "/todos/"+todo.Id, token, `{"name":"final"}`)
+	patch.Header.Set("Content-Type", jsonpatch.MergePatchContentType)
+	patch.Header.Set("If-Match", etag)
+	response, body := s.Do(t, patch)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH with the current ETag answered %d: %s", response.StatusCode, body)
+	}
+	if next := response.Header.Get("ETag"); next != utilities.VersionETag(todo.Id, todo.Version+1) {
+		t.Errorf("PATCH answered ETag %q", next)
+	}
+
+	stale := s.Request(t, http.MethodPatch, "/todos/"+todo.Id, token, `{"name":"lost update"}`)
+	stale.Header.Set("If-Match", etag)
+	if response, _ := s.Do(t, stale); response.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with a stale ETag answered %d, want 412", response.StatusCode)
+	}
+
+	other := createTodo(t, s, token, &models.Todo{Name: "other"})
+	wrongTodo := s.Request(t, http.MethodDelete, "/todos/"+todo.Id, token, nil)
+	wrongTodo.Header.Set("If-Match", utilities.VersionETag(other.Id, todo.Version+1))
+	if response, _ := s.Do(t, wrongTodo); response.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with another todo's ETag answered %d, want 412", response.StatusCode)
+	}
+
+	var fetched models.GetTodoResponse
+	s.Call(t, http.MethodGet, "/todos/"+todo.Id, token, nil, http.StatusOK, &fetched)
+	if fetched.Name != "final" {
+		t.Errorf("name is %q after the stale update, want final", fetched.Name)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	todo := createTodo(t, s, token, &models.Todo{Name: "groceries", Labels: []string{"home"}})
+	patch := func(contentType string, body string) (*http.Response, []byte) {
+		r := s.Request(t, http.MethodPatch, "/todos/"+todo.Id, token, body)
+		r.Header.Set("Content-Type", contentType)
+		return s.Do(t, r)
+	}
+
+	response, body := patch(jsonpatch.JSONPatchContentType, `[{"op":"test","path":"/name","value":"groceries"},{"op":"add","path":"/labels/-","value":"weekly"},{"op":"replace","path":"/status","value":2}]`)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("JSON Patch answered %d: %s", response.StatusCode, body)
+	}
+	var fetched models.GetTodoResponse
+	s.Call(t, http.MethodGet, "/todos/"+todo.Id, token, nil, http.StatusOK, &fetched)
+	if strings.Join(fetched.Labels, ",") != "home,weekly" || fetched.TaskStatus != models.Completed {
+		t.Errorf("after JSON Patch the todo is %+v", fetched)
+	}
+
+	checks := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{jsonpatch.JSONPatchContentType, `[{"op":"test","path":"/name","value":"other"}]`, http.StatusConflict},
+		{jsonpatch.MergePatchContentType, `{"status":9}`, http.StatusUnprocessableEntity},
+		{jsonpatch.MergePatchContentType, `{"owner":"bob"}`, http.StatusUnprocessableEntity},
+		{"text/plain", `name=x`, http.StatusUnsupportedMediaType},
+		{jsonpatch.MergePatchContentType, `{"description":"` + strings.Repeat("x", 2<<20) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, check := range checks {
+		if response, body := patch(check.contentType, check.body); response.StatusCode != check.status {
+			t.Errorf("PATCH %s %.40s answered %d, want %d: %s", check.contentType, check.body, response.StatusCode, check.status, body)
+		}
+	}
+}
+
+func TestDeleteWithSubtasks(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	root := createTodo(t, s, token, &models.Todo{Name: "move"})
+	pack := createSubtask(t, s, token, root.Id, "pack")
+	createSubtask(t, s, token, pack.Id, "buy boxes")
+	s.Call(t, http.MethodDelete, "/todos/"+root.Id, token, nil, http.StatusNoContent, nil)
+	s.Call(t, http.MethodGet, "/todos/"+root.Id, token, nil, http.StatusNotFound, nil)
+	var todos []models.GetTodoResponse
+	s.Call(t, http.MethodGet, "/todos/", token, nil, http.StatusOK, &todos)
+	if len(todos) != 0 {
+		t.Errorf("after deleting the root %d todos are left", len(todos))
+	}
+	s.Call(t, http.MethodDelete, "/todos/"+root.Id, token, nil, http.StatusNotFound, nil)
+}
+
+func TestPutOverDeleted(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	for _, ifNoneMatch := range []string{"", "*"} {
+		todo := createTodo(t, s, token, &models.Todo{Name: "draft"})
+		createSubtask(t, s, token, todo.Id, "outline")
+		s.Call(t, http.MethodDelete, "/todos/"+todo.Id, token, nil, http.StatusNoContent, nil)
+
+		replace := s.Request(t, http.MethodPut, "/todos/"+todo.Id, token, `{"name":"lost"}`)
+		replace.Header.Set("If-Match", "*")
+		if response, _ := s.Do(t, replace); response.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("PUT with If-Match: * over a deleted todo answered %d, want 412", response.StatusCode)
+		}
+
+		put := s.Request(t, http.MethodPut, "/todos/"+todo.Id, token, `{"name":"again"}`)
+		if ifNoneMatch != "" {
+			put.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		response, body := s.Do(t, put)
+		var created models.GetTodoResponse
+		json.Unmarshal(body, &created)
+		if response.StatusCode != http.StatusCreated || created.Name != "again" || created.Version != 1 {
+			t.Errorf("PUT with If-None-Match %q over a deleted todo answered %d: %s", ifNoneMatch, response.StatusCode, body)
+		}
+		// the deleted subtask went with the tombstone
+		if todos := listTodos(t, s, token); len(todos) != 1 || todos[0].Id != todo.Id {
+			t.Errorf("after the PUT the list is %+v", todos)
+		}
+		s.Call(t, http.MethodDelete, "/todos/"+todo.Id, token, nil, http.StatusNoContent, nil)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	for _, name := range []string{"a", "b", "c"} {
+		createTodo(t, s, token, &models.Todo{Name: name})
+	}
+	// limit on its own is what clients from before cursors send, they keep getting an array
+	var first []models.GetTodoResponse
+	response := s.Call(t, http.MethodGet, "/todos/?limit=2", token, nil, http.StatusOK, &first)
+	if len(first) != 2 {
+		t.Fatalf("first page has %d items, want 2", len(first))
+	}
+	if !strings.Contains(response.Header.Get("Link"), `rel="next"`) {
+		t.Errorf("first page has no next link: %q", response.Header.Get("Link"))
+	}
+	request := s.Request(t, http.MethodGet, "/todos/?limit=2", token, nil)
+	request.Header.Set("Accept", pagination.EnvelopeMediaType)
+	_, body := s.Do(t, request)
+	var page pagination.Page[models.GetTodoResponse]
+	if err := json.Unmarshal(body, &page); err != nil || len(page.Items) != 2 || page.NextCursor == "" {
+		t.Fatalf("first page envelope is %s, want two items and a cursor: %v", body, err)
+	}
+	var counted pagination.Page[models.GetTodoResponse]
+	s.Call(t, http.MethodGet, "/todos/?limit=2&include_total=true", token, nil, http.StatusOK, &counted)
+	if counted.Total == nil || *counted.Total != 3 {
+		t.Errorf("include_total page is %+v, want a total of 3", counted)
+	}
+	var rest pagination.Page[models.GetTodoResponse]
+	s.Call(t, http.MethodGet, "/todos/?limit=2&cursor="+page.NextCursor, token, nil, http.StatusOK, &rest)
+	if len(rest.Items) != 1 || rest.NextCursor != "" {
+		t.Errorf("second page is %+v, want the last item and no cursor", rest)
+	}
+
+	var offsetPage []models.GetTodoResponse
+	response = s.Call(t, http.MethodGet, "/todos/?page=2&limit=2", token, nil, http.StatusOK, &offsetPage)
+	if len(offsetPage) != 1 || response.Header.Get("X-Total-Count") != "3" {
+		t.Errorf("offset page 2 has %d items and X-Total-Count %q", len(offsetPage), response.Header.Get("X-Total-Count"))
+	}
+}
+
+func TestUsersAreIsolated(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, alice := s.SignUp(t, "alice")
+	_, bob := s.SignUp(t, "bob")
+	todo := createTodo(t, s, alice, &models.Todo{Name: "private"})
+	s.Call(t, http.MethodGet, "/todos/"+todo.Id, bob, nil, http.StatusNotFound, nil)
+	s.Call(t, http.MethodPatch, "/todos/"+todo.Id, bob, `{"name":"mine"}`, http.StatusNotFound, nil)
+	s.Call(t, http.MethodDelete, "/todos/"+todo.Id, bob, nil, http.StatusNotFound, nil)
+	var todos []models.GetTodoResponse
+	s.Call(t, http.MethodGet, "/todos/search?query=private", alice, nil, http.StatusOK, &todos)
+	if len(todos) != 1 {
+		t.Errorf("alice's search found %d todos, want her one", len(todos))
+	}
+	s.Call(t, http.MethodGet, "/todos/search?query=private", bob, nil, http.StatusOK, &todos)
+	if len(todos) != 0 {
+		t.Errorf("bob's search found %d of alice's todos", len(todos))
+	}
+}
+
+func TestPostgresOnlyFeatures(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	for _, path := range []string{"/sync", "/sync?since=abc", "/webhooks/"} {
+		s.Call(t, http.MethodGet, path, token, nil, http.StatusNotImplemented, nil)
+	}
+	s.Call(t, http.MethodPost, "/sync", token, `{"changes":[]}`, http.StatusNotImplemented, nil)
+	s.Call(t, http.MethodPost, "/users/ingest-key", token, nil, http.StatusNotImplemented, nil)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todos/events"
+	"todos/formats"
+	"todos/models"
+	"todos/repository"
+	"todos/utilities"
+	validateapp "todos/validator"
+)
+
+const maxImportSize = 10 << 20
+
+func (th *TodoHandler) ExportTodos(rw http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	queryMap := r.URL.Query()
+	format := queryMap.Get("format")
+	if format == "" {
+		format = formats.JSON
+	}
+	encoder, err := formats.NewEncoder(format, rw)
+	if err != nil {
+		utilities.WriteError(err.Error(), rw, http.StatusBadRequest)
+		return
+	}
+	filter := repository.TodoFilter{RootId: queryMap.Get("root")}
+	if status := queryMap.Get("status"); status != "" {
+		parsed, err := models.ParseStatus(status)
+		if err != nil {
+			utilities.WriteError(err.Error(), rw, http.StatusBadRequest)
+			return
+		}
+		filter.Status = &parsed
+	}
+	rw.Header().Set("Content-Type", formats.ContentType(format))
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="todos-%s.%s"`, time.Now().UTC().Format("20060102"), formats.Extension(format)))
+	flusher, _ := rw.(http.Flusher)
+	userId := r.Context().Value("userId").(string)
+	count := 0
+	started := false
+	err = th.Todos.StreamTodos(r.Context(), userId, filter, func(todo *models.GetTodoResponse) error {
+		if !started {
+			started = true
+			if err := encoder.Begin(); err != nil {
+				return err
+			}
+		}
+		if err := encoder.Encode(todo); err != nil {
+			return err
+		}
+		count++
+		if flusher != nil && count%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && !started {
+		utilities.WriteError(fmt.Sprintf("Error exporting the todos %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		// headers and part of the body are already on the wire, so the export can only be cut short here
+		log.Printf("error exporting todos for user %s after %d rows: %s", userId, count, err.Error())
+		return
+	}
+	if !started {
+		if err = encoder.Begin(); err != nil {
+			log.Printf("error starting todo export: %s", err.Error())
+			return
+		}
+	}
+	if err = encoder.End(); err != nil {
+		log.Printf("error finishing todo export: %s", err.Error())
+	}
+}
+
+func (th *TodoHandler) ImportTodos(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	queryMap := r.URL.Query()
+	dryRun := false
+	if value := queryMap.Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			utilities.WriteError(fmt.Sprintf("Invalid dry_run passed %s", err.Error()), rw, http.StatusBadRequest)
+			return
+		}
+		dryRun = parsed
+	}
+	r.Body = http.MaxBytesReader(rw, r.Body, maxImportSize)
+	var upload io.Reader = r.Body
+	fileName := ""
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			utilities.WriteError(fmt.Sprintf("error reading uploaded file: %s", err.Error()), rw, http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		upload = file
+		fileName = header.Filename
+		contentType = header.Header.Get("Content-Type")
+	}
+	format := queryMap.Get("format")
+	if format == "" {
+		format = formats.DetectFormat(fileName, contentType)
+	}
+	if format == "" {
+		utilities.WriteError("cannot detect the import format, pass one of csv, json, ndjson, markdown, todotxt, todoist or trello as format", rw, http.StatusBadRequest)
+		return
+	}
+	records, err := formats.Decode(format, upload)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error reading import: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+
+	report := &models.ImportReport{Format: format, DryRun: dryRun, Total: len(records)}
+	todos := make([]*models.Todo, 0, len(records))
+	for _, record := range records {
+		if record.Err == nil {
+			record.Err = validateapp.ValidateStruct(record.Todo)
+		}
+		if record.Err != nil {
+			report.Errors = append(report.Errors, models.ImportRowError{Row: record.Row, Message: record.Err.Error()})
+			continue
+		}
+		todos = append(todos, record.Todo)
+	}
+	if dryRun {
+		report.Todos = todos
+		utilities.WriteResponse(rw, report)
+		return
+	}
+	if len(report.Errors) > 0 {
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		utilities.WriteResponse(rw, report)
+		return
+	}
+	user_id := r.Context().Value("userId").(string)
+	report.Created, err = th.Todos.CreateTodos(r.Context(), todos, user_id)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while importing tasks, at Database layer: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	th.Events.Publish(events.Event{Type: events.TodosImported, UserId: user_id, Count: report.Created})
+	rw.WriteHeader(http.StatusCreated)
+	utilities.WriteResponse(rw, report)
+}
@@ -0,0 +1,66 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+	"todos/models"
+	"todos/router/routertest"
+)
+
+const importCSV = "id,parentId,name,status,labels\n" +
+	"a,,pay rent,in_progress,home;money\n" +
+	"b,a,check the transfer,completed,\n" +
+	"c,,water plants,,\n"
+
+func listTodos(t *testing.T, s *routertest.Server, token string) []models.GetTodoResponse {
+	t.Helper()
+	var todos []models.GetTodoResponse
+	s.Call(t, http.MethodGet, "/todos/", token, nil, http.StatusOK, &todos)
+	return todos
+}
+
+func TestImportDryRun(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	var report models.ImportReport
+	s.Call(t, http.MethodPost, "/todos/import?format=csv&dry_run=true", token, importCSV, http.StatusOK, &report)
+	if !report.DryRun || report.Total != 2 || report.Created != 0 || len(report.Todos) != 2 || len(report.Todos[0].Subtasks) != 1 {
+		t.Fatalf("dry run report = %+v", report)
+	}
+	if todos := listTodos(t, s, token); len(todos) != 0 {
+		t.Fatalf("a dry run created %d todos", len(todos))
+	}
+
+	report = models.ImportReport{}
+	s.Call(t, http.MethodPost, "/todos/import?format=csv", token, importCSV, http.StatusCreated, &report)
+	if report.DryRun || report.Created != 3 {
+		t.Fatalf("import report = %+v, want 3 todos created", report)
+	}
+	if todos := listTodos(t, s, token); len(todos) != 3 {
+		t.Fatalf("import left %d todos, want 3", len(todos))
+	}
+}
+
+func TestImportRowErrors(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	_, token := s.SignUp(t, "alice")
+	body := `[{"name":"ok"},{"name":42},{"description":"no name"}]`
+	var report models.ImportReport
+	s.Call(t, http.MethodPost, "/todos/import?dry_run=true", token, body, http.StatusOK, &report)
+	if len(report.Errors) != 2 || report.Errors[0].Row != 2 || report.Errors[1].Row != 3 || len(report.Todos) != 1 {
+		t.Fatalf("dry run report = %+v, want rows 2 and 3 rejected", report)
+	}
+
+	report = models.ImportReport{}
+	s.Call(t, http.MethodPost, "/todos/import", token, body, http.StatusUnprocessableEntity, &report)
+	if len(report.Errors) != 2 || report.Created != 0 {
+		t.Fatalf("import report = %+v", report)
+	}
+	if todos := listTodos(t, s, token); len(todos) != 0 {
+		t.Fatalf("an import with row errors created %d todos", len(todos))
+	}
+
+	s.Call(t, http.MethodPost, "/todos/import", token, `[{"name":"ok"},{"name":`, http.StatusBadRequest, nil)
+	s.Call(t, http.MethodPost, "/todos/import?format=xml", token, `<todo/>`, http.StatusBadRequest, nil)
+	s.Call(t, http.MethodPost, "/todos/import?dry_run=maybe", token, `[]`, http.StatusBadRequest, nil)
+}
@@ -44,7 +44,7 @@ func (th *TodoHandler) CreateUser(rw http.ResponseWriter, r *http.Request) {
 	userDBobj.HashedPassword = hashPassword
 	userDBobj.Email = user.Email
 	userDBobj.UserName = user.UserName
-	err = repository.CreateUser(r.Context(), th.DB, userDBobj)
+	err = th.Users.CreateUser(r.Context(), userDBobj)
 	if err != nil {
 		utilities.WriteError("error occured while creating the user.", rw, http.StatusInternalServerError)
 		return
@@ -75,7 +75,7 @@ func (th *TodoHandler) Login(rw http.ResponseWriter, r *http.Request) {
 		utilities.WriteError(fmt.Sprintf("error while validating the input: %s", err.Error()), rw, http.StatusBadRequest)
 		return
 	}
-	user, err := repository.FetchUserWithUserID(r.Context(), th.DB, loginRequest.UserName)
+	user, err := th.Users.FetchUserWithUserID(r.Context(), loginRequest.UserName)
 	if err != nil {
 		utilities.WriteError(err.Error(), rw, http.StatusInternalServerError)
 		return
@@ -89,6 +89,10 @@ func (th *TodoHandler) Login(rw http.ResponseWriter, r *http.Request) {
 		utilities.WriteError("incorrect username or password", rw, http.StatusInternalServerError)
 		return
 	}
+	if user.DisabledAt != nil {
+		utilities.WriteError("this account has been disabled", rw, http.StatusForbidden)
+		return
+	}
 
 	tokenString, err := utilities.GenerateJWT(user, th.TokenConfig)
 	if err != nil {
@@ -110,7 +114,7 @@ func (th *TodoHandler) Login(rw http.ResponseWriter, r *http.Request) {
 	http.SetCookie(rw, &cookie)
 	sum := sha256.Sum256([]byte(refreshTokenString))
 	refreshTokenHash := hex.EncodeToString(sum[:])
-	err = repository.SaveRefreshToken(r.Context(), th.DB, &models.SaveRefresh{UserId: user.Id, TokenHash: refreshTokenHash, ExpiresAt: time.Now().Add(th.TokenConfig.RefreshTTL)})
+	err = th.Tokens.SaveRefreshToken(r.Context(), &models.SaveRefresh{UserId: user.Id, TokenHash: refreshTokenHash, ExpiresAt: time.Now().Add(th.TokenConfig.RefreshTTL)})
 	if err != nil {
 		utilities.WriteError(err.Error(), rw, http.StatusInternalServerError)
 		return
@@ -133,7 +137,7 @@ func (th *TodoHandler) Refresh(rw http.ResponseWriter, r *http.Request) {
 	hashedArray := sha256.Sum256([]byte(refreshCookie.Value))
 	hashedRefresh := hex.EncodeToString(hashedArray[:])
 	savedRefresh := new(models.SaveRefresh)
-	savedRefresh, err = repository.FetchRefreshToken(r.Context(), th.DB, hashedRefresh)
+	savedRefresh, err = th.Tokens.FetchRefreshToken(r.Context(), hashedRefresh)
 	if err != nil {
 		utilities.WriteError(fmt.Sprintf("Error with refresh token %s", err.Error()), rw, http.StatusInternalServerError)
 		return
@@ -164,7 +168,7 @@ func (th *TodoHandler) Refresh(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = repository.InvalidateRefreshToken(r.Context(), th.DB, hashedRefresh)
+	err = th.Tokens.InvalidateRefreshToken(r.Context(), hashedRefresh)
 	if err != nil {
 		utilities.WriteError("error invalidating refresh token", rw, http.StatusUnauthorized)
 		return
@@ -180,7 +184,7 @@ func (th *TodoHandler) Refresh(rw http.ResponseWriter, r *http.Request) {
 	http.SetCookie(rw, &cookie)
 	sum := sha256.Sum256([]byte(refreshTokenString))
 	refreshTokenHash := hex.EncodeToString(sum[:])
-	err = repository.SaveRefreshToken(r.Context(), th.DB, &models.SaveRefresh{UserId: user.Id, TokenHash: refreshTokenHash, ExpiresAt: time.Now().Add(th.TokenConfig.RefreshTTL)})
+	err = th.Tokens.SaveRefreshToken(r.Context(), &models.SaveRefresh{UserId: user.Id, TokenHash: refreshTokenHash, ExpiresAt: time.Now().Add(th.TokenConfig.RefreshTTL)})
 	if err != nil {
 		utilities.WriteError(err.Error(), rw, http.StatusInternalServerError)
 		return
@@ -207,7 +211,7 @@ func (th *TodoHandler) ForgotPassword(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userId, err := repository.IsEmailExists(context.Background(), th.DB, forgotRequest)
+	userId, err := th.Users.IsEmailExists(context.Background(), forgotRequest)
 	if err != nil {
 		utilities.WriteError(err.Error(), rw, http.StatusInternalServerError)
 		return
@@ -220,7 +224,7 @@ func (th *TodoHandler) ForgotPassword(rw http.ResponseWriter, r *http.Request) {
 	forgotPassswordRequestMap["email"] = forgotRequest.Email
 	forgotPassswordRequestMap["token"] = shaTokenString
 	forgotPassswordRequestMap["userid"] = userId
-	errResponse := repository.StoreForgotPasswordToken(r.Context(), th.DB, forgotPassswordRequestMap)
+	errResponse := th.Tokens.StoreForgotPasswordToken(r.Context(), forgotPassswordRequestMap)
 	if errResponse != nil {
 		utilities.WriteError(errResponse.Message, rw, errResponse.Status)
 		return
@@ -279,9 +283,53 @@ func (th *TodoHandler) UpdatePassword(rw http.ResponseWriter, r *http.Request) {
 	}
 	newPassword.NewPassword = hashPassword
 
-	errResponse := repository.UpdatePassword(r.Context(), th.DB, newPassword, token)
+	errResponse := th.Tokens.UpdatePassword(r.Context(), newPassword, token)
 	if errResponse != nil {
 		utilities.WriteError(errResponse.Message, rw, errResponse.Status)
 		return
 	}
 }
+
+func (th *TodoHandler) GetSettings(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	userId := r.Context().Value("userId").(string)
+	settings, err := th.Users.GetUserSettings(r.Context(), userId)
+	if err != nil {
+		utilities.WriteError(err.Error(), rw, http.StatusInternalServerError)
+		return
+	}
+	utilities.WriteResponse(rw, settings)
+}
+
+func (th *TodoHandler) UpdateSettings(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	settings := new(models.UserSettings)
+	if err := json.NewDecoder(r.Body).Decode(settings); err != nil {
+		utilities.WriteError("error processing settings from request", rw, http.StatusBadRequest)
+		return
+	}
+	err := validateapp.ValidateStruct(settings)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while validating the input: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	userId := r.Context().Value("userId").(string)
+	err = th.Users.UpdateSearchLanguage(r.Context(), userId, settings.SearchLanguage)
+	if err != nil {
+		if errors.Is(err, repository.ErrUnknownSearchLanguage) {
+			utilities.WriteError(fmt.Sprintf("%s: %s", err.Error(), settings.SearchLanguage), rw, http.StatusBadRequest)
+			return
+		}
+		utilities.WriteError(fmt.Sprintf("error while updating settings: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	utilities.WriteResponse(rw, settings)
+}
@@ -0,0 +1,99 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"todos/mail/mailtest"
+	"todos/models"
+	"todos/router/routertest"
+	"todos/utilities"
+)
+
+func TestSignUpAndLogin(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	var created models.CreateResponse
+	s.Call(t, http.MethodPost, "/users/signup", "", &models.SignupRequest{UserName: "alice", Email: "alice@example.com", Password: routertest.Password}, http.StatusCreated, &created)
+	if created.UserName != "alice" {
+		t.Errorf("signup answered %+v", created)
+	}
+	s.Call(t, http.MethodPost, "/users/signup", "", &models.SignupRequest{UserName: "al", Email: "not an email", Password: "short"}, http.StatusBadRequest, nil)
+
+	var login map[string]string
+	response := s.Call(t, http.MethodPost, "/users/login", "", &models.LoginUser{UserName: "alice", Password: routertest.Password}, http.StatusOK, &login)
+	if login["token"] == "" {
+		t.Fatal("login returned no access token")
+	}
+	if !hasCookie(response, "refresh-token") {
+		t.Error("login set no refresh-token cookie")
+	}
+	s.Call(t, http.MethodGet, "/users/settings", login["token"], nil, http.StatusOK, nil)
+
+	response, _ = s.Do(t, s.Request(t, http.MethodPost, "/users/login", "", &models.LoginUser{UserName: "alice", Password: "wrong password"}))
+	if response.StatusCode == http.StatusOK {
+		t.Error("login with a wrong password succeeded")
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	user, token := s.SignUp(t, "alice")
+	s.Call(t, http.MethodGet, "/todos/", "", nil, http.StatusUnauthorized, nil)
+	s.Call(t, http.MethodGet, "/todos/", "not-a-token", nil, http.StatusUnauthorized, nil)
+	s.Call(t, http.MethodGet, "/todos/", token, nil, http.StatusOK, nil)
+
+	expired := *s.Auth
+	expired.AccessTTL = -time.Minute
+	expiredToken, err := utilities.GenerateJWT(user, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Call(t, http.MethodGet, "/todos/", expiredToken, nil, http.StatusUnauthorized, nil)
+
+	if err := s.Stores.Users.SetUserDisabled(t.Context(), user.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	s.Call(t, http.MethodGet, "/todos/", token, nil, http.StatusUnauthorized, nil)
+}
+
+func TestRefresh(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	s.SignUp(t, "alice")
+	response, _ := s.Do(t, s.Request(t, http.MethodPost, "/users/login", "", &models.LoginUser{UserName: "alice", Password: routertest.Password}))
+	refresh := s.Request(t, http.MethodPost, "/users/refresh", "", nil)
+	for _, cookie := range response.Cookies() {
+		refresh.AddCookie(cookie)
+	}
+	response, body := s.Do(t, refresh)
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(body), "token") {
+		t.Fatalf("refresh answered %d: %s", response.StatusCode, body)
+	}
+}
+
+func TestForgotPasswordSendsMail(t *testing.T) {
+	mailServer, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mailServer.Close() })
+	s := routertest.NewServer(t, nil, mailServer.Mail("todos@example.com", "app-password"))
+	s.SignUp(t, "alice")
+	s.Call(t, http.MethodPost, "/users/forgot-password", "", &models.ForgotPasswordRequest{Email: "alice@example.com"}, http.StatusOK, nil)
+	messages, err := mailServer.Wait(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := string(messages[0].Data); !strings.Contains(data, "http://localhost/reset?token=") {
+		t.Errorf("reset mail has no reset link: %q", data)
+	}
+}
+
+func hasCookie(response *http.Response, name string) bool {
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == name && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+	"todos/events"
+	"todos/models"
+	"todos/repository"
+	"todos/utilities"
+	validateapp "todos/validator"
+	"todos/webhooks"
+
+	"github.com/gorilla/mux"
+)
+
+const maxDeliveryLimit = 100
+
+func (th *TodoHandler) ListWebhooks(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	user_id := r.Context().Value("userId").(string)
+	hooks, err := repository.ListWebhooks(r.Context(), th.DB, user_id)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while fetching webhooks: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	utilities.WriteResponse(rw, hooks)
+}
+
+// CreateWebhook registers a receiver URL. The signing secret is generated here and only shown in this response.
+func (th *TodoHandler) CreateWebhook(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	request := new(models.WebhookRequest)
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		utilities.WriteError(fmt.Sprintf("error while decoding request: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	if err := validateapp.ValidateStruct(request); err != nil {
+		utilities.WriteError(fmt.Sprintf("error while validating the input: %s", err.Error()), rw, http.StatusBadRequest)
+		return
+	}
+	if parsed, err := url.Parse(request.URL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		utilities.WriteError("webhook url must be an http or https url", rw, http.StatusBadRequest)
+		return
+	}
+	for _, eventType := range request.EventTypes {
+		if !slices.Contains(events.WebhookTypes, eventType) {
+			utilities.WriteError(fmt.Sprintf("unknown event type %s, expected one of %v", eventType, events.WebhookTypes), rw, http.StatusBadRequest)
+			return
+		}
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		utilities.WriteError("error while generating webhook secret", rw, http.StatusInternalServerError)
+		return
+	}
+	user_id := r.Context().Value("userId").(string)
+	hook, err := repository.CreateWebhook(r.Context(), th.DB, user_id, request.URL, secret, request.EventTypes)
+	if err != nil {
+		utilities.WriteError(fmt.Sprintf("error while creating webhook: %s", err.Error()), rw, http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+	utilities.WriteResponse(rw, hook)
+}
+
+func (th *TodoHandler) DeleteWebhook(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	user_id := r.Context().Value("userId").(string)
+	if err := repository.DeleteWebhook(r.Context(), th.DB, id, user_id); err != nil {
+		writeWebhookError(rw, id, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// EnableWebhook and DisableWebhook switch deliveries on and off; enabling is how a webhook that was
+// disabled after repeated failures is brought back.
+func (th *TodoHandler) EnableWebhook(rw http.ResponseWriter, r *http.Request) {
+	th.setWebhookActive(rw, r, true)
+}
+
+func (th *TodoHandler) DisableWebhook(rw http.ResponseWriter, r *http.Request) {
+	th.setWebhookActive(rw, r, false)
+}
+
+func (th *TodoHandler) setWebhookActive(rw http.ResponseWriter, r *http.Request, active bool) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	user_id := r.Context().Value("userId").(string)
+	hook, err := repository.SetWebhookActive(r.Context(), th.DB, id, user_id, active)
+	if err != nil {
+		writeWebhookError(rw, id, err)
+		return
+	}
+	utilities.WriteResponse(rw, hook)
+}
+
+// PingWebhook queues a ping delivery so the receiver and its signature check can be tried out.
+func (th *TodoHandler) PingWebhook(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	user_id := r.Context().Value("userId").(string)
+	event := events.Event{Id: fmt.Sprintf("ping-%d", time.Now().UnixNano()), Type: webhooks.Ping, At: time.Now()}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		utilities.WriteError(err.Error(), rw, http.StatusInternalServerError)
+		return
+	}
+	queued, err := repository.EnqueueWebhookDeliveries(r.Context(), th.DB, user_id, id, event.Id, event.Type, payload)
+	if err == nil && queued == 0 {
+		err = repository.ErrWebhookNotFound
+	}
+	if err != nil {
+		writeWebhookError(rw, id, err)
+		return
+	}
+	rw.WriteHeader(http.StatusAccepted)
+	utilities.WriteResponse(rw, event)
+}
+
+func (th *TodoHandler) ListWebhookDeliveries(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limitInt, err := strconv.Atoi(value)
+		if err != nil || limitInt < 1 {
+			utilities.WriteError(fmt.Sprintf("Invalid limit passed %s", value), rw, http.StatusBadRequest)
+			return
+		}
+		limit = min(limitInt, maxDeliveryLimit)
+	}
+	user_id := r.Context().Value("userId").(string)
+	deliveries, err := repository.ListWebhookDeliveries(r.Context(), th.DB, id, user_id, limit)
+	if err != nil {
+		writeWebhookError(rw, id, err)
+		return
+	}
+	utilities.WriteResponse(rw, deliveries)
+}
+
+func writeWebhookError(rw http.ResponseWriter, id string, err error) {
+	if errors.Is(err, repository.ErrWebhookNotFound) {
+		utilities.WriteError(fmt.Sprintf("There is no webhook with Id: %s", id), rw, http.StatusNotFound)
+		return
+	}
+	utilities.WriteError(fmt.Sprintf("error while updating webhook: %s", err.Error()), rw, http.StatusInternalServerError)
+}
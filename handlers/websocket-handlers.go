@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"todos/events"
+	"todos/jsonpatch"
+	"todos/models"
+	"todos/repository"
+	validateapp "todos/validator"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait    = 10 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsPingInterval = 50 * time.Second
+	wsMaxMessage   = 1 << 20
+	// replies waiting to be written; a client that lets this fill up is disconnected
+	wsSendBuffer = 32
+)
+
+// messages a client sends
+const (
+	wsMutate   = "mutate"
+	wsPresence = "presence"
+	wsPing     = "ping"
+)
+
+// messages the server sends, besides the hub's events
+const (
+	wsEvent  = "event"
+	wsResult = "result"
+	wsReset  = "reset"
+	wsPong   = "pong"
+)
+
+type wsRequest struct {
+	Type      string          `json:"type"`
+	RequestId string          `json:"requestId"`
+	Op        string          `json:"op"`
+	Id        string          `json:"id"`
+	Todo      *models.Todo    `json:"todo"`
+	Patch     json.RawMessage `json:"patch"`
+	Version   *int            `json:"version"`
+	TodoId    string          `json:"todoId"`
+	State     string          `json:"state"`
+	Client    string          `json:"client"`
+}
+
+type wsMessage struct {
+	Type      string                  `json:"type"`
+	RequestId string                  `json:"requestId,omitempty"`
+	Status    int                     `json:"status,omitempty"`
+	Error     string                  `json:"error,omitempty"`
+	Todo      *models.GetTodoResponse `json:"todo,omitempty"`
+	Event     *events.Event           `json:"event,omitempty"`
+}
+
+// LiveUpdates upgrades to a WebSocket carrying the user's events out and todo mutations and presence
+// in. Mutations go through the same repository calls and ownership checks as the REST handlers and
+// are answered with a result message; their effect reaches every connection as an ordinary event.
+func (th *TodoHandler) LiveUpdates(rw http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: th.checkOrigin}
+	conn, err := upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		// Upgrade has already answered the request
+		return
+	}
+	user_id := r.Context().Value("userId").(string)
+	client := &wsClient{
+		th:           th,
+		conn:         conn,
+		userId:       user_id,
+		connectionId: newConnectionId(),
+		send:         make(chan wsMessage, wsSendBuffer),
+		done:         make(chan struct{}),
+	}
+	lastEventId := r.URL.Query().Get("lastEventId")
+	go client.writeLoop(lastEventId)
+	client.readLoop()
+}
+
+// checkOrigin only lets the frontend, and clients that are not browsers, open WebSockets
+func (th *TodoHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || origin == th.FrontEndConfig.FrontEndDomain
+}
+
+type wsClient struct {
+	th           *TodoHandler
+	conn         *websocket.Conn
+	userId       string
+	connectionId string
+	send         chan wsMessage
+	done         chan struct{}
+}
+
+func newConnectionId() string {
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+func (c *wsClient) readLoop() {
+	defer func() {
+		close(c.done)
+		c.th.Events.ClearPresence(c.userId, c.connectionId)
+		c.conn.Close()
+	}()
+	c.conn.SetReadLimit(wsMaxMessage)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		request := new(wsRequest)
+		if err = json.Unmarshal(data, request); err != nil {
+			if !c.reply(wsMessage{Type: wsResult, Status: http.StatusBadRequest, Error: err.Error()}) {
+				return
+			}
+			continue
+		}
+		var reply wsMessage
+		switch request.Type {
+		case wsMutate:
+			reply = c.mutate(request)
+		case wsPresence:
+			c.th.Events.SetPresence(c.userId, events.Presence{ConnectionId: c.connectionId, Client: request.Client, TodoId: request.TodoId, State: request.State})
+			continue
+		case wsPing:
+			reply = wsMessage{Type: wsPong, RequestId: request.RequestId}
+		default:
+			reply = wsMessage{Type: wsResult, RequestId: request.RequestId, Status: http.StatusBadRequest, Error: fmt.Sprintf("unknown message type %q", request.Type)}
+		}
+		if !c.reply(reply) {
+			return
+		}
+	}
+}
+
+// reply queues a message without blocking the reader; false means the client is not keeping up and is dropped
+func (c *wsClient) reply(message wsMessage) bool {
+	select {
+	case c.send <- message:
+		return true
+	default:
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "client too slow"), time.Now().Add(wsWriteWait))
+		return false
+	}
+}
+
+func (c *wsClient) mutate(request *wsRequest) wsMessage {
+	reply := wsMessage{Type: wsResult, RequestId: request.RequestId}
+	ctx, cancel := context.WithTimeout(context.Background(), wsWriteWait)
+	defer cancel()
+	var versions []int
+	if request.Version != nil {
+		versions = []int{*request.Version}
+	}
+	var err error
+	switch request.Op {
+	case "create":
+		if request.Todo == nil {
+			reply.Status, reply.Error = http.StatusBadRequest, "todo is required"
+			return reply
+		}
+		if err = validateapp.ValidateStruct(request.Todo); err != nil {
+			reply.Status, reply.Error = http.StatusBadRequest, fmt.Sprintf("error while validating the input: %s", err.Error())
+			return reply
+		}
+		reply.Todo, err = c.th.Todos.CreateTodo(ctx, request.Todo, c.userId)
+		if err != nil {
+			reply.Status, reply.Error = http.StatusInternalServerError, err.Error()
+			return reply
+		}
+		c.th.publish(events.TodoCreated, c.userId, reply.Todo)
+		reply.Status = http.StatusCreated
+	case "update":
+		reply.Todo, reply.Status, err = c.th.patchTodo(ctx, request.Id, c.userId, request.Patch, jsonpatch.MergePatch, versions)
+		if err != nil {
+			reply.Error = err.Error()
+		}
+	case "delete":
+		var deleted []string
+		deleted, err = c.th.Todos.DeleteTodo(ctx, request.Id, c.userId, versions)
+		switch {
+		case errors.Is(err, repository.ErrTodoNotFound):
+			reply.Status, reply.Error = http.StatusNotFound, fmt.Sprintf("There is no todo with Id: %s", request.Id)
+		case errors.Is(err, repository.ErrVersionMismatch):
+			reply.Status, reply.Error = http.StatusPreconditionFailed, err.Error()
+		case err != nil:
+			reply.Status, reply.Error = http.StatusInternalServerError, err.Error()
+		default:
+			c.th.publishDeleted(c.userId, deleted)
+			reply.Status = http.StatusNoContent
+		}
+	default:
+		reply.Status, reply.Error = http.StatusBadRequest, fmt.Sprintf("unknown op %q", request.Op)
+	}
+	return reply
+}
+
+// writeLoop is the only writer on the connection. When the hub drops the subscription because the
+// client fell behind, it resubscribes from the last event it wrote, so a slow client catches up from
+// the log instead of losing events; a reset is sent only if the log no longer reaches back that far.
+func (c *wsClient) writeLoop(lastEventId string) {
+	ping := time.NewTicker(wsPingInterval)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+	for {
+		subscription, backlog, complete := c.th.Events.Subscribe(c.userId, lastEventId)
+		if !complete {
+			if c.write(wsMessage{Type: wsReset}) != nil {
+				subscription.Close()
+				return
+			}
+			lastEventId = ""
+		}
+		for i := range backlog {
+			if c.write(wsMessage{Type: wsEvent, Event: &backlog[i]}) != nil {
+				subscription.Close()
+				return
+			}
+			lastEventId = backlog[i].Id
+		}
+		if !c.pump(subscription, ping, &lastEventId) {
+			subscription.Close()
+			return
+		}
+	}
+}
+
+// pump writes until the subscriber lags behind (true, resubscribe) or the connection or hub ends (false)
+func (c *wsClient) pump(subscription *events.Subscription, ping *time.Ticker, lastEventId *string) bool {
+	for {
+		select {
+		case <-c.done:
+			return false
+		case message := <-c.send:
+			if c.write(message) != nil {
+				return false
+			}
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if c.conn.WriteMessage(websocket.PingMessage, nil) != nil {
+				return false
+			}
+		case event, ok := <-subscription.C:
+			if !ok {
+				return subscription.Lagged()
+			}
+			if c.write(wsMessage{Type: wsEvent, Event: &event}) != nil {
+				return false
+			}
+			if event.Id != "" {
+				*lastEventId = event.Id
+			}
+		}
+	}
+}
+
+func (c *wsClient) write(message wsMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(message)
+}
@@ -0,0 +1,110 @@
+package inbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/mail"
+	"slices"
+	"strings"
+	"todos/events"
+	"todos/repository"
+)
+
+// EmailLabel is put on todos created from email so they can be told apart from ones typed in
+const EmailLabel = "email"
+
+// NewToken returns a fresh ingest secret. It doubles as the local part of the user's inbound address,
+// so it is kept to lower case hex.
+func NewToken() (string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(token)))
+	return hex.EncodeToString(sum[:])
+}
+
+func Address(token string, domain string) string {
+	if domain == "" {
+		return ""
+	}
+	return token + "@" + domain
+}
+
+// MailBackend accepts mail for "<ingest token>@domain" (plus addressing such as token+ci@domain
+// works too) and creates a todo in the owner's list for every message.
+type MailBackend struct {
+	DB     *sql.DB
+	Events *events.Hub
+	Domain string
+}
+
+var errWrongDomain = errors.New("recipient is not in the inbound domain")
+
+func (b *MailBackend) userFor(ctx context.Context, recipient string) (string, error) {
+	address, err := mail.ParseAddress("<" + recipient + ">")
+	if err != nil {
+		return "", err
+	}
+	local, domain, found := strings.Cut(address.Address, "@")
+	if !found || !strings.EqualFold(domain, b.Domain) {
+		return "", errWrongDomain
+	}
+	token, _, _ := strings.Cut(local, "+")
+	return repository.FetchUserIdByIngestKey(ctx, b.DB, HashToken(token))
+}
+
+func (b *MailBackend) Accept(ctx context.Context, recipient string) error {
+	_, err := b.userFor(ctx, recipient)
+	return err
+}
+
+func (b *MailBackend) Deliver(ctx context.Context, from string, recipients []string, data []byte) error {
+	message, err := ParseMessage(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	todo, err := message.Todo()
+	if err != nil {
+		return err
+	}
+	todo.Labels = []string{EmailLabel}
+	// every recipient is resolved before anything is created, so a rejected message leaves no todos
+	// behind for the sending MTA's retry to duplicate
+	var users []string
+	for _, recipient := range recipients {
+		user_id, err := b.userFor(ctx, recipient)
+		if err != nil {
+			return err
+		}
+		// the same user addressed twice, e.g. with two plus tags, still gets one todo
+		if !slices.Contains(users, user_id) {
+			users = append(users, user_id)
+		}
+	}
+	delivered := 0
+	for _, user_id := range users {
+		created, err := repository.CreateTodoWithAttachments(ctx, b.DB, todo, user_id, message.Attachments)
+		if err != nil {
+			// once a todo exists, rejecting the message would have the retry create it again
+			if delivered > 0 {
+				log.Printf("inbound mail from %s: creating todo for user %s: %s", from, user_id, err.Error())
+				continue
+			}
+			return err
+		}
+		delivered++
+		b.Events.Publish(events.Event{Type: events.TodoCreated, UserId: user_id, TodoId: created.Id, Todo: created})
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package inbound
+
+import (
+	"context"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"todos/events"
+	"todos/models"
+	"todos/pagination"
+	"todos/repository"
+	"todos/repository/pgtest"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	pgtest.Stop()
+	os.Exit(code)
+}
+
+func TestMailBackend(t *testing.T) {
+	store := &repository.Postgres{DB: pgtest.Open(t)}
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, &models.User{UserName: "alice", Email: "alice@example.com", HashedPassword: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := store.FetchUserWithUserID(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repository.SaveIngestKey(ctx, store.DB, user.Id, HashToken(token)); err != nil {
+		t.Fatal(err)
+	}
+	hub := events.NewHub(events.DefaultLogSize, events.DefaultBufferSize)
+	t.Cleanup(hub.Close)
+	conn := dial(t, startServer(t, &MailBackend{DB: store.DB, Events: hub, Domain: "ingest.test"}, 1<<20))
+	listTodos := func() []*models.GetTodoResponse {
+		t.Helper()
+		todos, _, err := store.ListTodos(ctx, &pagination.Request{Limit: 10}, user.Id, repository.TodoFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return todos
+	}
+
+	send(t, conn, 250, "EHLO client.test")
+	send(t, conn, 250, "MAIL FROM:<alice@example.com>")
+	send(t, conn, 550, "RCPT TO:<%s@ingest.test>", "0000"+token[4:])
+	send(t, conn, 550, "RCPT TO:<%s@elsewhere.test>", token)
+	send(t, conn, 503, "DATA")
+	if todos := listTodos(); len(todos) != 0 {
+		t.Fatalf("mail for unknown recipients created %d todos", len(todos))
+	}
+
+	send(t, conn, 250, "RCPT TO:<%s+bills@ingest.test>", token)
+	send(t, conn, 354, "DATA")
+	writer := conn.DotWriter()
+	io.WriteString(writer, multipartMessage)
+	writer.Close()
+	expect(t, conn, 250)
+	todos := listTodos()
+	if len(todos) != 1 || todos[0].Name != "Pay rent €" || todos[0].Description != "before the 5th" || !slices.Equal(todos[0].Labels, []string{EmailLabel}) {
+		t.Fatalf("todos after the message = %+v", todos)
+	}
+	attachments, err := repository.ListAttachments(ctx, store.DB, todos[0].Id, user.Id)
+	if err != nil || len(attachments) != 1 {
+		t.Fatalf("the todo has %d attachments, want 1: %v", len(attachments), err)
+	}
+	stored, err := repository.GetAttachment(ctx, store.DB, attachments[0].Id, todos[0].Id, user.Id)
+	if err != nil || stored.Filename != "invoice.pdf" || stored.ContentType != "application/pdf" || string(stored.Data) != "%PDF-1.4\n" {
+		t.Fatalf("stored attachment = %+v, %v", stored, err)
+	}
+}
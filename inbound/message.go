@@ -0,0 +1,156 @@
+package inbound
+
+import (
+	"encoding/base64"
+	"errors"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"todos/models"
+)
+
+const (
+	maxNameLength = 200
+	maxParts      = 100
+)
+
+// Message is the part of an email that becomes a todo: the subject, the text body and any attachments.
+type Message struct {
+	From        string
+	Subject     string
+	Text        string
+	Attachments []*models.Attachment
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+func ParseMessage(r io.Reader) (*Message, error) {
+	raw, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	message := &Message{From: raw.Header.Get("From")}
+	message.Subject, err = wordDecoder.DecodeHeader(raw.Header.Get("Subject"))
+	if err != nil {
+		message.Subject = raw.Header.Get("Subject")
+	}
+	var plain, htmlText string
+	parts := 0
+	var walk func(partHeader header, body io.Reader) error
+	walk = func(partHeader header, body io.Reader) error {
+		if parts++; parts > maxParts {
+			return errors.New("too many message parts")
+		}
+		mediaType, params, err := mime.ParseMediaType(partHeader.Get("Content-Type"))
+		if err != nil {
+			mediaType, params = "text/plain", map[string]string{}
+		}
+		decoded := decodeTransfer(partHeader.Get("Content-Transfer-Encoding"), body)
+		if strings.HasPrefix(mediaType, "multipart/") {
+			reader := multipart.NewReader(decoded, params["boundary"])
+			for {
+				part, err := reader.NextRawPart()
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if err = walk(part.Header, part); err != nil {
+					return err
+				}
+			}
+		}
+		disposition, dispositionParams, _ := mime.ParseMediaType(partHeader.Get("Content-Disposition"))
+		filename := dispositionParams["filename"]
+		if filename == "" {
+			filename = params["name"]
+		}
+		if disposition == "attachment" || filename != "" {
+			data, err := io.ReadAll(decoded)
+			if err != nil {
+				return err
+			}
+			if decodedName, err := wordDecoder.DecodeHeader(filename); err == nil {
+				filename = decodedName
+			}
+			if filename == "" {
+				filename = "attachment"
+			}
+			message.Attachments = append(message.Attachments, &models.Attachment{Filename: filename, ContentType: mediaType, Data: data})
+			return nil
+		}
+		data, err := io.ReadAll(decoded)
+		if err != nil {
+			return err
+		}
+		switch {
+		case mediaType == "text/plain" && plain == "":
+			plain = string(data)
+		case mediaType == "text/html" && htmlText == "":
+			htmlText = stripHTML(string(data))
+		}
+		return nil
+	}
+	if err = walk(raw.Header, raw.Body); err != nil {
+		return nil, err
+	}
+	message.Text = plain
+	if message.Text == "" {
+		message.Text = htmlText
+	}
+	message.Text = strings.TrimSpace(strings.ReplaceAll(message.Text, "\r\n", "\n"))
+	return message, nil
+}
+
+// header covers both mail.Header and the MIME headers of the parts
+type header interface {
+	Get(key string) string
+}
+
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+var (
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>|</li>`)
+	htmlTags   = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlSkip   = regexp.MustCompile(`(?is)<(style|script)[^>]*>.*?</(style|script)>`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+func stripHTML(text string) string {
+	text = htmlSkip.ReplaceAllString(text, "")
+	text = htmlBreaks.ReplaceAllString(text, "\n")
+	text = html.UnescapeString(htmlTags.ReplaceAllString(text, ""))
+	return blankLines.ReplaceAllString(text, "\n\n")
+}
+
+// Todo turns the message into a todo: the subject is the name, or the first line of the body when
+// the subject is empty, and the body is the description.
+func (m *Message) Todo() (*models.Todo, error) {
+	name := strings.TrimSpace(m.Subject)
+	description := m.Text
+	if name == "" {
+		first, rest, _ := strings.Cut(description, "\n")
+		name, description = strings.TrimSpace(first), strings.TrimSpace(rest)
+	}
+	if name == "" {
+		return nil, errors.New("message has neither a subject nor a body")
+	}
+	if runes := []rune(name); len(runes) > maxNameLength {
+		name = string(runes[:maxNameLength])
+	}
+	return &models.Todo{Name: name, Description: description}, nil
+}
@@ -0,0 +1,68 @@
+package inbound
+
+import (
+	"strings"
+	"testing"
+)
+
+const multipartMessage = "From: Alice <alice@example.com>\r\n" +
+	"Subject: =?utf-8?q?Pay_rent_=E2=82=AC?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"before the 5th\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>before the <b>5th</b></p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"invoice.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQK\r\n" +
+	"--outer--\r\n"
+
+func TestParseMessage(t *testing.T) {
+	message, err := ParseMessage(strings.NewReader(multipartMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Subject != "Pay rent €" || message.Text != "before the 5th" {
+		t.Fatalf("subject %q, text %q", message.Subject, message.Text)
+	}
+	if len(message.Attachments) != 1 {
+		t.Fatalf("%d attachments, want 1", len(message.Attachments))
+	}
+	attachment := message.Attachments[0]
+	if attachment.Filename != "invoice.pdf" || attachment.ContentType != "application/pdf" || string(attachment.Data) != "%PDF-1.4\n" {
+		t.Fatalf("attachment %q %q %q", attachment.Filename, attachment.ContentType, attachment.Data)
+	}
+}
+
+func TestMessageTodo(t *testing.T) {
+	tests := []struct {
+		subject, text     string
+		name, description string
+	}{
+		{"pay rent", "before the 5th", "pay rent", "before the 5th"},
+		{"", "pay rent\nbefore the 5th", "pay rent", "before the 5th"},
+		{strings.Repeat("é", maxNameLength+5), "", strings.Repeat("é", maxNameLength), ""},
+	}
+	for _, test := range tests {
+		todo, err := (&Message{Subject: test.subject, Text: test.text}).Todo()
+		if err != nil || todo.Name != test.name || todo.Description != test.description {
+			t.Errorf("Todo of %q, %q = %+v, %v", test.subject, test.text, todo, err)
+		}
+	}
+	if _, err := (&Message{}).Todo(); err == nil {
+		t.Error("an empty message became a todo")
+	}
+}
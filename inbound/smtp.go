@@ -0,0 +1,294 @@
+package inbound
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxRecipients = 100
+	maxLineLength = 2048
+	// DefaultMaxConns is how many sessions a Server without MaxConns serves at once
+	DefaultMaxConns = 100
+	// deliveries may be slow to arrive but a connection doing nothing for this long is dropped
+	commandTimeout = 5 * time.Minute
+)
+
+var (
+	ErrServerClosed = errors.New("smtp server closed")
+	errLineTooLong  = errors.New("line too long")
+)
+
+// Backend decides which recipients exist and what happens to a received message.
+type Backend interface {
+	Accept(ctx context.Context, recipient string) error
+	Deliver(ctx context.Context, from string, recipients []string, data []byte) error
+}
+
+// Server is a small receive-only SMTP server. It is meant to sit behind the MX that accepts mail for
+// the inbound domain, which forwards to it, so it does no TLS, authentication or relaying.
+type Server struct {
+	Domain  string
+	MaxSize int64
+	// MaxConns caps the sessions served at once, connections beyond it are turned away with a 421
+	MaxConns int
+	Backend  Backend
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.conns = make(map[net.Conn]struct{})
+	s.mu.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if len(s.conns) >= s.maxConns() {
+			s.mu.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(commandTimeout))
+			fmt.Fprintf(conn, "421 %s too busy, try again later\r\n", s.Domain)
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+				s.wg.Done()
+			}()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) maxConns() int {
+	if s.MaxConns > 0 {
+		return s.MaxConns
+	}
+	return DefaultMaxConns
+}
+
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+type session struct {
+	server *Server
+	conn   net.Conn
+	// reader buffers at most maxLineLength bytes, text reads message data through it
+	reader     *bufio.Reader
+	text       *textproto.Reader
+	writer     *textproto.Writer
+	from       string
+	inMail     bool
+	recipients []string
+	greeted    bool
+}
+
+func (s *Server) handle(conn net.Conn) {
+	reader := bufio.NewReaderSize(conn, maxLineLength)
+	sess := &session{server: s, conn: conn, reader: reader, text: textproto.NewReader(reader), writer: textproto.NewWriter(bufio.NewWriter(conn))}
+	sess.reply(220, "%s ESMTP todos inbound", s.Domain)
+	for {
+		conn.SetReadDeadline(time.Now().Add(commandTimeout))
+		line, err := sess.readLine()
+		if errors.Is(err, errLineTooLong) {
+			sess.reply(500, "line too long")
+			continue
+		}
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		if !sess.command(strings.ToUpper(verb), strings.TrimSpace(arg)) {
+			return
+		}
+	}
+}
+
+// readLine reads a command line without its line ending. A line that does not fit in the reader's
+// buffer is skipped as it arrives rather than collected, and reported as errLineTooLong.
+func (sess *session) readLine() (string, error) {
+	line, err := sess.reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = sess.reader.ReadSlice('\n')
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (sess *session) reply(code int, format string, args ...any) {
+	sess.conn.SetWriteDeadline(time.Now().Add(commandTimeout))
+	sess.writer.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+func (sess *session) reset() {
+	sess.from = ""
+	sess.inMail = false
+	sess.recipients = nil
+}
+
+// command handles one SMTP command and reports whether the session goes on
+func (sess *session) command(verb string, arg string) bool {
+	switch verb {
+	case "HELO":
+		sess.greeted = true
+		sess.reset()
+		sess.reply(250, "%s", sess.server.Domain)
+	case "EHLO":
+		sess.greeted = true
+		sess.reset()
+		sess.conn.SetWriteDeadline(time.Now().Add(commandTimeout))
+		sess.writer.PrintfLine("250-%s", sess.server.Domain)
+		sess.writer.PrintfLine("250-SIZE %d", sess.server.MaxSize)
+		sess.writer.PrintfLine("250-8BITMIME")
+		sess.writer.PrintfLine("250 PIPELINING")
+	case "MAIL":
+		if !sess.greeted {
+			sess.reply(503, "say hello first")
+			break
+		}
+		from, ok := pathArg(arg, "FROM:")
+		if !ok {
+			sess.reply(501, "syntax: MAIL FROM:<address>")
+			break
+		}
+		sess.reset()
+		sess.from = from
+		sess.inMail = true
+		sess.reply(250, "ok")
+	case "RCPT":
+		if !sess.inMail {
+			sess.reply(503, "need MAIL first")
+			break
+		}
+		recipient, ok := pathArg(arg, "TO:")
+		if !ok || recipient == "" {
+			sess.reply(501, "syntax: RCPT TO:<address>")
+			break
+		}
+		if len(sess.recipients) >= maxRecipients {
+			sess.reply(452, "too many recipients")
+			break
+		}
+		if err := sess.server.Backend.Accept(context.Background(), recipient); err != nil {
+			sess.reply(550, "no such mailbox")
+			break
+		}
+		sess.recipients = append(sess.recipients, recipient)
+		sess.reply(250, "ok")
+	case "DATA":
+		if len(sess.recipients) == 0 {
+			sess.reply(503, "need RCPT first")
+			break
+		}
+		sess.reply(354, "end data with <CR><LF>.<CR><LF>")
+		data, err := io.ReadAll(io.LimitReader(sess.text.DotReader(), sess.server.MaxSize+1))
+		if err != nil {
+			return false
+		}
+		if int64(len(data)) > sess.server.MaxSize {
+			// the rest of the message is still on the wire, there is no way to resync
+			sess.reply(552, "message exceeds %d bytes", sess.server.MaxSize)
+			return false
+		}
+		if err = sess.server.Backend.Deliver(context.Background(), sess.from, sess.recipients, data); err != nil {
+			// the reason stays in the log, it can name users or database errors
+			log.Printf("inbound mail from %s: %s", sess.from, err.Error())
+			sess.reply(554, "message rejected")
+		} else {
+			sess.reply(250, "queued")
+		}
+		sess.reset()
+	case "RSET":
+		sess.reset()
+		sess.reply(250, "ok")
+	case "NOOP":
+		sess.reply(250, "ok")
+	case "VRFY":
+		sess.reply(252, "cannot verify")
+	case "QUIT":
+		sess.reply(221, "bye")
+		return false
+	default:
+		sess.reply(502, "command not implemented")
+	}
+	return true
+}
+
+// pathArg reads the address out of "FROM:<a@b> SIZE=1" style arguments
+func pathArg(arg string, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(rest, '>')
+	if end < 0 {
+		return "", false
+	}
+	return rest[1:end], true
+}
@@ -0,0 +1,130 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingBackend accepts the recipients in its mailboxes and keeps every message delivered to it
+type recordingBackend struct {
+	mailboxes []string
+	mu        sync.Mutex
+	delivered [][]byte
+}
+
+func (b *recordingBackend) Accept(ctx context.Context, recipient string) error {
+	for _, mailbox := range b.mailboxes {
+		if strings.EqualFold(mailbox, recipient) {
+			return nil
+		}
+	}
+	return errors.New("unknown mailbox")
+}
+
+func (b *recordingBackend) Deliver(ctx context.Context, from string, recipients []string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.delivered = append(b.delivered, data)
+	return nil
+}
+
+func (b *recordingBackend) deliveries() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.delivered)
+}
+
+// startServer serves backend on a loopback port until the test ends and returns its address
+func startServer(t *testing.T, backend Backend, maxSize int64) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{Domain: "ingest.test", MaxSize: maxSize, Backend: backend}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// dial connects to addr and reads the greeting
+func dial(t *testing.T, addr string) *textproto.Conn {
+	t.Helper()
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	expect(t, conn, 220)
+	return conn
+}
+
+// send writes a command line and checks the reply code
+func send(t *testing.T, conn *textproto.Conn, code int, format string, args ...any) string {
+	t.Helper()
+	if err := conn.PrintfLine(format, args...); err != nil {
+		t.Fatal(err)
+	}
+	return expect(t, conn, code)
+}
+
+func expect(t *testing.T, conn *textproto.Conn, code int) string {
+	t.Helper()
+	_, message, err := conn.ReadResponse(code)
+	if err != nil {
+		t.Fatalf("want %d: %v", code, err)
+	}
+	return message
+}
+
+func TestLineTooLong(t *testing.T) {
+	conn := dial(t, startServer(t, &recordingBackend{}, 1<<10))
+	send(t, conn, 500, "HELO %s", strings.Repeat("a", 3*maxLineLength))
+	// the overlong line is skipped and the session goes on
+	send(t, conn, 250, "HELO client.test")
+	send(t, conn, 221, "QUIT")
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	backend := &recordingBackend{mailboxes: []string{"todo@ingest.test"}}
+	conn := dial(t, startServer(t, backend, 1<<10))
+	send(t, conn, 250, "EHLO client.test")
+	send(t, conn, 250, "MAIL FROM:<alice@example.com>")
+	send(t, conn, 250, "RCPT TO:<todo@ingest.test>")
+	send(t, conn, 354, "DATA")
+	writer := conn.DotWriter()
+	io.WriteString(writer, "Subject: big\r\n\r\n"+strings.Repeat("x", 2<<10)+"\r\n")
+	writer.Close()
+	expect(t, conn, 552)
+	if _, err := conn.ReadLine(); err == nil {
+		t.Fatal("the session went on after an oversized message")
+	}
+	if backend.deliveries() != 0 {
+		t.Fatal("an oversized message was delivered")
+	}
+}
+
+func TestUnknownRecipient(t *testing.T) {
+	backend := &recordingBackend{mailboxes: []string{"todo@ingest.test"}}
+	conn := dial(t, startServer(t, backend, 1<<10))
+	send(t, conn, 250, "HELO client.test")
+	send(t, conn, 250, "MAIL FROM:<alice@example.com>")
+	send(t, conn, 550, "RCPT TO:<nobody@ingest.test>")
+	send(t, conn, 503, "DATA")
+
+	send(t, conn, 250, "RCPT TO:<todo@ingest.test>")
+	send(t, conn, 354, "DATA")
+	writer := conn.DotWriter()
+	io.WriteString(writer, "Subject: pay rent\r\n\r\nbefore the 5th\r\n")
+	writer.Close()
+	expect(t, conn, 250)
+	if backend.deliveries() != 1 {
+		t.Fatalf("%d messages were delivered, want 1", backend.deliveries())
+	}
+}
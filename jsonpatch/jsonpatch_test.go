@@ -0,0 +1,170 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, raw string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		t.Fatalf("decoding %s: %v", raw, err)
+	}
+	return v
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		// want is the patched document, or empty when the patch must fail
+		want string
+	}{
+		// RFC 6902 appendix A
+		{"A.1 adding an object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"A.2 adding an array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"A.3 removing an object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"A.4 removing an array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"A.5 replacing a value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"A.6 moving a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"A.7 moving an array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{"A.8 testing a value: success", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{"A.9 testing a value: error", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ``},
+		{"A.10 adding a nested member object", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{"A.11 ignoring unrecognized elements", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{"A.12 adding to a nonexistent target", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ``},
+		{"A.13 invalid json patch document", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","op":"remove"}]`, ``},
+		{"A.14 escape ordering", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{"A.15 comparing strings and numbers", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, ``},
+		{"A.16 adding an array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+
+		// pointers
+		{"~1 escapes a slash", `{"a/b":1}`, `[{"op":"replace","path":"/a~1b","value":2}]`, `{"a/b":2}`},
+		{"~0 escapes a tilde", `{"m~n":1}`, `[{"op":"remove","path":"/m~0n"}]`, `{}`},
+		{"empty member name", `{"":1}`, `[{"op":"replace","path":"/","value":2}]`, `{"":2}`},
+		{"pointer without leading slash", `{"foo":1}`, `[{"op":"remove","path":"foo"}]`, ``},
+
+		// array indices
+		{"- appends", `{"foo":[1,2]}`, `[{"op":"add","path":"/foo/-","value":3}]`, `{"foo":[1,2,3]}`},
+		{"- only names a new element", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/-"}]`, ``},
+		{"- cannot be tested", `{"foo":[1,2]}`, `[{"op":"test","path":"/foo/-","value":2}]`, ``},
+		{"add at the end index", `{"foo":[1,2]}`, `[{"op":"add","path":"/foo/2","value":3}]`, `{"foo":[1,2,3]}`},
+		{"add past the end", `{"foo":[1,2]}`, `[{"op":"add","path":"/foo/3","value":3}]`, ``},
+		{"leading zero index", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/01"}]`, ``},
+		{"negative index", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/-1"}]`, ``},
+		{"nested array", `{"foo":[[1],[2]]}`, `[{"op":"add","path":"/foo/1/0","value":3}]`, `{"foo":[[1],[3,2]]}`},
+
+		// test, move and copy
+		{"test compares objects deeply", `{"a":{"b":[1,{"c":null}]}}`, `[{"op":"test","path":"/a","value":{"b":[1,{"c":null}]}}]`, `{"a":{"b":[1,{"c":null}]}}`},
+		{"test a missing member", `{"a":1}`, `[{"op":"test","path":"/b","value":1}]`, ``},
+		{"test null", `{"a":null}`, `[{"op":"test","path":"/a","value":null}]`, `{"a":null}`},
+		{"test without a value", `{"a":1}`, `[{"op":"test","path":"/a"}]`, ``},
+		{"failed test stops the patch", `{"a":1}`, `[{"op":"replace","path":"/a","value":2},{"op":"test","path":"/a","value":1}]`, ``},
+		{"copy a member", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"}]`, `{"a":{"b":1},"c":{"b":1}}`},
+		{"copy is independent of its source", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+		{"copy an array element to the end", `{"foo":[1,2]}`, `[{"op":"copy","from":"/foo/0","path":"/foo/-"}]`, `{"foo":[1,2,1]}`},
+		{"copy from a missing member", `{"a":1}`, `[{"op":"copy","from":"/b","path":"/c"}]`, ``},
+		{"move into its own child", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/c"}]`, ``},
+		{"move onto itself", `{"a":1}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":1}`},
+		{"move between arrays", `{"a":[1,2],"b":[3]}`, `[{"op":"move","from":"/a/0","path":"/b/0"}]`, `{"a":[2],"b":[1,3]}`},
+
+		// other operations
+		{"add replaces an existing member", `{"a":1}`, `[{"op":"add","path":"/a","value":2}]`, `{"a":2}`},
+		{"add null", `{}`, `[{"op":"add","path":"/a","value":null}]`, `{"a":null}`},
+		{"replace a missing member", `{"a":1}`, `[{"op":"replace","path":"/b","value":2}]`, ``},
+		{"replace the whole document", `{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+		{"add the whole document", `{"a":1}`, `[{"op":"add","path":"","value":{"b":2}}]`, `{"b":2}`},
+		{"remove the whole document", `{"a":1}`, `[{"op":"remove","path":""}]`, ``},
+		{"remove a missing member", `{"a":1}`, `[{"op":"remove","path":"/b"}]`, ``},
+		{"unknown operation", `{"a":1}`, `[{"op":"frobnicate","path":"/a"}]`, ``},
+		{"descend into a scalar", `{"a":1}`, `[{"op":"add","path":"/a/b","value":2}]`, ``},
+		{"empty patch", `{"a":1}`, `[]`, `{"a":1}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			operations, err := ParseOperations([]byte(test.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Apply(decode(t, test.doc), operations)
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("Apply = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := decode(t, test.want); !reflect.DeepEqual(got, want) {
+				t.Fatalf("Apply = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyTestFailed(t *testing.T) {
+	operations, err := ParseOperations([]byte(`[{"op":"test","path":"/a","value":2}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Apply(decode(t, `{"a":1}`), operations); !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("Apply = %v, want ErrTestFailed", err)
+	}
+	// a path that does not resolve is a malformed patch, not a failed test
+	operations, _ = ParseOperations([]byte(`[{"op":"test","path":"/b","value":2}]`))
+	if _, err = Apply(decode(t, `{"a":1}`), operations); err == nil || errors.Is(err, ErrTestFailed) {
+		t.Fatalf("Apply = %v, want an error other than ErrTestFailed", err)
+	}
+}
+
+func TestParseOperations(t *testing.T) {
+	for _, patch := range []string{`{"op":"add"}`, `[{"op":`, `null x`} {
+		if _, err := ParseOperations([]byte(patch)); err == nil {
+			t.Errorf("ParseOperations(%s) succeeded", patch)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// RFC 7396 appendix A
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.doc+" "+test.patch, func(t *testing.T) {
+			got, err := MergePatch(decode(t, test.doc), []byte(test.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := decode(t, test.want); !reflect.DeepEqual(got, want) {
+				t.Fatalf("MergePatch = %v, want %v", got, want)
+			}
+		})
+	}
+	if _, err := MergePatch(map[string]any{}, []byte(`{"a":`)); err == nil {
+		t.Fatal("MergePatch accepted malformed json")
+	}
+}
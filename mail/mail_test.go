@@ -0,0 +1,56 @@
+package mail_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"todos/mail/mailtest"
+	"todos/utilities"
+)
+
+func newServer(t *testing.T) *mailtest.Server {
+	t.Helper()
+	server, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestSendMail(t *testing.T) {
+	server := newServer(t)
+	m := server.Mail("todos@example.com", "app-password")
+	to := []string{"alice@example.com", "bob@example.com"}
+	body := utilities.GetMailBody("alice@example.com", "Reset your password", "follow the link")
+	if err := m.SendMail(context.Background(), m.GetAuth(), to, body); err != nil {
+		t.Fatalf("SendMail: %v", err)
+	}
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("received %d messages, want 1", len(messages))
+	}
+	message := messages[0]
+	if message.From != "todos@example.com" || !slices.Equal(message.To, to) {
+		t.Errorf("message from %s to %v, want from todos@example.com to %v", message.From, message.To, to)
+	}
+	if message.Username != "todos@example.com" || message.Password != "app-password" {
+		t.Errorf("logged in as %s/%s, want the sender and its password", message.Username, message.Password)
+	}
+	if data := string(message.Data); !strings.Contains(data, "Subject: Reset your password") || !strings.Contains(data, "follow the link") {
+		t.Errorf("message data is %q", data)
+	}
+}
+
+func TestSendMailWrongPassword(t *testing.T) {
+	server := newServer(t)
+	server.Username, server.Password = "todos@example.com", "app-password"
+	m := server.Mail("todos@example.com", "wrong")
+	if err := m.SendMail(context.Background(), m.GetAuth(), []string{"alice@example.com"}, []byte("Subject: x\r\n\r\nx\r\n")); err == nil {
+		t.Fatal("SendMail succeeded with a wrong password")
+	}
+	if messages := server.Messages(); len(messages) != 0 {
+		t.Errorf("received %d messages, want none", len(messages))
+	}
+}
@@ -0,0 +1,180 @@
+// Package mailtest runs a fake SMTP server on the loopback interface that accepts the PLAIN login
+// mail.Mail sends and keeps every message it receives, for tests of the code that sends mail.
+package mailtest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+	"todos/mail"
+)
+
+// Message is one message as the server received it.
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+	// Username and Password are what the client logged in with
+	Username string
+	Password string
+}
+
+type Server struct {
+	// Username and Password, when set, are the only login accepted; otherwise any login is
+	Username string
+	Password string
+
+	listener net.Listener
+	mu       sync.Mutex
+	messages []*Message
+	received chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a free loopback port. Close it when done.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener, received: make(chan struct{}, 1)}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Mail returns a mail configuration that sends through the server. smtp.PlainAuth only sends a
+// password without TLS to localhost, so the host is named that way.
+func (s *Server) Mail(from string, password string) *mail.Mail {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return &mail.Mail{From: from, Password: password, Host: "localhost", Port: port}
+}
+
+// Messages returns the messages received so far, oldest first.
+func (s *Server) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Message{}, s.messages...)
+}
+
+// Wait blocks until at least count messages have arrived, for mail sent in the background, and
+// fails after timeout.
+func (s *Server) Wait(count int, timeout time.Duration) ([]*Message, error) {
+	deadline := time.After(timeout)
+	for {
+		if messages := s.Messages(); len(messages) >= count {
+			return messages, nil
+		}
+		select {
+		case <-s.received:
+		case <-deadline:
+			return nil, fmt.Errorf("received %d messages in %s, want %d", len(s.Messages()), timeout, count)
+		}
+	}
+}
+
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Minute))
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *Server) handle(text *textproto.Conn) {
+	text.PrintfLine("220 localhost ESMTP mailtest")
+	message := new(Message)
+	var username, password string
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250-localhost")
+			text.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			if !strings.EqualFold(mechanism, "PLAIN") {
+				text.PrintfLine("504 only PLAIN is supported")
+				continue
+			}
+			username, password = decodePlain(initial)
+			if s.Username != "" && (username != s.Username || password != s.Password) {
+				text.PrintfLine("535 authentication failed")
+				continue
+			}
+			text.PrintfLine("235 authenticated")
+		case "MAIL":
+			message = &Message{From: address(arg), Username: username, Password: password}
+			text.PrintfLine("250 ok")
+		case "RCPT":
+			message.To = append(message.To, address(arg))
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			message.Data = data
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			select {
+			case s.received <- struct{}{}:
+			default:
+			}
+			text.PrintfLine("250 queued")
+		case "RSET", "NOOP":
+			text.PrintfLine("250 ok")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+// decodePlain reads the username and password out of a SASL PLAIN response
+func decodePlain(encoded string) (string, string) {
+	raw, _ := base64.StdEncoding.DecodeString(encoded)
+	parts := strings.SplitN(string(raw), "\x00", 3)
+	if len(parts) != 3 {
+		return "", ""
+	}
+	return parts[1], parts[2]
+}
+
+// address reads the address out of "FROM:<a@b>" style arguments
+func address(arg string) string {
+	start := strings.IndexByte(arg, '<')
+	end := strings.LastIndexByte(arg, '>')
+	if start < 0 || end < start {
+		return ""
+	}
+	return arg[start+1 : end]
+}
@@ -1,9 +1,188 @@
+// Command todos runs the todo api and the operational tasks around it.
+//
+//	todos serve
+//	todos migrate up
+//	todos user create -email alice@example.com alice
+//	todos user disable alice
+//	todos tokens revoke -user alice
+//	todos purge-trash -older-than 720h
+//	todos export -user alice -format csv > alice.csv
+//
+// Without a command it serves. Every command reads the same environment and local.env as the server.
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"todos/config"
+	"todos/repository"
 	"todos/server"
 )
 
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(admin *admin, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"serve", "serve", "run the http, grpc and smtp servers", runServe},
+		{"migrate", "migrate up [-to version] | down [-steps n] | status", "apply or revert schema migrations, or list them", runMigrate},
+		{"user", "user create -email address [-password-stdin] username | disable username | enable username | reset-password [-password-stdin] username", "manage accounts", runUser},
+		{"tokens", "tokens revoke -user username", "revoke a user's refresh tokens, signing them out everywhere", runTokens},
+		{"purge-trash", "purge-trash [-older-than duration] [-user username] [-dry-run]", "remove deleted todos for good", runPurgeTrash},
+		{"export", "export -user username [-format json|ndjson|csv|markdown] [-status status] [-root id] [-o file]", "write a user's todos to stdout or a file", runExport},
+	}
+}
+
+// admin carries what the commands share; the store is only opened when a command asks for it
+type admin struct {
+	ctx    context.Context
+	db     *sql.DB
+	opened *repository.Stores
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// stores opens the store the configuration selects, the same one the server uses
+func (a *admin) stores() (*repository.Stores, error) {
+	if a.opened == nil {
+		stores, err := server.OpenStores(config.LoadConfiguration())
+		if err != nil {
+			return nil, err
+		}
+		a.opened = stores
+	}
+	return a.opened, nil
+}
+
+func (a *admin) database() *sql.DB {
+	if a.db == nil {
+		// DBinit panics when the database cannot be reached, which is as good an exit as any here
+		a.db, _ = config.DBinit(&config.LoadConfiguration().DBconfig)
+	}
+	return a.db
+}
+
 func main() {
-	server.StartServer()
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := run(ctx, args)
+	var badUsage usageError
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return
+	case errors.As(err, &badUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "todos: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		usage(os.Stdout)
+		return nil
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			admin := &admin{ctx: ctx, stdin: os.Stdin, stdout: os.Stdout}
+			defer func() {
+				if admin.db != nil {
+					admin.db.Close()
+				}
+				if admin.opened != nil {
+					admin.opened.Close()
+				}
+			}()
+			return cmd.run(admin, args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "todos: unknown command %q\n", args[0])
+	usage(os.Stderr)
+	return usageError{}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: todos [command [flags] [args]]")
+	fmt.Fprintln(w)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "without a command todos serves; run todos command -h for the flags of a command")
+}
+
+// usageError means the problem has already been explained on stderr
+type usageError struct {
+	error
+}
+
+func flagError(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return usageError{err}
+}
+
+// parseFlags parses a command's flags, which may be mixed with its arguments
+func parseFlags(flags *flag.FlagSet, cmd string, args []string) ([]string, error) {
+	flags.Usage = func() {
+		for _, c := range commands {
+			if c.name == cmd {
+				fmt.Fprintf(flags.Output(), "usage: todos %s\n", c.usage)
+			}
+		}
+		flags.PrintDefaults()
+	}
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, flagError(err)
+		}
+		rest := flags.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// subcommand splits off the action of a command such as user or migrate
+func subcommand(cmd string, args []string, actions ...string) (string, []string, error) {
+	if len(args) > 0 {
+		for _, action := range actions {
+			if args[0] == action {
+				return action, args[1:], nil
+			}
+		}
+	}
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "todos: unknown %s action %q\n", cmd, args[0])
+	}
+	for _, c := range commands {
+		if c.name == cmd {
+			fmt.Fprintf(os.Stderr, "usage: todos %s\n", c.usage)
+		}
+	}
+	return "", nil, usageError{}
 }
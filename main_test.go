@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"todos/models"
+	"todos/repository"
+	"todos/repository/memory"
+)
+
+// memoryAdmin runs commands against a memory store, returning what they print
+func memoryAdmin(t *testing.T) (*admin, *strings.Builder) {
+	t.Helper()
+	stdout := new(strings.Builder)
+	return &admin{ctx: context.Background(), opened: memory.Stores(), stdin: strings.NewReader(""), stdout: stdout}, stdout
+}
+
+// runCommand runs one admin command and fails the test unless its output contains want
+func runCommand(t *testing.T, admin *admin, stdout *strings.Builder, want string, run func(*admin, []string) error, args ...string) {
+	t.Helper()
+	stdout.Reset()
+	if err := run(admin, args); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	if !strings.Contains(stdout.String(), want) {
+		t.Fatalf("%v printed %q, want %q", args, stdout.String(), want)
+	}
+}
+
+func createAlice(t *testing.T, admin *admin, stdout *strings.Builder) *models.User {
+	t.Helper()
+	admin.stdin = strings.NewReader("correct horse\n")
+	runCommand(t, admin, stdout, "created user alice", runUser, "create", "-email", "alice@example.com", "-password-stdin", "alice")
+	user, err := admin.opened.Users.FetchUserWithUserID(admin.ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func saveRefreshTokens(t *testing.T, admin *admin, userId string, hashes ...string) {
+	t.Helper()
+	for _, hash := range hashes {
+		refresh := &models.SaveRefresh{UserId: userId, TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := admin.opened.Tokens.SaveRefreshToken(admin.ctx, refresh); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUserCommands(t *testing.T) {
+	admin, stdout := memoryAdmin(t)
+	user := createAlice(t, admin, stdout)
+	if strings.Contains(stdout.String(), "password:") {
+		t.Error("a password read from stdin was printed")
+	}
+	saveRefreshTokens(t, admin, user.Id, "one")
+
+	runCommand(t, admin, stdout, "disabled user alice", runUser, "disable", "alice")
+	if user, _ = admin.opened.Users.FetchUserByID(admin.ctx, user.Id); user.DisabledAt == nil {
+		t.Fatal("alice is not disabled")
+	}
+	if refresh, _ := admin.opened.Tokens.FetchRefreshToken(admin.ctx, "one"); !refresh.Revoked {
+		t.Error("disabling alice left her refresh token working")
+	}
+	runCommand(t, admin, stdout, "enabled user alice", runUser, "enable", "alice")
+	if user, _ = admin.opened.Users.FetchUserByID(admin.ctx, user.Id); user.DisabledAt != nil {
+		t.Fatal("alice is still disabled")
+	}
+
+	if err := runUser(admin, []string{"disable", "bob"}); err == nil || !strings.Contains(err.Error(), "user bob") {
+		t.Errorf("disabling an unknown user: %v", err)
+	}
+	var badUsage usageError
+	if err := runUser(admin, []string{"disable"}); !errors.As(err, &badUsage) {
+		t.Errorf("disable without a username: %v, want a usage error", err)
+	}
+}
+
+func TestTokensRevoke(t *testing.T) {
+	admin, stdout := memoryAdmin(t)
+	user := createAlice(t, admin, stdout)
+	saveRefreshTokens(t, admin, user.Id, "one", "two")
+	runCommand(t, admin, stdout, "revoked 2 refresh tokens of alice", runTokens, "revoke", "-user", "alice")
+	for _, hash := range []string{"one", "two"} {
+		if refresh, _ := admin.opened.Tokens.FetchRefreshToken(admin.ctx, hash); !refresh.Revoked {
+			t.Errorf("refresh token %s still works", hash)
+		}
+	}
+	runCommand(t, admin, stdout, "revoked 0 refresh tokens of alice", runTokens, "revoke", "-user", "alice")
+	var badUsage usageError
+	if err := runTokens(admin, []string{"revoke"}); !errors.As(err, &badUsage) {
+		t.Errorf("revoke without -user: %v, want a usage error", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	admin, stdout := memoryAdmin(t)
+	alice := createAlice(t, admin, stdout)
+	bob := &models.User{UserName: "bob", Email: "bob@example.com", HashedPassword: "hash"}
+	if err := admin.opened.Users.CreateUser(admin.ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+	bob, _ = admin.opened.Users.FetchUserWithUserID(admin.ctx, "bob")
+	todos := admin.opened.Todos
+	create := func(userId string, name string, deleted bool) {
+		t.Helper()
+		todo, err := todos.CreateTodo(admin.ctx, &models.Todo{Name: name}, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted {
+			if _, err = todos.DeleteTodo(admin.ctx, todo.Id, userId, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	create(alice.Id, "kept", false)
+	create(alice.Id, "trash", true)
+	create(alice.Id, "more trash", true)
+	create(bob.Id, "trash", true)
+	deleted := func(userId string) int64 {
+		t.Helper()
+		count, err := todos.CountDeletedTodos(admin.ctx, userId, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// the default keeps a month of trash
+	runCommand(t, admin, stdout, "purged 0 todos", runPurgeTrash)
+	runCommand(t, admin, stdout, "would purge 2 todos", runPurgeTrash, "-older-than", "0s", "-user", "alice", "-dry-run")
+	if deleted(alice.Id) != 2 {
+		t.Fatal("a dry run purged todos")
+	}
+	runCommand(t, admin, stdout, "purged 2 todos", runPurgeTrash, "-older-than", "0s", "-user", "alice")
+	if deleted(alice.Id) != 0 || deleted(bob.Id) != 1 {
+		t.Fatalf("after purging alice's trash alice has %d and bob %d deleted todos", deleted(alice.Id), deleted(bob.Id))
+	}
+	if count, _ := todos.CountTodos(admin.ctx, alice.Id, repository.TodoFilter{}); count != 1 {
+		t.Fatalf("alice has %d todos left, want the kept one", count)
+	}
+	runCommand(t, admin, stdout, "purged 1 todos", runPurgeTrash, "-older-than", "0s")
+	if deleted(bob.Id) != 0 {
+		t.Fatal("purging everyone's trash left bob's")
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"todos/repository"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCAuth returns interceptors that accept the same access tokens as AuthMiddleWare, sent as
+// "authorization: Bearer <token>" metadata, and put the user id into the context the same way.
+// Calls to the services named in public, such as reflection, are let through unauthenticated.
+func GRPCAuth(secret string, users repository.UserStore, public ...string) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	isPublic := func(method string) bool {
+		for _, service := range public {
+			if strings.HasPrefix(method, "/"+service+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if isPublic(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticate(ctx, secret, users)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isPublic(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, err := authenticate(ss.Context(), secret, users)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+	return unary, stream
+}
+
+func authenticate(ctx context.Context, secret string, users repository.UserStore) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+	arr := strings.Split(values[0], " ")
+	if len(arr) != 2 || arr[0] != "Bearer" {
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization metadata")
+	}
+	user, err := UserFromToken(ctx, secret, users, arr[1])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return context.WithValue(ctx, "userId", user.Id), nil
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
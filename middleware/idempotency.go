@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+	"todos/repository"
+	"todos/utilities"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+	// large enough for the biggest import upload plus multipart framing
+	maxIdempotentBody = 11 << 20
+)
+
+// replayed responses only carry the headers a client can act on, not e.g. rate limit state of the first call
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// Idempotency makes requests carrying an Idempotency-Key safe to retry: the first response is stored
+// for ttl and sent again for any retry with the same key and body, without running the handler twice.
+// Keys are scoped to the authenticated user, so it has to run after AuthMiddleWare where there is one.
+// Keys are kept in postgres; without a database the key is ignored and every request runs.
+func Idempotency(db *sql.DB, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if db == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if len(key) > maxIdempotencyKeyLen {
+				utilities.WriteError("Idempotency-Key is too long", w, http.StatusBadRequest)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+			if err != nil {
+				utilities.WriteError("error while reading request", w, http.StatusBadRequest)
+				return
+			}
+			if len(body) > maxIdempotentBody {
+				utilities.WriteError("request body too large", w, http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			scope, _ := r.Context().Value("userId").(string)
+			fingerprint := requestFingerprint(r, body)
+
+			reserved, record, err := repository.ReserveIdempotencyKey(r.Context(), db, scope, key, fingerprint, ttl)
+			if err != nil {
+				utilities.WriteError("error while checking Idempotency-Key", w, http.StatusInternalServerError)
+				return
+			}
+			if !reserved {
+				switch {
+				case record == nil:
+					// the conflicting row expired and was removed between our insert and select
+					utilities.WriteError("Idempotency-Key is being reused, retry the request", w, http.StatusConflict)
+				case record.Fingerprint != fingerprint:
+					utilities.WriteError("Idempotency-Key was already used for a different request", w, http.StatusUnprocessableEntity)
+				case record.Status == 0:
+					utilities.WriteError("a request with this Idempotency-Key is still being processed", w, http.StatusConflict)
+				default:
+					for name, value := range record.Headers {
+						w.Header().Set(name, value)
+					}
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(record.Status)
+					w.Write(record.Body)
+				}
+				return
+			}
+
+			// the outcome is stored even if the client has gone away, that is exactly the retry case
+			ctx := context.WithoutCancel(r.Context())
+			rec := &recorder{ResponseWriter: w}
+			completed := false
+			defer func() {
+				if !completed {
+					if err := repository.ReleaseIdempotencyKey(ctx, db, scope, key); err != nil {
+						log.Printf("releasing idempotency key: %s", err.Error())
+					}
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			// server errors are not remembered so that a retry gets another chance
+			if rec.status >= http.StatusInternalServerError {
+				return
+			}
+			headers := make(map[string]string)
+			for _, name := range replayedHeaders {
+				if value := w.Header().Get(name); value != "" {
+					headers[name] = value
+				}
+			}
+			if err := repository.CompleteIdempotencyKey(ctx, db, scope, key, rec.status, headers, rec.body.Bytes()); err != nil {
+				log.Printf("storing idempotent response: %s", err.Error())
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n"+r.Header.Get("Content-Type")+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
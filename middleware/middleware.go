@@ -3,11 +3,13 @@ package middleware
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
+	"todos/models"
 	"todos/repository"
 	"todos/utilities"
 )
@@ -74,7 +76,7 @@ func (rl *RateLimiter) RateLimiterMiddleWare(next http.Handler) http.Handler {
 	})
 }
 
-func AuthMiddleWare(secret string, db *sql.DB) func(http.Handler) http.Handler {
+func AuthMiddleWare(secret string, users repository.UserStore) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -84,20 +86,9 @@ func AuthMiddleWare(secret string, db *sql.DB) func(http.Handler) http.Handler {
 				utilities.WriteError("invalid Authorization header in the request", w, http.StatusUnauthorized)
 				return
 			}
-			claim, err := utilities.GetClaimFromJWT(arr[1], secret)
+			user, err := UserFromToken(r.Context(), secret, users, arr[1])
 			if err != nil {
-				utilities.WriteError("error fetching claim from token", w, http.StatusUnauthorized)
-				return
-			}
-			if time.Now().After(claim.Expires_At) {
-				utilities.WriteError("token is already expired", w, http.StatusUnauthorized)
-				return
-			}
-			userName := claim.UserName
-			user, err := repository.FetchUserWithUserID(r.Context(), db, userName)
-			if err != nil {
-				utilities.WriteError("token is not linked to any real user", w, http.StatusUnauthorized)
-				w.Header().Set("Content-Type", "application/json")
+				utilities.WriteError(err.Error(), w, http.StatusUnauthorized)
 				return
 			}
 			ctx := context.WithValue(r.Context(), "userId", user.Id)
@@ -106,12 +97,32 @@ func AuthMiddleWare(secret string, db *sql.DB) func(http.Handler) http.Handler {
 	}
 }
 
+// UserFromToken returns the user an access token was issued to, if the token is valid and unexpired
+func UserFromToken(ctx context.Context, secret string, users repository.UserStore, token string) (*models.User, error) {
+	claim, err := utilities.GetClaimFromJWT(token, secret)
+	if err != nil {
+		return nil, errors.New("error fetching claim from token")
+	}
+	if time.Now().After(claim.Expires_At) {
+		return nil, errors.New("token is already expired")
+	}
+	user, err := users.FetchUserWithUserID(ctx, claim.UserName)
+	if err != nil {
+		return nil, errors.New("token is not linked to any real user")
+	}
+	if user.DisabledAt != nil {
+		return nil, errors.New("user has been disabled")
+	}
+	return user, nil
+}
+
 func CorsMiddleWare(origin string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match, Idempotency-Key, Last-Event-ID")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Idempotent-Replayed, Link, Location, X-Total-Count")
 			if r.Method == http.MethodOptions {
 				w.WriteHeader(http.StatusOK)
 				return
@@ -135,3 +146,16 @@ func extractIP(r *http.Request) string {
 	}
 	return host
 }
+
+// RequireDB answers 501 for features that only the postgres store supports when the server runs on another store.
+func RequireDB(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if db != nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			utilities.WriteError("this feature needs the postgres store", w, http.StatusNotImplemented)
+		})
+	}
+}
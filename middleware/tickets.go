@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+	"todos/repository"
+	"todos/utilities"
+)
+
+// TicketTTL is how long a ticket can wait before it is redeemed
+const TicketTTL = 30 * time.Second
+
+// Tickets stand in for the access token on connections a browser cannot put an Authorization header on,
+// the EventSource behind /events and the WebSockets behind /ws and /graphql. A ticket is bought with the
+// access token, lives for TicketTTL and opens a single connection, so the copy that ends up in access
+// logs and browser history is worthless.
+type Tickets struct {
+	ttl    time.Duration
+	mu     sync.Mutex
+	issued map[string]ticket
+}
+
+type ticket struct {
+	userId    string
+	expiresAt time.Time
+}
+
+func NewTickets(ttl time.Duration) *Tickets {
+	return &Tickets{ttl: ttl, issued: map[string]ticket{}}
+}
+
+// Issue returns a new ticket for the user and when it expires
+func (t *Tickets) Issue(userId string) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	value := hex.EncodeToString(buf)
+	now := time.Now()
+	expiresAt := now.Add(t.ttl)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// expired tickets are dropped here rather than by a background sweep
+	for key, issued := range t.issued {
+		if now.After(issued.expiresAt) {
+			delete(t.issued, key)
+		}
+	}
+	t.issued[value] = ticket{userId: userId, expiresAt: expiresAt}
+	return value, expiresAt, nil
+}
+
+// Redeem returns the user a ticket was issued to and forgets the ticket, so it works only once
+func (t *Tickets) Redeem(value string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	issued, ok := t.issued[value]
+	if !ok {
+		return "", false
+	}
+	delete(t.issued, value)
+	if time.Now().After(issued.expiresAt) {
+		return "", false
+	}
+	return issued.userId, true
+}
+
+// TicketAuth authenticates a request without an Authorization header by the ticket query parameter
+// and leaves every other request to auth.
+func TicketAuth(tickets *Tickets, users repository.UserStore, auth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authenticated := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.URL.Query().Get("ticket")
+			if value == "" || r.Header.Get("Authorization") != "" {
+				authenticated.ServeHTTP(w, r)
+				return
+			}
+			userId, ok := tickets.Redeem(value)
+			if !ok {
+				utilities.WriteError("ticket is invalid, expired or already used", w, http.StatusUnauthorized)
+				return
+			}
+			user, err := users.FetchUserByID(r.Context(), userId)
+			if err != nil {
+				utilities.WriteError("ticket is not linked to any real user", w, http.StatusUnauthorized)
+				return
+			}
+			if user.DisabledAt != nil {
+				utilities.WriteError("user has been disabled", w, http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(r.Context(), "userId", user.Id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,12 @@
+-- drops everything the baseline created, including every user and todo; the extensions are left installed
+drop table if exists attachments;
+drop table if exists ingest_keys;
+drop table if exists webhook_deliveries;
+drop table if exists webhooks;
+drop table if exists idempotency_keys;
+drop table if exists forgotpassword;
+drop table if exists refresh;
+drop table if exists todo;
+drop table if exists users;
+drop function if exists todo_change_track();
+drop function if exists todo_search_vector_update();
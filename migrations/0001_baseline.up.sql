@@ -0,0 +1,188 @@
+-- the schema as it stood before migrations; every statement tolerates objects that already exist so that
+-- databases set up from the old db/schema.sql are adopted by running it
+create extension if not exists pgcrypto;
+create extension if not exists pg_trgm;
+
+create table if not exists users (
+    id              uuid primary key default gen_random_uuid(),
+    username        text not null unique,
+    email           text not null unique,
+    hashpassword    text not null,
+    search_language regconfig not null default 'simple',
+    created_at      timestamptz not null default now()
+);
+
+create table if not exists todo (
+    id            uuid primary key default gen_random_uuid(),
+    user_id       uuid not null references users (id) on delete cascade,
+    parent_id     uuid references todo (id) on delete cascade,
+    name          text not null,
+    description   text not null default '',
+    status        integer not null default 0,
+    priority      integer not null default 0,
+    labels        text[] not null default '{}',
+    recurrence    text not null default '',
+    due_at        timestamptz,
+    created_at    timestamptz not null default now(),
+    version       integer not null default 1,
+    search_vector tsvector,
+    deleted_at    timestamptz,
+    field_clocks  jsonb not null default '{}',
+    change_xid    xid8 not null default pg_current_xact_id()
+);
+
+create index if not exists todo_user_created_idx on todo (user_id, created_at desc);
+create index if not exists todo_parent_idx on todo (parent_id);
+create index if not exists todo_labels_idx on todo using gin (labels);
+create index if not exists todo_search_idx on todo using gin (search_vector);
+create index if not exists todo_name_trgm_idx on todo using gin (name gin_trgm_ops);
+create index if not exists todo_description_trgm_idx on todo using gin (description gin_trgm_ops);
+create index if not exists todo_changes_idx on todo (user_id, change_xid, id);
+
+-- search_vector is stemmed with the owner's search_language, which a generated column cannot look up
+create or replace function todo_search_vector_update() returns trigger as $$
+declare
+    cfg regconfig;
+begin
+    select search_language into cfg from users where id = new.user_id;
+    cfg := coalesce(cfg, 'simple');
+    new.search_vector :=
+        setweight(to_tsvector(cfg, coalesce(new.name, '')), 'A') ||
+        setweight(to_tsvector(cfg, coalesce(new.description, '')), 'B') ||
+        setweight(to_tsvector('simple', array_to_string(new.labels, ' ')), 'C');
+    return new;
+end
+$$ language plpgsql;
+
+drop trigger if exists todo_search_vector_trigger on todo;
+create trigger todo_search_vector_trigger
+    before insert or update of name, description, labels on todo
+    for each row execute function todo_search_vector_update();
+
+-- feeds GET /sync: change_xid records the last writing transaction and field_clocks when each field
+-- last changed. Sync pushes write field_clocks themselves, every other write gets the fields it changed stamped.
+create or replace function todo_change_track() returns trigger as $$
+declare
+    stamp jsonb := to_jsonb(clock_timestamp());
+begin
+    new.change_xid := pg_current_xact_id();
+    if tg_op = 'INSERT' then
+        if new.field_clocks = '{}' then
+            new.field_clocks := jsonb_build_object('name', stamp, 'description', stamp, 'status', stamp, 'priority', stamp,
+                'labels', stamp, 'recurrence', stamp, 'dueAt', stamp, 'parentId', stamp);
+        end if;
+        return new;
+    end if;
+    if new.field_clocks is distinct from old.field_clocks then
+        return new;
+    end if;
+    if new.name is distinct from old.name then new.field_clocks := new.field_clocks || jsonb_build_object('name', stamp); end if;
+    if new.description is distinct from old.description then new.field_clocks := new.field_clocks || jsonb_build_object('description', stamp); end if;
+    if new.status is distinct from old.status then new.field_clocks := new.field_clocks || jsonb_build_object('status', stamp); end if;
+    if new.priority is distinct from old.priority then new.field_clocks := new.field_clocks || jsonb_build_object('priority', stamp); end if;
+    if new.labels is distinct from old.labels then new.field_clocks := new.field_clocks || jsonb_build_object('labels', stamp); end if;
+    if new.recurrence is distinct from old.recurrence then new.field_clocks := new.field_clocks || jsonb_build_object('recurrence', stamp); end if;
+    if new.due_at is distinct from old.due_at then new.field_clocks := new.field_clocks || jsonb_build_object('dueAt', stamp); end if;
+    if new.parent_id is distinct from old.parent_id then new.field_clocks := new.field_clocks || jsonb_build_object('parentId', stamp); end if;
+    if new.deleted_at is distinct from old.deleted_at then new.field_clocks := new.field_clocks || jsonb_build_object('deleted', stamp); end if;
+    return new;
+end
+$$ language plpgsql;
+
+drop trigger if exists todo_change_trigger on todo;
+create trigger todo_change_trigger
+    before insert or update on todo
+    for each row execute function todo_change_track();
+
+create table if not exists refresh (
+    id         bigserial primary key,
+    user_id    uuid not null references users (id) on delete cascade,
+    token_hash text not null unique,
+    expires_at timestamptz not null,
+    revoked    boolean not null default false,
+    created_at timestamptz not null default now()
+);
+
+create index if not exists refresh_user_idx on refresh (user_id);
+
+create table if not exists forgotpassword (
+    id         bigserial primary key,
+    userid     uuid not null references users (id) on delete cascade,
+    email      text not null,
+    token      text not null unique,
+    expires_at timestamptz not null default now() + interval '15 minutes',
+    used       boolean not null default false,
+    created_at timestamptz not null default now()
+);
+
+create index if not exists forgotpassword_email_idx on forgotpassword (email, created_at desc);
+create index if not exists forgotpassword_userid_idx on forgotpassword (userid);
+
+-- responses to requests sent with an Idempotency-Key; status is null while the first request is still running
+create table if not exists idempotency_keys (
+    scope       text not null,
+    key         text not null,
+    fingerprint text not null,
+    status      integer,
+    headers     jsonb not null default '{}',
+    body        bytea not null default '',
+    created_at  timestamptz not null default now(),
+    expires_at  timestamptz not null,
+    primary key (scope, key)
+);
+
+create index if not exists idempotency_keys_expires_idx on idempotency_keys (expires_at);
+
+create table if not exists webhooks (
+    id            uuid primary key default gen_random_uuid(),
+    user_id       uuid not null references users (id) on delete cascade,
+    url           text not null,
+    secret        text not null,
+    event_types   text[] not null default '{}',
+    active        boolean not null default true,
+    failure_count integer not null default 0,
+    disabled_at   timestamptz,
+    created_at    timestamptz not null default now()
+);
+
+create index if not exists webhooks_user_idx on webhooks (user_id);
+
+-- one row per event and webhook; the last attempt's outcome is kept on the row
+create table if not exists webhook_deliveries (
+    id              bigserial primary key,
+    webhook_id      uuid not null references webhooks (id) on delete cascade,
+    event_id        text not null,
+    event_type      text not null,
+    payload         jsonb not null,
+    status          text not null default 'pending',
+    attempts        integer not null default 0,
+    response_status integer,
+    response_body   text not null default '',
+    error           text not null default '',
+    next_attempt_at timestamptz not null default now(),
+    locked_until    timestamptz,
+    created_at      timestamptz not null default now(),
+    delivered_at    timestamptz
+);
+
+create index if not exists webhook_deliveries_due_idx on webhook_deliveries (next_attempt_at) where status = 'pending';
+create index if not exists webhook_deliveries_webhook_idx on webhook_deliveries (webhook_id, created_at desc);
+
+-- the secret behind a user's ingest URL and email address, stored hashed like refresh tokens
+create table if not exists ingest_keys (
+    user_id    uuid primary key references users (id) on delete cascade,
+    token_hash text not null unique,
+    created_at timestamptz not null default now()
+);
+
+create table if not exists attachments (
+    id           uuid primary key default gen_random_uuid(),
+    todo_id      uuid not null references todo (id) on delete cascade,
+    filename     text not null,
+    content_type text not null,
+    size         integer not null,
+    data         bytea not null,
+    created_at   timestamptz not null default now()
+);
+
+create index if not exists attachments_todo_idx on attachments (todo_id);
@@ -0,0 +1,50 @@
+-- rebuilt while the change trigger still skips search_vector only updates
+update todo t set search_vector =
+    setweight(to_tsvector(u.search_language, coalesce(t.name, '')), 'A') ||
+    setweight(to_tsvector(u.search_language, coalesce(t.description, '')), 'B') ||
+    setweight(to_tsvector('simple', array_to_string(t.labels, ' ')), 'C')
+from users u where u.id = t.user_id;
+
+create or replace function todo_search_vector_update() returns trigger as $$
+declare
+    cfg regconfig;
+begin
+    select search_language into cfg from users where id = new.user_id;
+    cfg := coalesce(cfg, 'simple');
+    new.search_vector :=
+        setweight(to_tsvector(cfg, coalesce(new.name, '')), 'A') ||
+        setweight(to_tsvector(cfg, coalesce(new.description, '')), 'B') ||
+        setweight(to_tsvector('simple', array_to_string(new.labels, ' ')), 'C');
+    return new;
+end
+$$ language plpgsql;
+
+create or replace function todo_change_track() returns trigger as $$
+declare
+    stamp jsonb := to_jsonb(clock_timestamp());
+begin
+    new.change_xid := pg_current_xact_id();
+    if tg_op = 'INSERT' then
+        if new.field_clocks = '{}' then
+            new.field_clocks := jsonb_build_object('name', stamp, 'description', stamp, 'status', stamp, 'priority', stamp,
+                'labels', stamp, 'recurrence', stamp, 'dueAt', stamp, 'parentId', stamp);
+        end if;
+        return new;
+    end if;
+    if new.field_clocks is distinct from old.field_clocks then
+        return new;
+    end if;
+    if new.name is distinct from old.name then new.field_clocks := new.field_clocks || jsonb_build_object('name', stamp); end if;
+    if new.description is distinct from old.description then new.field_clocks := new.field_clocks || jsonb_build_object('description', stamp); end if;
+    if new.status is distinct from old.status then new.field_clocks := new.field_clocks || jsonb_build_object('status', stamp); end if;
+    if new.priority is distinct from old.priority then new.field_clocks := new.field_clocks || jsonb_build_object('priority', stamp); end if;
+    if new.labels is distinct from old.labels then new.field_clocks := new.field_clocks || jsonb_build_object('labels', stamp); end if;
+    if new.recurrence is distinct from old.recurrence then new.field_clocks := new.field_clocks || jsonb_build_object('recurrence', stamp); end if;
+    if new.due_at is distinct from old.due_at then new.field_clocks := new.field_clocks || jsonb_build_object('dueAt', stamp); end if;
+    if new.parent_id is distinct from old.parent_id then new.field_clocks := new.field_clocks || jsonb_build_object('parentId', stamp); end if;
+    if new.deleted_at is distinct from old.deleted_at then new.field_clocks := new.field_clocks || jsonb_build_object('deleted', stamp); end if;
+    return new;
+end
+$$ language plpgsql;
+
+drop function if exists todo_search_vector(regconfig, text, text, text[]);
@@ -0,0 +1,53 @@
+-- labels are stemmed with the owner's search_language like name and description, so the query, which
+-- is built with it, matches them. The trigger and changes of search_language share this function.
+create or replace function todo_search_vector(cfg regconfig, name text, description text, labels text[]) returns tsvector as $$
+    select setweight(to_tsvector(cfg, coalesce(name, '')), 'A') ||
+        setweight(to_tsvector(cfg, coalesce(description, '')), 'B') ||
+        setweight(to_tsvector(cfg, array_to_string(labels, ' ')), 'C')
+$$ language sql immutable;
+
+create or replace function todo_search_vector_update() returns trigger as $$
+declare
+    cfg regconfig;
+begin
+    select search_language into cfg from users where id = new.user_id;
+    new.search_vector := todo_search_vector(coalesce(cfg, 'simple'), new.name, new.description, new.labels);
+    return new;
+end
+$$ language plpgsql;
+
+-- an update that only rebuilds search_vector is not a change of the todo, so it leaves change_xid and
+-- field_clocks alone and sync clients do not download the todo again
+create or replace function todo_change_track() returns trigger as $$
+declare
+    stamp jsonb := to_jsonb(clock_timestamp());
+begin
+    if tg_op = 'UPDATE' and to_jsonb(new) - 'search_vector' = to_jsonb(old) - 'search_vector' then
+        return new;
+    end if;
+    new.change_xid := pg_current_xact_id();
+    if tg_op = 'INSERT' then
+        if new.field_clocks = '{}' then
+            new.field_clocks := jsonb_build_object('name', stamp, 'description', stamp, 'status', stamp, 'priority', stamp,
+                'labels', stamp, 'recurrence', stamp, 'dueAt', stamp, 'parentId', stamp);
+        end if;
+        return new;
+    end if;
+    if new.field_clocks is distinct from old.field_clocks then
+        return new;
+    end if;
+    if new.name is distinct from old.name then new.field_clocks := new.field_clocks || jsonb_build_object('name', stamp); end if;
+    if new.description is distinct from old.description then new.field_clocks := new.field_clocks || jsonb_build_object('description', stamp); end if;
+    if new.status is distinct from old.status then new.field_clocks := new.field_clocks || jsonb_build_object('status', stamp); end if;
+    if new.priority is distinct from old.priority then new.field_clocks := new.field_clocks || jsonb_build_object('priority', stamp); end if;
+    if new.labels is distinct from old.labels then new.field_clocks := new.field_clocks || jsonb_build_object('labels', stamp); end if;
+    if new.recurrence is distinct from old.recurrence then new.field_clocks := new.field_clocks || jsonb_build_object('recurrence', stamp); end if;
+    if new.due_at is distinct from old.due_at then new.field_clocks := new.field_clocks || jsonb_build_object('dueAt', stamp); end if;
+    if new.parent_id is distinct from old.parent_id then new.field_clocks := new.field_clocks || jsonb_build_object('parentId', stamp); end if;
+    if new.deleted_at is distinct from old.deleted_at then new.field_clocks := new.field_clocks || jsonb_build_object('deleted', stamp); end if;
+    return new;
+end
+$$ language plpgsql;
+
+update todo t set search_vector = todo_search_vector(u.search_language, t.name, t.description, t.labels)
+from users u where u.id = t.user_id;
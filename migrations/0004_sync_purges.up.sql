@@ -0,0 +1,6 @@
+-- the last transaction that purged one of a user's deleted todos. A sync token from before it may have
+-- missed the tombstone, so GET /sync answers it with a full resync instead.
+create table if not exists sync_purges (
+    user_id    uuid primary key references users (id) on delete cascade,
+    purged_xid xid8 not null
+);
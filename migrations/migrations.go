@@ -0,0 +1,258 @@
+// Package migrations holds the database schema as numbered up and down SQL files embedded in the
+// binary, and applies them in order, recording each in the schema_migrations table.
+//
+// A migration is the pair NNNN_name.up.sql and NNNN_name.down.sql. Each runs in its own transaction,
+// and a postgres advisory lock keeps two servers starting at once from migrating the same database.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// lockKey identifies the advisory lock, any constant no other code locks on will do
+const lockKey int64 = 0x746f646f73
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrNoDownMigration = errors.New("migration cannot be reverted")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version int
+	Name    string
+	// AppliedAt is nil for a migration that is still pending
+	AppliedAt *time.Time
+	// Unknown marks a migration the database has but this binary does not, one from a newer build
+	Unknown bool
+}
+
+// All returns the embedded migrations ordered by version.
+func All() ([]*Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s is not named NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, match[2])
+		}
+		body, err := fs.ReadFile(files, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	slices.SortFunc(migrations, func(a, b *Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+// Up applies the pending migrations up to and including version target, or all of them when target is 0,
+// and returns the ones it applied.
+func Up(ctx context.Context, db *sql.DB, target int) ([]*Migration, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	var applied []*Migration
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if target > 0 && migration.Version > target {
+				break
+			}
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			err := run(ctx, conn, migration.Up, `insert into schema_migrations (version, name) values ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the steps most recently applied migrations, newest first, and returns the ones it reverted.
+func Down(ctx context.Context, db *sql.DB, steps int) ([]*Migration, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("cannot revert %d migrations", steps)
+	}
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []*Migration
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int, 0, len(done))
+		for version := range done {
+			versions = append(versions, version)
+		}
+		slices.Sort(versions)
+		slices.Reverse(versions)
+		for _, version := range versions[:min(steps, len(versions))] {
+			index := slices.IndexFunc(migrations, func(m *Migration) bool { return m.Version == version })
+			if index < 0 {
+				return fmt.Errorf("migration %d is not part of this build and cannot be reverted by it", version)
+			}
+			migration := migrations[index]
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, ErrNoDownMigration)
+			}
+			err := run(ctx, conn, migration.Down, `delete from schema_migrations where version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// List returns every migration this build knows or the database has applied, ordered by version.
+func List(ctx context.Context, db *sql.DB) ([]*Status, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	var list []*Status
+	var exists bool
+	if err := db.QueryRowContext(ctx, `select to_regclass('schema_migrations') is not null`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		rows, err := db.QueryContext(ctx, `select version, name, applied_at from schema_migrations order by version`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			status := new(Status)
+			if err := rows.Scan(&status.Version, &status.Name, &status.AppliedAt); err != nil {
+				return nil, err
+			}
+			status.Unknown = !slices.ContainsFunc(migrations, func(m *Migration) bool { return m.Version == status.Version })
+			list = append(list, status)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, migration := range migrations {
+		if !slices.ContainsFunc(list, func(s *Status) bool { return s.Version == migration.Version }) {
+			list = append(list, &Status{Version: migration.Version, Name: migration.Name})
+		}
+	}
+	slices.SortFunc(list, func(a, b *Status) int { return a.Version - b.Version })
+	return list, nil
+}
+
+// withLock runs fn on one connection while holding the migration lock; a session lock has to be
+// released on the connection that took it.
+func withLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("taking the migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockKey)
+	if err := createTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func createTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `create table if not exists schema_migrations (
+		version    integer primary key,
+		name       text not null,
+		applied_at timestamptz not null default now()
+	)`)
+	return err
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]struct{}, error) {
+	rows, err := conn.QueryContext(ctx, `select version from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := map[int]struct{}{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions[version] = struct{}{}
+	}
+	return versions, rows.Err()
+}
+
+// run executes a migration script and records it in schema_migrations in a single transaction
+func run(ctx context.Context, conn *sql.Conn, script string, record string, args ...any) error {
+	transaction, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+	// without arguments lib/pq sends the script as a simple query, which may hold many statements
+	if _, err := transaction.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return transaction.Commit()
+}
@@ -1,6 +1,11 @@
 package models
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,11 +19,85 @@ const (
 	Completed
 )
 
+var statusNames = map[Status]string{
+	Pending:   "pending",
+	InProgess: "in_progress",
+	Completed: "completed",
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("status(%d)", int(s))
+}
+
+func ParseStatus(value string) (Status, error) {
+	value = strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(strings.TrimSpace(value)))
+	for status, name := range statusNames {
+		if name == value {
+			return status, nil
+		}
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		if _, ok := statusNames[Status(n)]; ok {
+			return Status(n), nil
+		}
+	}
+	return Pending, fmt.Errorf("unknown status: %q", value)
+}
+
+type Priority int
+
+const (
+	NoPriority Priority = iota
+	LowPriority
+	MediumPriority
+	HighPriority
+)
+
+var priorityNames = map[Priority]string{
+	NoPriority:     "none",
+	LowPriority:    "low",
+	MediumPriority: "medium",
+	HighPriority:   "high",
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+func ParsePriority(value string) (Priority, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return NoPriority, nil
+	}
+	for priority, name := range priorityNames {
+		if name == value {
+			return priority, nil
+		}
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		if _, ok := priorityNames[Priority(n)]; ok {
+			return Priority(n), nil
+		}
+	}
+	return NoPriority, fmt.Errorf("unknown priority: %q", value)
+}
+
 type Todo struct {
-	Name        string    `json:"name" validate:"required"`
-	Description string    `json:"description"`
-	TaskStatus  Status    `json:"status"`
-	CreatedAt   time.Time `json:"createdAt"`
+	Name        string     `json:"name" validate:"required"`
+	Description string     `json:"description"`
+	TaskStatus  Status     `json:"status" validate:"min=0,max=2"`
+	Priority    Priority   `json:"priority" validate:"min=0,max=3"`
+	Labels      []string   `json:"labels,omitempty" validate:"omitempty,dive,required"`
+	Recurrence  string     `json:"recurrence,omitempty"`
+	DueAt       *time.Time `json:"dueAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	Subtasks    []*Todo    `json:"subtasks,omitempty" validate:"omitempty,dive"`
 }
 
 func (s *Todo) FuncToImplement() {
@@ -26,12 +105,174 @@ func (s *Todo) FuncToImplement() {
 }
 
 type GetTodoResponse struct {
-	Id          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	TaskStatus  Status    `json:"status"`
-	CreatedAt   time.Time `json:"createdAt"`
+	Id          string     `json:"id"`
+	ParentId    *string    `json:"parentId,omitempty"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	TaskStatus  Status     `json:"status"`
+	Priority    Priority   `json:"priority"`
+	Labels      []string   `json:"labels"`
+	Recurrence  string     `json:"recurrence,omitempty"`
+	DueAt       *time.Time `json:"dueAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	Version     int        `json:"version"`
+}
+
+// TodoDocument is the part of a todo clients may change; PATCH requests are applied to it and the
+// result is validated as a whole, so fields outside it can never reach the database.
+type TodoDocument struct {
+	Name        string     `json:"name" validate:"required"`
+	Description string     `json:"description"`
+	TaskStatus  Status     `json:"status" validate:"min=0,max=2"`
+	Priority    Priority   `json:"priority" validate:"min=0,max=3"`
+	Labels      []string   `json:"labels" validate:"dive,required"`
+	Recurrence  string     `json:"recurrence"`
+	DueAt       *time.Time `json:"dueAt"`
+	ParentId    *string    `json:"parentId" validate:"omitempty,uuid"`
 }
+
+func (s *TodoDocument) FuncToImplement() {
+
+}
+
+// DecodeTodoDocument decodes a whole document, rejecting any field that is not part of it.
+func DecodeTodoDocument(raw []byte) (*TodoDocument, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	doc := new(TodoDocument)
+	if err := decoder.Decode(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (t *GetTodoResponse) Document() *TodoDocument {
+	labels := t.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+	return &TodoDocument{
+		Name:        t.Name,
+		Description: t.Description,
+		TaskStatus:  t.TaskStatus,
+		Priority:    t.Priority,
+		Labels:      labels,
+		Recurrence:  t.Recurrence,
+		DueAt:       t.DueAt,
+		ParentId:    t.ParentId,
+	}
+}
+
+type QuickAddRequest struct {
+	Text     string `json:"text" validate:"required"`
+	Timezone string `json:"timezone"`
+}
+
+func (s *QuickAddRequest) FuncToImplement() {
+
+}
+
+type QuickAddMatch struct {
+	Field string `json:"field"`
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+type QuickAddResponse struct {
+	Message    string          `json:"message"`
+	Id         string          `json:"id"`
+	Todo       *Todo           `json:"todo"`
+	Understood []QuickAddMatch `json:"understood"`
+}
+
+// SearchHighlights hold the matched fields as html: the text is escaped and matching words are
+// wrapped in <mark> tags.
+type SearchHighlights struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+const (
+	SearchFieldName        = "name"
+	SearchFieldDescription = "description"
+	SearchFieldLabels      = "labels"
+)
+
+var SearchFields = []string{SearchFieldName, SearchFieldDescription, SearchFieldLabels}
+
+// SearchScopeComments is refused by search: todos have no comments to search yet
+const SearchScopeComments = "comments"
+
+type SearchResult struct {
+	GetTodoResponse
+	Rank          float64          `json:"rank"`
+	Highlights    SearchHighlights `json:"highlights"`
+	MatchedFields []string         `json:"matchedFields"`
+}
+
+type SyncChange struct {
+	Id          string               `json:"id"`
+	Deleted     bool                 `json:"deleted"`
+	DeletedAt   *time.Time           `json:"deletedAt,omitempty"`
+	Todo        *GetTodoResponse     `json:"todo,omitempty"`
+	FieldClocks map[string]time.Time `json:"fieldClocks"`
+}
+
+// SyncResponse is a page of the change feed. Resync is set when the since token could not be used and
+// the changes start over as a full sync, which the client applies in place of what it has.
+type SyncResponse struct {
+	Changes []*SyncChange `json:"changes"`
+	Token   string        `json:"token"`
+	HasMore bool          `json:"hasMore"`
+	Resync  bool          `json:"resync,omitempty"`
+}
+
+// SyncPushChange is one change made on a client while offline. Fields holds the changed TodoDocument
+// fields, each of which only wins over the server's value if ModifiedAt is newer than that field's clock.
+type SyncPushChange struct {
+	Id         string                     `json:"id" validate:"required,uuid"`
+	ModifiedAt time.Time                  `json:"modifiedAt"`
+	Deleted    bool                       `json:"deleted"`
+	Fields     map[string]json.RawMessage `json:"fields"`
+}
+
+type SyncPushRequest struct {
+	Changes []*SyncPushChange `json:"changes" validate:"required,max=500,dive,required"`
+}
+
+func (s *SyncPushRequest) FuncToImplement() {
+
+}
+
+const (
+	SyncApplied      = "applied"
+	SyncPartial      = "partial"
+	SyncConflict     = "conflict"
+	SyncRejected     = "rejected"
+	SyncDeletedField = "deleted"
+)
+
+type SyncFieldConflict struct {
+	Field            string          `json:"field"`
+	ServerValue      json.RawMessage `json:"serverValue"`
+	ServerModifiedAt time.Time       `json:"serverModifiedAt"`
+}
+
+type SyncPushResult struct {
+	Id        string              `json:"id"`
+	Status    string              `json:"status"`
+	Applied   []string            `json:"applied"`
+	Conflicts []SyncFieldConflict `json:"conflicts,omitempty"`
+	Todo      *GetTodoResponse    `json:"todo,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	// Deleted lists the ids a delete removed, the todo and its subtasks, for publishing events
+	Deleted []string `json:"-"`
+}
+
+type SyncPushResponse struct {
+	Results []*SyncPushResult `json:"results"`
+}
+
 type ErrorResponse struct {
 	Message string `json:"message"`
 	Status  int    `json:"status"`
@@ -39,6 +280,7 @@ type ErrorResponse struct {
 
 type CreateResponse struct {
 	Message  string `json:"message"`
+	Id       string `json:"id,omitempty"`
 	UserName string `json:"username"`
 }
 
@@ -60,11 +302,20 @@ func (s *SignupRequest) FuncToImplement() {
 }
 
 type User struct {
-	Id             string    `json:"id"`
-	UserName       string    `json:"username"`
-	Email          string    `json:"email"`
-	HashedPassword string    `json:"-"`
-	CreatedAt      time.Time `json:"joined_at"`
+	Id             string     `json:"id"`
+	UserName       string     `json:"username"`
+	Email          string     `json:"email"`
+	HashedPassword string     `json:"-"`
+	CreatedAt      time.Time  `json:"joined_at"`
+	DisabledAt     *time.Time `json:"-"`
+}
+
+type UserSettings struct {
+	SearchLanguage string `json:"searchLanguage" validate:"required"`
+}
+
+func (s *UserSettings) FuncToImplement() {
+
 }
 
 type LoginUser struct {
@@ -105,3 +356,109 @@ type UpdatePasswordRequest struct {
 func (s *UpdatePasswordRequest) FuncToImplement() {
 
 }
+
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+type ImportReport struct {
+	Format  string           `json:"format"`
+	DryRun  bool             `json:"dryRun"`
+	Total   int              `json:"total"`
+	Created int              `json:"created"`
+	Todos   []*Todo          `json:"todos,omitempty"`
+	Errors  []ImportRowError `json:"errors,omitempty"`
+}
+
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	// Status is zero while the original request is still being processed
+	Status    int
+	Headers   map[string]string
+	Body      []byte
+	ExpiresAt time.Time
+}
+
+type Webhook struct {
+	Id           string     `json:"id"`
+	URL          string     `json:"url"`
+	EventTypes   []string   `json:"eventTypes"`
+	Active       bool       `json:"active"`
+	FailureCount int        `json:"failureCount"`
+	DisabledAt   *time.Time `json:"disabledAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	// only returned when the webhook is created
+	Secret string `json:"secret,omitempty"`
+}
+
+type WebhookRequest struct {
+	URL        string   `json:"url" validate:"required,url"`
+	EventTypes []string `json:"eventTypes" validate:"omitempty,dive,required"`
+}
+
+func (s *WebhookRequest) FuncToImplement() {
+
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type WebhookDelivery struct {
+	Id             int64           `json:"id"`
+	WebhookId      string          `json:"webhookId"`
+	EventId        string          `json:"eventId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"responseStatus,omitempty"`
+	ResponseBody   string          `json:"responseBody,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty"`
+	// filled in when a delivery is claimed for sending
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// IngestRequest is the payload external systems post to a user's ingest URL.
+type IngestRequest struct {
+	Title    string     `json:"title" validate:"required"`
+	Body     string     `json:"body"`
+	Labels   []string   `json:"labels" validate:"omitempty,dive,required"`
+	Priority Priority   `json:"priority" validate:"min=0,max=3"`
+	DueAt    *time.Time `json:"dueAt"`
+	Source   string     `json:"source"`
+}
+
+func (s *IngestRequest) FuncToImplement() {
+
+}
+
+type IngestKeyResponse struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+	Email string `json:"email,omitempty"`
+}
+
+// TicketResponse is a single-use credential for /events, /ws and /graphql, passed as the ticket query parameter
+type TicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type Attachment struct {
+	Id          string    `json:"id"`
+	TodoId      string    `json:"todoId"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int       `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Data        []byte    `json:"-"`
+}
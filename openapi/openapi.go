@@ -0,0 +1,102 @@
+// Package openapi holds the OpenAPI 3 description of the http api, serves it together with a
+// docs page, and checks it against the routes a router actually registers.
+package openapi
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed openapi.json docs.html
+var files embed.FS
+
+// Spec returns the OpenAPI document
+func Spec() []byte {
+	spec, _ := files.ReadFile("openapi.json")
+	return spec
+}
+
+func ServeSpec(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(Spec())
+}
+
+func ServeDocs(rw http.ResponseWriter, r *http.Request) {
+	docs, _ := files.ReadFile("docs.html")
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write(docs)
+}
+
+var specMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// Operations lists the operations in the document as "METHOD /path" in the mux template syntax
+func Operations() ([]string, error) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(Spec(), &doc); err != nil {
+		return nil, fmt.Errorf("malformed openapi document: %w", err)
+	}
+	var operations []string
+	for path, item := range doc.Paths {
+		for method := range item {
+			if slices.Contains(specMethods, strings.ToUpper(method)) {
+				operations = append(operations, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+	slices.Sort(operations)
+	return operations, nil
+}
+
+// CheckRoutes compares the routes registered on router with the document and reports every route
+// that is not documented and every documented operation that no route serves. OPTIONS, which all
+// routes answer for CORS preflights, is left out.
+func CheckRoutes(router *mux.Router) error {
+	documented, err := Operations()
+	if err != nil {
+		return err
+	}
+	var registered []string
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			// subrouter prefixes have no methods of their own
+			return nil
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			if method != http.MethodOptions {
+				registered = append(registered, method+" "+path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, operation := range registered {
+		if !slices.Contains(documented, operation) {
+			problems = append(problems, "undocumented route "+operation)
+		}
+	}
+	for _, operation := range documented {
+		if !slices.Contains(registered, operation) {
+			problems = append(problems, "documented operation without a route "+operation)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("openapi document is out of date: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
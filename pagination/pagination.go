@@ -0,0 +1,177 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLimit = 10
+	MaxLimit     = 100
+)
+
+// Cursor is the sort key of the item a page starts after (or before, when Backward is set).
+// Clients only ever see it base64 encoded and must treat it as opaque.
+type Cursor struct {
+	Rank      *float64  `json:"r,omitempty"`
+	CreatedAt time.Time `json:"c"`
+	Id        string    `json:"i"`
+	Backward  bool      `json:"b,omitempty"`
+}
+
+func (c *Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func DecodeCursor(value string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	cursor := new(Cursor)
+	if err = json.Unmarshal(raw, cursor); err != nil || cursor.Id == "" {
+		return nil, errors.New("malformed cursor")
+	}
+	return cursor, nil
+}
+
+// EnvelopeMediaType is the Accept value that asks for a Page envelope on a first page requested
+// without any paging parameters.
+const EnvelopeMediaType = "application/json; envelope=page"
+
+type Request struct {
+	Limit        int
+	Offset       int
+	OffsetMode   bool
+	Cursor       *Cursor
+	IncludeTotal bool
+	// Envelope answers with a Page instead of the bare array existing clients expect
+	Envelope bool
+}
+
+// ParseRequest reads limit, cursor and include_total from the query string. A page or offset
+// parameter switches to the older offset paging, which is kept for existing clients. Only a cursor
+// or include_total opts into the Page envelope: existing clients send limit on its own and still get
+// the bare array, unless WantsEnvelope says otherwise.
+func ParseRequest(values url.Values) (*Request, error) {
+	request := &Request{Limit: DefaultLimit}
+	if limit := values.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil || limitInt < 1 {
+			return nil, fmt.Errorf("Invalid limit passed %s", limit)
+		}
+		request.Limit = min(limitInt, MaxLimit)
+	}
+	if total := values.Get("include_total"); total != "" {
+		includeTotal, err := strconv.ParseBool(total)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid include_total passed %s", total)
+		}
+		request.IncludeTotal = includeTotal
+	}
+	page := values.Get("page")
+	offset := values.Get("offset")
+	cursor := values.Get("cursor")
+	if cursor != "" && (page != "" || offset != "") {
+		return nil, errors.New("cursor cannot be combined with page or offset")
+	}
+	switch {
+	case cursor != "":
+		decoded, err := DecodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		request.Cursor = decoded
+	case page != "":
+		pageInt, err := strconv.Atoi(page)
+		if err != nil || pageInt < 1 {
+			return nil, fmt.Errorf("Invalid page passed %s", page)
+		}
+		request.OffsetMode = true
+		request.Offset = (pageInt - 1) * request.Limit
+	case offset != "":
+		offsetInt, err := strconv.Atoi(offset)
+		if err != nil || offsetInt < 0 {
+			return nil, fmt.Errorf("Invalid offset passed %s", offset)
+		}
+		request.OffsetMode = true
+		request.Offset = offsetInt
+	}
+	request.Envelope = !request.OffsetMode && (cursor != "" || request.IncludeTotal)
+	return request, nil
+}
+
+// WantsEnvelope reports whether an Accept header lists EnvelopeMediaType.
+func WantsEnvelope(accept string) bool {
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(value)
+		if err == nil && mediaType == "application/json" && params["envelope"] == "page" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Request) Backward() bool {
+	return r.Cursor != nil && r.Cursor.Backward
+}
+
+// Trim cuts the extra row a keyset query fetches to detect further pages and restores display
+// order for backward pages, which are queried in reverse.
+func Trim[T any](items []T, r *Request) ([]T, bool) {
+	more := len(items) > r.Limit
+	if more {
+		items = items[:r.Limit]
+	}
+	if r.Backward() {
+		slices.Reverse(items)
+	}
+	return items, more
+}
+
+// Neighbours reports whether pages exist after and before the one just fetched.
+func (r *Request) Neighbours(more bool) (hasNext bool, hasPrev bool) {
+	if r.Backward() {
+		return true, more
+	}
+	return more, r.Cursor != nil
+}
+
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	Total      *int   `json:"total,omitempty"`
+}
+
+// Link renders an RFC 8288 link to the current request with the given query parameters replaced;
+// an empty value removes the parameter.
+func Link(r *http.Request, rel string, params map[string]string) string {
+	link := *r.URL
+	query := link.Query()
+	for key, value := range params {
+		if value == "" {
+			query.Del(key)
+			continue
+		}
+		query.Set(key, value)
+	}
+	link.RawQuery = query.Encode()
+	return fmt.Sprintf(`<%s>; rel="%s"`, link.RequestURI(), rel)
+}
+
+func SetLinks(rw http.ResponseWriter, links []string) {
+	if len(links) > 0 {
+		rw.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,64 @@
+package pagination
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseRequest(t *testing.T) {
+	cursor := (&Cursor{CreatedAt: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC), Id: "a"}).Encode()
+	tests := []struct {
+		query    string
+		limit    int
+		offset   int
+		offsetOn bool
+		envelope bool
+	}{
+		{"", DefaultLimit, 0, false, false},
+		// clients from before cursors send limit alone and expect the array
+		{"limit=20", 20, 0, false, false},
+		{"limit=500", MaxLimit, 0, false, false},
+		{"cursor=" + cursor, DefaultLimit, 0, false, true},
+		{"limit=5&cursor=" + cursor, 5, 0, false, true},
+		{"include_total=true", DefaultLimit, 0, false, true},
+		{"include_total=false&limit=5", 5, 0, false, false},
+		{"page=3&limit=5", 5, 10, true, false},
+		{"offset=7&include_total=true", DefaultLimit, 7, true, false},
+	}
+	for _, test := range tests {
+		values, _ := url.ParseQuery(test.query)
+		request, err := ParseRequest(values)
+		if err != nil {
+			t.Errorf("ParseRequest(%q): %v", test.query, err)
+			continue
+		}
+		if request.Limit != test.limit || request.Offset != test.offset || request.OffsetMode != test.offsetOn || request.Envelope != test.envelope {
+			t.Errorf("ParseRequest(%q) = %+v", test.query, request)
+		}
+	}
+}
+
+func TestParseRequestErrors(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=ten", "page=0", "offset=-1", "include_total=maybe", "cursor=not*a*cursor", "cursor=abc&page=2"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseRequest(values); err == nil {
+			t.Errorf("ParseRequest(%q) accepted it", query)
+		}
+	}
+}
+
+func TestWantsEnvelope(t *testing.T) {
+	tests := map[string]bool{
+		"":                 false,
+		"application/json": false,
+		EnvelopeMediaType:  true,
+		"text/html, application/json;envelope=page;q=0.9": true,
+		"application/xml; envelope=page":                  false,
+	}
+	for accept, want := range tests {
+		if got := WantsEnvelope(accept); got != want {
+			t.Errorf("WantsEnvelope(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package quickadd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// short weekday names double as ordinary words ("sat", "wed"), so they only count after a preposition
+var shortWeekdays = map[string]time.Weekday{
+	"sun":   time.Sunday,
+	"mon":   time.Monday,
+	"tue":   time.Tuesday,
+	"tues":  time.Tuesday,
+	"wed":   time.Wednesday,
+	"thu":   time.Thursday,
+	"thur":  time.Thursday,
+	"thurs": time.Thursday,
+	"fri":   time.Friday,
+	"sat":   time.Saturday,
+}
+
+var months = map[string]time.Month{
+	"jan": time.January, "january": time.January,
+	"feb": time.February, "february": time.February,
+	"mar": time.March, "march": time.March,
+	"apr": time.April, "april": time.April,
+	"may": time.May,
+	"jun": time.June, "june": time.June,
+	"jul": time.July, "july": time.July,
+	"aug": time.August, "august": time.August,
+	"sep": time.September, "sept": time.September, "september": time.September,
+	"oct": time.October, "october": time.October,
+	"nov": time.November, "november": time.November,
+	"dec": time.December, "december": time.December,
+}
+
+var rruleDays = map[time.Weekday]string{
+	time.Sunday:    "SU",
+	time.Monday:    "MO",
+	time.Tuesday:   "TU",
+	time.Wednesday: "WE",
+	time.Thursday:  "TH",
+	time.Friday:    "FR",
+	time.Saturday:  "SA",
+}
+
+var (
+	isoDate      = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	dayOfMonth   = regexp.MustCompile(`^(\d{1,2})(st|nd|rd|th)?$`)
+	clock12      = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)$`)
+	clock24      = regexp.MustCompile(`^(\d{1,2}):(\d{2})$`)
+	yearPattern  = regexp.MustCompile(`^\d{4}$`)
+	datePrefixes = map[string]bool{"on": true, "by": true, "due": true, "this": true}
+)
+
+func (p *parser) today() time.Time {
+	return p.daysFromToday(0)
+}
+
+// daysFromToday counts calendar days in the user's location; adding 24h would drift by an hour
+// across a daylight saving change
+func (p *parser) daysFromToday(days int) time.Time {
+	y, m, d := p.now.Date()
+	return time.Date(y, m, d+days, 0, 0, 0, 0, p.now.Location())
+}
+
+func (p *parser) nextWeekday(day time.Weekday) time.Time {
+	ahead := (int(day) - int(p.now.Weekday()) + 7) % 7
+	if ahead == 0 {
+		ahead = 7
+	}
+	return p.daysFromToday(ahead)
+}
+
+type dateMatch struct {
+	date  time.Time
+	clock *clockTime
+	n     int
+}
+
+func (p *parser) matchDate(i int) int {
+	if p.date != nil {
+		return 0
+	}
+	prefixed := datePrefixes[p.word(i)]
+	start := i
+	if prefixed {
+		start++
+	}
+	match, ok := p.dateAt(start, prefixed)
+	if !ok {
+		return 0
+	}
+	if match.clock != nil && p.clock == nil {
+		p.clock = match.clock
+	}
+	n := match.n + start - i
+	p.date = &match.date
+	p.dueText = append(p.dueText, p.consume(i, n))
+	return n
+}
+
+// dateAt recognises a date starting at token i, not counting a leading preposition
+func (p *parser) dateAt(i int, prefixed bool) (dateMatch, bool) {
+	word := p.word(i)
+	switch word {
+	case "":
+		return dateMatch{}, false
+	case "today":
+		return dateMatch{date: p.today(), n: 1}, true
+	case "tonight":
+		return dateMatch{date: p.today(), clock: &clockTime{hour: 20}, n: 1}, true
+	case "tomorrow", "tmr", "tmrw":
+		return dateMatch{date: p.daysFromToday(1), n: 1}, true
+	case "next":
+		return p.nextAt(i)
+	case "in":
+		if prefixed {
+			return dateMatch{}, false
+		}
+		return p.relativeAt(i)
+	}
+	if day, ok := weekdays[word]; ok {
+		return dateMatch{date: p.nextWeekday(day), n: 1}, true
+	}
+	if day, ok := shortWeekdays[word]; ok && prefixed {
+		return dateMatch{date: p.nextWeekday(day), n: 1}, true
+	}
+	if isoDate.MatchString(word) {
+		date, err := time.ParseInLocation("2006-01-02", word, p.now.Location())
+		return dateMatch{date: date, n: 1}, err == nil
+	}
+	return p.calendarDateAt(i)
+}
+
+func (p *parser) nextAt(i int) (dateMatch, bool) {
+	unit := p.word(i + 1)
+	var date time.Time
+	switch unit {
+	case "week":
+		date = p.nextWeekday(time.Monday)
+	case "month":
+		y, m, _ := p.now.Date()
+		date = time.Date(y, m+1, 1, 0, 0, 0, 0, p.now.Location())
+	case "year":
+		date = time.Date(p.now.Year()+1, time.January, 1, 0, 0, 0, 0, p.now.Location())
+	default:
+		day, ok := weekdays[unit]
+		if !ok {
+			day, ok = shortWeekdays[unit]
+		}
+		if !ok {
+			return dateMatch{}, false
+		}
+		date = p.nextWeekday(day)
+	}
+	return dateMatch{date: date, n: 2}, true
+}
+
+func (p *parser) relativeAt(i int) (dateMatch, bool) {
+	amount := p.word(i + 1)
+	count, err := strconv.Atoi(amount)
+	if amount == "a" || amount == "an" {
+		count, err = 1, nil
+	}
+	if err != nil || count <= 0 {
+		return dateMatch{}, false
+	}
+	switch unit := strings.TrimSuffix(p.word(i+2), "s"); unit {
+	case "min", "minute", "hour", "hr":
+		duration := time.Duration(count) * time.Minute
+		if unit == "hour" || unit == "hr" {
+			duration = time.Duration(count) * time.Hour
+		}
+		at := p.now.Add(duration)
+		y, m, d := at.Date()
+		date := time.Date(y, m, d, 0, 0, 0, 0, p.now.Location())
+		return dateMatch{date: date, clock: &clockTime{hour: at.Hour(), minute: at.Minute()}, n: 3}, true
+	case "day":
+		return dateMatch{date: p.daysFromToday(count), n: 3}, true
+	case "week":
+		return dateMatch{date: p.daysFromToday(7 * count), n: 3}, true
+	case "month":
+		return dateMatch{date: p.today().AddDate(0, count, 0), n: 3}, true
+	case "year":
+		return dateMatch{date: p.today().AddDate(count, 0, 0), n: 3}, true
+	}
+	return dateMatch{}, false
+}
+
+// calendarDateAt handles "oct 20", "20 october" and "october 20th", each with an optional year
+func (p *parser) calendarDateAt(i int) (dateMatch, bool) {
+	var month time.Month
+	var day int
+	if m, ok := months[p.word(i)]; ok {
+		match := dayOfMonth.FindStringSubmatch(p.word(i + 1))
+		if match == nil {
+			return dateMatch{}, false
+		}
+		month = m
+		day, _ = strconv.Atoi(match[1])
+	} else if match := dayOfMonth.FindStringSubmatch(p.word(i)); match != nil {
+		m, ok := months[p.word(i+1)]
+		if !ok {
+			return dateMatch{}, false
+		}
+		month = m
+		day, _ = strconv.Atoi(match[1])
+	} else {
+		return dateMatch{}, false
+	}
+	n := 2
+	year := p.now.Year()
+	explicitYear := false
+	if yearPattern.MatchString(p.word(i + 2)) {
+		year, _ = strconv.Atoi(p.word(i + 2))
+		explicitYear = true
+		n++
+	}
+	date := time.Date(year, month, day, 0, 0, 0, 0, p.now.Location())
+	if date.Month() != month || day == 0 {
+		return dateMatch{}, false
+	}
+	if !explicitYear && date.Before(p.today()) {
+		date = date.AddDate(1, 0, 0)
+	}
+	return dateMatch{date: date, n: n}, true
+}
+
+func (p *parser) matchTime(i int) int {
+	if p.clock != nil {
+		return 0
+	}
+	start := i
+	if p.word(i) == "at" {
+		start++
+	}
+	word := p.word(start)
+	n := 1
+	var clock *clockTime
+	switch {
+	case word == "noon":
+		clock = &clockTime{hour: 12}
+	case word == "midnight":
+		clock = &clockTime{}
+	case clock24.MatchString(word):
+		match := clock24.FindStringSubmatch(word)
+		hour, _ := strconv.Atoi(match[1])
+		minute, _ := strconv.Atoi(match[2])
+		if hour < 24 && minute < 60 {
+			clock = &clockTime{hour: hour, minute: minute}
+		}
+	default:
+		// "5pm" and "5 pm" are both common
+		if next := p.word(start + 1); next == "am" || next == "pm" {
+			word += next
+			n = 2
+		}
+		if match := clock12.FindStringSubmatch(word); match != nil {
+			hour, _ := strconv.Atoi(match[1])
+			minute, _ := strconv.Atoi(match[2])
+			if hour >= 1 && hour <= 12 && minute < 60 {
+				hour %= 12
+				if match[3] == "pm" {
+					hour += 12
+				}
+				clock = &clockTime{hour: hour, minute: minute}
+			}
+		}
+	}
+	if clock == nil {
+		return 0
+	}
+	n += start - i
+	p.clock = clock
+	p.dueText = append(p.dueText, p.consume(i, n))
+	return n
+}
+
+func (p *parser) matchRecurrence(i int) int {
+	if p.recurrence != "" || p.word(i) != "every" {
+		return 0
+	}
+	interval := 1
+	n := 1
+	switch amount := p.word(i + 1); amount {
+	case "other":
+		interval = 2
+		n++
+	default:
+		if count, err := strconv.Atoi(amount); err == nil && count > 0 {
+			interval = count
+			n++
+		}
+	}
+	unit := p.word(i + n)
+	rule := ""
+	switch strings.TrimSuffix(unit, "s") {
+	case "day":
+		rule = "FREQ=DAILY"
+	case "week":
+		rule = "FREQ=WEEKLY"
+	case "month":
+		rule = "FREQ=MONTHLY"
+	case "year":
+		rule = "FREQ=YEARLY"
+	case "weekday":
+		rule = "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"
+	default:
+		day, ok := weekdays[strings.TrimSuffix(unit, "s")]
+		if !ok {
+			day, ok = shortWeekdays[unit]
+		}
+		if !ok {
+			return 0
+		}
+		rule = "FREQ=WEEKLY;BYDAY=" + rruleDays[day]
+		if p.date == nil {
+			p.recurrenceStart = p.nextWeekday(day)
+		}
+	}
+	n++
+	if interval > 1 {
+		rule += fmt.Sprintf(";INTERVAL=%d", interval)
+	}
+	p.recurrence = rule
+	p.result.Todo.Recurrence = rule
+	p.understood(FieldRecurrence, p.consume(i, n), rule)
+	return n
+}
+
+func (p *parser) resolveDue() {
+	if p.date == nil && !p.recurrenceStart.IsZero() {
+		p.date = &p.recurrenceStart
+	}
+	if p.date == nil && p.clock == nil {
+		return
+	}
+	var due time.Time
+	if p.date == nil {
+		y, m, d := p.now.Date()
+		due = time.Date(y, m, d, p.clock.hour, p.clock.minute, 0, 0, p.now.Location())
+		// a bare time that has already passed today means tomorrow
+		if due.Before(p.now) {
+			due = time.Date(y, m, d+1, p.clock.hour, p.clock.minute, 0, 0, p.now.Location())
+		}
+	} else {
+		y, m, d := p.date.Date()
+		hour, minute := 0, 0
+		if p.clock != nil {
+			hour, minute = p.clock.hour, p.clock.minute
+		}
+		due = time.Date(y, m, d, hour, minute, 0, 0, p.now.Location())
+	}
+	p.result.Todo.DueAt = &due
+	if len(p.dueText) > 0 {
+		p.understood(FieldDue, strings.Join(p.dueText, " "), due.Format(time.RFC3339))
+	}
+}
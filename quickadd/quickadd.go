@@ -0,0 +1,179 @@
+package quickadd
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"todos/models"
+)
+
+const (
+	FieldDue        = "due"
+	FieldLabel      = "label"
+	FieldPriority   = "priority"
+	FieldRecurrence = "recurrence"
+)
+
+type Result struct {
+	Todo       *models.Todo
+	Understood []models.QuickAddMatch
+}
+
+type token struct {
+	text    string
+	literal bool
+}
+
+// parser state for a single line; now carries the user's location, so every date is resolved in their time zone
+type parser struct {
+	now        time.Time
+	tokens     []token
+	used       []bool
+	result     *Result
+	date       *time.Time
+	clock      *clockTime
+	dueText    []string
+	recurrence string
+	// first occurrence implied by "every monday" when no explicit date is given
+	recurrenceStart time.Time
+}
+
+type clockTime struct {
+	hour   int
+	minute int
+}
+
+// Parse turns a line such as "Pay invoice tomorrow 5pm #finance !high every month" into a todo.
+// Words wrapped in double quotes are never interpreted and always end up in the name.
+func Parse(line string, now time.Time) (*Result, error) {
+	p := &parser{
+		now:    now,
+		tokens: tokenize(line),
+		result: &Result{Todo: new(models.Todo), Understood: []models.QuickAddMatch{}},
+	}
+	p.used = make([]bool, len(p.tokens))
+	for i := 0; i < len(p.tokens); i++ {
+		if p.used[i] || p.tokens[i].literal {
+			continue
+		}
+		for _, match := range []func(int) int{p.matchLabel, p.matchPriority, p.matchRecurrence, p.matchDate, p.matchTime} {
+			if n := match(i); n > 0 {
+				i += n - 1
+				break
+			}
+		}
+	}
+	p.resolveDue()
+
+	var name []string
+	for i, tok := range p.tokens {
+		if !p.used[i] {
+			name = append(name, tok.text)
+		}
+	}
+	p.result.Todo.Name = strings.Join(name, " ")
+	if p.result.Todo.Name == "" {
+		return p.result, errors.New("nothing left for the todo name after parsing")
+	}
+	return p.result, nil
+}
+
+func tokenize(line string) []token {
+	var tokens []token
+	for len(line) > 0 {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			break
+		}
+		if line[0] == '"' {
+			if end := strings.IndexByte(line[1:], '"'); end >= 0 {
+				if quoted := strings.TrimSpace(line[1 : end+1]); quoted != "" {
+					tokens = append(tokens, token{text: quoted, literal: true})
+				}
+				line = line[end+2:]
+				continue
+			}
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		tokens = append(tokens, token{text: line[:end]})
+		line = line[end:]
+	}
+	return tokens
+}
+
+// word returns the lower-cased token at i with trailing punctuation removed, or "" past the end or on quoted text
+func (p *parser) word(i int) string {
+	if i < 0 || i >= len(p.tokens) || p.used[i] || p.tokens[i].literal {
+		return ""
+	}
+	return strings.ToLower(strings.TrimRight(p.tokens[i].text, ",.;"))
+}
+
+func (p *parser) consume(i int, n int) string {
+	parts := make([]string, 0, n)
+	for j := i; j < i+n; j++ {
+		p.used[j] = true
+		parts = append(parts, p.tokens[j].text)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p *parser) understood(field string, text string, value string) {
+	p.result.Understood = append(p.result.Understood, models.QuickAddMatch{Field: field, Text: text, Value: value})
+}
+
+func (p *parser) matchLabel(i int) int {
+	text := p.tokens[i].text
+	if len(text) < 2 || (text[0] != '#' && text[0] != '@') {
+		return 0
+	}
+	label := strings.TrimRight(text[1:], ",.;")
+	for _, r := range label {
+		if !isLabelRune(r) {
+			return 0
+		}
+	}
+	if label == "" {
+		return 0
+	}
+	for _, existing := range p.result.Todo.Labels {
+		if existing == label {
+			p.consume(i, 1)
+			return 1
+		}
+	}
+	p.result.Todo.Labels = append(p.result.Todo.Labels, label)
+	p.understood(FieldLabel, p.consume(i, 1), label)
+	return 1
+}
+
+func isLabelRune(r rune) bool {
+	return r == '-' || r == '_' || r == '/' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127
+}
+
+var priorityWords = map[string]models.Priority{
+	"!high":   models.HighPriority,
+	"!urgent": models.HighPriority,
+	"!1":      models.HighPriority,
+	"!!!":     models.HighPriority,
+	"!medium": models.MediumPriority,
+	"!med":    models.MediumPriority,
+	"!2":      models.MediumPriority,
+	"!!":      models.MediumPriority,
+	"!low":    models.LowPriority,
+	"!3":      models.LowPriority,
+	"!":       models.LowPriority,
+}
+
+func (p *parser) matchPriority(i int) int {
+	priority, ok := priorityWords[p.word(i)]
+	if !ok {
+		return 0
+	}
+	p.result.Todo.Priority = priority
+	p.understood(FieldPriority, p.consume(i, 1), priority.String())
+	return 1
+}
@@ -0,0 +1,178 @@
+package quickadd
+
+import (
+	"slices"
+	"testing"
+	"time"
+	"todos/models"
+)
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s unavailable: %v", name, err)
+	}
+	return location
+}
+
+func TestParseDue(t *testing.T) {
+	newYork := mustLocation(t, "America/New_York")
+	at := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	// Wednesday 21 October 2026, 10:00
+	wednesday := at("2026-10-21 10:00")
+	tests := []struct {
+		line string
+		now  time.Time
+		name string
+		due  string
+	}{
+		{"pay rent today", wednesday, "pay rent", "2026-10-21 00:00"},
+		{"pay rent tomorrow 5pm", wednesday, "pay rent", "2026-10-22 17:00"},
+		{"pay rent tomorrow at 5 pm", wednesday, "pay rent", "2026-10-22 17:00"},
+		{"pay rent tonight", wednesday, "pay rent", "2026-10-21 20:00"},
+		{"standup 9:30", wednesday, "standup", "2026-10-22 09:30"},
+		{"standup 11am", wednesday, "standup", "2026-10-21 11:00"},
+		{"lunch noon", wednesday, "lunch", "2026-10-21 12:00"},
+
+		// weekdays always mean the next one, a week ahead when it is today
+		{"review friday", wednesday, "review", "2026-10-23 00:00"},
+		{"review wednesday", wednesday, "review", "2026-10-28 00:00"},
+		{"review on sat", wednesday, "review", "2026-10-24 00:00"},
+		{"sat exam", wednesday, "sat exam", ""},
+		{"review monday 3pm", wednesday, "review", "2026-10-26 15:00"},
+
+		{"review next friday", wednesday, "review", "2026-10-23 00:00"},
+		{"review next fri", wednesday, "review", "2026-10-23 00:00"},
+		{"plan next week", wednesday, "plan", "2026-10-26 00:00"},
+		{"plan next month", wednesday, "plan", "2026-11-01 00:00"},
+		{"plan next year", wednesday, "plan", "2027-01-01 00:00"},
+
+		{"call back in 2 hours", wednesday, "call back", "2026-10-21 12:00"},
+		{"call back in 45 minutes", wednesday, "call back", "2026-10-21 10:45"},
+		{"renew in 3 days", wednesday, "renew", "2026-10-24 00:00"},
+		{"renew in a week", wednesday, "renew", "2026-10-28 00:00"},
+		{"renew in 2 months", wednesday, "renew", "2026-12-21 00:00"},
+		{"renew in 1 year", wednesday, "renew", "2027-10-21 00:00"},
+		{"check in with bob", wednesday, "check in with bob", ""},
+
+		{"party oct 30", wednesday, "party", "2026-10-30 00:00"},
+		{"party 3rd march", wednesday, "party", "2027-03-03 00:00"},
+		{"party october 1st 2027", wednesday, "party", "2027-10-01 00:00"},
+		{"party 2026-12-24", wednesday, "party", "2026-12-24 00:00"},
+		{"party feb 30", wednesday, "party feb 30", ""},
+
+		// clocks go forward at 02:00 on Sunday 8 March 2026
+		{"brunch tomorrow 11am", at("2026-03-07 22:00"), "brunch", "2026-03-08 11:00"},
+		{"brunch 5pm", at("2026-03-08 01:00"), "brunch", "2026-03-08 17:00"},
+		{"brunch in 1 hour", at("2026-03-08 01:30"), "brunch", "2026-03-08 03:30"},
+		{"brunch in 2 days", at("2026-03-07 12:00"), "brunch", "2026-03-09 00:00"},
+		{"brunch sunday", at("2026-03-07 12:00"), "brunch", "2026-03-08 00:00"},
+		// and back at 02:00 on Sunday 1 November 2026
+		{"standup tomorrow 9am", at("2026-10-31 12:00"), "standup", "2026-11-01 09:00"},
+		{"standup 9am", at("2026-11-01 00:30"), "standup", "2026-11-01 09:00"},
+		{"standup 11pm", at("2026-10-31 23:30"), "standup", "2026-11-01 23:00"},
+		{"standup in a week", at("2026-10-28 12:00"), "standup", "2026-11-04 00:00"},
+		{"standup next monday", at("2026-10-31 12:00"), "standup", "2026-11-02 00:00"},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			result, err := Parse(test.line, test.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Todo.Name != test.name {
+				t.Errorf("name = %q, want %q", result.Todo.Name, test.name)
+			}
+			switch {
+			case test.due == "" && result.Todo.DueAt != nil:
+				t.Errorf("due = %s, want none", result.Todo.DueAt)
+			case test.due != "" && result.Todo.DueAt == nil:
+				t.Errorf("no due date, want %s", test.due)
+			case test.due != "" && !result.Todo.DueAt.Equal(at(test.due)):
+				t.Errorf("due = %s, want %s", result.Todo.DueAt.In(newYork), at(test.due))
+			}
+		})
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	now := time.Date(2026, time.October, 21, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		line       string
+		name       string
+		labels     []string
+		priority   models.Priority
+		recurrence string
+	}{
+		{"Pay invoice tomorrow 5pm #finance !high every month", "Pay invoice", []string{"finance"}, models.HighPriority, "FREQ=MONTHLY"},
+		{"water plants every other day @home", "water plants", []string{"home"}, models.NoPriority, "FREQ=DAILY;INTERVAL=2"},
+		{"gym every monday !!", "gym", nil, models.MediumPriority, "FREQ=WEEKLY;BYDAY=MO"},
+		{"timesheet every weekday !low", "timesheet", nil, models.LowPriority, "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"},
+		{"report every 3 weeks", "report", nil, models.NoPriority, "FREQ=WEEKLY;INTERVAL=3"},
+		{"tag #a #b #a", "tag", []string{"a", "b"}, models.NoPriority, ""},
+		{`read "next friday" #books`, "read next friday", []string{"books"}, models.NoPriority, ""},
+		{"email #1 contact", "email contact", []string{"1"}, models.NoPriority, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			result, err := Parse(test.line, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			todo := result.Todo
+			if todo.Name != test.name {
+				t.Errorf("name = %q, want %q", todo.Name, test.name)
+			}
+			if !slices.Equal(todo.Labels, test.labels) {
+				t.Errorf("labels = %q, want %q", todo.Labels, test.labels)
+			}
+			if todo.Priority != test.priority {
+				t.Errorf("priority = %s, want %s", todo.Priority, test.priority)
+			}
+			if todo.Recurrence != test.recurrence {
+				t.Errorf("recurrence = %q, want %q", todo.Recurrence, test.recurrence)
+			}
+		})
+	}
+}
+
+func TestParseRecurrenceStart(t *testing.T) {
+	now := time.Date(2026, time.October, 21, 10, 0, 0, 0, time.UTC)
+	result, err := Parse("gym every friday 7am", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2026, time.October, 23, 7, 0, 0, 0, time.UTC)
+	if result.Todo.DueAt == nil || !result.Todo.DueAt.Equal(want) {
+		t.Fatalf("due = %v, want %s", result.Todo.DueAt, want)
+	}
+}
+
+func TestParseUnderstood(t *testing.T) {
+	now := time.Date(2026, time.October, 21, 10, 0, 0, 0, time.UTC)
+	result, err := Parse("Pay invoice tomorrow 5pm #finance !high", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.QuickAddMatch{
+		{Field: FieldLabel, Text: "#finance", Value: "finance"},
+		{Field: FieldPriority, Text: "!high", Value: "high"},
+		{Field: FieldDue, Text: "tomorrow 5pm", Value: "2026-10-22T17:00:00Z"},
+	}
+	if !slices.Equal(result.Understood, want) {
+		t.Fatalf("understood = %+v, want %+v", result.Understood, want)
+	}
+}
+
+func TestParseEmptyName(t *testing.T) {
+	if _, err := Parse("tomorrow #work", time.Now()); err == nil {
+		t.Fatal("expected an error when only metadata is given")
+	}
+}
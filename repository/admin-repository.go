@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+const revokeRefreshTokensQuery = `update refresh set revoked = true where user_id = $1 and not revoked and expires_at > now()`
+
+// SetUserDisabled disables or re-enables a user. Disabling also revokes the user's refresh tokens,
+// access tokens stop working on their next request.
+func SetUserDisabled(ctx context.Context, db *sql.DB, user_id string, disabled bool) error {
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+	query := `update users set disabled_at = case when $2 then coalesce(disabled_at, now()) end where id = $1`
+	result, err := transaction.ExecContext(ctx, query, user_id, disabled)
+	if err != nil {
+		return err
+	}
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowCount == 0 {
+		return ErrUserNotFound
+	}
+	if disabled {
+		if _, err = transaction.ExecContext(ctx, revokeRefreshTokensQuery, user_id); err != nil {
+			return err
+		}
+	}
+	return transaction.Commit()
+}
+
+// SetUserPassword replaces the password hash and revokes every refresh token issued with the old password.
+func SetUserPassword(ctx context.Context, db *sql.DB, user_id string, hashPassword string) error {
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+	result, err := transaction.ExecContext(ctx, `update users set hashpassword = $2 where id = $1`, user_id, hashPassword)
+	if err != nil {
+		return err
+	}
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowCount == 0 {
+		return ErrUserNotFound
+	}
+	if _, err = transaction.ExecContext(ctx, revokeRefreshTokensQuery, user_id); err != nil {
+		return err
+	}
+	return transaction.Commit()
+}
+
+// RevokeRefreshTokens revokes the user's outstanding refresh tokens and returns how many there were.
+func RevokeRefreshTokens(ctx context.Context, db *sql.DB, user_id string) (int64, error) {
+	result, err := db.ExecContext(ctx, revokeRefreshTokensQuery, user_id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// purgeQuery deletes the todos matching its where clause, their subtasks going along through the foreign
+// key, and moves each affected user's sync purge horizon up to this transaction so that sync tokens which
+// may have missed the deletions get a full resync. It yields the number of todos matched.
+const purgeQuery = `with purged as (delete from todo where %s returning user_id),
+	horizon as (insert into sync_purges (user_id, purged_xid) select distinct user_id, pg_current_xact_id() from purged
+		on conflict (user_id) do update set purged_xid = excluded.purged_xid)
+	select count(*) from purged`
+
+// PurgeDeletedTodos removes todos that were deleted before cutoff, for one user or for everyone when
+// user_id is empty. Clients that last synced before a purged deletion are sent a full resync.
+func PurgeDeletedTodos(ctx context.Context, db *sql.DB, user_id string, cutoff time.Time) (int64, error) {
+	var count int64
+	query := fmt.Sprintf(purgeQuery, `deleted_at < $1 and ($2::uuid is null or user_id = $2)`)
+	err := db.QueryRowContext(ctx, query, cutoff, userIdArg(user_id)).Scan(&count)
+	return count, err
+}
+
+// CountDeletedTodos counts what PurgeDeletedTodos would remove.
+func CountDeletedTodos(ctx context.Context, db *sql.DB, user_id string, cutoff time.Time) (int64, error) {
+	var count int64
+	query := `select count(*) from todo where deleted_at < $1 and ($2::uuid is null or user_id = $2)`
+	err := db.QueryRowContext(ctx, query, cutoff, userIdArg(user_id)).Scan(&count)
+	return count, err
+}
+
+func userIdArg(user_id string) any {
+	if user_id == "" {
+		return nil
+	}
+	return user_id
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+	"todos/models"
+)
+
+// ReserveIdempotencyKey claims key for a request that is about to run. It returns false, and the
+// existing record, when the key was used before and has not expired yet.
+func ReserveIdempotencyKey(ctx context.Context, db *sql.DB, scope string, key string, fingerprint string, ttl time.Duration) (bool, *models.IdempotencyRecord, error) {
+	_, err := db.ExecContext(ctx, `delete from idempotency_keys where scope = $1 and key = $2 and expires_at < now()`, scope, key)
+	if err != nil {
+		return false, nil, err
+	}
+	query := `insert into idempotency_keys (scope, key, fingerprint, expires_at) values ($1, $2, $3, now() + $4 * interval '1 second')
+		on conflict (scope, key) do nothing`
+	result, err := db.ExecContext(ctx, query, scope, key, fingerprint, ttl.Seconds())
+	if err != nil {
+		return false, nil, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, nil, err
+	}
+	if inserted == 1 {
+		return true, nil, nil
+	}
+	record, err := GetIdempotencyKey(ctx, db, scope, key)
+	if err != nil {
+		return false, nil, err
+	}
+	return false, record, nil
+}
+
+func GetIdempotencyKey(ctx context.Context, db *sql.DB, scope string, key string) (*models.IdempotencyRecord, error) {
+	query := `select key, fingerprint, status, headers, body, expires_at from idempotency_keys where scope = $1 and key = $2`
+	record := new(models.IdempotencyRecord)
+	var status sql.NullInt64
+	var headers []byte
+	err := db.QueryRowContext(ctx, query, scope, key).Scan(&record.Key, &record.Fingerprint, &status, &headers, &record.Body, &record.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record.Status = int(status.Int64)
+	if err = json.Unmarshal(headers, &record.Headers); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func CompleteIdempotencyKey(ctx context.Context, db *sql.DB, scope string, key string, status int, headers map[string]string, body []byte) error {
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	query := `update idempotency_keys set status = $3, headers = $4, body = $5 where scope = $1 and key = $2`
+	_, err = db.ExecContext(ctx, query, scope, key, status, encoded, body)
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a reserved key so the request can be retried, used when it failed on our side.
+func ReleaseIdempotencyKey(ctx context.Context, db *sql.DB, scope string, key string) error {
+	_, err := db.ExecContext(ctx, `delete from idempotency_keys where scope = $1 and key = $2 and status is null`, scope, key)
+	return err
+}
+
+func PurgeIdempotencyKeys(ctx context.Context, db *sql.DB) (int64, error) {
+	result, err := db.ExecContext(ctx, `delete from idempotency_keys where expires_at < now()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
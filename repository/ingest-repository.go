@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"todos/models"
+)
+
+var (
+	ErrUnknownIngestKey   = errors.New("unknown ingest key")
+	ErrAttachmentNotFound = errors.New("attachment not found")
+)
+
+// SaveIngestKey replaces the user's ingest key, which invalidates the previous URL and email address.
+func SaveIngestKey(ctx context.Context, db *sql.DB, user_id string, tokenHash string) error {
+	query := `insert into ingest_keys (user_id, token_hash) values ($1, $2)
+		on conflict (user_id) do update set token_hash = excluded.token_hash, created_at = now()`
+	_, err := db.ExecContext(ctx, query, user_id, tokenHash)
+	return err
+}
+
+func DeleteIngestKey(ctx context.Context, db *sql.DB, user_id string) error {
+	_, err := db.ExecContext(ctx, `delete from ingest_keys where user_id = $1`, user_id)
+	return err
+}
+
+func FetchUserIdByIngestKey(ctx context.Context, db *sql.DB, tokenHash string) (string, error) {
+	var user_id string
+	err := db.QueryRowContext(ctx, `select k.user_id from ingest_keys k join users u on u.id = k.user_id
+		where k.token_hash = $1 and u.disabled_at is null`, tokenHash).Scan(&user_id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUnknownIngestKey
+	}
+	return user_id, err
+}
+
+// CreateTodoWithAttachments creates the todo and stores its attachments in one transaction.
+func CreateTodoWithAttachments(ctx context.Context, db *sql.DB, todo *models.Todo, user_id string, attachments []*models.Attachment) (*models.GetTodoResponse, error) {
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+	query := `insert into todo (name, description, priority, labels, recurrence, due_at, user_id) values ($1, $2, $3, $4, $5, $6, $7) returning ` + todoColumns
+	created, err := scanTodo(transaction.QueryRowContext(ctx, query, todo.Name, todo.Description, todo.Priority, labelsArg(todo.Labels), todo.Recurrence, todo.DueAt, user_id))
+	if err != nil {
+		return nil, err
+	}
+	for _, attachment := range attachments {
+		query := `insert into attachments (todo_id, filename, content_type, size, data) values ($1, $2, $3, $4, $5) returning id, todo_id, created_at`
+		err = transaction.QueryRowContext(ctx, query, created.Id, attachment.Filename, attachment.ContentType, len(attachment.Data), attachment.Data).
+			Scan(&attachment.Id, &attachment.TodoId, &attachment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		attachment.Size = len(attachment.Data)
+	}
+	return created, transaction.Commit()
+}
+
+func ListAttachments(ctx context.Context, db *sql.DB, todo_id string, user_id string) ([]*models.Attachment, error) {
+	query := `select a.id, a.todo_id, a.filename, a.content_type, a.size, a.created_at
+		from attachments a join todo t on t.id = a.todo_id
+		where a.todo_id = $1 and t.user_id = $2 and t.deleted_at is null
+		order by a.created_at, a.id`
+	rows, err := db.QueryContext(ctx, query, todo_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attachments := []*models.Attachment{}
+	for rows.Next() {
+		attachment := new(models.Attachment)
+		err = rows.Scan(&attachment.Id, &attachment.TodoId, &attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+func GetAttachment(ctx context.Context, db *sql.DB, id string, todo_id string, user_id string) (*models.Attachment, error) {
+	query := `select a.id, a.todo_id, a.filename, a.content_type, a.size, a.created_at, a.data
+		from attachments a join todo t on t.id = a.todo_id
+		where a.id = $1 and a.todo_id = $2 and t.user_id = $3 and t.deleted_at is null`
+	attachment := new(models.Attachment)
+	err := db.QueryRowContext(ctx, query, id, todo_id, user_id).
+		Scan(&attachment.Id, &attachment.TodoId, &attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.CreatedAt, &attachment.Data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
@@ -0,0 +1,286 @@
+package repository
+
+import (
+	"cmp"
+	"crypto/rand"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"time"
+	"todos/models"
+	"todos/pagination"
+	"unicode"
+)
+
+// What follows does in Go what the postgres store leaves to the database: filtering, keyset paging
+// and a plain word search without stemming. The memory and sqlite stores share it.
+
+// SearchLanguages are the text search configurations postgres ships with, which the other stores
+// accept as settings even though they do not stem.
+var SearchLanguages = []string{"simple", "arabic", "armenian", "basque", "catalan", "danish", "dutch", "english", "finnish",
+	"french", "german", "greek", "hindi", "hungarian", "indonesian", "irish", "italian", "lithuanian", "nepali", "norwegian",
+	"portuguese", "romanian", "russian", "serbian", "spanish", "swedish", "tamil", "turkish", "yiddish"}
+
+// NewId returns a random version 4 uuid, formatted like postgres' gen_random_uuid.
+func NewId() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Now is the current time at the microsecond precision postgres keeps.
+func Now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// Matches reports whether todo passes the status and label parts of filter.
+func (filter TodoFilter) Matches(todo *models.GetTodoResponse) bool {
+	if filter.Status != nil && todo.TaskStatus != *filter.Status {
+		return false
+	}
+	return filter.Label == "" || slices.Contains(todo.Labels, filter.Label)
+}
+
+// NewestFirst orders todos the way listings show them.
+func NewestFirst(a, b *models.GetTodoResponse) int {
+	if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(b.Id, a.Id)
+}
+
+// PageTodos picks the page out of todos, which must hold every matching todo ordered newest first.
+func PageTodos(todos []*models.GetTodoResponse, page *pagination.Request) ([]*models.GetTodoResponse, bool) {
+	if page.OffsetMode {
+		return offsetPage(todos, page)
+	}
+	return keysetPage(todos, page, func(todo *models.GetTodoResponse) int {
+		return compareKey(0, todo.CreatedAt, todo.Id, page.Cursor)
+	})
+}
+
+// PageSearchResults does what PageTodos does for results ordered by RankResults.
+func PageSearchResults(results []*models.SearchResult, page *pagination.Request) ([]*models.SearchResult, bool) {
+	if page.OffsetMode {
+		results, _ = offsetPage(results, page)
+		return results, len(results) == page.Limit
+	}
+	return keysetPage(results, page, func(result *models.SearchResult) int {
+		return compareKey(result.Rank, result.CreatedAt, result.Id, page.Cursor)
+	})
+}
+
+func RankResults(a, b *models.SearchResult) int {
+	if c := cmp.Compare(b.Rank, a.Rank); c != 0 {
+		return c
+	}
+	return NewestFirst(&a.GetTodoResponse, &b.GetTodoResponse)
+}
+
+func offsetPage[T any](items []T, page *pagination.Request) ([]T, bool) {
+	if page.Offset >= len(items) {
+		return []T{}, false
+	}
+	items = items[page.Offset:]
+	return pagination.Trim(items[:min(len(items), page.Limit+1)], page)
+}
+
+// keysetPage takes up to one more than a page of the items following the cursor, or preceding it
+// for a backward page, the way the postgres store's keyset queries do. compare orders an item's
+// sort key against the cursor's.
+func keysetPage[T any](items []T, page *pagination.Request, compare func(T) int) ([]T, bool) {
+	selected := []T{}
+	if page.Backward() {
+		for i := len(items) - 1; i >= 0 && len(selected) <= page.Limit; i-- {
+			if compare(items[i]) > 0 {
+				selected = append(selected, items[i])
+			}
+		}
+		return pagination.Trim(selected, page)
+	}
+	for _, item := range items {
+		if len(selected) > page.Limit {
+			break
+		}
+		if page.Cursor == nil || compare(item) < 0 {
+			selected = append(selected, item)
+		}
+	}
+	return pagination.Trim(selected, page)
+}
+
+// compareKey compares an item's sort key with the cursor's; listings run from high keys to low ones
+func compareKey(rank float64, createdAt time.Time, id string, cursor *pagination.Cursor) int {
+	cursorRank := 0.0
+	if cursor.Rank != nil {
+		cursorRank = *cursor.Rank
+	}
+	if c := cmp.Compare(rank, cursorRank); c != 0 {
+		return c
+	}
+	if c := createdAt.Compare(cursor.CreatedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(id, cursor.Id)
+}
+
+// searchWeights follow ts_rank's defaults for the weights the postgres store gives each field
+var searchWeights = map[string]float64{
+	models.SearchFieldName:        1.0,
+	models.SearchFieldDescription: 0.4,
+	models.SearchFieldLabels:      0.2,
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func searchFieldText(todo *models.GetTodoResponse, field string) string {
+	switch field {
+	case models.SearchFieldName:
+		return todo.Name
+	case models.SearchFieldDescription:
+		return todo.Description
+	}
+	return strings.Join(todo.Labels, " ")
+}
+
+// MatchTodo searches one todo the way SearchTodo searches them all and returns nil when it does not match.
+func MatchTodo(todo *models.GetTodoResponse, options SearchOptions) *models.SearchResult {
+	if options.Mode == SearchModeFuzzy {
+		return fuzzyMatch(todo, options)
+	}
+	terms := words(options.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+	matches := func(term int, word string) bool {
+		if options.Prefix && term == len(terms)-1 {
+			return strings.HasPrefix(word, terms[term])
+		}
+		return word == terms[term]
+	}
+	result := &models.SearchResult{GetTodoResponse: *todo, MatchedFields: []string{}}
+	found := make([]float64, len(terms))
+	for _, field := range models.SearchFields {
+		if !options.hasField(field) {
+			continue
+		}
+		fieldWords := words(searchFieldText(todo, field))
+		all := true
+		for term := range terms {
+			if slices.ContainsFunc(fieldWords, func(word string) bool { return matches(term, word) }) {
+				found[term] = max(found[term], searchWeights[field])
+			} else {
+				all = false
+			}
+		}
+		if all {
+			result.MatchedFields = append(result.MatchedFields, field)
+		}
+	}
+	for _, weight := range found {
+		if weight == 0 {
+			return nil
+		}
+		result.Rank += weight / float64(len(terms))
+	}
+	highlight := func(text string) string {
+		return highlightWords(text, func(word string) bool {
+			for term := range terms {
+				if matches(term, word) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	result.Highlights.Name = highlight(todo.Name)
+	result.Highlights.Description = highlight(todo.Description)
+	return result
+}
+
+// highlightWords marks the words of text that match and escapes the rest as html, like the postgres
+// highlights
+func highlightWords(text string, match func(word string) bool) string {
+	var b strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			b.WriteString(html.EscapeString(string(runes[i])))
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+			j++
+		}
+		word := string(runes[i:j])
+		if match(strings.ToLower(word)) {
+			b.WriteString("<mark>" + word + "</mark>")
+		} else {
+			b.WriteString(word)
+		}
+		i = j
+	}
+	return b.String()
+}
+
+func fuzzyMatch(todo *models.GetTodoResponse, options SearchOptions) *models.SearchResult {
+	query := words(options.Text)
+	if len(query) == 0 {
+		return nil
+	}
+	queryTrigrams := trigrams(query)
+	result := &models.SearchResult{GetTodoResponse: *todo, MatchedFields: []string{}}
+	for _, field := range models.SearchFields {
+		if !options.hasField(field) {
+			continue
+		}
+		score := wordSimilarity(queryTrigrams, len(query), words(searchFieldText(todo, field)))
+		if score >= fuzzyThreshold {
+			result.MatchedFields = append(result.MatchedFields, field)
+		}
+		result.Rank = max(result.Rank, score)
+	}
+	if len(result.MatchedFields) == 0 {
+		return nil
+	}
+	result.Highlights.Name = html.EscapeString(todo.Name)
+	result.Highlights.Description = html.EscapeString(todo.Description)
+	return result
+}
+
+// wordSimilarity is the share of the query's trigrams found in the best run of as many words of the
+// field, close to what pg_trgm's word_similarity measures
+func wordSimilarity(query map[string]bool, size int, fieldWords []string) float64 {
+	best := 0.0
+	for start := 0; start < len(fieldWords); start++ {
+		window := trigrams(fieldWords[start:min(start+size, len(fieldWords))])
+		common := 0
+		for trigram := range query {
+			if window[trigram] {
+				common++
+			}
+		}
+		best = max(best, float64(common)/float64(len(query)))
+	}
+	return best
+}
+
+// trigrams splits words into trigrams like pg_trgm, padding each word with two spaces in front and one behind
+func trigrams(words []string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,596 @@
+// Package memory keeps todos, users and tokens in maps. It is meant for tests and demos; nothing
+// survives a restart.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"todos/models"
+	"todos/pagination"
+	"todos/repository"
+)
+
+type todoRecord struct {
+	todo      models.GetTodoResponse
+	userId    string
+	deletedAt *time.Time
+}
+
+type forgotRecord struct {
+	userId    string
+	email     string
+	token     string
+	expiresAt time.Time
+	used      bool
+}
+
+type Store struct {
+	mu      sync.Mutex
+	todos   map[string]*todoRecord
+	users   map[string]*models.User
+	search  map[string]string
+	refresh map[string]*models.SaveRefresh
+	// newest last, like the rows the postgres store orders by created_at
+	forgot []*forgotRecord
+}
+
+var _ repository.Store = (*Store)(nil)
+
+func New() *Store {
+	return &Store{
+		todos:   map[string]*todoRecord{},
+		users:   map[string]*models.User{},
+		search:  map[string]string{},
+		refresh: map[string]*models.SaveRefresh{},
+	}
+}
+
+// Stores returns a fresh memory store set up for the handlers.
+func Stores() *repository.Stores {
+	return repository.NewStores(New(), nil, func() error { return nil })
+}
+
+// copyTodo hands out a todo callers may change without touching the store
+func copyTodo(todo *models.GetTodoResponse) *models.GetTodoResponse {
+	copied := *todo
+	copied.Labels = append([]string{}, todo.Labels...)
+	if todo.ParentId != nil {
+		parentId := *todo.ParentId
+		copied.ParentId = &parentId
+	}
+	if todo.DueAt != nil {
+		dueAt := *todo.DueAt
+		copied.DueAt = &dueAt
+	}
+	return &copied
+}
+
+// live returns the user's todo unless it is missing or deleted
+func (s *Store) live(id string, userId string) *todoRecord {
+	record, ok := s.todos[strings.ToLower(id)]
+	if !ok || record.userId != userId || record.deletedAt != nil {
+		return nil
+	}
+	return record
+}
+
+// listing returns the user's live todos that pass filter, newest first
+func (s *Store) listing(userId string, filter repository.TodoFilter) []*models.GetTodoResponse {
+	todos := []*models.GetTodoResponse{}
+	for _, record := range s.todos {
+		if record.userId == userId && record.deletedAt == nil && filter.Matches(&record.todo) {
+			todos = append(todos, &record.todo)
+		}
+	}
+	slices.SortFunc(todos, repository.NewestFirst)
+	return todos
+}
+
+func copyAll(todos []*models.GetTodoResponse) []*models.GetTodoResponse {
+	copies := make([]*models.GetTodoResponse, len(todos))
+	for i, todo := range todos {
+		copies[i] = copyTodo(todo)
+	}
+	return copies
+}
+
+func (s *Store) ListTodos(ctx context.Context, page *pagination.Request, userId string, filter repository.TodoFilter) ([]*models.GetTodoResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	todos, more := repository.PageTodos(s.listing(userId, filter), page)
+	return copyAll(todos), more, nil
+}
+
+func (s *Store) CountTodos(ctx context.Context, userId string, filter repository.TodoFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.listing(userId, filter)), nil
+}
+
+func (s *Store) ListSubtasks(ctx context.Context, parentId string, userId string) ([]*models.GetTodoResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyAll(s.children(strings.ToLower(parentId), userId)), nil
+}
+
+// children returns the live subtasks of a todo, oldest first
+func (s *Store) children(parentId string, userId string) []*models.GetTodoResponse {
+	todos := []*models.GetTodoResponse{}
+	for _, record := range s.todos {
+		if record.todo.ParentId != nil && *record.todo.ParentId == parentId && record.userId == userId && record.deletedAt == nil {
+			todos = append(todos, &record.todo)
+		}
+	}
+	slices.SortFunc(todos, func(a, b *models.GetTodoResponse) int { return repository.NewestFirst(b, a) })
+	return todos
+}
+
+// subtree returns the ids of a live todo and of its live descendants
+func (s *Store) subtree(id string, userId string) []string {
+	ids := []string{id}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range s.children(ids[i], userId) {
+			ids = append(ids, child.Id)
+		}
+	}
+	return ids
+}
+
+func (s *Store) StreamTodos(ctx context.Context, userId string, filter repository.TodoFilter, fn func(*models.GetTodoResponse) error) error {
+	s.mu.Lock()
+	todos := s.listing(userId, filter)
+	if filter.RootId != "" {
+		var ids []string
+		if s.live(filter.RootId, userId) != nil {
+			ids = s.subtree(strings.ToLower(filter.RootId), userId)
+		}
+		todos = slices.DeleteFunc(todos, func(todo *models.GetTodoResponse) bool { return !slices.Contains(ids, todo.Id) })
+	}
+	todos = copyAll(todos)
+	s.mu.Unlock()
+	for _, todo := range todos {
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) GetTodoByID(ctx context.Context, id string, userId string) (*models.GetTodoResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.live(id, userId)
+	if record == nil {
+		return nil, nil
+	}
+	return copyTodo(&record.todo), nil
+}
+
+func newTodo(id string, todo *models.Todo, createdAt time.Time) models.GetTodoResponse {
+	created := models.GetTodoResponse{
+		Id:          id,
+		Name:        todo.Name,
+		Description: todo.Description,
+		TaskStatus:  todo.TaskStatus,
+		Priority:    todo.Priority,
+		Labels:      append([]string{}, todo.Labels...),
+		Recurrence:  todo.Recurrence,
+		DueAt:       todo.DueAt,
+		CreatedAt:   createdAt,
+		Version:     1,
+	}
+	if !todo.CreatedAt.IsZero() {
+		created.CreatedAt = todo.CreatedAt.Truncate(time.Microsecond)
+	}
+	return created
+}
+
+func (s *Store) CreateTodo(ctx context.Context, todo *models.Todo, userId string) (*models.GetTodoResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := &todoRecord{todo: newTodo(repository.NewId(), todo, repository.Now()), userId: userId}
+	// like the postgres store, a new todo always starts at the current time
+	record.todo.CreatedAt = repository.Now()
+	s.todos[record.todo.Id] = record
+	return copyTodo(&record.todo), nil
+}
+
+func (s *Store) CreateTodos(ctx context.Context, todos []*models.Todo, userId string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var created []*todoRecord
+	var insert func(todos []*models.Todo, parentId *string)
+	insert = func(todos []*models.Todo, parentId *string) {
+		for _, todo := range todos {
+			// later rows get later times so an import keeps its order, as clock_timestamp does in postgres
+			createdAt := repository.Now()
+			if len(created) > 0 && !createdAt.After(created[len(created)-1].todo.CreatedAt) {
+				createdAt = created[len(created)-1].todo.CreatedAt.Add(time.Microsecond)
+			}
+			record := &todoRecord{todo: newTodo(repository.NewId(), todo, createdAt), userId: userId}
+			record.todo.ParentId = parentId
+			created = append(created, record)
+			insert(todo.Subtasks, &record.todo.Id)
+		}
+	}
+	insert(todos, nil)
+	for _, record := range created {
+		s.todos[record.todo.Id] = record
+	}
+	return len(created), nil
+}
+
+// versionConflict tells apart the two reasons a guarded write is refused
+func (s *Store) versionConflict(id string, userId string) error {
+	if s.live(id, userId) != nil {
+		return repository.ErrVersionMismatch
+	}
+	return repository.ErrTodoNotFound
+}
+
+func versionMatches(version int, versions []int) bool {
+	return versions == nil || slices.Contains(versions, version)
+}
+
+func replace(record *todoRecord, todo *models.Todo) {
+	record.todo.Name = todo.Name
+	record.todo.Description = todo.Description
+	record.todo.TaskStatus = todo.TaskStatus
+	record.todo.Priority = todo.Priority
+	record.todo.Labels = append([]string{}, todo.Labels...)
+	record.todo.Recurrence = todo.Recurrence
+	record.todo.DueAt = todo.DueAt
+	record.todo.Version++
+}
+
+func (s *Store) PutTodo(ctx context.Context, id string, todo *models.Todo, userId string, condition repository.PutCondition, versions []int) (*models.GetTodoResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id = strings.ToLower(id)
+	record, exists := s.todos[id]
+	// the user's own deleted todo counts as absent, it is purged and the id starts over
+	if exists && record.deletedAt != nil && record.userId == userId && condition != repository.PutReplaceOnly {
+		delete(s.todos, id)
+		s.removeOrphans()
+		exists = false
+	}
+	switch {
+	case condition == repository.PutReplaceOnly:
+		record = s.live(id, userId)
+		if record == nil || !versionMatches(record.todo.Version, versions) {
+			return nil, false, s.versionConflict(id, userId)
+		}
+		replace(record, todo)
+		return copyTodo(&record.todo), false, nil
+	case !exists:
+		record = &todoRecord{todo: newTodo(id, todo, repository.Now()), userId: userId}
+		s.todos[id] = record
+		return copyTodo(&record.todo), true, nil
+	case condition == repository.PutAny && record.userId == userId:
+		replace(record, todo)
+		return copyTodo(&record.todo), false, nil
+	}
+	err := s.versionConflict(id, userId)
+	// the id exists but belongs to somebody else
+	if errors.Is(err, repository.ErrTodoNotFound) {
+		err = repository.ErrTodoIdTaken
+	}
+	return nil, false, err
+}
+
+func (s *Store) UpdateTodo(ctx context.Context, doc *models.TodoDocument, id string, userId string, versions []int) (*models.GetTodoResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id = strings.ToLower(id)
+	var parentId *string
+	if doc.ParentId != nil {
+		parent := strings.ToLower(*doc.ParentId)
+		if s.live(parent, userId) == nil {
+			return nil, repository.ErrInvalidParent
+		}
+		// the todo may not become a subtask of itself or of one of its own subtasks
+		for ancestor := &parent; ancestor != nil; ancestor = s.todos[*ancestor].todo.ParentId {
+			if *ancestor == id {
+				return nil, repository.ErrInvalidParent
+			}
+			if _, ok := s.todos[*ancestor]; !ok {
+				break
+			}
+		}
+		parentId = &parent
+	}
+	record := s.live(id, userId)
+	if record == nil || !versionMatches(record.todo.Version, versions) {
+		return nil, s.versionConflict(id, userId)
+	}
+	record.todo.Name = doc.Name
+	record.todo.Description = doc.Description
+	record.todo.TaskStatus = doc.TaskStatus
+	record.todo.Priority = doc.Priority
+	record.todo.Labels = append([]string{}, doc.Labels...)
+	record.todo.Recurrence = doc.Recurrence
+	record.todo.DueAt = doc.DueAt
+	record.todo.ParentId = parentId
+	record.todo.Version++
+	return copyTodo(&record.todo), nil
+}
+
+func (s *Store) DeleteTodo(ctx context.Context, id string, userId string, versions []int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id = strings.ToLower(id)
+	record := s.live(id, userId)
+	if record == nil || !versionMatches(record.todo.Version, versions) {
+		return nil, s.versionConflict(id, userId)
+	}
+	now := repository.Now()
+	subtree := s.subtree(id, userId)
+	for _, deleted := range subtree {
+		s.todos[deleted].deletedAt = &now
+		s.todos[deleted].todo.Version++
+	}
+	return subtree, nil
+}
+
+func (s *Store) SearchTodo(ctx context.Context, options repository.SearchOptions, userId string) ([]*models.SearchResult, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := []*models.SearchResult{}
+	for _, todo := range s.listing(userId, repository.TodoFilter{}) {
+		if result := repository.MatchTodo(copyTodo(todo), options); result != nil {
+			results = append(results, result)
+		}
+	}
+	slices.SortFunc(results, repository.RankResults)
+	results, more := repository.PageSearchResults(results, options.Page)
+	return results, more, nil
+}
+
+func (s *Store) deletedBefore(userId string, cutoff time.Time) []string {
+	var ids []string
+	for id, record := range s.todos {
+		if record.deletedAt != nil && record.deletedAt.Before(cutoff) && (userId == "" || record.userId == userId) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (s *Store) PurgeDeletedTodos(ctx context.Context, userId string, cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged := s.deletedBefore(userId, cutoff)
+	for _, id := range purged {
+		delete(s.todos, id)
+	}
+	s.removeOrphans()
+	return int64(len(purged)), nil
+}
+
+// removeOrphans drops the subtasks of purged todos, as the foreign key does in postgres
+func (s *Store) removeOrphans() {
+	for removed := true; removed; {
+		removed = false
+		for id, record := range s.todos {
+			if record.todo.ParentId != nil && s.todos[*record.todo.ParentId] == nil {
+				delete(s.todos, id)
+				removed = true
+			}
+		}
+	}
+}
+
+func (s *Store) CountDeletedTodos(ctx context.Context, userId string, cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.deletedBefore(userId, cutoff))), nil
+}
+
+func copyUser(user *models.User) *models.User {
+	copied := *user
+	if user.DisabledAt != nil {
+		disabledAt := *user.DisabledAt
+		copied.DisabledAt = &disabledAt
+	}
+	return &copied
+}
+
+func (s *Store) CreateUser(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.users {
+		if existing.UserName == user.UserName {
+			return fmt.Errorf("username %s is already taken", user.UserName)
+		}
+		if existing.Email == user.Email {
+			return fmt.Errorf("email %s is already taken", user.Email)
+		}
+	}
+	created := &models.User{Id: repository.NewId(), UserName: user.UserName, Email: user.Email, HashedPassword: user.HashedPassword, CreatedAt: repository.Now()}
+	s.users[created.Id] = created
+	s.search[created.Id] = "simple"
+	return nil
+}
+
+func (s *Store) FetchUserWithUserID(ctx context.Context, userName string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.UserName == userName {
+			return copyUser(user), nil
+		}
+	}
+	return nil, errors.New("there is no user with this user-name")
+}
+
+func (s *Store) FetchUserByID(ctx context.Context, id string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[strings.ToLower(id)]
+	if !ok {
+		return nil, errors.New("there is no user with this id")
+	}
+	return copyUser(user), nil
+}
+
+func (s *Store) IsEmailExists(ctx context.Context, forgot *models.ForgotPasswordRequest) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Email == forgot.Email {
+			return user.Id, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (s *Store) GetUserSettings(ctx context.Context, userId string) (*models.UserSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	language, ok := s.search[strings.ToLower(userId)]
+	if !ok {
+		return nil, errors.New("there is no user with this id")
+	}
+	return &models.UserSettings{SearchLanguage: language}, nil
+}
+
+func (s *Store) UpdateSearchLanguage(ctx context.Context, userId string, language string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(repository.SearchLanguages, language) {
+		return repository.ErrUnknownSearchLanguage
+	}
+	if _, ok := s.search[strings.ToLower(userId)]; ok {
+		s.search[strings.ToLower(userId)] = language
+	}
+	return nil
+}
+
+func (s *Store) SetUserDisabled(ctx context.Context, userId string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[strings.ToLower(userId)]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	if !disabled {
+		user.DisabledAt = nil
+		return nil
+	}
+	if user.DisabledAt == nil {
+		now := repository.Now()
+		user.DisabledAt = &now
+	}
+	s.revoke(user.Id)
+	return nil
+}
+
+func (s *Store) SetUserPassword(ctx context.Context, userId string, hashPassword string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[strings.ToLower(userId)]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	user.HashedPassword = hashPassword
+	s.revoke(user.Id)
+	return nil
+}
+
+func (s *Store) SaveRefreshToken(ctx context.Context, saveRefresh *models.SaveRefresh) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.refresh[saveRefresh.TokenHash]; ok {
+		return errors.New("refresh token is already stored")
+	}
+	saved := *saveRefresh
+	saved.Revoked = false
+	s.refresh[saved.TokenHash] = &saved
+	return nil
+}
+
+func (s *Store) FetchRefreshToken(ctx context.Context, hashedRefresh string) (*models.SaveRefresh, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved, ok := s.refresh[hashedRefresh]
+	if !ok {
+		return nil, errors.New("no token found")
+	}
+	copied := *saved
+	return &copied, nil
+}
+
+func (s *Store) InvalidateRefreshToken(ctx context.Context, hashedRefresh string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if saved, ok := s.refresh[hashedRefresh]; ok {
+		saved.Revoked = true
+	}
+	return nil
+}
+
+func (s *Store) RevokeRefreshTokens(ctx context.Context, userId string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoke(strings.ToLower(userId)), nil
+}
+
+// revoke revokes a user's unexpired refresh tokens and counts them
+func (s *Store) revoke(userId string) int64 {
+	var count int64
+	now := time.Now()
+	for _, saved := range s.refresh {
+		if saved.UserId == userId && !saved.Revoked && saved.ExpiresAt.After(now) {
+			saved.Revoked = true
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Store) StoreForgotPasswordToken(ctx context.Context, request map[string]string) *models.ErrorResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email, ok := request["email"]
+	if !ok {
+		return &models.ErrorResponse{Message: "no email received from request", Status: http.StatusBadRequest}
+	}
+	for i := len(s.forgot) - 1; i >= 0; i-- {
+		if s.forgot[i].email != email {
+			continue
+		}
+		if s.forgot[i].expiresAt.After(time.Now()) && !s.forgot[i].used {
+			return &models.ErrorResponse{Message: "an active link has already been sent to your mail, for new token wait for sometime", Status: http.StatusBadRequest}
+		}
+		break
+	}
+	s.forgot = append(s.forgot, &forgotRecord{userId: request["userid"], email: email, token: request["token"], expiresAt: time.Now().Add(15 * time.Minute)})
+	return nil
+}
+
+func (s *Store) UpdatePassword(ctx context.Context, request *models.UpdatePasswordRequest, token string) *models.ErrorResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := slices.IndexFunc(s.forgot, func(record *forgotRecord) bool { return record.token == token })
+	if index < 0 {
+		return &models.ErrorResponse{Message: "no rows with this token", Status: http.StatusNotFound}
+	}
+	record := s.forgot[index]
+	if record.expiresAt.Before(time.Now()) || record.used {
+		return &models.ErrorResponse{Message: "provided token has either already been used or is expired", Status: http.StatusInternalServerError}
+	}
+	user, ok := s.users[record.userId]
+	if !ok {
+		return &models.ErrorResponse{Message: "no user affected", Status: http.StatusInternalServerError}
+	}
+	user.HashedPassword = request.NewPassword
+	record.used = true
+	return nil
+}
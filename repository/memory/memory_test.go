@@ -0,0 +1,11 @@
+package memory
+
+import (
+	"testing"
+	"todos/repository"
+	"todos/repository/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store { return New() })
+}
@@ -0,0 +1,190 @@
+// Package pgtest hands tests a freshly migrated postgres database of their own.
+//
+// The databases live on the server TODOS_TEST_DATABASE_URL points at, a connection string for a
+// user allowed to create databases. Without it, a throwaway server is started from the initdb and
+// pg_ctl found on PATH (or in TODOS_TEST_PG_BIN) and stopped again when the tests finish. When
+// neither is available the tests that need postgres are skipped, or fail when
+// TODOS_REQUIRE_POSTGRES=1 so that CI cannot pass without running them.
+package pgtest
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"todos/migrations"
+
+	_ "github.com/lib/pq"
+)
+
+var (
+	startOnce sync.Once
+	serverURL string
+	startErr  error
+	stop      func()
+)
+
+// Open creates an empty database, applies every migration and returns a connection to it. The
+// database is dropped when t finishes.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	base := server(t)
+	name := "todos_test_" + randomSuffix()
+	admin, err := sql.Open("postgres", base)
+	if err != nil {
+		t.Fatalf("pgtest: %v", err)
+	}
+	defer admin.Close()
+	if _, err := admin.Exec(`create database ` + name); err != nil {
+		t.Fatalf("pgtest: creating database %s: %v", name, err)
+	}
+	dsn, err := withDatabase(base, name)
+	if err != nil {
+		t.Fatalf("pgtest: %v", err)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("pgtest: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		admin, err := sql.Open("postgres", base)
+		if err != nil {
+			return
+		}
+		defer admin.Close()
+		admin.Exec(`drop database if exists ` + name + ` with (force)`)
+	})
+	if _, err := migrations.Up(context.Background(), db, 0); err != nil {
+		t.Fatalf("pgtest: migrating %s: %v", name, err)
+	}
+	return db
+}
+
+// Stop shuts down the server Open started, if it started one. Call it from TestMain after m.Run;
+// a server left running is only removed with its temporary directory.
+func Stop() {
+	if stop != nil {
+		stop()
+	}
+}
+
+// server returns the connection string of the server test databases are created on
+func server(t testing.TB) string {
+	t.Helper()
+	startOnce.Do(func() {
+		if serverURL = os.Getenv("TODOS_TEST_DATABASE_URL"); serverURL == "" {
+			serverURL, startErr = start()
+		}
+	})
+	if startErr != nil && os.Getenv("TODOS_REQUIRE_POSTGRES") == "1" {
+		t.Fatalf("pgtest: TODOS_REQUIRE_POSTGRES is set but there is no postgres to test against: %v", startErr)
+	}
+	if startErr != nil {
+		t.Skipf("pgtest: no postgres to test against, set TODOS_TEST_DATABASE_URL or put initdb on PATH: %v", startErr)
+	}
+	return serverURL
+}
+
+// start runs a throwaway server in a temporary directory, listening on a free loopback port
+func start() (string, error) {
+	initdb, err := binary("initdb")
+	if err != nil {
+		return "", err
+	}
+	pgCtl, err := binary("pg_ctl")
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "pgtest")
+	if err != nil {
+		return "", err
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("initdb: %v: %s", err, out)
+	}
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	// fsync off and the socket in the temporary directory keep the server fast and out of the way
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c fsync=off -c synchronous_commit=off -c full_page_writes=off", port, dir)
+	cmd := exec.Command(pgCtl, "-D", data, "-l", filepath.Join(dir, "log"), "-o", options, "-w", "-t", "60", "start")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+	stop = func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+		os.RemoveAll(dir)
+	}
+	dsn := fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+	if err := waitReady(dsn); err != nil {
+		Stop()
+		return "", err
+	}
+	return dsn, nil
+}
+
+func binary(name string) (string, error) {
+	if dir := os.Getenv("TODOS_TEST_PG_BIN"); dir != "" {
+		return filepath.Join(dir, name), nil
+	}
+	return exec.LookPath(name)
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func waitReady(dsn string) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		err := db.Ping()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// withDatabase points a connection string, either a URL or key=value pairs, at another database
+func withDatabase(dsn string, name string) (string, error) {
+	if parsed, err := url.Parse(dsn); err == nil && (parsed.Scheme == "postgres" || parsed.Scheme == "postgresql") {
+		parsed.Path = "/" + name
+		return parsed.String(), nil
+	}
+	if dsn == "" {
+		return "", fmt.Errorf("empty connection string")
+	}
+	// lib/pq takes the last value given for a key
+	return dsn + " dbname=" + name, nil
+}
+
+func randomSuffix() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
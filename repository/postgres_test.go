@@ -0,0 +1,456 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"testing"
+	"time"
+	"todos/events"
+	"todos/migrations"
+	"todos/models"
+	"todos/pagination"
+	"todos/repository"
+	"todos/repository/pgtest"
+	"todos/router/routertest"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	pgtest.Stop()
+	os.Exit(code)
+}
+
+// postgresUser creates a user named name directly in db and returns its id
+func postgresUser(t *testing.T, db *sql.DB, name string) string {
+	t.Helper()
+	ctx := context.Background()
+	if err := repository.CreateUser(ctx, db, &models.User{UserName: name, Email: name + "@example.com", HashedPassword: "hash-" + name}); err != nil {
+		t.Fatalf("creating %s: %v", name, err)
+	}
+	user, err := repository.FetchUserWithUserID(ctx, db, name)
+	if err != nil {
+		t.Fatalf("fetching %s: %v", name, err)
+	}
+	return user.Id
+}
+
+func TestMigrations(t *testing.T) {
+	db := pgtest.Open(t)
+	ctx := context.Background()
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := migrations.List(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(all) {
+		t.Fatalf("listed %d migrations, want %d", len(list), len(all))
+	}
+	for _, status := range list {
+		if status.AppliedAt == nil || status.Unknown {
+			t.Fatalf("migration %d_%s is not applied after Open", status.Version, status.Name)
+		}
+	}
+	// every down script has to undo its up script for the round trip to apply cleanly again
+	reverted, err := migrations.Down(ctx, db, len(all))
+	if err != nil {
+		t.Fatalf("reverting every migration: %v", err)
+	}
+	if len(reverted) != len(all) {
+		t.Fatalf("reverted %d migrations, want %d", len(reverted), len(all))
+	}
+	applied, err := migrations.Up(ctx, db, 0)
+	if err != nil {
+		t.Fatalf("applying the migrations again: %v", err)
+	}
+	if len(applied) != len(all) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(all))
+	}
+	if applied, err = migrations.Up(ctx, db, 0); err != nil || len(applied) != 0 {
+		t.Fatalf("Up with nothing pending applied %d migrations: %v", len(applied), err)
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	db := pgtest.Open(t)
+	ctx := context.Background()
+	reserved, _, err := repository.ReserveIdempotencyKey(ctx, db, "alice", "key-1", "POST /todos/", time.Hour)
+	if err != nil || !reserved {
+		t.Fatalf("first reservation = %v, %v", reserved, err)
+	}
+	reserved, record, err := repository.ReserveIdempotencyKey(ctx, db, "alice", "key-1", "POST /todos/", time.Hour)
+	if err != nil || reserved || record == nil || record.Status != 0 {
+		t.Fatalf("second reservation = %v, %+v, %v, want the in-flight record", reserved, record, err)
+	}
+	// keys are scoped, another user can use the same one
+	if reserved, _, err = repository.ReserveIdempotencyKey(ctx, db, "bob", "key-1", "POST /todos/", time.Hour); err != nil || !reserved {
+		t.Fatalf("reservation in another scope = %v, %v", reserved, err)
+	}
+
+	err = repository.CompleteIdempotencyKey(ctx, db, "alice", "key-1", 201, map[string]string{"Location": "/todos/1"}, []byte(`{"id":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err = repository.GetIdempotencyKey(ctx, db, "alice", "key-1")
+	if err != nil || record == nil {
+		t.Fatalf("completed record = %+v, %v", record, err)
+	}
+	if record.Status != 201 || record.Headers["Location"] != "/todos/1" || string(record.Body) != `{"id":"1"}` {
+		t.Fatalf("completed record = %+v", record)
+	}
+	// a completed key is kept for replay, releasing only drops keys still in flight
+	if err = repository.ReleaseIdempotencyKey(ctx, db, "alice", "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if record, _ = repository.GetIdempotencyKey(ctx, db, "alice", "key-1"); record == nil {
+		t.Fatal("releasing dropped a completed key")
+	}
+	if err = repository.ReleaseIdempotencyKey(ctx, db, "bob", "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if record, _ = repository.GetIdempotencyKey(ctx, db, "bob", "key-1"); record != nil {
+		t.Fatal("releasing kept a key in flight")
+	}
+
+	if _, _, err = repository.ReserveIdempotencyKey(ctx, db, "alice", "key-2", "POST /todos/", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	purged, err := repository.PurgeIdempotencyKeys(ctx, db)
+	if err != nil || purged != 1 {
+		t.Fatalf("purged %d keys, want the expired one: %v", purged, err)
+	}
+	// an expired key can be reserved again
+	if reserved, _, err = repository.ReserveIdempotencyKey(ctx, db, "carol", "key-3", "POST /todos/", -time.Second); err != nil || !reserved {
+		t.Fatal(err)
+	}
+	if reserved, _, err = repository.ReserveIdempotencyKey(ctx, db, "carol", "key-3", "POST /todos/", time.Hour); err != nil || !reserved {
+		t.Fatalf("reserving an expired key = %v, %v", reserved, err)
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	db := pgtest.Open(t)
+	ctx := context.Background()
+	alice := postgresUser(t, db, "alice")
+	bob := postgresUser(t, db, "bob")
+	all, err := repository.CreateWebhook(ctx, db, alice, "https://example.com/all", "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments, err := repository.CreateWebhook(ctx, db, alice, "https://example.com/comments", "secret", []string{events.CommentAdded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repository.CreateWebhook(ctx, db, bob, "https://example.com/bob", "secret", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := repository.EnqueueWebhookDeliveries(ctx, db, alice, "", "event-1", events.TodoCreated, []byte(`{}`))
+	if err != nil || queued != 1 {
+		t.Fatalf("queued %d deliveries of todo.created, want 1: %v", queued, err)
+	}
+	queued, err = repository.EnqueueWebhookDeliveries(ctx, db, alice, "", "event-2", events.CommentAdded, []byte(`{}`))
+	if err != nil || queued != 2 {
+		t.Fatalf("queued %d deliveries of comment.added, want 2: %v", queued, err)
+	}
+	queued, err = repository.EnqueueWebhookDeliveries(ctx, db, alice, comments.Id, "event-3", events.CommentAdded, []byte(`{}`))
+	if err != nil || queued != 1 {
+		t.Fatalf("queued %d deliveries for one webhook, want 1: %v", queued, err)
+	}
+
+	claimed, err := repository.ClaimWebhookDeliveries(ctx, db, 10, time.Minute)
+	if err != nil || len(claimed) != 4 {
+		t.Fatalf("claimed %d deliveries, want 4: %v", len(claimed), err)
+	}
+	// leased deliveries stay with the dispatcher that claimed them
+	if again, err := repository.ClaimWebhookDeliveries(ctx, db, 10, time.Minute); err != nil || len(again) != 0 {
+		t.Fatalf("claimed %d leased deliveries: %v", len(again), err)
+	}
+
+	var failed *models.WebhookDelivery
+	for _, delivery := range claimed {
+		if delivery.WebhookId == all.Id {
+			failed = delivery
+			continue
+		}
+		if _, err = repository.RecordWebhookAttempt(ctx, db, delivery, repository.WebhookAttempt{Success: true}, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	disabled, err := repository.RecordWebhookAttempt(ctx, db, failed, repository.WebhookAttempt{Error: "connection refused"}, 1)
+	if err != nil || !disabled {
+		t.Fatalf("a failure at the limit disabled the webhook = %v, %v", disabled, err)
+	}
+	webhooks, err := repository.ListWebhooks(ctx, db, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, webhook := range webhooks {
+		if webhook.Id == all.Id && (webhook.Active || webhook.DisabledAt == nil) {
+			t.Fatalf("webhook %s is still active after reaching the failure limit", webhook.Id)
+		}
+	}
+	deliveries, err := repository.ListWebhookDeliveries(ctx, db, comments.Id, alice, 10)
+	if err != nil || len(deliveries) != 2 {
+		t.Fatalf("listed %d deliveries, want 2: %v", len(deliveries), err)
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status != models.DeliverySucceeded {
+			t.Fatalf("delivery %d is %s, want %s", delivery.Id, delivery.Status, models.DeliverySucceeded)
+		}
+	}
+	if _, err = repository.EnqueueWebhookDeliveries(ctx, db, alice, all.Id, "event-4", events.TodoCreated, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, _ = repository.ClaimWebhookDeliveries(ctx, db, 10, time.Minute); len(claimed) != 0 {
+		t.Fatalf("a disabled webhook got %d new deliveries", len(claimed))
+	}
+}
+
+func TestIngestKeys(t *testing.T) {
+	db := pgtest.Open(t)
+	ctx := context.Background()
+	alice := postgresUser(t, db, "alice")
+	if err := repository.SaveIngestKey(ctx, db, alice, "hash-1"); err != nil {
+		t.Fatal(err)
+	}
+	if userId, err := repository.FetchUserIdByIngestKey(ctx, db, "hash-1"); err != nil || userId != alice {
+		t.Fatalf("ingest key belongs to %q, %v", userId, err)
+	}
+	// saving a new key invalidates the old one
+	if err := repository.SaveIngestKey(ctx, db, alice, "hash-2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.FetchUserIdByIngestKey(ctx, db, "hash-1"); !errors.Is(err, repository.ErrUnknownIngestKey) {
+		t.Fatalf("replaced ingest key: %v", err)
+	}
+	if err := repository.SetUserDisabled(ctx, db, alice, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.FetchUserIdByIngestKey(ctx, db, "hash-2"); !errors.Is(err, repository.ErrUnknownIngestKey) {
+		t.Fatalf("ingest key of a disabled user: %v", err)
+	}
+	if err := repository.SetUserDisabled(ctx, db, alice, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.DeleteIngestKey(ctx, db, alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.FetchUserIdByIngestKey(ctx, db, "hash-2"); !errors.Is(err, repository.ErrUnknownIngestKey) {
+		t.Fatalf("deleted ingest key: %v", err)
+	}
+}
+
+func TestSearchLanguageLeavesTodosUnchanged(t *testing.T) {
+	store := &repository.Postgres{DB: pgtest.Open(t)}
+	ctx := context.Background()
+	alice := postgresUser(t, store.DB, "alice")
+	todo, err := store.CreateTodo(ctx, &models.Todo{Name: "running shoes", Labels: []string{"meetings"}}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, token, _, err := repository.GetSyncChanges(ctx, store.DB, alice, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.UpdateSearchLanguage(ctx, alice, "english"); err != nil {
+		t.Fatal(err)
+	}
+	// the vectors were rebuilt, stemmed words now match
+	search := func(text string, fields ...string) []*models.SearchResult {
+		t.Helper()
+		options := repository.SearchOptions{Text: text, Mode: repository.SearchModeFullText, Fields: fields, Page: &pagination.Request{Limit: 10}}
+		results, _, err := store.SearchTodo(ctx, options, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	if results := search("run"); len(results) != 1 {
+		t.Fatalf("search for run after switching to english found %d todos", len(results))
+	}
+	changes, _, _, err := repository.GetSyncChanges(ctx, store.DB, alice, token, 100)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("changing the search language put %d todos in the sync feed: %v", len(changes), err)
+	}
+	fetched, err := store.GetTodoByID(ctx, todo.Id, alice)
+	if err != nil || fetched == nil {
+		t.Fatalf("fetching the todo: %v", err)
+	}
+	if fetched.Version != todo.Version {
+		t.Fatalf("version after changing the search language = %d, want %d", fetched.Version, todo.Version)
+	}
+}
+
+func TestSearchStemsLabels(t *testing.T) {
+	store := &repository.Postgres{DB: pgtest.Open(t)}
+	ctx := context.Background()
+	alice := postgresUser(t, store.DB, "alice")
+	if err := store.UpdateSearchLanguage(ctx, alice, "english"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateTodo(ctx, &models.Todo{Name: "book a room", Labels: []string{"meetings"}}, alice); err != nil {
+		t.Fatal(err)
+	}
+	for _, fields := range [][]string{nil, {models.SearchFieldLabels}} {
+		options := repository.SearchOptions{Text: "meeting", Mode: repository.SearchModeFullText, Fields: fields, Page: &pagination.Request{Limit: 10}}
+		results, _, err := store.SearchTodo(ctx, options, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || !slices.Contains(results[0].MatchedFields, models.SearchFieldLabels) {
+			t.Fatalf("search for meeting in %v found %+v, want the todo labelled meetings", fields, results)
+		}
+	}
+}
+
+func TestSyncFieldClocks(t *testing.T) {
+	store := &repository.Postgres{DB: pgtest.Open(t)}
+	ctx := context.Background()
+	alice := postgresUser(t, store.DB, "alice")
+	todo, err := store.CreateTodo(ctx, &models.Todo{Name: "call the bank"}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := func(modifiedAt time.Time, fields string) *models.SyncPushResult {
+		t.Helper()
+		change := &models.SyncPushChange{Id: todo.Id, ModifiedAt: modifiedAt}
+		if err := json.Unmarshal([]byte(fields), &change.Fields); err != nil {
+			t.Fatal(err)
+		}
+		result, err := repository.ApplySyncChange(ctx, store.DB, change, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	// two devices edit the name offline, the later edit wins whichever arrives first
+	later := time.Now().Add(time.Minute)
+	if result := push(later, `{"name":"call the bank today"}`); result.Status != models.SyncApplied {
+		t.Fatalf("the later edit = %+v", result)
+	}
+	result := push(later.Add(-30*time.Second), `{"name":"email the bank","priority":3}`)
+	if result.Status != models.SyncPartial || !slices.Equal(result.Applied, []string{"priority"}) {
+		t.Fatalf("the earlier edit = %+v, want only priority applied", result)
+	}
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Field != "name" || !result.Conflicts[0].ServerModifiedAt.Equal(later) ||
+		string(result.Conflicts[0].ServerValue) != `"call the bank today"` {
+		t.Fatalf("conflicts = %+v, want name held by the later edit", result.Conflicts)
+	}
+	fetched, err := store.GetTodoByID(ctx, todo.Id, alice)
+	if err != nil || fetched.Name != "call the bank today" || fetched.Priority != models.HighPriority {
+		t.Fatalf("after both edits the todo is %+v, %v", fetched, err)
+	}
+}
+
+func TestSyncTombstones(t *testing.T) {
+	store := &repository.Postgres{DB: pgtest.Open(t)}
+	ctx := context.Background()
+	alice := postgresUser(t, store.DB, "alice")
+	kept, err := store.CreateTodo(ctx, &models.Todo{Name: "kept"}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := store.CreateTodo(ctx, &models.Todo{Name: "gone"}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, token, _, err := repository.GetSyncChanges(ctx, store.DB, alice, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.DeleteTodo(ctx, gone.Id, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	// a full sync has nothing to delete, so it leaves the tombstone out
+	changes, _, _, err := repository.GetSyncChanges(ctx, store.DB, alice, nil, 100)
+	if err != nil || len(changes) != 1 || changes[0].Id != kept.Id || changes[0].Deleted {
+		t.Fatalf("full sync = %+v, %v, want only the kept todo", changes, err)
+	}
+	changes, _, _, err = repository.GetSyncChanges(ctx, store.DB, alice, token, 100)
+	if err != nil || len(changes) != 1 || changes[0].Id != gone.Id || !changes[0].Deleted || changes[0].Todo != nil || changes[0].DeletedAt == nil {
+		t.Fatalf("incremental sync = %+v, %v, want the tombstone", changes, err)
+	}
+}
+
+func TestSyncStaleToken(t *testing.T) {
+	store := &repository.Postgres{DB: pgtest.Open(t)}
+	ctx := context.Background()
+	alice := postgresUser(t, store.DB, "alice")
+	bob := postgresUser(t, store.DB, "bob")
+	for _, user := range []string{alice, bob} {
+		if _, err := store.CreateTodo(ctx, &models.Todo{Name: "kept"}, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, aliceToken, _, err := repository.GetSyncChanges(ctx, store.DB, alice, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, bobToken, _, err := repository.GetSyncChanges(ctx, store.DB, bob, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := store.CreateTodo(ctx, &models.Todo{Name: "gone"}, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.DeleteTodo(ctx, gone.Id, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.PurgeDeletedTodos(ctx, alice, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// alice's token never saw the purged tombstone, bob lost nothing
+	if _, _, _, err = repository.GetSyncChanges(ctx, store.DB, alice, aliceToken, 100); !errors.Is(err, repository.ErrStaleSyncToken) {
+		t.Fatalf("a token from before the purge: %v", err)
+	}
+	if _, _, _, err = repository.GetSyncChanges(ctx, store.DB, bob, bobToken, 100); err != nil {
+		t.Fatalf("another user's token after the purge: %v", err)
+	}
+	_, fresh, _, err := repository.GetSyncChanges(ctx, store.DB, alice, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = repository.GetSyncChanges(ctx, store.DB, alice, fresh, 100); err != nil {
+		t.Fatalf("a token from after the purge: %v", err)
+	}
+	future := &repository.SyncToken{From: fresh.From + 1<<40}
+	if _, _, _, err = repository.GetSyncChanges(ctx, store.DB, alice, future, 100); !errors.Is(err, repository.ErrStaleSyncToken) {
+		t.Fatalf("a token from another database: %v", err)
+	}
+}
+
+func TestSyncResync(t *testing.T) {
+	db := pgtest.Open(t)
+	s := routertest.NewServer(t, repository.NewPostgresStores(db), nil)
+	user, token := s.SignUp(t, "alice")
+	var created models.CreateResponse
+	s.Call(t, http.MethodPost, "/todos/", token, &models.Todo{Name: "kept"}, http.StatusCreated, &created)
+	var first models.SyncResponse
+	s.Call(t, http.MethodGet, "/sync", token, nil, http.StatusOK, &first)
+	if first.Resync || len(first.Changes) != 1 {
+		t.Fatalf("first sync = %+v", first)
+	}
+	var gone models.CreateResponse
+	s.Call(t, http.MethodPost, "/todos/", token, &models.Todo{Name: "gone"}, http.StatusCreated, &gone)
+	s.Call(t, http.MethodDelete, "/todos/"+gone.Id, token, nil, http.StatusNoContent, nil)
+	if _, err := repository.PurgeDeletedTodos(context.Background(), db, user.Id, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, since := range []string{first.Token, "not-a-token"} {
+		var response models.SyncResponse
+		s.Call(t, http.MethodGet, "/sync?since="+url.QueryEscape(since), token, nil, http.StatusOK, &response)
+		if !response.Resync || len(response.Changes) != 1 || response.Changes[0].Id != created.Id || response.Token == "" {
+			t.Fatalf("sync since %q = %+v, want a full resync", since, response)
+		}
+	}
+}
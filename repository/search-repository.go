@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"todos/models"
+	"todos/pagination"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+const (
+	nameHeadlineOptions        = `StartSel=<mark>, StopSel=</mark>, HighlightAll=true`
+	descriptionHeadlineOptions = `StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MinWords=5, MaxWords=20, FragmentDelimiter=" … "`
+)
+
+// htmlEscaped is the sql expression for column with & < > " escaped. Highlights are read as html, so
+// ts_headline gets the escaped text and the <mark> tags it adds are the only markup in its output.
+func htmlEscaped(column string) string {
+	return `replace(replace(replace(replace(` + column + `, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;')`
+}
+
+// PrefixQuery turns free text into a to_tsquery expression whose last word matches as a prefix,
+// so results show up while the user is still typing. Everything but letters and digits is dropped,
+// which keeps tsquery operators in the input from reaching to_tsquery.
+func PrefixQuery(text string) string {
+	terms := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) == 0 {
+		return ""
+	}
+	terms[len(terms)-1] += ":*"
+	return strings.Join(terms, " & ")
+}
+
+const (
+	SearchModeFullText = "fulltext"
+	SearchModeFuzzy    = "fuzzy"
+
+	// pg_trgm's default of 0.6 misses most typos in short names
+	fuzzyThreshold = 0.3
+)
+
+type SearchOptions struct {
+	Text   string
+	Mode   string
+	Prefix bool
+	Fields []string
+	Page   *pagination.Request
+}
+
+func (o SearchOptions) hasField(field string) bool {
+	if len(o.Fields) == 0 {
+		return true
+	}
+	for _, f := range o.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// the letters are the tsvector weights todo_search_vector assigns to each field
+var searchFieldWeights = map[string]string{
+	models.SearchFieldName:        "a",
+	models.SearchFieldDescription: "b",
+	models.SearchFieldLabels:      "c",
+}
+
+func SearchTodo(ctx context.Context, db *sql.DB, options SearchOptions, user_id string) ([]*models.SearchResult, bool, error) {
+	if options.Mode == SearchModeFuzzy {
+		return fuzzySearchTodo(ctx, db, options, user_id)
+	}
+	prefixQuery := ""
+	if options.Prefix {
+		prefixQuery = PrefixQuery(options.Text)
+	}
+	weights := []string{}
+	for _, field := range models.SearchFields {
+		if options.hasField(field) {
+			weights = append(weights, searchFieldWeights[field])
+		}
+	}
+	condition, orderBy, keysetArgs := keyset(options.Page, searchSortKey, 7)
+	// ranking happens in the inner query so the cursor can compare against it
+	query := `with q as (
+			select u.search_language as cfg,
+				websearch_to_tsquery(u.search_language, $2) || to_tsquery(u.search_language, $3) as query
+			from users u where u.id = $1
+		)
+		select * from (
+			select ` + todoColumns + `,
+				ts_rank(ts_filter(search_vector, $4), q.query) as rank,
+				ts_headline(q.cfg, ` + htmlEscaped("name") + `, q.query, '` + nameHeadlineOptions + `') as name_headline,
+				ts_headline(q.cfg, ` + htmlEscaped("description") + `, q.query, '` + descriptionHeadlineOptions + `') as description_headline,
+				ts_filter(search_vector, '{a}') @@ q.query as name_match,
+				ts_filter(search_vector, '{b}') @@ q.query as description_match,
+				ts_filter(search_vector, '{c}') @@ q.query as labels_match
+			from todo, q
+			where user_id = $1 and deleted_at is null and search_vector @@ q.query and ts_filter(search_vector, $4) @@ q.query
+		) results
+		where true` + condition + orderBy + `
+		limit $5 offset $6`
+	args := append([]any{user_id, options.Text, prefixQuery, pq.Array(weights)}, pageArgs(options.Page)...)
+	rows, err := db.QueryContext(ctx, query, append(args, keysetArgs...)...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	results := []*models.SearchResult{}
+	for rows.Next() {
+		result := new(models.SearchResult)
+		var nameMatch, descriptionMatch, labelsMatch bool
+		err = scanTodoInto(rows, &result.GetTodoResponse, &result.Rank, &result.Highlights.Name, &result.Highlights.Description, &nameMatch, &descriptionMatch, &labelsMatch)
+		if err != nil {
+			return nil, false, err
+		}
+		result.MatchedFields = matchedFields(options, nameMatch, descriptionMatch, labelsMatch)
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+	return trimResults(results, options.Page)
+}
+
+// fuzzySearchTodo uses trigram word similarity, so misspelt words still find the todo they were meant for
+func fuzzySearchTodo(ctx context.Context, db *sql.DB, options SearchOptions, user_id string) ([]*models.SearchResult, bool, error) {
+	transaction, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, false, err
+	}
+	defer transaction.Rollback()
+	// the <% operator compares against this setting, which is what lets it use the trigram indexes
+	_, err = transaction.ExecContext(ctx, `select set_config('pg_trgm.word_similarity_threshold', $1, true)`, fmt.Sprint(fuzzyThreshold))
+	if err != nil {
+		return nil, false, err
+	}
+	condition, orderBy, keysetArgs := keyset(options.Page, searchSortKey, 8)
+	query := `select * from (
+			select ` + todoColumns + `,
+				greatest(scores.name_score, scores.description_score, scores.labels_score) as rank,
+				scores.name_score, scores.description_score, scores.labels_score
+			from todo,
+				lateral (select
+					case when $3 then word_similarity($2, name) else 0 end as name_score,
+					case when $4 then word_similarity($2, description) else 0 end as description_score,
+					case when $5 then word_similarity($2, array_to_string(labels, ' ')) else 0 end as labels_score
+				) scores
+			where user_id = $1 and deleted_at is null and (
+				($3 and $2 <% name) or
+				($4 and $2 <% description) or
+				($5 and $2 <% array_to_string(labels, ' '))
+			)
+		) results
+		where true` + condition + orderBy + `
+		limit $6 offset $7`
+	args := []any{user_id, options.Text,
+		options.hasField(models.SearchFieldName), options.hasField(models.SearchFieldDescription), options.hasField(models.SearchFieldLabels)}
+	args = append(args, pageArgs(options.Page)...)
+	rows, err := transaction.QueryContext(ctx, query, append(args, keysetArgs...)...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	results := []*models.SearchResult{}
+	for rows.Next() {
+		result := new(models.SearchResult)
+		var nameScore, descriptionScore, labelsScore float64
+		err = scanTodoInto(rows, &result.GetTodoResponse, &result.Rank, &nameScore, &descriptionScore, &labelsScore)
+		if err != nil {
+			return nil, false, err
+		}
+		result.Highlights.Name = html.EscapeString(result.Name)
+		result.Highlights.Description = html.EscapeString(result.Description)
+		result.MatchedFields = matchedFields(options, nameScore >= fuzzyThreshold, descriptionScore >= fuzzyThreshold, labelsScore >= fuzzyThreshold)
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+	return trimResults(results, options.Page)
+}
+
+var searchSortKey = []string{"rank", "created_at", "id"}
+
+// pageArgs fills the limit and offset placeholders; keyset pages fetch one extra row to detect a next page
+func pageArgs(page *pagination.Request) []any {
+	if page.OffsetMode {
+		return []any{page.Limit, page.Offset}
+	}
+	return []any{page.Limit + 1, 0}
+}
+
+func trimResults(results []*models.SearchResult, page *pagination.Request) ([]*models.SearchResult, bool, error) {
+	if page.OffsetMode {
+		return results, len(results) == page.Limit, nil
+	}
+	results, more := pagination.Trim(results, page)
+	return results, more, nil
+}
+
+func matchedFields(options SearchOptions, name bool, description bool, labels bool) []string {
+	fields := []string{}
+	if name && options.hasField(models.SearchFieldName) {
+		fields = append(fields, models.SearchFieldName)
+	}
+	if description && options.hasField(models.SearchFieldDescription) {
+		fields = append(fields, models.SearchFieldDescription)
+	}
+	if labels && options.hasField(models.SearchFieldLabels) {
+		fields = append(fields, models.SearchFieldLabels)
+	}
+	return fields
+}
+
+func GetUserSettings(ctx context.Context, db *sql.DB, userId string) (*models.UserSettings, error) {
+	query := `select search_language::text from users where id = $1`
+	settings := new(models.UserSettings)
+	err := db.QueryRowContext(ctx, query, userId).Scan(&settings.SearchLanguage)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("there is no user with this id")
+		}
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateSearchLanguage switches the text search configuration used for stemming a user's todos
+// and rebuilds their search vectors so existing todos are searchable under the new language.
+func UpdateSearchLanguage(ctx context.Context, db *sql.DB, userId string, language string) error {
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+	var exists bool
+	err = transaction.QueryRowContext(ctx, `select exists (select 1 from pg_ts_config where cfgname = $1)`, language).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownSearchLanguage
+	}
+	if _, err = transaction.ExecContext(ctx, `update users set search_language = $1::regconfig where id = $2`, language, userId); err != nil {
+		return err
+	}
+	// only search_vector is written, which the change trigger does not count as a change of the todo,
+	// so versions and the sync feed stay as they are
+	query := `update todo set search_vector = todo_search_vector($2::regconfig, name, description, labels) where user_id = $1`
+	if _, err = transaction.ExecContext(ctx, query, userId, language); err != nil {
+		return err
+	}
+	return transaction.Commit()
+}
+
+var ErrUnknownSearchLanguage = errors.New("unknown text search language")
@@ -0,0 +1,734 @@
+// Package sqlite keeps todos, users and tokens in a single SQLite file, for running the server
+// without postgres. Times are stored as unix microseconds and labels as a JSON array.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"todos/models"
+	"todos/pagination"
+	"todos/repository"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+create table if not exists users (
+	id              text primary key,
+	username        text not null unique,
+	email           text not null unique,
+	hashpassword    text not null,
+	search_language text not null default 'simple',
+	created_at      integer not null,
+	disabled_at     integer
+);
+
+create table if not exists todo (
+	id          text primary key,
+	user_id     text not null references users (id) on delete cascade,
+	parent_id   text references todo (id) on delete cascade,
+	name        text not null,
+	description text not null default '',
+	status      integer not null default 0,
+	priority    integer not null default 0,
+	labels      text not null default '[]',
+	recurrence  text not null default '',
+	due_at      integer,
+	created_at  integer not null,
+	version     integer not null default 1,
+	deleted_at  integer
+);
+
+create index if not exists todo_user_created_idx on todo (user_id, created_at desc, id desc);
+create index if not exists todo_parent_idx on todo (parent_id);
+
+create table if not exists refresh (
+	token_hash text primary key,
+	user_id    text not null references users (id) on delete cascade,
+	expires_at integer not null,
+	revoked    integer not null default 0
+);
+
+create index if not exists refresh_user_idx on refresh (user_id);
+
+create table if not exists forgotpassword (
+	id         integer primary key autoincrement,
+	userid     text not null references users (id) on delete cascade,
+	email      text not null,
+	token      text not null unique,
+	expires_at integer not null,
+	used       integer not null default 0,
+	created_at integer not null
+);
+
+create index if not exists forgotpassword_email_idx on forgotpassword (email, created_at desc);
+`
+
+const todoColumns = `id, parent_id, name, description, status, priority, labels, recurrence, due_at, created_at, version`
+
+type Store struct {
+	db *sql.DB
+}
+
+var _ repository.Store = (*Store)(nil)
+
+// pathEscaper keeps characters that mean something in a "file:" URI from cutting the path short
+var pathEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+// Open opens or creates the database at path and brings its schema up to date.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", "file:"+pathEscaper.Replace(path)+"?"+url.Values{"_foreign_keys": {"on"}, "_busy_timeout": {"5000"}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	// sqlite has a single writer anyway, and one connection keeps a ":memory:" database from
+	// turning into one database per connection
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating the sqlite schema in %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Stores opens the database at path and sets it up for the handlers.
+func Stores(path string) (*repository.Stores, error) {
+	store, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return repository.NewStores(store, nil, store.Close), nil
+}
+
+func micros(t time.Time) int64 {
+	return t.UnixMicro()
+}
+
+func microsArg(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UnixMicro()
+}
+
+func timeOf(micros sql.NullInt64) *time.Time {
+	if !micros.Valid {
+		return nil
+	}
+	t := time.UnixMicro(micros.Int64)
+	return &t
+}
+
+func labelsArg(labels []string) string {
+	if labels == nil {
+		labels = []string{}
+	}
+	encoded, _ := json.Marshal(labels)
+	return string(encoded)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTodo(row rowScanner) (*models.GetTodoResponse, error) {
+	todo := new(models.GetTodoResponse)
+	var labels string
+	var dueAt sql.NullInt64
+	var createdAt int64
+	err := row.Scan(&todo.Id, &todo.ParentId, &todo.Name, &todo.Description, &todo.TaskStatus, &todo.Priority, &labels, &todo.Recurrence, &dueAt, &createdAt, &todo.Version)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(labels), &todo.Labels); err != nil {
+		return nil, err
+	}
+	todo.DueAt = timeOf(dueAt)
+	todo.CreatedAt = time.UnixMicro(createdAt)
+	return todo, nil
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func queryTodos(ctx context.Context, q querier, query string, args ...any) ([]*models.GetTodoResponse, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	todos := []*models.GetTodoResponse{}
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+	return todos, rows.Err()
+}
+
+// filterCondition is the status and label part of filter
+func filterCondition(filter repository.TodoFilter) (string, []any) {
+	var label *string
+	if filter.Label != "" {
+		label = &filter.Label
+	}
+	condition := ` and (?1 is null or status = ?1) and (?2 is null or exists (select 1 from json_each(labels) where value = ?2))`
+	return condition, []any{filter.Status, label}
+}
+
+func (s *Store) ListTodos(ctx context.Context, page *pagination.Request, userId string, filter repository.TodoFilter) ([]*models.GetTodoResponse, bool, error) {
+	filtered, args := filterCondition(filter)
+	query := `select ` + todoColumns + ` from todo where user_id = ?3 and deleted_at is null` + filtered
+	args = append(args, userId, page.Limit+1)
+	switch {
+	case page.OffsetMode:
+		query += ` order by created_at desc, id desc limit ?4 offset ?5`
+		args = append(args, page.Offset)
+	case page.Backward():
+		query += ` and (created_at, id) > (?5, ?6) order by created_at, id limit ?4`
+		args = append(args, micros(page.Cursor.CreatedAt), page.Cursor.Id)
+	case page.Cursor != nil:
+		query += ` and (created_at, id) < (?5, ?6) order by created_at desc, id desc limit ?4`
+		args = append(args, micros(page.Cursor.CreatedAt), page.Cursor.Id)
+	default:
+		query += ` order by created_at desc, id desc limit ?4`
+	}
+	todos, err := queryTodos(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	todos, more := pagination.Trim(todos, page)
+	return todos, more, nil
+}
+
+func (s *Store) CountTodos(ctx context.Context, userId string, filter repository.TodoFilter) (int, error) {
+	var count int
+	filtered, args := filterCondition(filter)
+	err := s.db.QueryRowContext(ctx, `select count(*) from todo where user_id = ?3 and deleted_at is null`+filtered, append(args, userId)...).Scan(&count)
+	return count, err
+}
+
+func (s *Store) ListSubtasks(ctx context.Context, parentId string, userId string) ([]*models.GetTodoResponse, error) {
+	query := `select ` + todoColumns + ` from todo where parent_id = ? and user_id = ? and deleted_at is null order by created_at, id`
+	return queryTodos(ctx, s.db, query, strings.ToLower(parentId), userId)
+}
+
+func (s *Store) StreamTodos(ctx context.Context, userId string, filter repository.TodoFilter, fn func(*models.GetTodoResponse) error) error {
+	filtered, args := filterCondition(filter)
+	query := `select ` + todoColumns + ` from todo where user_id = ?3 and deleted_at is null` + filtered + ` order by created_at desc, id desc`
+	args = append(args, userId)
+	if filter.RootId != "" {
+		query = `with recursive subtree (id) as (
+			select id from todo where id = ?4 and user_id = ?3 and deleted_at is null
+			union all
+			select t.id from todo t join subtree s on t.parent_id = s.id where t.deleted_at is null
+		) select ` + todoColumns + ` from todo where id in (select id from subtree)` + filtered + ` order by created_at desc, id desc`
+		args = append(args, strings.ToLower(filter.RootId))
+	}
+	// the rows are read before fn runs, the single connection is not free while they are open
+	todos, err := queryTodos(ctx, s.db, query, args...)
+	if err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getTodo(ctx context.Context, q querier, id string, userId string) (*models.GetTodoResponse, error) {
+	query := `select ` + todoColumns + ` from todo where id = ? and user_id = ? and deleted_at is null`
+	todo, err := scanTodo(q.QueryRowContext(ctx, query, strings.ToLower(id), userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return todo, err
+}
+
+func (s *Store) GetTodoByID(ctx context.Context, id string, userId string) (*models.GetTodoResponse, error) {
+	return getTodo(ctx, s.db, id, userId)
+}
+
+const insertTodo = `insert into todo (id, name, description, status, priority, labels, recurrence, due_at, created_at, parent_id, user_id)
+	values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func (s *Store) CreateTodo(ctx context.Context, todo *models.Todo, userId string) (*models.GetTodoResponse, error) {
+	id := repository.NewId()
+	// like the postgres store, a new todo always starts at the current time
+	_, err := s.db.ExecContext(ctx, insertTodo, id, todo.Name, todo.Description, todo.TaskStatus, todo.Priority, labelsArg(todo.Labels), todo.Recurrence,
+		microsArg(todo.DueAt), micros(repository.Now()), nil, userId)
+	if err != nil {
+		return nil, err
+	}
+	return getTodo(ctx, s.db, id, userId)
+}
+
+func (s *Store) CreateTodos(ctx context.Context, todos []*models.Todo, userId string) (int, error) {
+	transaction, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer transaction.Rollback()
+	count := 0
+	var last time.Time
+	var insert func(todos []*models.Todo, parentId *string) error
+	insert = func(todos []*models.Todo, parentId *string) error {
+		for _, todo := range todos {
+			// later rows get later times so an import keeps its order, as clock_timestamp does in postgres
+			createdAt := repository.Now()
+			if !createdAt.After(last) {
+				createdAt = last.Add(time.Microsecond)
+			}
+			last = createdAt
+			if !todo.CreatedAt.IsZero() {
+				createdAt = todo.CreatedAt
+			}
+			id := repository.NewId()
+			_, err := transaction.ExecContext(ctx, insertTodo, id, todo.Name, todo.Description, todo.TaskStatus, todo.Priority, labelsArg(todo.Labels), todo.Recurrence,
+				microsArg(todo.DueAt), micros(createdAt), parentId, userId)
+			if err != nil {
+				return fmt.Errorf("error inserting todo %d: %w", count+1, err)
+			}
+			count++
+			if err = insert(todo.Subtasks, &id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err = insert(todos, nil); err != nil {
+		return 0, err
+	}
+	if err = transaction.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// versionConflict tells apart the two reasons a guarded write is refused
+func versionConflict(ctx context.Context, q querier, id string, userId string) error {
+	todo, err := getTodo(ctx, q, id, userId)
+	if err != nil {
+		return err
+	}
+	if todo != nil {
+		return repository.ErrVersionMismatch
+	}
+	return repository.ErrTodoNotFound
+}
+
+func versionMatches(version int, versions []int) bool {
+	return versions == nil || slices.Contains(versions, version)
+}
+
+func (s *Store) PutTodo(ctx context.Context, id string, todo *models.Todo, userId string, condition repository.PutCondition, versions []int) (*models.GetTodoResponse, bool, error) {
+	id = strings.ToLower(id)
+	transaction, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer transaction.Rollback()
+	var owner string
+	var version int
+	var deletedAt sql.NullInt64
+	err = transaction.QueryRowContext(ctx, `select user_id, version, deleted_at from todo where id = ?`, id).Scan(&owner, &version, &deletedAt)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+	// the user's own deleted todo counts as absent, it is purged with its subtasks and the id starts over
+	if exists && deletedAt.Valid && owner == userId && condition != repository.PutReplaceOnly {
+		if _, err = transaction.ExecContext(ctx, `delete from todo where id = ?`, id); err != nil {
+			return nil, false, err
+		}
+		exists = false
+	}
+	replace := `update todo set name = ?, description = ?, status = ?, priority = ?, labels = ?, recurrence = ?, due_at = ?,
+		version = version + 1 where id = ?`
+	args := []any{todo.Name, todo.Description, todo.TaskStatus, todo.Priority, labelsArg(todo.Labels), todo.Recurrence, microsArg(todo.DueAt), id}
+	created := false
+	switch {
+	case condition == repository.PutReplaceOnly:
+		if !exists || owner != userId || deletedAt.Valid || !versionMatches(version, versions) {
+			return nil, false, versionConflict(ctx, transaction, id, userId)
+		}
+		_, err = transaction.ExecContext(ctx, replace, args...)
+	case !exists:
+		createdAt := repository.Now()
+		if !todo.CreatedAt.IsZero() {
+			createdAt = todo.CreatedAt
+		}
+		_, err = transaction.ExecContext(ctx, insertTodo, id, todo.Name, todo.Description, todo.TaskStatus, todo.Priority, labelsArg(todo.Labels), todo.Recurrence,
+			microsArg(todo.DueAt), micros(createdAt), nil, userId)
+		created = true
+	case condition == repository.PutAny && owner == userId:
+		_, err = transaction.ExecContext(ctx, replace, args...)
+	default:
+		err = versionConflict(ctx, transaction, id, userId)
+		// the id exists but belongs to somebody else
+		if errors.Is(err, repository.ErrTodoNotFound) {
+			err = repository.ErrTodoIdTaken
+		}
+		return nil, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	result, err := getTodo(ctx, transaction, id, userId)
+	if err != nil {
+		return nil, false, err
+	}
+	return result, created, transaction.Commit()
+}
+
+func (s *Store) UpdateTodo(ctx context.Context, doc *models.TodoDocument, id string, userId string, versions []int) (*models.GetTodoResponse, error) {
+	id = strings.ToLower(id)
+	transaction, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+	var parentId *string
+	if doc.ParentId != nil {
+		parent := strings.ToLower(*doc.ParentId)
+		if err = checkParent(ctx, transaction, parent, id, userId); err != nil {
+			return nil, err
+		}
+		parentId = &parent
+	}
+	current, err := getTodo(ctx, transaction, id, userId)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || !versionMatches(current.Version, versions) {
+		return nil, versionConflict(ctx, transaction, id, userId)
+	}
+	query := `update todo set name = ?, description = ?, status = ?, priority = ?, labels = ?, recurrence = ?, due_at = ?, parent_id = ?, version = version + 1
+		where id = ?`
+	_, err = transaction.ExecContext(ctx, query, doc.Name, doc.Description, doc.TaskStatus, doc.Priority, labelsArg(doc.Labels), doc.Recurrence, microsArg(doc.DueAt),
+		parentId, id)
+	if err != nil {
+		return nil, err
+	}
+	todo, err := getTodo(ctx, transaction, id, userId)
+	if err != nil {
+		return nil, err
+	}
+	return todo, transaction.Commit()
+}
+
+// checkParent makes sure the parent exists and is neither the todo itself nor one of its subtasks
+func checkParent(ctx context.Context, transaction *sql.Tx, parentId string, id string, userId string) error {
+	query := `with recursive ancestors (id, parent_id) as (
+			select id, parent_id from todo where id = ?1 and user_id = ?3 and deleted_at is null
+			union all
+			select t.id, t.parent_id from todo t join ancestors a on t.id = a.parent_id
+		)
+		select exists (select 1 from todo where id = ?1 and user_id = ?3 and deleted_at is null), exists (select 1 from ancestors where id = ?2)`
+	var parentExists, cycle bool
+	if err := transaction.QueryRowContext(ctx, query, parentId, id, userId).Scan(&parentExists, &cycle); err != nil {
+		return err
+	}
+	if !parentExists || cycle {
+		return repository.ErrInvalidParent
+	}
+	return nil
+}
+
+func (s *Store) DeleteTodo(ctx context.Context, id string, userId string, versions []int) ([]string, error) {
+	id = strings.ToLower(id)
+	transaction, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+	current, err := getTodo(ctx, transaction, id, userId)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || !versionMatches(current.Version, versions) {
+		return nil, versionConflict(ctx, transaction, id, userId)
+	}
+	query := `with recursive subtree (id) as (
+			select ?1
+			union all
+			select t.id from todo t join subtree s on t.parent_id = s.id where t.deleted_at is null
+		)
+		update todo set deleted_at = ?2, version = version + 1 where id in (select id from subtree) returning id`
+	rows, err := transaction.QueryContext(ctx, query, id, micros(repository.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deleted := []string{}
+	for rows.Next() {
+		var deletedId string
+		if err = rows.Scan(&deletedId); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, deletedId)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	return deleted, transaction.Commit()
+}
+
+func (s *Store) SearchTodo(ctx context.Context, options repository.SearchOptions, userId string) ([]*models.SearchResult, bool, error) {
+	todos, err := queryTodos(ctx, s.db, `select `+todoColumns+` from todo where user_id = ? and deleted_at is null`, userId)
+	if err != nil {
+		return nil, false, err
+	}
+	results := []*models.SearchResult{}
+	for _, todo := range todos {
+		if result := repository.MatchTodo(todo, options); result != nil {
+			results = append(results, result)
+		}
+	}
+	slices.SortFunc(results, repository.RankResults)
+	results, more := repository.PageSearchResults(results, options.Page)
+	return results, more, nil
+}
+
+// PurgeDeletedTodos removes todos deleted before cutoff; the foreign key takes their subtasks along.
+func (s *Store) PurgeDeletedTodos(ctx context.Context, userId string, cutoff time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `delete from todo where deleted_at < ?1 and (?2 is null or user_id = ?2)`, micros(cutoff), userIdArg(userId))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *Store) CountDeletedTodos(ctx context.Context, userId string, cutoff time.Time) (int64, error) {
+	var count int64
+	query := `select count(*) from todo where deleted_at < ?1 and (?2 is null or user_id = ?2)`
+	err := s.db.QueryRowContext(ctx, query, micros(cutoff), userIdArg(userId)).Scan(&count)
+	return count, err
+}
+
+func userIdArg(userId string) any {
+	if userId == "" {
+		return nil
+	}
+	return strings.ToLower(userId)
+}
+
+func (s *Store) CreateUser(ctx context.Context, user *models.User) error {
+	query := `insert into users (id, username, email, hashpassword, created_at) values (?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, repository.NewId(), user.UserName, user.Email, user.HashedPassword, micros(repository.Now()))
+	return err
+}
+
+func (s *Store) fetchUser(ctx context.Context, column string, value string) (*models.User, error) {
+	query := `select id, username, email, hashpassword, created_at, disabled_at from users where ` + column + ` = ?`
+	user := new(models.User)
+	var createdAt int64
+	var disabledAt sql.NullInt64
+	err := s.db.QueryRowContext(ctx, query, value).Scan(&user.Id, &user.UserName, &user.Email, &user.HashedPassword, &createdAt, &disabledAt)
+	if err != nil {
+		return nil, err
+	}
+	user.CreatedAt = time.UnixMicro(createdAt)
+	user.DisabledAt = timeOf(disabledAt)
+	return user, nil
+}
+
+func (s *Store) FetchUserWithUserID(ctx context.Context, userName string) (*models.User, error) {
+	user, err := s.fetchUser(ctx, "username", userName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("there is no user with this user-name")
+	}
+	return user, err
+}
+
+func (s *Store) FetchUserByID(ctx context.Context, id string) (*models.User, error) {
+	user, err := s.fetchUser(ctx, "id", strings.ToLower(id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("there is no user with this id")
+	}
+	return user, err
+}
+
+func (s *Store) IsEmailExists(ctx context.Context, forgot *models.ForgotPasswordRequest) (string, error) {
+	userId := ""
+	err := s.db.QueryRowContext(ctx, `select id from users where email = ?`, forgot.Email).Scan(&userId)
+	return userId, err
+}
+
+func (s *Store) GetUserSettings(ctx context.Context, userId string) (*models.UserSettings, error) {
+	settings := new(models.UserSettings)
+	err := s.db.QueryRowContext(ctx, `select search_language from users where id = ?`, strings.ToLower(userId)).Scan(&settings.SearchLanguage)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("there is no user with this id")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateSearchLanguage only records the language; searches here do not stem.
+func (s *Store) UpdateSearchLanguage(ctx context.Context, userId string, language string) error {
+	if !slices.Contains(repository.SearchLanguages, language) {
+		return repository.ErrUnknownSearchLanguage
+	}
+	_, err := s.db.ExecContext(ctx, `update users set search_language = ? where id = ?`, language, strings.ToLower(userId))
+	return err
+}
+
+const revokeRefreshTokensQuery = `update refresh set revoked = 1 where user_id = ? and not revoked and expires_at > ?`
+
+// updateUser runs query on one user and revokes the user's refresh tokens when revoke is set
+func (s *Store) updateUser(ctx context.Context, userId string, revoke bool, query string, args ...any) error {
+	transaction, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+	result, err := transaction.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowCount == 0 {
+		return repository.ErrUserNotFound
+	}
+	if revoke {
+		if _, err = transaction.ExecContext(ctx, revokeRefreshTokensQuery, userId, micros(time.Now())); err != nil {
+			return err
+		}
+	}
+	return transaction.Commit()
+}
+
+func (s *Store) SetUserDisabled(ctx context.Context, userId string, disabled bool) error {
+	userId = strings.ToLower(userId)
+	query := `update users set disabled_at = case when ?2 then coalesce(disabled_at, ?3) end where id = ?1`
+	return s.updateUser(ctx, userId, disabled, query, userId, disabled, micros(repository.Now()))
+}
+
+func (s *Store) SetUserPassword(ctx context.Context, userId string, hashPassword string) error {
+	userId = strings.ToLower(userId)
+	return s.updateUser(ctx, userId, true, `update users set hashpassword = ? where id = ?`, hashPassword, userId)
+}
+
+func (s *Store) SaveRefreshToken(ctx context.Context, saveRefresh *models.SaveRefresh) error {
+	query := `insert into refresh (user_id, token_hash, expires_at) values (?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, strings.ToLower(saveRefresh.UserId), saveRefresh.TokenHash, micros(saveRefresh.ExpiresAt))
+	return err
+}
+
+func (s *Store) FetchRefreshToken(ctx context.Context, hashedRefresh string) (*models.SaveRefresh, error) {
+	refresh := new(models.SaveRefresh)
+	var expiresAt int64
+	err := s.db.QueryRowContext(ctx, `select user_id, token_hash, expires_at, revoked from refresh where token_hash = ?`, hashedRefresh).
+		Scan(&refresh.UserId, &refresh.TokenHash, &expiresAt, &refresh.Revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("no token found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	refresh.ExpiresAt = time.UnixMicro(expiresAt)
+	return refresh, nil
+}
+
+func (s *Store) InvalidateRefreshToken(ctx context.Context, hashedRefresh string) error {
+	_, err := s.db.ExecContext(ctx, `update refresh set revoked = 1 where token_hash = ?`, hashedRefresh)
+	return err
+}
+
+func (s *Store) RevokeRefreshTokens(ctx context.Context, userId string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, revokeRefreshTokensQuery, strings.ToLower(userId), micros(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func internalError(err error) *models.ErrorResponse {
+	return &models.ErrorResponse{Message: err.Error(), Status: http.StatusInternalServerError}
+}
+
+func (s *Store) StoreForgotPasswordToken(ctx context.Context, request map[string]string) *models.ErrorResponse {
+	email, ok := request["email"]
+	if !ok {
+		return &models.ErrorResponse{Message: "no email received from request", Status: http.StatusBadRequest}
+	}
+	var expiresAt int64
+	var used bool
+	err := s.db.QueryRowContext(ctx, `select expires_at, used from forgotpassword where email = ? order by created_at desc, id desc`, email).Scan(&expiresAt, &used)
+	switch {
+	case err == nil:
+		if time.UnixMicro(expiresAt).After(time.Now()) && !used {
+			return &models.ErrorResponse{Message: "an active link has already been sent to your mail, for new token wait for sometime", Status: http.StatusBadRequest}
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return internalError(err)
+	}
+	now := time.Now()
+	query := `insert into forgotpassword (userid, email, token, expires_at, created_at) values (?, ?, ?, ?, ?)`
+	_, err = s.db.ExecContext(ctx, query, strings.ToLower(request["userid"]), email, request["token"], micros(now.Add(15*time.Minute)), micros(now))
+	if err != nil {
+		return internalError(err)
+	}
+	return nil
+}
+
+func (s *Store) UpdatePassword(ctx context.Context, request *models.UpdatePasswordRequest, token string) *models.ErrorResponse {
+	transaction, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return internalError(err)
+	}
+	defer transaction.Rollback()
+	var userId string
+	var expiresAt int64
+	var used bool
+	err = transaction.QueryRowContext(ctx, `select userid, expires_at, used from forgotpassword where token = ?`, token).Scan(&userId, &expiresAt, &used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &models.ErrorResponse{Message: "no rows with this token", Status: http.StatusNotFound}
+	}
+	if err != nil {
+		return &models.ErrorResponse{Message: fmt.Sprintf("something went wrong while processing the token : %s", err.Error()), Status: http.StatusInternalServerError}
+	}
+	if time.UnixMicro(expiresAt).Before(time.Now()) || used {
+		return &models.ErrorResponse{Message: "provided token has either already been used or is expired", Status: http.StatusInternalServerError}
+	}
+	result, err := transaction.ExecContext(ctx, `update users set hashpassword = ? where id = ?`, request.NewPassword, userId)
+	if err != nil {
+		return &models.ErrorResponse{Message: "something went wrong while updating the password", Status: http.StatusInternalServerError}
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return &models.ErrorResponse{Message: "no user affected", Status: http.StatusInternalServerError}
+	}
+	if _, err = transaction.ExecContext(ctx, `update forgotpassword set used = 1 where token = ?`, token); err != nil {
+		return &models.ErrorResponse{Message: "something went wrong while updating the password", Status: http.StatusInternalServerError}
+	}
+	if err = transaction.Commit(); err != nil {
+		return &models.ErrorResponse{Message: "update password has failed, rolling back", Status: http.StatusInternalServerError}
+	}
+	return nil
+}
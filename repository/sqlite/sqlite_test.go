@@ -0,0 +1,41 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"todos/models"
+	"todos/repository"
+	"todos/repository/storetest"
+)
+
+func open(t *testing.T, path string) *Store {
+	t.Helper()
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open(%s): %v", path, err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store { return open(t, filepath.Join(t.TempDir(), "todos.db")) })
+}
+
+func TestInMemory(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store { return open(t, ":memory:") })
+}
+
+// TestPathEscaping opens two databases whose paths only differ after a character that means
+// something in a "file:" URI; unescaped, both would open the same file.
+func TestPathEscaping(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a#1.db", "a#2.db", "b?1.db", "b?2.db", "c%3f.db"} {
+		store := open(t, filepath.Join(dir, name))
+		user := &models.User{UserName: "alice", Email: "alice@example.com", HashedPassword: "hash"}
+		if err := store.CreateUser(context.Background(), user); err != nil {
+			t.Fatalf("CreateUser in %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"todos/models"
+	"todos/pagination"
+)
+
+// TodoStore keeps a user's todos. Deleted todos stay behind, marked deleted, until they are purged.
+type TodoStore interface {
+	// ListTodos returns a page of todos, newest first, and whether there are more
+	ListTodos(ctx context.Context, page *pagination.Request, userId string, filter TodoFilter) ([]*models.GetTodoResponse, bool, error)
+	CountTodos(ctx context.Context, userId string, filter TodoFilter) (int, error)
+	ListSubtasks(ctx context.Context, parentId string, userId string) ([]*models.GetTodoResponse, error)
+	StreamTodos(ctx context.Context, userId string, filter TodoFilter, fn func(*models.GetTodoResponse) error) error
+	// GetTodoByID returns nil without an error when there is no such todo
+	GetTodoByID(ctx context.Context, id string, userId string) (*models.GetTodoResponse, error)
+	CreateTodo(ctx context.Context, todo *models.Todo, userId string) (*models.GetTodoResponse, error)
+	// CreateTodos creates todos with their subtasks, all or none, and returns how many it created
+	CreateTodos(ctx context.Context, todos []*models.Todo, userId string) (int, error)
+	PutTodo(ctx context.Context, id string, todo *models.Todo, userId string, condition PutCondition, versions []int) (*models.GetTodoResponse, bool, error)
+	UpdateTodo(ctx context.Context, doc *models.TodoDocument, id string, userId string, versions []int) (*models.GetTodoResponse, error)
+	// DeleteTodo deletes the todo with its subtasks and returns the ids of all of them
+	DeleteTodo(ctx context.Context, id string, userId string, versions []int) ([]string, error)
+	SearchTodo(ctx context.Context, options SearchOptions, userId string) ([]*models.SearchResult, bool, error)
+	PurgeDeletedTodos(ctx context.Context, userId string, cutoff time.Time) (int64, error)
+	CountDeletedTodos(ctx context.Context, userId string, cutoff time.Time) (int64, error)
+}
+
+type UserStore interface {
+	CreateUser(ctx context.Context, user *models.User) error
+	FetchUserWithUserID(ctx context.Context, userName string) (*models.User, error)
+	FetchUserByID(ctx context.Context, id string) (*models.User, error)
+	IsEmailExists(ctx context.Context, forgot *models.ForgotPasswordRequest) (string, error)
+	GetUserSettings(ctx context.Context, userId string) (*models.UserSettings, error)
+	UpdateSearchLanguage(ctx context.Context, userId string, language string) error
+	SetUserDisabled(ctx context.Context, userId string, disabled bool) error
+	SetUserPassword(ctx context.Context, userId string, hashPassword string) error
+}
+
+// TokenStore keeps the hashes of refresh tokens and password reset tokens.
+type TokenStore interface {
+	SaveRefreshToken(ctx context.Context, saveRefresh *models.SaveRefresh) error
+	FetchRefreshToken(ctx context.Context, hashedRefresh string) (*models.SaveRefresh, error)
+	InvalidateRefreshToken(ctx context.Context, hashedRefresh string) error
+	RevokeRefreshTokens(ctx context.Context, userId string) (int64, error)
+	StoreForgotPasswordToken(ctx context.Context, request map[string]string) *models.ErrorResponse
+	UpdatePassword(ctx context.Context, request *models.UpdatePasswordRequest, token string) *models.ErrorResponse
+}
+
+// Store is a backend that keeps everything the core api needs.
+type Store interface {
+	TodoStore
+	UserStore
+	TokenStore
+}
+
+// Stores is what the handlers are built on. Sync, webhooks, idempotency keys and ingest only exist
+// in postgres; DB is nil with any other backend and those features answer 501.
+type Stores struct {
+	Todos  TodoStore
+	Users  UserStore
+	Tokens TokenStore
+	DB     *sql.DB
+	Close  func() error
+}
+
+func NewStores(store Store, db *sql.DB, close func() error) *Stores {
+	return &Stores{Todos: store, Users: store, Tokens: store, DB: db, Close: close}
+}
+
+// Postgres is the store behind the functions of this package.
+type Postgres struct {
+	DB *sql.DB
+}
+
+func NewPostgresStores(db *sql.DB) *Stores {
+	return NewStores(&Postgres{DB: db}, db, db.Close)
+}
+
+func (p *Postgres) ListTodos(ctx context.Context, page *pagination.Request, userId string, filter TodoFilter) ([]*models.GetTodoResponse, bool, error) {
+	if !page.OffsetMode {
+		return ListTodos(ctx, p.DB, page, userId, filter)
+	}
+	todos, err := GetAllTodos(ctx, p.DB, page.Offset, page.Limit+1, userId, filter)
+	if err != nil {
+		return nil, false, err
+	}
+	todos, more := pagination.Trim(todos, page)
+	return todos, more, nil
+}
+
+func (p *Postgres) CountTodos(ctx context.Context, userId string, filter TodoFilter) (int, error) {
+	return CountTodos(ctx, p.DB, userId, filter)
+}
+
+func (p *Postgres) ListSubtasks(ctx context.Context, parentId string, userId string) ([]*models.GetTodoResponse, error) {
+	return ListSubtasks(ctx, p.DB, parentId, userId)
+}
+
+func (p *Postgres) StreamTodos(ctx context.Context, userId string, filter TodoFilter, fn func(*models.GetTodoResponse) error) error {
+	return StreamTodos(ctx, p.DB, userId, filter, fn)
+}
+
+func (p *Postgres) GetTodoByID(ctx context.Context, id string, userId string) (*models.GetTodoResponse, error) {
+	return GetTodoByID(ctx, p.DB, id, userId)
+}
+
+func (p *Postgres) CreateTodo(ctx context.Context, todo *models.Todo, userId string) (*models.GetTodoResponse, error) {
+	return CreateTodo(ctx, p.DB, todo, userId)
+}
+
+func (p *Postgres) CreateTodos(ctx context.Context, todos []*models.Todo, userId string) (int, error) {
+	return CreateTodos(ctx, p.DB, todos, userId)
+}
+
+func (p *Postgres) PutTodo(ctx context.Context, id string, todo *models.Todo, userId string, condition PutCondition, versions []int) (*models.GetTodoResponse, bool, error) {
+	return PutTodo(ctx, p.DB, id, todo, userId, condition, versions)
+}
+
+func (p *Postgres) UpdateTodo(ctx context.Context, doc *models.TodoDocument, id string, userId string, versions []int) (*models.GetTodoResponse, error) {
+	return UpdateTodo(ctx, p.DB, doc, id, userId, versions)
+}
+
+func (p *Postgres) DeleteTodo(ctx context.Context, id string, userId string, versions []int) ([]string, error) {
+	return DeleteTodo(ctx, p.DB, id, userId, versions)
+}
+
+func (p *Postgres) SearchTodo(ctx context.Context, options SearchOptions, userId string) ([]*models.SearchResult, bool, error) {
+	return SearchTodo(ctx, p.DB, options, userId)
+}
+
+func (p *Postgres) PurgeDeletedTodos(ctx context.Context, userId string, cutoff time.Time) (int64, error) {
+	return PurgeDeletedTodos(ctx, p.DB, userId, cutoff)
+}
+
+func (p *Postgres) CountDeletedTodos(ctx context.Context, userId string, cutoff time.Time) (int64, error) {
+	return CountDeletedTodos(ctx, p.DB, userId, cutoff)
+}
+
+func (p *Postgres) CreateUser(ctx context.Context, user *models.User) error {
+	return CreateUser(ctx, p.DB, user)
+}
+
+func (p *Postgres) FetchUserWithUserID(ctx context.Context, userName string) (*models.User, error) {
+	return FetchUserWithUserID(ctx, p.DB, userName)
+}
+
+func (p *Postgres) FetchUserByID(ctx context.Context, id string) (*models.User, error) {
+	return FetchUserByID(ctx, p.DB, id)
+}
+
+func (p *Postgres) IsEmailExists(ctx context.Context, forgot *models.ForgotPasswordRequest) (string, error) {
+	return IsEmailExists(ctx, p.DB, forgot)
+}
+
+func (p *Postgres) GetUserSettings(ctx context.Context, userId string) (*models.UserSettings, error) {
+	return GetUserSettings(ctx, p.DB, userId)
+}
+
+func (p *Postgres) UpdateSearchLanguage(ctx context.Context, userId string, language string) error {
+	return UpdateSearchLanguage(ctx, p.DB, userId, language)
+}
+
+func (p *Postgres) SetUserDisabled(ctx context.Context, userId string, disabled bool) error {
+	return SetUserDisabled(ctx, p.DB, userId, disabled)
+}
+
+func (p *Postgres) SetUserPassword(ctx context.Context, userId string, hashPassword string) error {
+	return SetUserPassword(ctx, p.DB, userId, hashPassword)
+}
+
+func (p *Postgres) SaveRefreshToken(ctx context.Context, saveRefresh *models.SaveRefresh) error {
+	return SaveRefreshToken(ctx, p.DB, saveRefresh)
+}
+
+func (p *Postgres) FetchRefreshToken(ctx context.Context, hashedRefresh string) (*models.SaveRefresh, error) {
+	return FetchRefreshToken(ctx, p.DB, hashedRefresh)
+}
+
+func (p *Postgres) InvalidateRefreshToken(ctx context.Context, hashedRefresh string) error {
+	return InvalidateRefreshToken(ctx, p.DB, hashedRefresh)
+}
+
+func (p *Postgres) RevokeRefreshTokens(ctx context.Context, userId string) (int64, error) {
+	return RevokeRefreshTokens(ctx, p.DB, userId)
+}
+
+func (p *Postgres) StoreForgotPasswordToken(ctx context.Context, request map[string]string) *models.ErrorResponse {
+	return StoreForgotPasswordToken(ctx, p.DB, request)
+}
+
+func (p *Postgres) UpdatePassword(ctx context.Context, request *models.UpdatePasswordRequest, token string) *models.ErrorResponse {
+	return UpdatePassword(ctx, p.DB, request, token)
+}
@@ -0,0 +1,12 @@
+package repository_test
+
+import (
+	"testing"
+	"todos/repository"
+	"todos/repository/pgtest"
+	"todos/repository/storetest"
+)
+
+func TestPostgresStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.Store { return &repository.Postgres{DB: pgtest.Open(t)} })
+}
@@ -0,0 +1,699 @@
+// Package storetest checks that a repository.Store behaves the way the handlers expect, which is the
+// way the postgres store behaves. A backend's tests call Run with a function that opens an empty store:
+//
+//	func TestStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) repository.Store { return memory.New() })
+//	}
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"todos/models"
+	"todos/pagination"
+	"todos/repository"
+)
+
+// Run runs every conformance check, each against a store of its own.
+func Run(t *testing.T, open func(t *testing.T) repository.Store) {
+	checks := []struct {
+		name  string
+		check func(t *testing.T, store repository.Store)
+	}{
+		{"Users", testUsers},
+		{"DisableUser", testDisableUser},
+		{"RefreshTokens", testRefreshTokens},
+		{"ForgotPassword", testForgotPassword},
+		{"SearchLanguage", testSearchLanguage},
+		{"CreateAndGet", testCreateAndGet},
+		{"ListKeyset", testListKeyset},
+		{"ListOffset", testListOffset},
+		{"Filter", testFilter},
+		{"PutTodo", testPutTodo},
+		{"UpdateTodo", testUpdateTodo},
+		{"DeleteTodo", testDeleteTodo},
+		{"Subtasks", testSubtasks},
+		{"Search", testSearch},
+		{"SearchHighlights", testSearchHighlights},
+		{"Purge", testPurge},
+		{"Isolation", testIsolation},
+	}
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			c.check(t, open(t))
+		})
+	}
+}
+
+// newUser creates a user named name and returns it
+func newUser(t *testing.T, store repository.Store, name string) *models.User {
+	t.Helper()
+	ctx := context.Background()
+	err := store.CreateUser(ctx, &models.User{UserName: name, Email: name + "@example.com", HashedPassword: "hash-" + name})
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %v", name, err)
+	}
+	user, err := store.FetchUserWithUserID(ctx, name)
+	if err != nil {
+		t.Fatalf("FetchUserWithUserID(%s): %v", name, err)
+	}
+	return user
+}
+
+func createTodo(t *testing.T, store repository.Store, userId string, todo *models.Todo) *models.GetTodoResponse {
+	t.Helper()
+	created, err := store.CreateTodo(context.Background(), todo, userId)
+	if err != nil {
+		t.Fatalf("CreateTodo(%s): %v", todo.Name, err)
+	}
+	return created
+}
+
+// createNumbered imports count todos named "todo 1" to "todo <count>", oldest first
+func createNumbered(t *testing.T, store repository.Store, userId string, count int) {
+	t.Helper()
+	todos := make([]*models.Todo, count)
+	for i := range todos {
+		todos[i] = &models.Todo{Name: fmt.Sprintf("todo %d", i+1)}
+	}
+	if created, err := store.CreateTodos(context.Background(), todos, userId); err != nil || created != count {
+		t.Fatalf("CreateTodos: created %d of %d, %v", created, count, err)
+	}
+}
+
+func names(todos []*models.GetTodoResponse) []string {
+	names := make([]string, len(todos))
+	for i, todo := range todos {
+		names[i] = todo.Name
+	}
+	return names
+}
+
+func cursorOf(todo *models.GetTodoResponse, backward bool) *pagination.Cursor {
+	return &pagination.Cursor{CreatedAt: todo.CreatedAt, Id: todo.Id, Backward: backward}
+}
+
+func testUsers(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	if user.Id == "" || user.Email != "alice@example.com" || user.HashedPassword != "hash-alice" || user.CreatedAt.IsZero() || user.DisabledAt != nil {
+		t.Errorf("FetchUserWithUserID returned %+v", user)
+	}
+	byId, err := store.FetchUserByID(ctx, user.Id)
+	if err != nil || byId.UserName != "alice" {
+		t.Errorf("FetchUserByID = %+v, %v", byId, err)
+	}
+	if _, err := store.FetchUserWithUserID(ctx, "nobody"); err == nil {
+		t.Error("FetchUserWithUserID found a user that does not exist")
+	}
+	if _, err := store.FetchUserByID(ctx, repository.NewId()); err == nil {
+		t.Error("FetchUserByID found a user that does not exist")
+	}
+	if err := store.CreateUser(ctx, &models.User{UserName: "alice", Email: "other@example.com", HashedPassword: "x"}); err == nil {
+		t.Error("CreateUser accepted a username that is taken")
+	}
+	if err := store.CreateUser(ctx, &models.User{UserName: "alicia", Email: "alice@example.com", HashedPassword: "x"}); err == nil {
+		t.Error("CreateUser accepted an email that is taken")
+	}
+	userId, err := store.IsEmailExists(ctx, &models.ForgotPasswordRequest{Email: "alice@example.com"})
+	if err != nil || userId != user.Id {
+		t.Errorf("IsEmailExists = %q, %v; want %q", userId, err, user.Id)
+	}
+	if _, err := store.IsEmailExists(ctx, &models.ForgotPasswordRequest{Email: "nobody@example.com"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("IsEmailExists of an unknown email returned %v, want sql.ErrNoRows", err)
+	}
+	if err := store.SetUserPassword(ctx, user.Id, "new-hash"); err != nil {
+		t.Fatalf("SetUserPassword: %v", err)
+	}
+	if user, _ = store.FetchUserByID(ctx, user.Id); user.HashedPassword != "new-hash" {
+		t.Errorf("password hash is %q after SetUserPassword", user.HashedPassword)
+	}
+	if err := store.SetUserPassword(ctx, repository.NewId(), "x"); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("SetUserPassword of an unknown user returned %v", err)
+	}
+}
+
+func testDisableUser(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	saved := &models.SaveRefresh{UserId: user.Id, TokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.SaveRefreshToken(ctx, saved); err != nil {
+		t.Fatalf("SaveRefreshToken: %v", err)
+	}
+	if err := store.SetUserDisabled(ctx, user.Id, true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	disabled, _ := store.FetchUserByID(ctx, user.Id)
+	if disabled.DisabledAt == nil {
+		t.Error("DisabledAt is nil after disabling")
+	}
+	if refresh, _ := store.FetchRefreshToken(ctx, "hash"); refresh == nil || !refresh.Revoked {
+		t.Error("disabling a user did not revoke their refresh token")
+	}
+	if err := store.SetUserDisabled(ctx, user.Id, false); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	if enabled, _ := store.FetchUserByID(ctx, user.Id); enabled.DisabledAt != nil {
+		t.Error("DisabledAt is set after enabling")
+	}
+	if err := store.SetUserDisabled(ctx, repository.NewId(), true); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("SetUserDisabled of an unknown user returned %v", err)
+	}
+}
+
+func testRefreshTokens(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	for _, hash := range []string{"one", "two"} {
+		if err := store.SaveRefreshToken(ctx, &models.SaveRefresh{UserId: user.Id, TokenHash: hash, ExpiresAt: expiresAt}); err != nil {
+			t.Fatalf("SaveRefreshToken: %v", err)
+		}
+	}
+	if err := store.SaveRefreshToken(ctx, &models.SaveRefresh{UserId: user.Id, TokenHash: "one", ExpiresAt: expiresAt}); err == nil {
+		t.Error("SaveRefreshToken stored the same hash twice")
+	}
+	refresh, err := store.FetchRefreshToken(ctx, "one")
+	if err != nil || refresh.UserId != user.Id || refresh.Revoked || !refresh.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("FetchRefreshToken = %+v, %v", refresh, err)
+	}
+	if _, err := store.FetchRefreshToken(ctx, "unknown"); err == nil {
+		t.Error("FetchRefreshToken found a token that was never saved")
+	}
+	if err := store.InvalidateRefreshToken(ctx, "one"); err != nil {
+		t.Fatalf("InvalidateRefreshToken: %v", err)
+	}
+	if refresh, _ = store.FetchRefreshToken(ctx, "one"); !refresh.Revoked {
+		t.Error("token is not revoked after InvalidateRefreshToken")
+	}
+	if count, err := store.RevokeRefreshTokens(ctx, user.Id); err != nil || count != 1 {
+		t.Errorf("RevokeRefreshTokens = %d, %v; want the one token still active", count, err)
+	}
+	if refresh, _ = store.FetchRefreshToken(ctx, "two"); !refresh.Revoked {
+		t.Error("token is not revoked after RevokeRefreshTokens")
+	}
+}
+
+func testForgotPassword(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	request := map[string]string{"userid": user.Id, "email": user.Email, "token": "reset"}
+	if errResponse := store.StoreForgotPasswordToken(ctx, request); errResponse != nil {
+		t.Fatalf("StoreForgotPasswordToken: %+v", errResponse)
+	}
+	again := map[string]string{"userid": user.Id, "email": user.Email, "token": "reset-again"}
+	if errResponse := store.StoreForgotPasswordToken(ctx, again); errResponse == nil || errResponse.Status != http.StatusBadRequest {
+		t.Errorf("a second token while the first is active returned %+v, want a 400", errResponse)
+	}
+	if errResponse := store.StoreForgotPasswordToken(ctx, map[string]string{"userid": user.Id}); errResponse == nil || errResponse.Status != http.StatusBadRequest {
+		t.Errorf("a request without an email returned %+v, want a 400", errResponse)
+	}
+	if errResponse := store.UpdatePassword(ctx, &models.UpdatePasswordRequest{NewPassword: "x"}, "unknown"); errResponse == nil || errResponse.Status != http.StatusNotFound {
+		t.Errorf("UpdatePassword with an unknown token returned %+v, want a 404", errResponse)
+	}
+	if errResponse := store.UpdatePassword(ctx, &models.UpdatePasswordRequest{NewPassword: "reset-hash"}, "reset"); errResponse != nil {
+		t.Fatalf("UpdatePassword: %+v", errResponse)
+	}
+	if updated, _ := store.FetchUserByID(ctx, user.Id); updated.HashedPassword != "reset-hash" {
+		t.Errorf("password hash is %q after UpdatePassword", updated.HashedPassword)
+	}
+	if errResponse := store.UpdatePassword(ctx, &models.UpdatePasswordRequest{NewPassword: "x"}, "reset"); errResponse == nil {
+		t.Error("UpdatePassword accepted a token twice")
+	}
+	// the used token no longer blocks a new one
+	if errResponse := store.StoreForgotPasswordToken(ctx, again); errResponse != nil {
+		t.Errorf("StoreForgotPasswordToken after the first token was used: %+v", errResponse)
+	}
+}
+
+func testSearchLanguage(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	settings, err := store.GetUserSettings(ctx, user.Id)
+	if err != nil || settings.SearchLanguage != "simple" {
+		t.Errorf("GetUserSettings = %+v, %v; want simple", settings, err)
+	}
+	if err := store.UpdateSearchLanguage(ctx, user.Id, "german"); err != nil {
+		t.Fatalf("UpdateSearchLanguage: %v", err)
+	}
+	if settings, _ = store.GetUserSettings(ctx, user.Id); settings.SearchLanguage != "german" {
+		t.Errorf("search language is %q after UpdateSearchLanguage", settings.SearchLanguage)
+	}
+	if err := store.UpdateSearchLanguage(ctx, user.Id, "klingon"); !errors.Is(err, repository.ErrUnknownSearchLanguage) {
+		t.Errorf("UpdateSearchLanguage(klingon) returned %v", err)
+	}
+	if _, err := store.GetUserSettings(ctx, repository.NewId()); err == nil {
+		t.Error("GetUserSettings found a user that does not exist")
+	}
+}
+
+func testCreateAndGet(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	dueAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	created := createTodo(t, store, user.Id, &models.Todo{Name: "buy milk", Description: "two litres", TaskStatus: models.Status(2),
+		Priority: 2, Labels: []string{"shop", "home"}, Recurrence: "weekly", DueAt: &dueAt})
+	if created.Id == "" || created.Version != 1 || created.CreatedAt.IsZero() || created.ParentId != nil {
+		t.Errorf("CreateTodo returned %+v", created)
+	}
+	if created.TaskStatus != models.Status(2) {
+		t.Errorf("a created todo has status %v, want the status it was created with", created.TaskStatus)
+	}
+	got, err := store.GetTodoByID(ctx, created.Id, user.Id)
+	if err != nil || got == nil {
+		t.Fatalf("GetTodoByID = %v, %v", got, err)
+	}
+	if got.Name != "buy milk" || got.Description != "two litres" || got.Priority != 2 || !slices.Equal(got.Labels, []string{"shop", "home"}) ||
+		got.Recurrence != "weekly" || got.DueAt == nil || !got.DueAt.Equal(dueAt) || !got.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("GetTodoByID returned %+v, created %+v", got, created)
+	}
+	plain := createTodo(t, store, user.Id, &models.Todo{Name: "no labels"})
+	if plain.Labels == nil || len(plain.Labels) != 0 {
+		t.Errorf("a todo without labels has labels %#v, want an empty slice", plain.Labels)
+	}
+	if missing, err := store.GetTodoByID(ctx, repository.NewId(), user.Id); missing != nil || err != nil {
+		t.Errorf("GetTodoByID of a missing todo = %v, %v; want nil, nil", missing, err)
+	}
+}
+
+func testListKeyset(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	createNumbered(t, store, user.Id, 5)
+	page := &pagination.Request{Limit: 2}
+	todos, more, err := store.ListTodos(ctx, page, user.Id, repository.TodoFilter{})
+	if err != nil || !more || !slices.Equal(names(todos), []string{"todo 5", "todo 4"}) {
+		t.Fatalf("first page = %v, %v, %v", names(todos), more, err)
+	}
+	page = &pagination.Request{Limit: 2, Cursor: cursorOf(todos[1], false)}
+	todos, more, err = store.ListTodos(ctx, page, user.Id, repository.TodoFilter{})
+	if err != nil || !more || !slices.Equal(names(todos), []string{"todo 3", "todo 2"}) {
+		t.Fatalf("second page = %v, %v, %v", names(todos), more, err)
+	}
+	last, more, err := store.ListTodos(ctx, &pagination.Request{Limit: 2, Cursor: cursorOf(todos[1], false)}, user.Id, repository.TodoFilter{})
+	if err != nil || more || !slices.Equal(names(last), []string{"todo 1"}) {
+		t.Fatalf("last page = %v, %v, %v", names(last), more, err)
+	}
+	// back from the second page to the first
+	page = &pagination.Request{Limit: 2, Cursor: cursorOf(todos[0], true)}
+	todos, more, err = store.ListTodos(ctx, page, user.Id, repository.TodoFilter{})
+	if err != nil || more || !slices.Equal(names(todos), []string{"todo 5", "todo 4"}) {
+		t.Fatalf("backward page = %v, %v, %v", names(todos), more, err)
+	}
+	if count, err := store.CountTodos(ctx, user.Id, repository.TodoFilter{}); err != nil || count != 5 {
+		t.Errorf("CountTodos = %d, %v; want 5", count, err)
+	}
+}
+
+func testListOffset(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	createNumbered(t, store, user.Id, 5)
+	todos, more, err := store.ListTodos(ctx, &pagination.Request{Limit: 2, Offset: 2, OffsetMode: true}, user.Id, repository.TodoFilter{})
+	if err != nil || !more || !slices.Equal(names(todos), []string{"todo 3", "todo 2"}) {
+		t.Errorf("offset 2 = %v, %v, %v", names(todos), more, err)
+	}
+	todos, more, err = store.ListTodos(ctx, &pagination.Request{Limit: 2, Offset: 4, OffsetMode: true}, user.Id, repository.TodoFilter{})
+	if err != nil || more || !slices.Equal(names(todos), []string{"todo 1"}) {
+		t.Errorf("offset 4 = %v, %v, %v", names(todos), more, err)
+	}
+	todos, more, err = store.ListTodos(ctx, &pagination.Request{Limit: 2, Offset: 10, OffsetMode: true}, user.Id, repository.TodoFilter{})
+	if err != nil || more || len(todos) != 0 || todos == nil {
+		t.Errorf("offset past the end = %#v, %v, %v; want an empty slice", todos, more, err)
+	}
+}
+
+func testFilter(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	done := models.Status(2)
+	todos := []*models.Todo{
+		{Name: "a", Labels: []string{"work"}},
+		{Name: "b", Labels: []string{"home"}, TaskStatus: done},
+		{Name: "c", Labels: []string{"work", "home"}, TaskStatus: done},
+	}
+	if _, err := store.CreateTodos(ctx, todos, user.Id); err != nil {
+		t.Fatalf("CreateTodos: %v", err)
+	}
+	for _, c := range []struct {
+		filter repository.TodoFilter
+		want   []string
+	}{
+		{repository.TodoFilter{Label: "work"}, []string{"c", "a"}},
+		{repository.TodoFilter{Status: &done}, []string{"c", "b"}},
+		{repository.TodoFilter{Status: &done, Label: "work"}, []string{"c"}},
+		{repository.TodoFilter{Label: "none"}, []string{}},
+	} {
+		listed, _, err := store.ListTodos(ctx, &pagination.Request{Limit: 10}, user.Id, c.filter)
+		if err != nil || !slices.Equal(names(listed), c.want) {
+			t.Errorf("ListTodos(%+v) = %v, %v; want %v", c.filter, names(listed), err, c.want)
+		}
+		if count, err := store.CountTodos(ctx, user.Id, c.filter); err != nil || count != len(c.want) {
+			t.Errorf("CountTodos(%+v) = %d, %v; want %d", c.filter, count, err, len(c.want))
+		}
+	}
+}
+
+func testPutTodo(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	other := newUser(t, store, "bob")
+	id := repository.NewId()
+	todo, created, err := store.PutTodo(ctx, id, &models.Todo{Name: "first", TaskStatus: models.Status(1)}, user.Id, repository.PutAny, nil)
+	if err != nil || !created || todo.Id != id || todo.Version != 1 || todo.TaskStatus != models.Status(1) {
+		t.Fatalf("PutTodo creating = %+v, %v, %v", todo, created, err)
+	}
+	todo, created, err = store.PutTodo(ctx, id, &models.Todo{Name: "second"}, user.Id, repository.PutAny, nil)
+	if err != nil || created || todo.Name != "second" || todo.Version != 2 {
+		t.Fatalf("PutTodo replacing = %+v, %v, %v", todo, created, err)
+	}
+	if _, _, err = store.PutTodo(ctx, id, &models.Todo{Name: "x"}, user.Id, repository.PutCreateOnly, nil); !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("PutCreateOnly on an existing todo returned %v", err)
+	}
+	if _, _, err = store.PutTodo(ctx, id, &models.Todo{Name: "x"}, user.Id, repository.PutReplaceOnly, []int{1}); !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("PutReplaceOnly with a stale version returned %v", err)
+	}
+	todo, _, err = store.PutTodo(ctx, id, &models.Todo{Name: "third"}, user.Id, repository.PutReplaceOnly, []int{2})
+	if err != nil || todo.Name != "third" || todo.Version != 3 {
+		t.Errorf("PutReplaceOnly = %+v, %v", todo, err)
+	}
+	if _, _, err = store.PutTodo(ctx, repository.NewId(), &models.Todo{Name: "x"}, user.Id, repository.PutReplaceOnly, []int{1}); !errors.Is(err, repository.ErrTodoNotFound) {
+		t.Errorf("PutReplaceOnly on a missing todo returned %v", err)
+	}
+	// nil versions is If-Match: *, any existing todo but never a new one
+	todo, _, err = store.PutTodo(ctx, id, &models.Todo{Name: "third"}, user.Id, repository.PutReplaceOnly, nil)
+	if err != nil || todo.Version != 4 {
+		t.Errorf("PutReplaceOnly with any version = %+v, %v", todo, err)
+	}
+	if _, _, err = store.PutTodo(ctx, repository.NewId(), &models.Todo{Name: "x"}, user.Id, repository.PutReplaceOnly, nil); !errors.Is(err, repository.ErrTodoNotFound) {
+		t.Errorf("PutReplaceOnly with any version on a missing todo returned %v", err)
+	}
+	if _, _, err = store.PutTodo(ctx, id, &models.Todo{Name: "x"}, other.Id, repository.PutAny, nil); !errors.Is(err, repository.ErrTodoIdTaken) {
+		t.Errorf("PutTodo on another user's id returned %v", err)
+	}
+	// a deleted todo counts as absent: a replace finds nothing, either create purges it with its
+	// subtasks and starts the id over
+	for _, condition := range []repository.PutCondition{repository.PutAny, repository.PutCreateOnly} {
+		child := createTodo(t, store, user.Id, &models.Todo{Name: "child"})
+		if _, err = store.UpdateTodo(ctx, &models.TodoDocument{Name: "child", Labels: []string{}, ParentId: &id}, child.Id, user.Id, nil); err != nil {
+			t.Fatalf("UpdateTodo: %v", err)
+		}
+		if _, err = store.DeleteTodo(ctx, id, user.Id, nil); err != nil {
+			t.Fatalf("DeleteTodo: %v", err)
+		}
+		if _, _, err = store.PutTodo(ctx, id, &models.Todo{Name: "x"}, user.Id, repository.PutReplaceOnly, nil); !errors.Is(err, repository.ErrTodoNotFound) {
+			t.Errorf("PutReplaceOnly over a deleted todo returned %v", err)
+		}
+		todo, created, err = store.PutTodo(ctx, id, &models.Todo{Name: "back"}, user.Id, condition, nil)
+		if err != nil || !created || todo.Name != "back" || todo.Version != 1 {
+			t.Errorf("PutTodo(%v) over a deleted todo = %+v, %v, %v", condition, todo, created, err)
+		}
+		if got, _ := store.GetTodoByID(ctx, id, user.Id); got == nil {
+			t.Errorf("PutTodo(%v) left the todo deleted", condition)
+		}
+		if count, _ := store.CountDeletedTodos(ctx, user.Id, time.Now().Add(time.Hour)); count != 0 {
+			t.Errorf("PutTodo(%v) left %d deleted todos behind", condition, count)
+		}
+		if _, _, err = store.PutTodo(ctx, child.Id, &models.Todo{Name: "x"}, user.Id, repository.PutReplaceOnly, nil); !errors.Is(err, repository.ErrTodoNotFound) {
+			t.Errorf("the deleted subtask survived PutTodo(%v): %v", condition, err)
+		}
+	}
+}
+
+func testUpdateTodo(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	parent := createTodo(t, store, user.Id, &models.Todo{Name: "parent"})
+	child := createTodo(t, store, user.Id, &models.Todo{Name: "child"})
+	doc := &models.TodoDocument{Name: "renamed", TaskStatus: models.Status(1), Labels: []string{"x"}, ParentId: &parent.Id}
+	updated, err := store.UpdateTodo(ctx, doc, child.Id, user.Id, []int{1})
+	if err != nil || updated.Name != "renamed" || updated.Version != 2 || updated.ParentId == nil || *updated.ParentId != parent.Id {
+		t.Fatalf("UpdateTodo = %+v, %v", updated, err)
+	}
+	if _, err = store.UpdateTodo(ctx, doc, child.Id, user.Id, []int{1}); !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("UpdateTodo with a stale version returned %v", err)
+	}
+	if _, err = store.UpdateTodo(ctx, doc, repository.NewId(), user.Id, nil); !errors.Is(err, repository.ErrTodoNotFound) {
+		t.Errorf("UpdateTodo of a missing todo returned %v", err)
+	}
+	// the parent may not move under its own child, nor under itself
+	cycle := &models.TodoDocument{Name: "parent", Labels: []string{}, ParentId: &child.Id}
+	if _, err = store.UpdateTodo(ctx, cycle, parent.Id, user.Id, nil); !errors.Is(err, repository.ErrInvalidParent) {
+		t.Errorf("moving a todo under its subtask returned %v", err)
+	}
+	self := &models.TodoDocument{Name: "parent", Labels: []string{}, ParentId: &parent.Id}
+	if _, err = store.UpdateTodo(ctx, self, parent.Id, user.Id, nil); !errors.Is(err, repository.ErrInvalidParent) {
+		t.Errorf("moving a todo under itself returned %v", err)
+	}
+	missing := repository.NewId()
+	orphan := &models.TodoDocument{Name: "child", Labels: []string{}, ParentId: &missing}
+	if _, err = store.UpdateTodo(ctx, orphan, child.Id, user.Id, nil); !errors.Is(err, repository.ErrInvalidParent) {
+		t.Errorf("moving a todo under a missing parent returned %v", err)
+	}
+	// without a parent the todo moves back to the top level
+	updated, err = store.UpdateTodo(ctx, &models.TodoDocument{Name: "child", Labels: []string{}}, child.Id, user.Id, nil)
+	if err != nil || updated.ParentId != nil {
+		t.Errorf("UpdateTodo without a parent = %+v, %v", updated, err)
+	}
+}
+
+func testDeleteTodo(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	tree := []*models.Todo{{Name: "root", Subtasks: []*models.Todo{{Name: "child", Subtasks: []*models.Todo{{Name: "grandchild"}}}}}, {Name: "other"}}
+	if _, err := store.CreateTodos(ctx, tree, user.Id); err != nil {
+		t.Fatalf("CreateTodos: %v", err)
+	}
+	todos, _, _ := store.ListTodos(ctx, &pagination.Request{Limit: 10}, user.Id, repository.TodoFilter{})
+	root := todos[slices.IndexFunc(todos, func(todo *models.GetTodoResponse) bool { return todo.Name == "root" })]
+	if _, err := store.DeleteTodo(ctx, root.Id, user.Id, []int{root.Version + 1}); !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("DeleteTodo with a stale version returned %v", err)
+	}
+	deleted, err := store.DeleteTodo(ctx, root.Id, user.Id, []int{root.Version})
+	if err != nil {
+		t.Fatalf("DeleteTodo: %v", err)
+	}
+	if len(deleted) != 3 || !slices.Contains(deleted, root.Id) {
+		t.Errorf("DeleteTodo returned %v, want the ids of root and its two subtasks", deleted)
+	}
+	todos, _, _ = store.ListTodos(ctx, &pagination.Request{Limit: 10}, user.Id, repository.TodoFilter{})
+	if !slices.Equal(names(todos), []string{"other"}) {
+		t.Errorf("after deleting root the list is %v, want its subtasks gone too", names(todos))
+	}
+	if got, _ := store.GetTodoByID(ctx, root.Id, user.Id); got != nil {
+		t.Error("GetTodoByID returned a deleted todo")
+	}
+	if _, err := store.DeleteTodo(ctx, root.Id, user.Id, nil); !errors.Is(err, repository.ErrTodoNotFound) {
+		t.Errorf("deleting a deleted todo returned %v", err)
+	}
+}
+
+func testSubtasks(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	done := models.Status(2)
+	tree := []*models.Todo{
+		{Name: "root", Subtasks: []*models.Todo{{Name: "first", TaskStatus: done, Subtasks: []*models.Todo{{Name: "nested"}}}, {Name: "second"}}},
+		{Name: "unrelated"},
+	}
+	if created, err := store.CreateTodos(ctx, tree, user.Id); err != nil || created != 5 {
+		t.Fatalf("CreateTodos = %d, %v; want 5", created, err)
+	}
+	var root *models.GetTodoResponse
+	var streamed []string
+	err := store.StreamTodos(ctx, user.Id, repository.TodoFilter{}, func(todo *models.GetTodoResponse) error {
+		if todo.Name == "root" {
+			root = todo
+		}
+		streamed = append(streamed, todo.Name)
+		return nil
+	})
+	if err != nil || !slices.Equal(streamed, []string{"unrelated", "second", "nested", "first", "root"}) {
+		t.Fatalf("StreamTodos = %v, %v; want every todo newest first", streamed, err)
+	}
+	subtasks, err := store.ListSubtasks(ctx, root.Id, user.Id)
+	if err != nil || !slices.Equal(names(subtasks), []string{"first", "second"}) {
+		t.Errorf("ListSubtasks = %v, %v; want oldest first", names(subtasks), err)
+	}
+	if subtasks[0].TaskStatus != done || subtasks[0].ParentId == nil || *subtasks[0].ParentId != root.Id {
+		t.Errorf("imported subtask is %+v", subtasks[0])
+	}
+	streamed = nil
+	err = store.StreamTodos(ctx, user.Id, repository.TodoFilter{RootId: root.Id}, func(todo *models.GetTodoResponse) error {
+		streamed = append(streamed, todo.Name)
+		return nil
+	})
+	if err != nil || !slices.Equal(streamed, []string{"second", "nested", "first", "root"}) {
+		t.Errorf("StreamTodos of the root's subtree = %v, %v", streamed, err)
+	}
+	stop := errors.New("stop")
+	calls := 0
+	err = store.StreamTodos(ctx, user.Id, repository.TodoFilter{}, func(*models.GetTodoResponse) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("StreamTodos kept going after fn failed: %v after %d calls", err, calls)
+	}
+}
+
+func testSearch(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	todos := []*models.Todo{
+		{Name: "Buy groceries", Description: "milk and bread"},
+		{Name: "Call mum", Description: "about the groceries"},
+		{Name: "Fix bike", Labels: []string{"groceries"}},
+		{Name: "Write report"},
+	}
+	if _, err := store.CreateTodos(ctx, todos, user.Id); err != nil {
+		t.Fatalf("CreateTodos: %v", err)
+	}
+	search := func(options repository.SearchOptions) []*models.SearchResult {
+		t.Helper()
+		if options.Page == nil {
+			options.Page = &pagination.Request{Limit: 10}
+		}
+		results, _, err := store.SearchTodo(ctx, options, user.Id)
+		if err != nil {
+			t.Fatalf("SearchTodo(%q): %v", options.Text, err)
+		}
+		return results
+	}
+	resultNames := func(results []*models.SearchResult) []string {
+		names := make([]string, len(results))
+		for i, result := range results {
+			names[i] = result.Name
+		}
+		return names
+	}
+	results := search(repository.SearchOptions{Text: "groceries", Mode: repository.SearchModeFullText})
+	// a match in the name ranks above one in the description, which ranks above one in the labels
+	if !slices.Equal(resultNames(results), []string{"Buy groceries", "Call mum", "Fix bike"}) {
+		t.Errorf("search for groceries found %v", resultNames(results))
+	}
+	if len(results) > 0 && (!slices.Contains(results[0].MatchedFields, models.SearchFieldName) || results[0].Rank <= 0) {
+		t.Errorf("best result is %+v", results[0])
+	}
+	results = search(repository.SearchOptions{Text: "groceries", Mode: repository.SearchModeFullText, Fields: []string{models.SearchFieldName}})
+	if !slices.Equal(resultNames(results), []string{"Buy groceries"}) {
+		t.Errorf("search for groceries in names found %v", resultNames(results))
+	}
+	results = search(repository.SearchOptions{Text: "rep", Mode: repository.SearchModeFullText, Prefix: true})
+	if !slices.Equal(resultNames(results), []string{"Write report"}) {
+		t.Errorf("prefix search for rep found %v", resultNames(results))
+	}
+	if results = search(repository.SearchOptions{Text: "rep", Mode: repository.SearchModeFullText}); len(results) != 0 {
+		t.Errorf("search for rep without prefix found %v", resultNames(results))
+	}
+	results = search(repository.SearchOptions{Text: "grocreies", Mode: repository.SearchModeFuzzy, Fields: []string{models.SearchFieldName}})
+	if !slices.Equal(resultNames(results), []string{"Buy groceries"}) {
+		t.Errorf("fuzzy search for a typo found %v", resultNames(results))
+	}
+	first, more, err := store.SearchTodo(ctx, repository.SearchOptions{Text: "groceries", Mode: repository.SearchModeFullText, Page: &pagination.Request{Limit: 2}}, user.Id)
+	if err != nil || !more || len(first) != 2 {
+		t.Fatalf("first search page = %v, %v, %v", resultNames(first), more, err)
+	}
+	last := first[len(first)-1]
+	cursor := &pagination.Cursor{Rank: &last.Rank, CreatedAt: last.CreatedAt, Id: last.Id}
+	rest, more, err := store.SearchTodo(ctx, repository.SearchOptions{Text: "groceries", Mode: repository.SearchModeFullText, Page: &pagination.Request{Limit: 2, Cursor: cursor}}, user.Id)
+	if err != nil || more || !slices.Equal(resultNames(rest), []string{"Fix bike"}) {
+		t.Errorf("second search page = %v, %v, %v", resultNames(rest), more, err)
+	}
+}
+
+func testSearchHighlights(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	todo := &models.Todo{Name: `<img src=x onerror="alert(1)"> groceries`, Description: "groceries & <b>bread</b>"}
+	if _, err := store.CreateTodo(ctx, todo, user.Id); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	for _, mode := range []string{repository.SearchModeFullText, repository.SearchModeFuzzy} {
+		options := repository.SearchOptions{Text: "groceries", Mode: mode, Page: &pagination.Request{Limit: 10}}
+		results, _, err := store.SearchTodo(ctx, options, user.Id)
+		if err != nil || len(results) != 1 {
+			t.Fatalf("%s search = %v, %v", mode, results, err)
+		}
+		highlights := results[0].Highlights
+		// the text is escaped, so the only tags left are the marks around matches
+		for _, text := range []string{highlights.Name, highlights.Description} {
+			if stripped := strings.NewReplacer("<mark>", "", "</mark>", "").Replace(text); strings.ContainsAny(stripped, `<>"`) {
+				t.Errorf("%s highlight %q is not escaped", mode, text)
+			}
+		}
+		if !strings.Contains(highlights.Name, "&lt;img") {
+			t.Errorf("%s highlights = %+v", mode, highlights)
+		}
+		if mode == repository.SearchModeFullText && !strings.Contains(highlights.Name, "<mark>groceries</mark>") {
+			t.Errorf("full text highlight %q does not mark the match", highlights.Name)
+		}
+	}
+}
+
+func testPurge(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	user := newUser(t, store, "alice")
+	other := newUser(t, store, "bob")
+	gone := createTodo(t, store, user.Id, &models.Todo{Name: "gone"})
+	createTodo(t, store, user.Id, &models.Todo{Name: "kept"})
+	theirs := createTodo(t, store, other.Id, &models.Todo{Name: "theirs"})
+	for _, todo := range []*models.GetTodoResponse{gone, theirs} {
+		owner := user.Id
+		if todo == theirs {
+			owner = other.Id
+		}
+		if _, err := store.DeleteTodo(ctx, todo.Id, owner, nil); err != nil {
+			t.Fatalf("DeleteTodo: %v", err)
+		}
+	}
+	if count, err := store.CountDeletedTodos(ctx, "", time.Now().Add(-time.Hour)); err != nil || count != 0 {
+		t.Errorf("CountDeletedTodos before the deletions = %d, %v; want 0", count, err)
+	}
+	cutoff := time.Now().Add(time.Second)
+	if count, err := store.CountDeletedTodos(ctx, "", cutoff); err != nil || count != 2 {
+		t.Errorf("CountDeletedTodos for everyone = %d, %v; want 2", count, err)
+	}
+	if count, err := store.PurgeDeletedTodos(ctx, user.Id, cutoff); err != nil || count != 1 {
+		t.Errorf("PurgeDeletedTodos for alice = %d, %v; want 1", count, err)
+	}
+	if count, err := store.CountDeletedTodos(ctx, "", cutoff); err != nil || count != 1 {
+		t.Errorf("CountDeletedTodos after purging alice = %d, %v; want bob's todo left", count, err)
+	}
+	if _, _, err := store.PutTodo(ctx, gone.Id, &models.Todo{Name: "reused"}, other.Id, repository.PutAny, nil); err != nil {
+		t.Errorf("a purged id cannot be reused: %v", err)
+	}
+	if count, err := store.CountTodos(ctx, user.Id, repository.TodoFilter{}); err != nil || count != 1 {
+		t.Errorf("CountTodos after the purge = %d, %v; want 1", count, err)
+	}
+}
+
+func testIsolation(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	alice := newUser(t, store, "alice")
+	bob := newUser(t, store, "bob")
+	todo := createTodo(t, store, alice.Id, &models.Todo{Name: "private"})
+	if got, err := store.GetTodoByID(ctx, todo.Id, bob.Id); got != nil || err != nil {
+		t.Errorf("bob can read alice's todo: %v, %v", got, err)
+	}
+	if todos, _, _ := store.ListTodos(ctx, &pagination.Request{Limit: 10}, bob.Id, repository.TodoFilter{}); len(todos) != 0 {
+		t.Errorf("bob lists %v", names(todos))
+	}
+	if _, err := store.UpdateTodo(ctx, &models.TodoDocument{Name: "mine", Labels: []string{}}, todo.Id, bob.Id, nil); !errors.Is(err, repository.ErrTodoNotFound) {
+		t.Errorf("bob updating alice's todo returned %v", err)
+	}
+	if _, err := store.DeleteTodo(ctx, todo.Id, bob.Id, nil); !errors.Is(err, repository.ErrTodoNotFound) {
+		t.Errorf("bob deleting alice's todo returned %v", err)
+	}
+	results, _, err := store.SearchTodo(ctx, repository.SearchOptions{Text: "private", Mode: repository.SearchModeFullText, Page: &pagination.Request{Limit: 10}}, bob.Id)
+	if err != nil || len(results) != 0 {
+		t.Errorf("bob's search found %d results, %v", len(results), err)
+	}
+}
@@ -0,0 +1,365 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"todos/jsonpatch"
+	"todos/models"
+	validateapp "todos/validator"
+)
+
+// SyncToken marks how far a client has read the change feed. Changes are ordered by the id of the
+// transaction that wrote them and a feed read only covers transactions that had finished when it
+// started (From up to To), so a write committing late is picked up by a later read instead of being
+// skipped. AfterXid and AfterId continue a read that was cut off by its limit.
+type SyncToken struct {
+	From     uint64 `json:"f"`
+	To       uint64 `json:"t,omitempty"`
+	AfterXid uint64 `json:"x,omitempty"`
+	AfterId  string `json:"i,omitempty"`
+}
+
+func (t *SyncToken) Encode() string {
+	raw, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func DecodeSyncToken(value string) (*SyncToken, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("malformed sync token")
+	}
+	token := new(SyncToken)
+	if err = json.Unmarshal(raw, token); err != nil || (token.To != 0 && token.To < token.From) {
+		return nil, errors.New("malformed sync token")
+	}
+	return token, nil
+}
+
+// ErrStaleSyncToken is returned for a token that cannot be continued from: one issued before deleted
+// todos it had not seen yet were purged, or one this database never issued. The client starts over.
+var ErrStaleSyncToken = errors.New("sync token is stale, start a full sync")
+
+const nilUUID = "00000000-0000-0000-0000-000000000000"
+
+// GetSyncChanges returns the user's todos written since token, deleted ones included as tombstones,
+// and the token to pass next time. A nil token starts a full sync, which leaves out tombstones.
+func GetSyncChanges(ctx context.Context, db *sql.DB, user_id string, token *SyncToken, limit int) ([]*models.SyncChange, *SyncToken, bool, error) {
+	if token == nil {
+		token = new(SyncToken)
+	}
+	var xmin, xmax, purged string
+	query := `select pg_snapshot_xmin(s)::text, pg_snapshot_xmax(s)::text,
+			coalesce((select purged_xid::text from sync_purges where user_id = $1), '0')
+		from pg_current_snapshot() s`
+	if err := db.QueryRowContext(ctx, query, user_id).Scan(&xmin, &xmax, &purged); err != nil {
+		return nil, nil, false, err
+	}
+	var snapshot [3]uint64
+	for i, value := range []string{xmin, xmax, purged} {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		snapshot[i] = parsed
+	}
+	// a purge at or after From may have removed tombstones the client has not read, a full sync (From
+	// zero) leaves them out anyway; and no transaction this database ran can be past xmax
+	if token.From != 0 && snapshot[2] >= token.From || max(token.From, token.To) > snapshot[1] {
+		return nil, nil, false, ErrStaleSyncToken
+	}
+	window := *token
+	if window.To == 0 {
+		window.To = snapshot[0]
+	}
+	afterId := window.AfterId
+	if afterId == "" {
+		afterId = nilUUID
+	}
+	query = `select ` + todoColumns + `, deleted_at, field_clocks, change_xid::text from todo
+		where user_id = $1 and change_xid >= $2::text::xid8 and change_xid < $3::text::xid8
+		and (change_xid, id) > ($4::text::xid8, $5::uuid) and ($6 or deleted_at is null)
+		order by change_xid, id
+		limit $7`
+	rows, err := db.QueryContext(ctx, query, user_id, fmt.Sprint(window.From), fmt.Sprint(window.To), fmt.Sprint(window.AfterXid), afterId,
+		token.From != 0, limit+1)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer rows.Close()
+	changes := []*models.SyncChange{}
+	var xids []string
+	for rows.Next() {
+		todo := new(models.GetTodoResponse)
+		change := new(models.SyncChange)
+		var clocks []byte
+		var xid string
+		if err = scanTodoInto(rows, todo, &change.DeletedAt, &clocks, &xid); err != nil {
+			return nil, nil, false, err
+		}
+		if err = json.Unmarshal(clocks, &change.FieldClocks); err != nil {
+			return nil, nil, false, err
+		}
+		change.Id = todo.Id
+		change.Deleted = change.DeletedAt != nil
+		if !change.Deleted {
+			change.Todo = todo
+		}
+		changes = append(changes, change)
+		xids = append(xids, xid)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, false, err
+	}
+	if len(changes) <= limit {
+		return changes, &SyncToken{From: window.To}, false, nil
+	}
+	changes = changes[:limit]
+	afterXid, err := strconv.ParseUint(xids[limit-1], 10, 64)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return changes, &SyncToken{From: window.From, To: window.To, AfterXid: afterXid, AfterId: changes[limit-1].Id}, true, nil
+}
+
+var syncFields = map[string]bool{
+	"name": true, "description": true, "status": true, "priority": true,
+	"labels": true, "recurrence": true, "dueAt": true, "parentId": true,
+}
+
+// ApplySyncChange merges one offline change into the todo field by field: a field is taken from the
+// client when its ModifiedAt is newer than the server's clock for that field, otherwise the server
+// keeps its value and the field is reported as a conflict. Deleted todos stay deleted.
+func ApplySyncChange(ctx context.Context, db *sql.DB, change *models.SyncPushChange, user_id string) (*models.SyncPushResult, error) {
+	result := &models.SyncPushResult{Id: change.Id, Applied: []string{}}
+	reject := func(message string) (*models.SyncPushResult, error) {
+		result.Status = models.SyncRejected
+		result.Error = message
+		return result, nil
+	}
+	if change.ModifiedAt.IsZero() {
+		return reject("modifiedAt is required")
+	}
+	for field := range change.Fields {
+		if !syncFields[field] {
+			return reject(fmt.Sprintf("unknown field %s", field))
+		}
+	}
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+
+	current := new(models.GetTodoResponse)
+	var owner string
+	var deletedAt *time.Time
+	var rawClocks []byte
+	query := `select ` + todoColumns + `, user_id, deleted_at, field_clocks from todo where id = $1 for update`
+	err = scanTodoInto(transaction.QueryRowContext(ctx, query, change.Id), current, &owner, &deletedAt, &rawClocks)
+	if errors.Is(err, sql.ErrNoRows) {
+		if change.Deleted {
+			result.Status = models.SyncApplied
+			return result, nil
+		}
+		return createFromSync(ctx, transaction, change, user_id, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if owner != user_id {
+		return reject(ErrTodoIdTaken.Error())
+	}
+	clocks := map[string]time.Time{}
+	if err = json.Unmarshal(rawClocks, &clocks); err != nil {
+		return nil, err
+	}
+
+	if deletedAt != nil {
+		if change.Deleted {
+			result.Status = models.SyncApplied
+			return result, nil
+		}
+		result.Status = models.SyncConflict
+		result.Conflicts = []models.SyncFieldConflict{{Field: models.SyncDeletedField, ServerValue: json.RawMessage("true"), ServerModifiedAt: clocks[models.SyncDeletedField]}}
+		return result, nil
+	}
+
+	serverDoc, err := documentMap(current.Document())
+	if err != nil {
+		return nil, err
+	}
+	if change.Deleted {
+		// a delete loses against any edit made on the server after it
+		for field, clock := range clocks {
+			if syncFields[field] && clock.After(change.ModifiedAt) {
+				result.Conflicts = append(result.Conflicts, fieldConflict(serverDoc, field, clock))
+			}
+		}
+		if len(result.Conflicts) > 0 {
+			result.Status = models.SyncConflict
+			result.Todo = current
+			return result, nil
+		}
+		stamp, _ := json.Marshal(map[string]time.Time{models.SyncDeletedField: change.ModifiedAt})
+		query := `with recursive subtree as (
+				select id from todo where id = $1
+				union all
+				select t.id from todo t join subtree s on t.parent_id = s.id where t.deleted_at is null
+			)
+			update todo set deleted_at = now(), field_clocks = field_clocks || $2::jsonb, version = version + 1 where id in (select id from subtree) returning id`
+		rows, err := transaction.QueryContext(ctx, query, change.Id, stamp)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var deletedId string
+			if err = rows.Scan(&deletedId); err != nil {
+				return nil, err
+			}
+			result.Deleted = append(result.Deleted, deletedId)
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		rows.Close()
+		result.Status = models.SyncApplied
+		result.Applied = append(result.Applied, models.SyncDeletedField)
+		return result, transaction.Commit()
+	}
+
+	patch := map[string]json.RawMessage{}
+	for field, value := range change.Fields {
+		if clock, ok := clocks[field]; ok && !change.ModifiedAt.After(clock) {
+			result.Conflicts = append(result.Conflicts, fieldConflict(serverDoc, field, clock))
+			continue
+		}
+		patch[field] = value
+		clocks[field] = change.ModifiedAt
+		result.Applied = append(result.Applied, field)
+	}
+	if len(patch) == 0 {
+		result.Status = models.SyncApplied
+		if len(result.Conflicts) > 0 {
+			result.Status = models.SyncConflict
+		}
+		result.Todo = current
+		return result, nil
+	}
+	doc, err := mergeSyncFields(serverDoc, patch)
+	if err != nil {
+		return reject(err.Error())
+	}
+	if doc.ParentId != nil && (current.ParentId == nil || *current.ParentId != *doc.ParentId) {
+		if err = checkParent(ctx, transaction, *doc.ParentId, change.Id, user_id); err != nil {
+			return reject(err.Error())
+		}
+	}
+	encodedClocks, err := json.Marshal(clocks)
+	if err != nil {
+		return nil, err
+	}
+	query = `update todo set name = $2, description = $3, status = $4, priority = $5, labels = $6, recurrence = $7, due_at = $8, parent_id = $9,
+			field_clocks = $10, version = version + 1
+		where id = $1
+		returning ` + todoColumns
+	row := transaction.QueryRowContext(ctx, query, change.Id, doc.Name, doc.Description, doc.TaskStatus, doc.Priority, labelsArg(doc.Labels), doc.Recurrence, doc.DueAt, doc.ParentId, encodedClocks)
+	if result.Todo, err = scanTodo(row); err != nil {
+		return nil, err
+	}
+	result.Status = models.SyncApplied
+	if len(result.Conflicts) > 0 {
+		result.Status = models.SyncPartial
+	}
+	return result, transaction.Commit()
+}
+
+func createFromSync(ctx context.Context, transaction *sql.Tx, change *models.SyncPushChange, user_id string, result *models.SyncPushResult) (*models.SyncPushResult, error) {
+	empty, err := documentMap(&models.TodoDocument{Labels: []string{}})
+	if err != nil {
+		return nil, err
+	}
+	doc, err := mergeSyncFields(empty, change.Fields)
+	if err == nil && doc.ParentId != nil {
+		err = checkParent(ctx, transaction, *doc.ParentId, change.Id, user_id)
+	}
+	if err != nil {
+		result.Status = models.SyncRejected
+		result.Error = err.Error()
+		return result, nil
+	}
+	// every field starts out at the client's clock, so older changes queued on other devices lose
+	clocks := map[string]time.Time{}
+	for field := range syncFields {
+		clocks[field] = change.ModifiedAt
+	}
+	encodedClocks, err := json.Marshal(clocks)
+	if err != nil {
+		return nil, err
+	}
+	query := `insert into todo (id, name, description, status, priority, labels, recurrence, due_at, parent_id, user_id, field_clocks)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		on conflict (id) do nothing
+		returning ` + todoColumns
+	row := transaction.QueryRowContext(ctx, query, change.Id, doc.Name, doc.Description, doc.TaskStatus, doc.Priority, labelsArg(doc.Labels), doc.Recurrence, doc.DueAt, doc.ParentId, user_id, encodedClocks)
+	result.Todo, err = scanTodo(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		// created concurrently by another push, the client will see it on its next pull
+		result.Status = models.SyncRejected
+		result.Error = "todo was created concurrently, pull and retry"
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Status = models.SyncApplied
+	for field := range change.Fields {
+		result.Applied = append(result.Applied, field)
+	}
+	return result, transaction.Commit()
+}
+
+func documentMap(doc *models.TodoDocument) (map[string]any, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	generic := map[string]any{}
+	err = json.Unmarshal(raw, &generic)
+	return generic, err
+}
+
+func mergeSyncFields(doc map[string]any, fields map[string]json.RawMessage) (*models.TodoDocument, error) {
+	patch, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := jsonpatch.MergePatch(doc, patch)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	result, err := models.DecodeTodoDocument(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateapp.ValidateStruct(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func fieldConflict(serverDoc map[string]any, field string, clock time.Time) models.SyncFieldConflict {
+	value, _ := json.Marshal(serverDoc[field])
+	return models.SyncFieldConflict{Field: field, ServerValue: value, ServerModifiedAt: clock}
+}
@@ -9,32 +9,205 @@ import (
 	"strings"
 	"time"
 	"todos/models"
+	"todos/pagination"
+
+	"github.com/lib/pq"
 )
 
-func GetAllTodos(ctx context.Context, db *sql.DB, offset int, limit int, userId string) ([]*models.GetTodoResponse, error) {
-	query := `select id,name,description,status,created_at from todo where user_id = $1 order by created_at desc limit $2 offset $3`
-	rows, err := db.QueryContext(ctx, query, userId, limit, offset)
+const todoColumns = `id, parent_id, name, description, status, priority, labels, recurrence, due_at, created_at, version`
+
+var (
+	ErrTodoNotFound    = errors.New("todo not found")
+	ErrVersionMismatch = errors.New("todo has been modified since it was read")
+)
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTodo(row rowScanner) (*models.GetTodoResponse, error) {
+	todo := new(models.GetTodoResponse)
+	if err := scanTodoInto(row, todo); err != nil {
+		return nil, err
+	}
+	return todo, nil
+}
+
+// scanTodoInto scans todoColumns into todo, followed by any extra columns the query selects after them
+func scanTodoInto(row rowScanner, todo *models.GetTodoResponse, extra ...any) error {
+	dest := []any{&todo.Id, &todo.ParentId, &todo.Name, &todo.Description, &todo.TaskStatus, &todo.Priority, pq.Array(&todo.Labels), &todo.Recurrence, &todo.DueAt, &todo.CreatedAt, &todo.Version}
+	return row.Scan(append(dest, extra...)...)
+}
+
+func labelsArg(labels []string) any {
+	if labels == nil {
+		labels = []string{}
+	}
+	return pq.Array(labels)
+}
+
+func GetAllTodos(ctx context.Context, db *sql.DB, offset int, limit int, userId string, filter TodoFilter) ([]*models.GetTodoResponse, error) {
+	filtered, filterArgs := filterCondition(filter, 4)
+	query := `select ` + todoColumns + ` from todo where user_id = $1 and deleted_at is null` + filtered + ` order by created_at desc limit $2 offset $3`
+	rows, err := db.QueryContext(ctx, query, append([]any{userId, limit, offset}, filterArgs...)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var todos []*models.GetTodoResponse
+	todos := []*models.GetTodoResponse{}
 	for rows.Next() {
-		var todo models.GetTodoResponse
-		err = rows.Scan(&todo.Id, &todo.Name, &todo.Description, &todo.TaskStatus, &todo.CreatedAt)
+		todo, err := scanTodo(rows)
 		if err != nil {
 			return nil, err
 		}
-		todos = append(todos, &todo)
+		todos = append(todos, todo)
 	}
 	return todos, nil
 }
 
+// keyset returns the condition and ordering that continue a newest-first listing from cursor.
+// sortKey names the columns the cursor holds, in sort order; args are numbered from next.
+func keyset(page *pagination.Request, sortKey []string, next int) (string, string, []any) {
+	direction := "desc"
+	if page.Backward() {
+		direction = "asc"
+	}
+	order := make([]string, len(sortKey))
+	for i, column := range sortKey {
+		order[i] = column + " " + direction
+	}
+	orderBy := " order by " + strings.Join(order, ", ")
+	if page.Cursor == nil {
+		return "", orderBy, nil
+	}
+	var args []any
+	var placeholders []string
+	for _, column := range sortKey {
+		switch column {
+		case "rank":
+			rank := 0.0
+			if page.Cursor.Rank != nil {
+				rank = *page.Cursor.Rank
+			}
+			args = append(args, rank)
+			placeholders = append(placeholders, fmt.Sprintf("$%d::real", next))
+		case "created_at":
+			args = append(args, page.Cursor.CreatedAt)
+			placeholders = append(placeholders, fmt.Sprintf("$%d::timestamptz", next))
+		case "id":
+			args = append(args, page.Cursor.Id)
+			placeholders = append(placeholders, fmt.Sprintf("$%d::uuid", next))
+		}
+		next++
+	}
+	comparison := "<"
+	if page.Backward() {
+		comparison = ">"
+	}
+	condition := fmt.Sprintf(" and (%s) %s (%s)", strings.Join(sortKey, ", "), comparison, strings.Join(placeholders, ", "))
+	return condition, orderBy, args
+}
+
+// filterCondition is the status and label part of filter, with arguments numbered from next;
+// subtree filtering is only supported by StreamTodos.
+func filterCondition(filter TodoFilter, next int) (string, []any) {
+	var label *string
+	if filter.Label != "" {
+		label = &filter.Label
+	}
+	condition := fmt.Sprintf(` and ($%d::integer is null or status = $%d) and ($%d::text is null or $%d = any(labels))`, next, next, next+1, next+1)
+	return condition, []any{filter.Status, label}
+}
+
+func ListTodos(ctx context.Context, db *sql.DB, page *pagination.Request, userId string, filter TodoFilter) ([]*models.GetTodoResponse, bool, error) {
+	filtered, filterArgs := filterCondition(filter, 3)
+	condition, orderBy, keysetArgs := keyset(page, []string{"created_at", "id"}, 5)
+	query := `select ` + todoColumns + ` from todo where user_id = $1 and deleted_at is null` + filtered + condition + orderBy + ` limit $2`
+	args := append(append([]any{userId, page.Limit + 1}, filterArgs...), keysetArgs...)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	todos := []*models.GetTodoResponse{}
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		todos = append(todos, todo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+	todos, more := pagination.Trim(todos, page)
+	return todos, more, nil
+}
+
+func CountTodos(ctx context.Context, db *sql.DB, userId string, filter TodoFilter) (int, error) {
+	var count int
+	filtered, filterArgs := filterCondition(filter, 2)
+	err := db.QueryRowContext(ctx, `select count(*) from todo where user_id = $1 and deleted_at is null`+filtered, append([]any{userId}, filterArgs...)...).Scan(&count)
+	return count, err
+}
+
+type TodoFilter struct {
+	Status *models.Status
+	Label  string
+	RootId string
+}
+
+func ListSubtasks(ctx context.Context, db *sql.DB, parent_id string, user_id string) ([]*models.GetTodoResponse, error) {
+	query := `select ` + todoColumns + ` from todo where parent_id = $1 and user_id = $2 and deleted_at is null order by created_at`
+	rows, err := db.QueryContext(ctx, query, parent_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	todos := []*models.GetTodoResponse{}
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+	return todos, rows.Err()
+}
+
+func StreamTodos(ctx context.Context, db *sql.DB, userId string, filter TodoFilter, fn func(*models.GetTodoResponse) error) error {
+	filtered, filterArgs := filterCondition(filter, 2)
+	query := `select ` + todoColumns + ` from todo where user_id = $1 and deleted_at is null` + filtered + ` order by created_at desc`
+	args := append([]any{userId}, filterArgs...)
+	if filter.RootId != "" {
+		query = `with recursive subtree as (
+			select ` + todoColumns + ` from todo where id = $4 and user_id = $1 and deleted_at is null
+			union all
+			select t.id, t.parent_id, t.name, t.description, t.status, t.priority, t.labels, t.recurrence, t.due_at, t.created_at, t.version from todo t join subtree s on t.parent_id = s.id where t.deleted_at is null
+		) select ` + todoColumns + ` from subtree where true` + filtered + ` order by created_at desc`
+		args = append(args, filter.RootId)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(todo); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 func GetTodoByID(ctx context.Context, db *sql.DB, id string, user_id string) (*models.GetTodoResponse, error) {
-	var todo = new(models.GetTodoResponse)
-	query := `select id,name,description,status,created_at from todo where id= $1 and user_id =$2`
+	query := `select ` + todoColumns + ` from todo where id= $1 and user_id =$2 and deleted_at is null`
 	row := db.QueryRowContext(ctx, query, id, user_id)
-	err := row.Scan(&todo.Id, &todo.Name, &todo.Description, &todo.TaskStatus, &todo.CreatedAt)
+	todo, err := scanTodo(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -44,65 +217,227 @@ func GetTodoByID(ctx context.Context, db *sql.DB, id string, user_id string) (*m
 	return todo, nil
 }
 
-func CreateTodo(ctx context.Context, db *sql.DB, todo *models.Todo, user_id string) error {
-	query := `insert into todo (name, description, user_id) values ($1, $2, $3)`
-	_, err := db.ExecContext(ctx, query, todo.Name, todo.Description, user_id)
-	return err
+func CreateTodo(ctx context.Context, db *sql.DB, todo *models.Todo, user_id string) (*models.GetTodoResponse, error) {
+	query := `insert into todo (name, description, status, priority, labels, recurrence, due_at, user_id) values ($1, $2, $3, $4, $5, $6, $7, $8) returning ` + todoColumns
+	row := db.QueryRowContext(ctx, query, todo.Name, todo.Description, todo.TaskStatus, todo.Priority, labelsArg(todo.Labels), todo.Recurrence, todo.DueAt, user_id)
+	return scanTodo(row)
 }
 
-func DeleteTodo(ctx context.Context, db *sql.DB, id string, user_id string) error {
-	query := `delete from todo where id=$1 and user_id=$2;`
-	rows, _ := db.ExecContext(ctx, query, id, user_id)
-	rowCount, err := rows.RowsAffected()
-	if rowCount == 0 {
-		return fmt.Errorf("no rows found for this user with this Id: %s", id)
-	}
-	return err
-}
+var ErrTodoIdTaken = errors.New("todo id is already in use")
+
+type PutCondition int
 
-func UpdateTodo(ctx context.Context, db *sql.DB, params map[string]interface{}, id string, user_id string) error {
+const (
+	// PutAny creates the todo or replaces it, whichever applies
+	PutAny PutCondition = iota
+	// PutCreateOnly fails with ErrVersionMismatch if the todo already exists (If-None-Match: *)
+	PutCreateOnly
+	// PutReplaceOnly only replaces an existing todo whose version is one of the given versions, or
+	// any existing todo when versions is nil (If-Match)
+	PutReplaceOnly
+)
 
-	paramNames := []string{}
-	paramValues := []interface{}{}
+// PutTodo creates or fully replaces the todo with a client chosen id and reports whether it was created.
+// A replace keeps the todo's place in the tree and its creation time; everything else comes from todo.
+// The user's own deleted todo counts as absent: unless only a replace is allowed it is purged, with
+// its deleted subtasks, and the todo is created afresh under the same id.
+func PutTodo(ctx context.Context, db *sql.DB, id string, todo *models.Todo, user_id string, condition PutCondition, versions []int) (*models.GetTodoResponse, bool, error) {
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer transaction.Rollback()
+	if condition != PutReplaceOnly {
+		_, err = transaction.ExecContext(ctx, fmt.Sprintf(purgeQuery, `id = $1 and user_id = $2 and deleted_at is not null`), id, user_id)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	var query string
+	var args []any
+	switch condition {
+	case PutReplaceOnly:
+		query = `update todo set name = $2, description = $3, status = $4, priority = $5, labels = $6, recurrence = $7, due_at = $8, version = version + 1
+			where id = $1 and user_id = $9 and deleted_at is null and ($10::integer[] is null or version = any($10))
+			returning ` + todoColumns + `, false`
+		args = []any{id, todo.Name, todo.Description, todo.TaskStatus, todo.Priority, labelsArg(todo.Labels), todo.Recurrence, todo.DueAt, user_id, versionsArg(versions)}
+	default:
+		conflict := `do update set name = excluded.name, description = excluded.description, status = excluded.status, priority = excluded.priority,
+			labels = excluded.labels, recurrence = excluded.recurrence, due_at = excluded.due_at, version = todo.version + 1
+			where todo.user_id = excluded.user_id`
+		if condition == PutCreateOnly {
+			conflict = `do nothing`
+		}
+		var createdAt *time.Time
+		if !todo.CreatedAt.IsZero() {
+			createdAt = &todo.CreatedAt
+		}
+		// xmax is only zero for a freshly inserted row, which tells the two outcomes of the upsert apart
+		query = `insert into todo (id, name, description, status, priority, labels, recurrence, due_at, created_at, user_id)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, coalesce($9::timestamptz, now()), $10)
+			on conflict (id) ` + conflict + `
+			returning ` + todoColumns + `, (xmax = 0)`
+		args = []any{id, todo.Name, todo.Description, todo.TaskStatus, todo.Priority, labelsArg(todo.Labels), todo.Recurrence, todo.DueAt, createdAt, user_id}
+	}
+	result := new(models.GetTodoResponse)
+	var created bool
+	err = scanTodoInto(transaction.QueryRowContext(ctx, query, args...), result, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		transaction.Rollback()
+		err = versionConflict(ctx, db, id, user_id)
+		// on an upsert the id exists but belongs to somebody else
+		if errors.Is(err, ErrTodoNotFound) && condition != PutReplaceOnly {
+			err = ErrTodoIdTaken
+		}
+		return nil, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return result, created, transaction.Commit()
+}
 
-	var i int = 1
-	for key, value := range params {
-		paramNames = append(paramNames, fmt.Sprintf("%s=$%d", key, i))
-		paramValues = append(paramValues, value)
-		i++
+func CreateTodos(ctx context.Context, db *sql.DB, todos []*models.Todo, user_id string) (int, error) {
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
 	}
-	j := i + 1
-	query := fmt.Sprintf(`update todo set %s where id = $%d and user_id = $%d`, strings.Join(paramNames, ","), i, j)
-	paramValues = append(paramValues, id)
-	paramValues = append(paramValues, user_id)
-	res, err := db.ExecContext(ctx, query, paramValues...)
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("no rows found for id: %s", id)
+	defer transaction.Rollback()
+	// clock_timestamp keeps rows of one import distinct and in file order, now() would be the same for the whole transaction
+	query := `insert into todo (name, description, status, priority, labels, recurrence, due_at, created_at, parent_id, user_id)
+		values ($1, $2, $3, $4, $5, $6, $7, coalesce($8::timestamptz, clock_timestamp()), $9, $10) returning id`
+	stmt, err := transaction.PrepareContext(ctx, query)
+	if err != nil {
+		return 0, err
 	}
-	return err
+	defer stmt.Close()
+	count := 0
+	var insert func(todos []*models.Todo, parentId *string) error
+	insert = func(todos []*models.Todo, parentId *string) error {
+		for _, todo := range todos {
+			var createdAt *time.Time
+			if !todo.CreatedAt.IsZero() {
+				createdAt = &todo.CreatedAt
+			}
+			var id string
+			err := stmt.QueryRowContext(ctx, todo.Name, todo.Description, todo.TaskStatus, todo.Priority, labelsArg(todo.Labels), todo.Recurrence, todo.DueAt, createdAt, parentId, user_id).Scan(&id)
+			if err != nil {
+				return fmt.Errorf("error inserting todo %d: %w", count+1, err)
+			}
+			count++
+			if err = insert(todo.Subtasks, &id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err = insert(todos, nil); err != nil {
+		return 0, err
+	}
+	if err = transaction.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
 
+// versionConflict tells apart the two reasons a guarded write touches no rows
+func versionConflict(ctx context.Context, db *sql.DB, id string, user_id string) error {
+	var exists bool
+	err := db.QueryRowContext(ctx, `select exists (select 1 from todo where id = $1 and user_id = $2 and deleted_at is null)`, id, user_id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrVersionMismatch
+	}
+	return ErrTodoNotFound
 }
 
-func SearchTodo(ctx context.Context, db *sql.DB, searchParam string, limit int, offset int, user_id string) ([]*models.GetTodoResponse, error) {
-	query := `select id, name, description, status, created_at from todo where user_id =$1 to_tsvector('simple', name || ' ' || description) @@ to_tsquery('simple', $2) limit $3 offset $4`
-	fmt.Println(query)
-	var todos []*models.GetTodoResponse
-	rows, err := db.QueryContext(ctx, query, user_id, searchParam, limit, offset)
+// DeleteTodo removes the todo and its subtasks if its version is one of versions; a nil versions deletes
+// unconditionally. It returns the ids of every todo it deleted. Rows are only marked deleted so that
+// syncing clients learn about the deletion.
+func DeleteTodo(ctx context.Context, db *sql.DB, id string, user_id string, versions []int) ([]string, error) {
+	query := `with recursive subtree as (
+			select id from todo where id = $1 and user_id = $2 and deleted_at is null and ($3::integer[] is null or version = any($3))
+			union all
+			select t.id from todo t join subtree s on t.parent_id = s.id where t.deleted_at is null
+		)
+		update todo set deleted_at = now(), version = version + 1 where id in (select id from subtree) returning id`
+	rows, err := db.QueryContext(ctx, query, id, user_id, versionsArg(versions))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+	deleted := []string{}
 	for rows.Next() {
-		var todo models.GetTodoResponse
-		err = rows.Scan(&todo.Id, &todo.Name, &todo.Description, &todo.TaskStatus, &todo.CreatedAt)
-		if err != nil {
+		var deletedId string
+		if err = rows.Scan(&deletedId); err != nil {
 			return nil, err
 		}
-		todos = append(todos, &todo)
+		deleted = append(deleted, deletedId)
 	}
-	return todos, nil
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(deleted) == 0 {
+		return nil, versionConflict(ctx, db, id, user_id)
+	}
+	return deleted, nil
+}
+
+func versionsArg(versions []int) any {
+	if versions == nil {
+		return nil
+	}
+	return pq.Array(versions)
+}
+
+// UpdateTodo writes doc over the todo if its version is one of versions and returns the updated todo.
+func UpdateTodo(ctx context.Context, db *sql.DB, doc *models.TodoDocument, id string, user_id string, versions []int) (*models.GetTodoResponse, error) {
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer transaction.Rollback()
+	if doc.ParentId != nil {
+		if err = checkParent(ctx, transaction, *doc.ParentId, id, user_id); err != nil {
+			return nil, err
+		}
+	}
+	query := `update todo set name = $1, description = $2, status = $3, priority = $4, labels = $5, recurrence = $6, due_at = $7, parent_id = $8, version = version + 1
+		where id = $9 and user_id = $10 and deleted_at is null and ($11::integer[] is null or version = any($11))
+		returning ` + todoColumns
+	row := transaction.QueryRowContext(ctx, query, doc.Name, doc.Description, doc.TaskStatus, doc.Priority, labelsArg(doc.Labels), doc.Recurrence, doc.DueAt, doc.ParentId,
+		id, user_id, versionsArg(versions))
+	todo, err := scanTodo(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, versionConflict(ctx, db, id, user_id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return todo, transaction.Commit()
+}
+
+var ErrInvalidParent = errors.New("parent todo does not exist or would create a cycle")
 
+// checkParent makes sure a todo can be moved under parentId: the parent must belong to the same user
+// and must not be the todo itself or one of its subtasks.
+func checkParent(ctx context.Context, transaction *sql.Tx, parentId string, id string, user_id string) error {
+	query := `with recursive ancestors as (
+			select id, parent_id from todo where id = $1 and user_id = $3 and deleted_at is null
+			union all
+			select t.id, t.parent_id from todo t join ancestors a on t.id = a.parent_id
+		)
+		select exists (select 1 from todo where id = $1 and user_id = $3 and deleted_at is null), exists (select 1 from ancestors where id = $2)`
+	var parentExists, cycle bool
+	if err := transaction.QueryRowContext(ctx, query, parentId, id, user_id).Scan(&parentExists, &cycle); err != nil {
+		return err
+	}
+	if !parentExists || cycle {
+		return ErrInvalidParent
+	}
+	return nil
 }
 
 func CreateUser(ctx context.Context, db *sql.DB, user *models.User) error {
@@ -113,10 +448,10 @@ func CreateUser(ctx context.Context, db *sql.DB, user *models.User) error {
 }
 
 func FetchUserWithUserID(ctx context.Context, db *sql.DB, userName string) (*models.User, error) {
-	query := `select id, username, email, hashpassword, created_at  from users where username = $1`
+	query := `select id, username, email, hashpassword, created_at, disabled_at from users where username = $1`
 	row := db.QueryRowContext(ctx, query, userName)
 	user := new(models.User)
-	err := row.Scan(&user.Id, &user.UserName, &user.Email, &user.HashedPassword, &user.CreatedAt)
+	err := row.Scan(&user.Id, &user.UserName, &user.Email, &user.HashedPassword, &user.CreatedAt, &user.DisabledAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.New("there is no user with this user-name")
@@ -126,6 +461,19 @@ func FetchUserWithUserID(ctx context.Context, db *sql.DB, userName string) (*mod
 	return user, nil
 }
 
+func FetchUserByID(ctx context.Context, db *sql.DB, id string) (*models.User, error) {
+	query := `select id, username, email, hashpassword, created_at, disabled_at from users where id = $1`
+	user := new(models.User)
+	err := db.QueryRowContext(ctx, query, id).Scan(&user.Id, &user.UserName, &user.Email, &user.HashedPassword, &user.CreatedAt, &user.DisabledAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("there is no user with this id")
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
 func SaveRefreshToken(ctx context.Context, db *sql.DB, saveRefresh *models.SaveRefresh) error {
 	query := `insert into refresh (user_id, token_hash, expires_at) values ($1, $2, $3)`
 	_, err := db.ExecContext(ctx, query, saveRefresh.UserId, saveRefresh.TokenHash, saveRefresh.ExpiresAt)
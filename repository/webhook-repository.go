@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"todos/models"
+
+	"github.com/lib/pq"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+const webhookColumns = "id, url, event_types, active, failure_count, disabled_at, created_at"
+
+func scanWebhook(row rowScanner, extra ...any) (*models.Webhook, error) {
+	webhook := new(models.Webhook)
+	dest := []any{&webhook.Id, &webhook.URL, pq.Array(&webhook.EventTypes), &webhook.Active, &webhook.FailureCount, &webhook.DisabledAt, &webhook.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func CreateWebhook(ctx context.Context, db *sql.DB, user_id string, url string, secret string, eventTypes []string) (*models.Webhook, error) {
+	query := `insert into webhooks (user_id, url, secret, event_types) values ($1, $2, $3, $4) returning ` + webhookColumns
+	webhook, err := scanWebhook(db.QueryRowContext(ctx, query, user_id, url, secret, labelsArg(eventTypes)))
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = secret
+	return webhook, nil
+}
+
+func ListWebhooks(ctx context.Context, db *sql.DB, user_id string) ([]*models.Webhook, error) {
+	rows, err := db.QueryContext(ctx, `select `+webhookColumns+` from webhooks where user_id = $1 order by created_at`, user_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := []*models.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func DeleteWebhook(ctx context.Context, db *sql.DB, id string, user_id string) error {
+	result, err := db.ExecContext(ctx, `delete from webhooks where id = $1 and user_id = $2`, id, user_id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// SetWebhookActive enables or disables a webhook; enabling also clears the failure count that may have disabled it.
+func SetWebhookActive(ctx context.Context, db *sql.DB, id string, user_id string, active bool) (*models.Webhook, error) {
+	query := `update webhooks set active = $3,
+			failure_count = case when $3 then 0 else failure_count end,
+			disabled_at = case when $3 then null else now() end
+		where id = $1 and user_id = $2
+		returning ` + webhookColumns
+	webhook, err := scanWebhook(db.QueryRowContext(ctx, query, id, user_id, active))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	return webhook, err
+}
+
+const deliveryColumns = "id, webhook_id, event_id, event_type, payload, status, attempts, response_status, response_body, error, next_attempt_at, created_at, delivered_at"
+
+func scanDelivery(row rowScanner, extra ...any) (*models.WebhookDelivery, error) {
+	delivery := new(models.WebhookDelivery)
+	dest := []any{&delivery.Id, &delivery.WebhookId, &delivery.EventId, &delivery.EventType, &delivery.Payload, &delivery.Status, &delivery.Attempts,
+		&delivery.ResponseStatus, &delivery.ResponseBody, &delivery.Error, &delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.DeliveredAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func ListWebhookDeliveries(ctx context.Context, db *sql.DB, webhook_id string, user_id string, limit int) ([]*models.WebhookDelivery, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `select exists (select 1 from webhooks where id = $1 and user_id = $2)`, webhook_id, user_id).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+	query := `select ` + deliveryColumns + ` from webhook_deliveries where webhook_id = $1 order by created_at desc, id desc limit $2`
+	rows, err := db.QueryContext(ctx, query, webhook_id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// EnqueueWebhookDeliveries queues the event for every active webhook of the user subscribed to its type,
+// or only for webhookId when it is set. A webhook without event types receives everything.
+func EnqueueWebhookDeliveries(ctx context.Context, db *sql.DB, user_id string, webhookId string, eventId string, eventType string, payload []byte) (int64, error) {
+	query := `insert into webhook_deliveries (webhook_id, event_id, event_type, payload)
+		select id, $3, $4, $5 from webhooks
+		where user_id = $1 and active and ($2 = '' or id::text = $2) and (cardinality(event_types) = 0 or $4 = any(event_types))`
+	result, err := db.ExecContext(ctx, query, user_id, webhookId, eventId, eventType, payload)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ClaimWebhookDeliveries leases up to limit due deliveries to the caller. Another dispatcher can only
+// take them over once the lease has run out, which covers a process dying mid-delivery.
+func ClaimWebhookDeliveries(ctx context.Context, db *sql.DB, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	query := `with due as (
+			select d.id from webhook_deliveries d
+			where d.status = 'pending' and d.next_attempt_at <= now() and (d.locked_until is null or d.locked_until < now())
+			order by d.next_attempt_at
+			limit $1
+			for update skip locked
+		)
+		update webhook_deliveries d set locked_until = now() + $2 * interval '1 second'
+		from due, webhooks w
+		where d.id = due.id and w.id = d.webhook_id
+		returning d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.response_status, d.response_body, d.error,
+			d.next_attempt_at, d.created_at, d.delivered_at, w.url, w.secret, w.active`
+	rows, err := db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		var url, secret string
+		var active bool
+		delivery, err := scanDelivery(rows, &url, &secret, &active)
+		if err != nil {
+			return nil, err
+		}
+		// a webhook disabled since the delivery was queued gets no URL, the dispatcher then drops it
+		if active {
+			delivery.URL, delivery.Secret = url, secret
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+type WebhookAttempt struct {
+	Success        bool
+	ResponseStatus *int
+	ResponseBody   string
+	Error          string
+	// when to try again; nil gives up and marks the delivery failed
+	RetryAt *time.Time
+}
+
+// RecordWebhookAttempt stores the outcome of one attempt and keeps the webhook's count of consecutive
+// failed attempts, disabling the webhook once it reaches disableAfter. It reports whether it did.
+func RecordWebhookAttempt(ctx context.Context, db *sql.DB, delivery *models.WebhookDelivery, attempt WebhookAttempt, disableAfter int) (bool, error) {
+	transaction, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer transaction.Rollback()
+	status := models.DeliveryPending
+	switch {
+	case attempt.Success:
+		status = models.DeliverySucceeded
+	case attempt.RetryAt == nil:
+		status = models.DeliveryFailed
+	}
+	var retryAt any
+	if attempt.RetryAt != nil {
+		retryAt = *attempt.RetryAt
+	}
+	query := `update webhook_deliveries set status = $2, attempts = attempts + 1, response_status = $3, response_body = $4, error = $5,
+			next_attempt_at = coalesce($6::timestamptz, next_attempt_at), locked_until = null,
+			delivered_at = case when $2 = 'succeeded' then now() else delivered_at end
+		where id = $1`
+	_, err = transaction.ExecContext(ctx, query, delivery.Id, status, attempt.ResponseStatus, attempt.ResponseBody, attempt.Error, retryAt)
+	if err != nil {
+		return false, err
+	}
+	var disabled bool
+	query = `update webhooks set
+			failure_count = case when $2 then 0 else failure_count + 1 end,
+			active = active and ($2 or failure_count + 1 < $3),
+			disabled_at = case when active and not $2 and failure_count + 1 >= $3 then now() else disabled_at end
+		where id = $1
+		returning not active and disabled_at is not null and failure_count >= $3`
+	err = transaction.QueryRowContext(ctx, query, delivery.WebhookId, attempt.Success, disableAfter).Scan(&disabled)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	if disabled {
+		// nothing else will be sent, so the queue is not left holding deliveries for a dead endpoint
+		_, err = transaction.ExecContext(ctx, `update webhook_deliveries set status = 'failed', error = 'webhook disabled', locked_until = null
+			where webhook_id = $1 and status = 'pending'`, delivery.WebhookId)
+		if err != nil {
+			return false, err
+		}
+	}
+	return disabled, transaction.Commit()
+}
@@ -0,0 +1,139 @@
+package router_test
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+	"todos/config"
+	"todos/events"
+	"todos/models"
+	"todos/repository/memory"
+	"todos/router"
+	"todos/todospb"
+	"todos/utilities"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcClient serves NewGRPCServer over an in-memory listener until the test ends and returns a
+// connection to it, along with the auth config and a user to issue tokens for
+func grpcClient(t *testing.T) (*grpc.ClientConn, *config.AuthConfig, *models.User) {
+	t.Helper()
+	stores := memory.Stores()
+	ctx := context.Background()
+	if err := stores.Users.CreateUser(ctx, &models.User{UserName: "alice", Email: "alice@example.com", HashedPassword: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := stores.Users.FetchUserWithUserID(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &config.AuthConfig{JWTSecret: "grpc-test-secret", AccessTTL: 15 * time.Minute, RefreshTTL: time.Hour}
+	hub := events.NewHub(events.DefaultLogSize, events.DefaultBufferSize)
+	server := router.NewGRPCServer(stores, auth, &config.GRPCConfig{Reflection: true}, hub)
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+		hub.Close()
+	})
+	return conn, auth, user
+}
+
+func withToken(t *testing.T, auth *config.AuthConfig, user *models.User) context.Context {
+	t.Helper()
+	token, err := utilities.GenerateJWT(user, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestGRPCAuth(t *testing.T) {
+	conn, auth, user := grpcClient(t)
+	client := todospb.NewTodoServiceClient(conn)
+	expired := *auth
+	expired.AccessTTL = -time.Minute
+	forged := *auth
+	forged.JWTSecret = "another-secret"
+	contexts := map[string]context.Context{
+		"no token":       context.Background(),
+		"not bearer":     metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic YWxpY2U6c2VjcmV0"),
+		"expired token":  withToken(t, &expired, user),
+		"another secret": withToken(t, &forged, user),
+	}
+	for name, ctx := range contexts {
+		_, err := client.GetTodo(ctx, &todospb.GetTodoRequest{Id: "00000000-0000-4000-8000-000000000000"})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("GetTodo with %s: %v, want Unauthenticated", name, err)
+		}
+	}
+}
+
+func TestGRPCTodoRoundTrip(t *testing.T) {
+	conn, auth, user := grpcClient(t)
+	client := todospb.NewTodoServiceClient(conn)
+	ctx := withToken(t, auth, user)
+	created, err := client.CreateTodo(ctx, &todospb.CreateTodoRequest{Todo: &todospb.Todo{
+		Name: "pay rent", Description: "before the 5th", Status: todospb.Status_STATUS_IN_PROGRESS, Labels: []string{"home"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched, err := client.GetTodo(ctx, &todospb.GetTodoRequest{Id: created.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Id != created.Id || fetched.Name != "pay rent" || fetched.Description != "before the 5th" ||
+		fetched.Status != todospb.Status_STATUS_IN_PROGRESS || !slices.Equal(fetched.Labels, []string{"home"}) || fetched.Version != 1 {
+		t.Fatalf("fetched %v, want the created todo", fetched)
+	}
+	if _, err = client.GetTodo(ctx, &todospb.GetTodoRequest{Id: "00000000-0000-4000-8000-000000000000"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("GetTodo of a missing todo: %v, want NotFound", err)
+	}
+	if _, err = client.CreateTodo(ctx, &todospb.CreateTodoRequest{Todo: &todospb.Todo{}}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("CreateTodo without a name: %v, want InvalidArgument", err)
+	}
+}
+
+func TestGRPCReflection(t *testing.T) {
+	conn, _, _ := grpcClient(t)
+	// reflection is public, no token is sent
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}}
+	if err = stream.Send(request); err != nil {
+		t.Fatal(err)
+	}
+	response, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var services []string
+	for _, service := range response.GetListServicesResponse().GetService() {
+		services = append(services, service.Name)
+	}
+	for _, want := range []string{"todos.v1.TodoService", "todos.v1.UserService"} {
+		if !slices.Contains(services, want) {
+			t.Errorf("reflection lists %v, want %s among them", services, want)
+		}
+	}
+	stream.CloseSend()
+}
@@ -1,41 +1,93 @@
 package router
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
 	"todos/config"
+	"todos/events"
 	"todos/handlers"
 	"todos/mail"
 	"todos/middleware"
+	"todos/openapi"
+	"todos/repository"
 
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 )
 
-func NewRouter(db *sql.DB, authConfig *config.AuthConfig, mailConfig *mail.Mail, frontEndConfig *config.FrontEndConfig) *mux.Router {
-	todoHandler := handlers.NewTodoHandler(db, authConfig, mailConfig, frontEndConfig)
+func NewRouter(stores *repository.Stores, authConfig *config.AuthConfig, mailConfig *mail.Mail, frontEndConfig *config.FrontEndConfig, idempotencyConfig *config.IdempotencyConfig, inboundConfig *config.InboundConfig, graphQLConfig *config.GraphQLConfig, hub *events.Hub) *mux.Router {
+	todoHandler := handlers.NewTodoHandler(stores, authConfig, mailConfig, frontEndConfig, inboundConfig, graphQLConfig, hub)
 	log.Println(todoHandler.MailConfig.From)
 	rl := new(middleware.RateLimiter)
 	r := mux.NewRouter()
 
-	authMiddleWare := middleware.AuthMiddleWare(todoHandler.TokenConfig.JWTSecret, todoHandler.DB)
+	authMiddleWare := middleware.AuthMiddleWare(todoHandler.TokenConfig.JWTSecret, stores.Users)
+	idempotent := middleware.Idempotency(stores.DB, idempotencyConfig.TTL)
+	postgresOnly := middleware.RequireDB(stores.DB)
 	todoSubrouter := r.PathPrefix("/todos").Subrouter()
 	userSubrouter := r.PathPrefix("/users").Subrouter()
 	r.Use(middleware.CorsMiddleWare(frontEndConfig.FrontEndDomain))
 	todoSubrouter.Use(rl.RateLimiterMiddleWare)
 	todoSubrouter.Use(authMiddleWare)
 	todoSubrouter.HandleFunc("/", todoHandler.ListAllTodos).Methods(http.MethodGet, http.MethodOptions)
+	todoSubrouter.HandleFunc("/export", todoHandler.ExportTodos).Methods(http.MethodGet, http.MethodOptions)
+	todoSubrouter.Handle("/import", idempotent(http.HandlerFunc(todoHandler.ImportTodos))).Methods(http.MethodPost, http.MethodOptions)
+	todoSubrouter.Handle("/quick", idempotent(http.HandlerFunc(todoHandler.QuickAddTask))).Methods(http.MethodPost, http.MethodOptions)
 	todoSubrouter.HandleFunc("/search", todoHandler.SearchTask).Methods(http.MethodGet, http.MethodOptions)
 	todoSubrouter.HandleFunc("/{id}", todoHandler.FetchTodoByID).Methods(http.MethodGet, http.MethodOptions)
-	todoSubrouter.HandleFunc("/", todoHandler.CreateTask).Methods(http.MethodPost, http.MethodOptions)
+	todoSubrouter.Handle("/", idempotent(http.HandlerFunc(todoHandler.CreateTask))).Methods(http.MethodPost, http.MethodOptions)
 	todoSubrouter.HandleFunc("/{id}", todoHandler.DeleteTask).Methods(http.MethodDelete, http.MethodOptions)
 	todoSubrouter.HandleFunc("/{id}", todoHandler.UpdateTask).Methods(http.MethodPatch, http.MethodOptions)
+	todoSubrouter.HandleFunc("/{id}", todoHandler.PutTask).Methods(http.MethodPut, http.MethodOptions)
+	todoSubrouter.Handle("/{id}/attachments", postgresOnly(http.HandlerFunc(todoHandler.ListAttachments))).Methods(http.MethodGet, http.MethodOptions)
+	todoSubrouter.Handle("/{id}/attachments/{attachmentId}", postgresOnly(http.HandlerFunc(todoHandler.DownloadAttachment))).Methods(http.MethodGet, http.MethodOptions)
 
-	userSubrouter.HandleFunc("/signup", todoHandler.CreateUser).Methods(http.MethodPost, http.MethodOptions)
+	userSubrouter.Handle("/signup", idempotent(http.HandlerFunc(todoHandler.CreateUser))).Methods(http.MethodPost, http.MethodOptions)
 	userSubrouter.HandleFunc("/login", todoHandler.Login).Methods(http.MethodPost, http.MethodOptions)
 	userSubrouter.HandleFunc("/refresh", todoHandler.Refresh).Methods(http.MethodPost, http.MethodOptions)
 	userSubrouter.HandleFunc("/forgot-password", todoHandler.ForgotPassword).Methods(http.MethodPost, http.MethodOptions)
 	userSubrouter.HandleFunc("/update-password", todoHandler.UpdatePassword).Methods(http.MethodPatch, http.MethodOptions)
+	userSubrouter.Handle("/settings", authMiddleWare(http.HandlerFunc(todoHandler.GetSettings))).Methods(http.MethodGet, http.MethodOptions)
+	userSubrouter.Handle("/settings", authMiddleWare(http.HandlerFunc(todoHandler.UpdateSettings))).Methods(http.MethodPatch, http.MethodOptions)
+	userSubrouter.Handle("/ingest-key", postgresOnly(authMiddleWare(http.HandlerFunc(todoHandler.RotateIngestKey)))).Methods(http.MethodPost, http.MethodOptions)
+	userSubrouter.Handle("/ingest-key", postgresOnly(authMiddleWare(http.HandlerFunc(todoHandler.RevokeIngestKey)))).Methods(http.MethodDelete, http.MethodOptions)
+	r.Handle("/ingest", postgresOnly(rl.RateLimiterMiddleWare(http.HandlerFunc(todoHandler.Ingest)))).Methods(http.MethodPost, http.MethodOptions)
+	r.Handle("/ingest/{token}", postgresOnly(rl.RateLimiterMiddleWare(http.HandlerFunc(todoHandler.Ingest)))).Methods(http.MethodPost, http.MethodOptions)
+	webhookSubrouter := r.PathPrefix("/webhooks").Subrouter()
+	webhookSubrouter.Use(postgresOnly)
+	webhookSubrouter.Use(rl.RateLimiterMiddleWare)
+	webhookSubrouter.Use(authMiddleWare)
+	webhookSubrouter.HandleFunc("/", todoHandler.ListWebhooks).Methods(http.MethodGet, http.MethodOptions)
+	webhookSubrouter.HandleFunc("/", todoHandler.CreateWebhook).Methods(http.MethodPost, http.MethodOptions)
+	webhookSubrouter.HandleFunc("/{id}", todoHandler.DeleteWebhook).Methods(http.MethodDelete, http.MethodOptions)
+	webhookSubrouter.HandleFunc("/{id}/enable", todoHandler.EnableWebhook).Methods(http.MethodPost, http.MethodOptions)
+	webhookSubrouter.HandleFunc("/{id}/disable", todoHandler.DisableWebhook).Methods(http.MethodPost, http.MethodOptions)
+	webhookSubrouter.HandleFunc("/{id}/ping", todoHandler.PingWebhook).Methods(http.MethodPost, http.MethodOptions)
+	webhookSubrouter.HandleFunc("/{id}/deliveries", todoHandler.ListWebhookDeliveries).Methods(http.MethodGet, http.MethodOptions)
+	r.Handle("/sync", postgresOnly(rl.RateLimiterMiddleWare(authMiddleWare(http.HandlerFunc(todoHandler.GetSync))))).Methods(http.MethodGet, http.MethodOptions)
+	r.Handle("/sync", postgresOnly(rl.RateLimiterMiddleWare(authMiddleWare(idempotent(http.HandlerFunc(todoHandler.PushSync)))))).Methods(http.MethodPost, http.MethodOptions)
+	// browsers cannot set headers on EventSource and WebSocket connections, so these also take a ticket from /events/ticket
+	ticketAuth := middleware.TicketAuth(todoHandler.Tickets, stores.Users, authMiddleWare)
+	r.Handle("/events/ticket", rl.RateLimiterMiddleWare(authMiddleWare(http.HandlerFunc(todoHandler.IssueTicket)))).Methods(http.MethodPost, http.MethodOptions)
+	r.Handle("/events", ticketAuth(http.HandlerFunc(todoHandler.StreamEvents))).Methods(http.MethodGet, http.MethodOptions)
+	r.Handle("/ws", ticketAuth(http.HandlerFunc(todoHandler.LiveUpdates))).Methods(http.MethodGet)
+	r.Handle("/graphql", rl.RateLimiterMiddleWare(ticketAuth(http.HandlerFunc(todoHandler.GraphQL)))).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/openapi.json", openapi.ServeSpec).Methods(http.MethodGet)
+	r.HandleFunc("/docs", openapi.ServeDocs).Methods(http.MethodGet)
 	return r
 
 }
+
+// NewGRPCServer serves the todo and user services over gRPC, authenticated with the same access
+// tokens as the http api and publishing to the same hub.
+func NewGRPCServer(stores *repository.Stores, authConfig *config.AuthConfig, grpcConfig *config.GRPCConfig, hub *events.Hub) *grpc.Server {
+	todoHandler := handlers.NewTodoHandler(stores, authConfig, nil, nil, nil, nil, hub)
+	unary, stream := middleware.GRPCAuth(authConfig.JWTSecret, stores.Users, "grpc.reflection.v1.ServerReflection", "grpc.reflection.v1alpha.ServerReflection")
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(unary), grpc.ChainStreamInterceptor(stream))
+	todoHandler.RegisterGRPC(server)
+	if grpcConfig.Reflection {
+		reflection.Register(server)
+	}
+	return server
+}
@@ -0,0 +1,18 @@
+package router_test
+
+import (
+	"testing"
+	"todos/openapi"
+	"todos/router/routertest"
+
+	"github.com/gorilla/mux"
+)
+
+// TestRoutesDocumented fails when a route is added without documenting it in openapi.json, or when
+// the document keeps an operation whose route is gone.
+func TestRoutesDocumented(t *testing.T) {
+	s := routertest.NewServer(t, nil, nil)
+	if err := openapi.CheckRoutes(s.Config.Handler.(*mux.Router)); err != nil {
+		t.Fatal(err)
+	}
+}
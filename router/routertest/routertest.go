@@ -0,0 +1,154 @@
+// Package routertest serves the whole http api from httptest for handler tests, on the memory store
+// unless a test passes another one, and has helpers for signing up and sending authenticated requests.
+package routertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"todos/config"
+	"todos/events"
+	"todos/mail"
+	"todos/models"
+	"todos/repository"
+	"todos/repository/memory"
+	"todos/router"
+	"todos/utilities"
+)
+
+// Password is the password SignUp gives every user.
+const Password = "correct horse battery"
+
+type Server struct {
+	*httptest.Server
+	Stores *repository.Stores
+	Auth   *config.AuthConfig
+	Events *events.Hub
+	// clients counts the addresses requests claim to come from, see Do
+	clients atomic.Int64
+}
+
+// NewServer starts the api on stores, or on a fresh memory store when stores is nil. Password reset
+// mail goes to mailConfig, which may point at a mailtest server; nil sends it nowhere. The server is
+// closed when t finishes.
+func NewServer(t testing.TB, stores *repository.Stores, mailConfig *mail.Mail) *Server {
+	t.Helper()
+	if stores == nil {
+		stores = memory.Stores()
+	}
+	if mailConfig == nil {
+		mailConfig = &mail.Mail{Host: "127.0.0.1", Port: "1"}
+	}
+	s := &Server{
+		Stores: stores,
+		Auth:   &config.AuthConfig{JWTSecret: "routertest-secret", AccessTTL: 15 * time.Minute, RefreshTTL: 24 * time.Hour},
+		Events: events.NewHub(events.DefaultLogSize, events.DefaultBufferSize),
+	}
+	handler := router.NewRouter(stores, s.Auth, mailConfig, &config.FrontEndConfig{FrontEndDomain: "http://localhost", ResetPath: "/reset"},
+		&config.IdempotencyConfig{TTL: time.Hour}, &config.InboundConfig{Domain: "ingest.localhost", MaxMessageSize: 1 << 20},
+		&config.GraphQLConfig{MaxDepth: 8, MaxComplexity: 2000}, s.Events)
+	s.Server = httptest.NewServer(handler)
+	t.Cleanup(func() {
+		s.Events.Close()
+		s.Server.Close()
+	})
+	return s
+}
+
+// Request builds a request to path on the server. A non-nil body is sent as JSON, or as is when it
+// is already a []byte or string; a non-empty token goes into the Authorization header.
+func (s *Server) Request(t testing.TB, method string, path string, token string, body any) *http.Request {
+	t.Helper()
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(body)
+	case string:
+		reader = bytes.NewReader([]byte(body))
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("routertest: encoding the request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	r, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		t.Fatalf("routertest: %v", err)
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+// Do sends r and returns the response with its body read. Every request claims a client address of
+// its own, so the per address rate limit never gets in the way; a test of the limit sets
+// X-Forwarded-For itself.
+func (s *Server) Do(t testing.TB, r *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	if r.Header.Get("X-Forwarded-For") == "" {
+		n := s.clients.Add(1)
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("10.%d.%d.%d", n>>16&0xff, n>>8&0xff, n&0xff))
+	}
+	response, err := s.Client().Do(r)
+	if err != nil {
+		t.Fatalf("routertest: %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("routertest: reading the response to %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	return response, body
+}
+
+// Call sends a request and decodes the JSON response into out, which may be nil. It fails the test
+// unless the response has status want.
+func (s *Server) Call(t testing.TB, method string, path string, token string, body any, want int, out any) *http.Response {
+	t.Helper()
+	response, responseBody := s.Do(t, s.Request(t, method, path, token, body))
+	if response.StatusCode != want {
+		t.Fatalf("routertest: %s %s answered %d, want %d: %s", method, path, response.StatusCode, want, responseBody)
+	}
+	if out != nil {
+		if err := json.Unmarshal(responseBody, out); err != nil {
+			t.Fatalf("routertest: decoding the response to %s %s: %v: %s", method, path, err, responseBody)
+		}
+	}
+	return response
+}
+
+// SignUp creates a user through the api, logs them in and returns them with their access token.
+func (s *Server) SignUp(t testing.TB, username string) (*models.User, string) {
+	t.Helper()
+	signup := &models.SignupRequest{UserName: username, Email: username + "@example.com", Password: Password}
+	s.Call(t, http.MethodPost, "/users/signup", "", signup, http.StatusCreated, nil)
+	var login map[string]string
+	s.Call(t, http.MethodPost, "/users/login", "", &models.LoginUser{UserName: username, Password: Password}, http.StatusOK, &login)
+	user, err := s.Stores.Users.FetchUserWithUserID(t.Context(), username)
+	if err != nil {
+		t.Fatalf("routertest: %v", err)
+	}
+	return user, login["token"]
+}
+
+// Token issues an access token for user the way login does, without going through the api.
+func (s *Server) Token(t testing.TB, user *models.User) string {
+	t.Helper()
+	token, err := utilities.GenerateJWT(user, s.Auth)
+	if err != nil {
+		t.Fatalf("routertest: %v", err)
+	}
+	return token
+}
@@ -2,23 +2,37 @@ package server
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 	"todos/config"
+	"todos/events"
+	"todos/inbound"
 	"todos/mail"
+	"todos/migrations"
+	"todos/repository"
+	"todos/repository/memory"
+	"todos/repository/sqlite"
 	"todos/router"
+	"todos/webhooks"
 )
 
 func StartServer() {
 	appConfig := config.LoadConfiguration()
 	appHostAndPort := fmt.Sprintf("%s:%d", appConfig.Host, appConfig.Port)
 
-	db, err := config.DBinit(&appConfig.DBconfig)
+	stores, err := OpenStores(appConfig)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot Start the application: %s", err.Error()))
+	}
+	defer stores.Close()
 	authConfig := &config.AuthConfig{
 		JWTSecret:  appConfig.AuthConfig.JWTSecret,
 		AccessTTL:  appConfig.AuthConfig.AccessTTL,
@@ -30,14 +44,53 @@ func StartServer() {
 		Host:     appConfig.Mail.Host,
 		Port:     appConfig.Mail.Port,
 	}
-	if err != nil {
-		panic("Cannot Start the application")
-	}
-	r := router.NewRouter(db, authConfig, mailConfig, &appConfig.FrontEndConfig)
+	hub := events.NewHub(events.DefaultLogSize, events.DefaultBufferSize)
+	r := router.NewRouter(stores, authConfig, mailConfig, &appConfig.FrontEndConfig, &appConfig.Idempotency, &appConfig.Inbound, &appConfig.GraphQL, hub)
 	serv := http.Server{
 		Addr:    appHostAndPort,
 		Handler: r,
 	}
+	// event streams never finish on their own, closing the hub ends them so shutdown does not wait
+	serv.RegisterOnShutdown(hub.Close)
+	// webhooks, email ingest and idempotency keys keep their state in postgres, other stores run without them
+	if stores.DB != nil {
+		dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+		dispatcher := webhooks.NewDispatcher(stores.DB, appConfig.Webhooks)
+		hub.Listen(dispatcher.Enqueue)
+		go dispatcher.Run(dispatcherCtx)
+		serv.RegisterOnShutdown(stopDispatcher)
+
+		purgeCtx, stopPurge := context.WithCancel(context.Background())
+		go purgeIdempotencyKeys(purgeCtx, stores.DB, appConfig.Idempotency.PurgeInterval)
+		serv.RegisterOnShutdown(stopPurge)
+	}
+	if appConfig.Inbound.SMTPAddr != "" && stores.DB != nil {
+		smtpServer := &inbound.Server{
+			Domain:   appConfig.Inbound.Domain,
+			MaxSize:  appConfig.Inbound.MaxMessageSize,
+			MaxConns: appConfig.Inbound.MaxConnections,
+			Backend:  &inbound.MailBackend{DB: stores.DB, Events: hub, Domain: appConfig.Inbound.Domain},
+		}
+		go func() {
+			if err := smtpServer.ListenAndServe(appConfig.Inbound.SMTPAddr); err != nil && !errors.Is(err, inbound.ErrServerClosed) {
+				log.Printf("inbound smtp server stopped: %s", err.Error())
+			}
+		}()
+		serv.RegisterOnShutdown(func() { smtpServer.Close() })
+	}
+	if appConfig.GRPC.Port != 0 {
+		grpcServer := router.NewGRPCServer(stores, authConfig, &appConfig.GRPC, hub)
+		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", appConfig.Host, appConfig.GRPC.Port))
+		if err != nil {
+			panic(fmt.Sprintf("cannot listen for grpc: %s", err.Error()))
+		}
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Printf("grpc server stopped: %s", err.Error())
+			}
+		}()
+		serv.RegisterOnShutdown(grpcServer.GracefulStop)
+	}
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
@@ -55,3 +108,65 @@ func StartServer() {
 	}
 
 }
+
+// purgeIdempotencyKeys deletes expired idempotency keys every interval until ctx is done
+func purgeIdempotencyKeys(ctx context.Context, db *sql.DB, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := repository.PurgeIdempotencyKeys(ctx, db)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("purging expired idempotency keys: %s", err.Error())
+		} else if purged > 0 {
+			log.Printf("purged %d expired idempotency keys", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// logPostgresOnly warns about what a store other than postgres runs without, since clients are only
+// told by a 501 or, for retries, not at all.
+func logPostgresOnly(driver string) {
+	log.Printf("the %s store has no sync, webhooks, email and http ingest or attachments, these answer 501", driver)
+	log.Printf("the %s store keeps no idempotency keys, retried requests carrying an Idempotency-Key run again", driver)
+}
+
+// OpenStores opens the store appConfig.Store selects. For postgres it also applies pending migrations
+// when auto-migrate is on.
+func OpenStores(appConfig *config.AppConfig) (*repository.Stores, error) {
+	switch appConfig.Store.Driver {
+	case "memory":
+		log.Printf("keeping data in memory, it is lost when the server stops")
+		logPostgresOnly("memory")
+		return memory.Stores(), nil
+	case "sqlite":
+		log.Printf("using the sqlite store in %s", appConfig.Store.Path)
+		logPostgresOnly("sqlite")
+		return sqlite.Stores(appConfig.Store.Path)
+	case "postgres", "":
+	default:
+		return nil, fmt.Errorf("unknown store driver %q, use postgres, sqlite or memory", appConfig.Store.Driver)
+	}
+	db, err := config.DBinit(&appConfig.DBconfig)
+	if err != nil {
+		return nil, err
+	}
+	if appConfig.DBconfig.AutoMigrate {
+		applied, err := migrations.Up(context.Background(), db, 0)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("cannot migrate the database: %w", err)
+		}
+		for _, migration := range applied {
+			log.Printf("applied migration %d_%s", migration.Version, migration.Name)
+		}
+	}
+	return repository.NewPostgresStores(db), nil
+}
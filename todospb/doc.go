@@ -0,0 +1,4 @@
+// Package todospb holds the gRPC API definition in todos.proto and the code generated from it.
+package todospb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative todos.proto
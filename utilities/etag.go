@@ -0,0 +1,72 @@
+package utilities
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VersionETag tags one version of one todo. The id is part of the tag so it never validates against
+// another todo, or against a todo recreated under the same id.
+func VersionETag(id string, version int) string {
+	return fmt.Sprintf(`"%s-%d"`, strings.ToLower(id), version)
+}
+
+// ContentETag is a weak validator for responses assembled from several rows, such as list pages
+func ContentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func splitETags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// NoneMatch reports whether an If-None-Match header matches etag, using the weak comparison RFC 9110 asks for
+func NoneMatch(header string, etag string) bool {
+	for _, tag := range splitETags(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// IfMatchVersions parses an If-Match header into the versions of todo id it accepts. A nil slice
+// means any version is fine, either because the header is absent or because it is "*"; a header
+// naming only other todos gives an empty slice, which no version matches.
+func IfMatchVersions(header string, id string) ([]int, error) {
+	var versions []int
+	for _, tag := range splitETags(header) {
+		if tag == "*" {
+			return nil, nil
+		}
+		if strings.HasPrefix(tag, "W/") {
+			return nil, fmt.Errorf("weak entity tag %s cannot be used in If-Match", tag)
+		}
+		opaque := strings.Trim(tag, `"`)
+		separator := strings.LastIndexByte(opaque, '-')
+		if separator < 0 {
+			return nil, fmt.Errorf("malformed entity tag %s in If-Match", tag)
+		}
+		version, err := strconv.Atoi(opaque[separator+1:])
+		if err != nil {
+			return nil, fmt.Errorf("malformed entity tag %s in If-Match", tag)
+		}
+		if versions == nil {
+			versions = []int{}
+		}
+		if strings.EqualFold(opaque[:separator], id) {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
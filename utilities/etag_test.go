@@ -0,0 +1,54 @@
+package utilities
+
+import (
+	"slices"
+	"testing"
+)
+
+const etagId = "0B8F4D0E-6A5C-4D1E-9F53-3C2A1B0E9D87"
+
+func TestVersionETag(t *testing.T) {
+	if tag := VersionETag(etagId, 3); tag != `"0b8f4d0e-6a5c-4d1e-9f53-3c2a1b0e9d87-3"` {
+		t.Errorf("VersionETag = %s", tag)
+	}
+}
+
+func TestIfMatchVersions(t *testing.T) {
+	other := "5d2c4b1a-0000-4000-8000-000000000000"
+	tests := []struct {
+		header   string
+		versions []int
+	}{
+		{"", nil},
+		{"*", nil},
+		{VersionETag(etagId, 3), []int{3}},
+		{VersionETag(etagId, 3) + ", " + VersionETag(etagId, 4), []int{3, 4}},
+		{VersionETag(other, 3), []int{}},
+		{VersionETag(other, 3) + "," + VersionETag(etagId, 5), []int{5}},
+	}
+	for _, test := range tests {
+		versions, err := IfMatchVersions(test.header, etagId)
+		if err != nil {
+			t.Errorf("IfMatchVersions(%q): %v", test.header, err)
+			continue
+		}
+		if (versions == nil) != (test.versions == nil) || !slices.Equal(versions, test.versions) {
+			t.Errorf("IfMatchVersions(%q) = %#v, want %#v", test.header, versions, test.versions)
+		}
+	}
+	for _, header := range []string{`W/"` + etagId + `-3"`, `"3"`, `"` + etagId + `-x"`} {
+		if _, err := IfMatchVersions(header, etagId); err == nil {
+			t.Errorf("IfMatchVersions(%q) accepted it", header)
+		}
+	}
+}
+
+func TestNoneMatch(t *testing.T) {
+	etag := ContentETag([]byte("[]"))
+	if !NoneMatch(etag, etag) || !NoneMatch("*", etag) || !NoneMatch(`"a", `+etag[2:], etag) {
+		t.Error("NoneMatch missed a matching tag")
+	}
+	if NoneMatch(ContentETag([]byte("{}")), etag) || NoneMatch("", etag) {
+		t.Error("NoneMatch matched a different tag")
+	}
+}
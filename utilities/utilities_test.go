@@ -0,0 +1,106 @@
+package utilities
+
+import (
+	"testing"
+	"time"
+	"todos/config"
+	"todos/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var tokenConfig = &config.AuthConfig{JWTSecret: "test-secret", AccessTTL: 15 * time.Minute, RefreshTTL: 24 * time.Hour}
+
+func TestGenerateJWT(t *testing.T) {
+	user := &models.User{Id: "0b8f4d0e-6a5c-4d1e-9f53-3c2a1b0e9d87", UserName: "alice"}
+	before := time.Now()
+	token, err := GenerateJWT(user, tokenConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim, err := GetClaimFromJWT(token, tokenConfig.JWTSecret)
+	if err != nil {
+		t.Fatalf("GetClaimFromJWT: %v", err)
+	}
+	if claim.UserName != user.UserName || claim.UserId != user.Id {
+		t.Errorf("claim is for %s (%s), want %s (%s)", claim.UserName, claim.UserId, user.UserName, user.Id)
+	}
+	if claim.Expires_At.Before(before.Add(tokenConfig.AccessTTL)) || claim.Expires_At.After(time.Now().Add(tokenConfig.AccessTTL)) {
+		t.Errorf("access token expires at %s, want %s from now", claim.Expires_At, tokenConfig.AccessTTL)
+	}
+}
+
+func TestGenerateRefresh(t *testing.T) {
+	user := &models.User{Id: "0b8f4d0e-6a5c-4d1e-9f53-3c2a1b0e9d87", UserName: "alice"}
+	token, err := GenerateRefresh(user, tokenConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim, err := GetClaimFromJWT(token, tokenConfig.JWTSecret)
+	if err != nil {
+		t.Fatalf("GetClaimFromJWT: %v", err)
+	}
+	if claim.Expires_At.Before(time.Now().Add(tokenConfig.RefreshTTL - time.Minute)) {
+		t.Errorf("refresh token expires at %s, want %s from now", claim.Expires_At, tokenConfig.RefreshTTL)
+	}
+}
+
+func TestGetClaimFromJWTRejects(t *testing.T) {
+	user := &models.User{Id: "0b8f4d0e-6a5c-4d1e-9f53-3c2a1b0e9d87", UserName: "alice"}
+	token, err := GenerateJWT(user, tokenConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetClaimFromJWT(token, "another-secret"); err == nil {
+		t.Error("a token signed with another secret was accepted")
+	}
+	if _, err := GetClaimFromJWT(token[:len(token)-2]+"xx", tokenConfig.JWTSecret); err == nil {
+		t.Error("a token with a tampered signature was accepted")
+	}
+	if _, err := GetClaimFromJWT("not a token", tokenConfig.JWTSecret); err == nil {
+		t.Error("a malformed token was accepted")
+	}
+	other, err := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{"username": "alice"}).SignedString([]byte(tokenConfig.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetClaimFromJWT(other, tokenConfig.JWTSecret); err == nil {
+		t.Error("a token signed with HS512 was accepted")
+	}
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"username": "alice"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetClaimFromJWT(unsigned, tokenConfig.JWTSecret); err == nil {
+		t.Error("an unsigned token was accepted")
+	}
+}
+
+// expiry is checked by the callers against Expires_At, so an expired token still parses and reports it
+func TestExpiredToken(t *testing.T) {
+	expired := &config.AuthConfig{JWTSecret: tokenConfig.JWTSecret, AccessTTL: -time.Minute}
+	token, err := GenerateJWT(&models.User{UserName: "alice"}, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim, err := GetClaimFromJWT(token, tokenConfig.JWTSecret)
+	if err != nil {
+		t.Fatalf("GetClaimFromJWT: %v", err)
+	}
+	if !time.Now().After(claim.Expires_At) {
+		t.Errorf("token expires at %s, want it in the past", claim.Expires_At)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := CompareHash("correct horse battery", hash); !ok || err != nil {
+		t.Errorf("CompareHash with the right password = %v, %v", ok, err)
+	}
+	if ok, err := CompareHash("wrong horse battery", hash); ok || err != nil {
+		t.Errorf("CompareHash with a wrong password = %v, %v", ok, err)
+	}
+}
@@ -10,3 +10,8 @@ func ValidateStruct(s StructToValidate) error {
 	valid := validator.New()
 	return valid.Struct(s)
 }
+
+func ValidateVar(field any, tag string) error {
+	valid := validator.New()
+	return valid.Var(field, tag)
+}
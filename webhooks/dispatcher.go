@@ -0,0 +1,222 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+	"todos/config"
+	"todos/events"
+	"todos/models"
+	"todos/repository"
+)
+
+const (
+	// Ping is sent by the test endpoint so users can check their receiver
+	Ping = "ping"
+
+	storeTimeout  = 5 * time.Second
+	claimBatch    = 20
+	senders       = 4
+	maxBackoff    = time.Hour
+	baseBackoff   = 30 * time.Second
+	maxStoredBody = 2048
+	maxReadBody   = 64 << 10
+	leaseSlack    = 30 * time.Second
+	userAgent     = "todos-webhooks/1"
+)
+
+var ErrPrivateTarget = errors.New("webhook target resolves to a private address")
+
+// Dispatcher turns hub events into rows in webhook_deliveries and sends due deliveries. Enqueue stores
+// the rows before the handler publishing the event returns, so once an event is published its
+// deliveries and their retries survive restarts.
+type Dispatcher struct {
+	db     *sql.DB
+	config config.WebhookConfig
+	client *http.Client
+}
+
+func NewDispatcher(db *sql.DB, webhookConfig config.WebhookConfig) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		config: webhookConfig,
+		client: NewClient(webhookConfig.Timeout, webhookConfig.AllowPrivateTargets),
+	}
+}
+
+// NewClient returns the client deliveries are sent with. It does not follow redirects and, unless
+// allowPrivate is set, refuses to connect to loopback, private and link-local addresses so webhooks
+// cannot be used to probe the network the service runs in.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+				return ErrPrivateTarget
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Enqueue stores a delivery for every webhook subscribed to the event. It runs on the publishing
+// handler's goroutine, detached from its request so a client hanging up does not lose the deliveries.
+func (d *Dispatcher) Enqueue(event events.Event) {
+	if !slices.Contains(events.WebhookTypes, event.Type) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := d.Send(ctx, event.UserId, "", event); err != nil && !errors.Is(err, repository.ErrWebhookNotFound) {
+		log.Printf("queueing webhook deliveries for %s: %s", event.Id, err.Error())
+	}
+}
+
+// Send queues an event for a single webhook, bypassing its event type filter.
+func (d *Dispatcher) Send(ctx context.Context, userId string, webhookId string, event events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	queued, err := repository.EnqueueWebhookDeliveries(ctx, d.db, userId, webhookId, event.Id, event.Type, payload)
+	if err == nil && queued == 0 {
+		err = repository.ErrWebhookNotFound
+	}
+	return err
+}
+
+// Run sends due deliveries until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for {
+		lease := d.config.Timeout + leaseSlack
+		deliveries, err := repository.ClaimWebhookDeliveries(ctx, d.db, claimBatch, lease)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("claiming webhook deliveries: %s", err.Error())
+			}
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+		var wg sync.WaitGroup
+		limit := make(chan struct{}, senders)
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			limit <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-limit }()
+				d.deliver(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+		if len(deliveries) < claimBatch {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	var attempt repository.WebhookAttempt
+	if delivery.URL == "" {
+		attempt.Error = "webhook disabled"
+	} else {
+		attempt = d.attempt(ctx, delivery)
+	}
+	if !attempt.Success && delivery.URL != "" && delivery.Attempts+1 < d.config.MaxAttempts {
+		retryAt := time.Now().Add(Backoff(delivery.Attempts + 1))
+		attempt.RetryAt = &retryAt
+	}
+	disabled, err := repository.RecordWebhookAttempt(context.WithoutCancel(ctx), d.db, delivery, attempt, d.config.DisableAfter)
+	if err != nil {
+		log.Printf("recording webhook delivery %d: %s", delivery.Id, err.Error())
+		return
+	}
+	if disabled {
+		log.Printf("webhook %s disabled after %d consecutive failures", delivery.WebhookId, d.config.DisableAfter)
+	}
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) repository.WebhookAttempt {
+	var attempt repository.WebhookAttempt
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", userAgent)
+	request.Header.Set(EventHeader, delivery.EventType)
+	request.Header.Set(DeliveryHeader, fmt.Sprint(delivery.Id))
+	request.Header.Set(SignatureHeader, Sign(delivery.Secret, time.Now(), delivery.Payload))
+	response, err := d.client.Do(request)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(response.Body, maxReadBody))
+	if len(body) > maxStoredBody {
+		body = body[:maxStoredBody]
+	}
+	status := response.StatusCode
+	attempt.ResponseStatus = &status
+	attempt.ResponseBody = string(bytes.ToValidUTF8(body, nil))
+	attempt.Success = status >= 200 && status < 300
+	if !attempt.Success {
+		attempt.Error = fmt.Sprintf("receiver answered %s", response.Status)
+	}
+	return attempt
+}
+
+// Backoff is the wait before the given retry: doubling from 30 seconds up to an hour, with jitter so
+// deliveries that failed together do not all retry at the same moment.
+func Backoff(retry int) time.Duration {
+	delay := maxBackoff
+	if retry < 8 {
+		delay = min(baseBackoff<<(retry-1), maxBackoff)
+	}
+	jitter := time.Duration(rand.Int64N(int64(delay) / 5))
+	return delay - delay/10 + jitter
+}
@@ -0,0 +1,176 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"todos/config"
+	"todos/events"
+	"todos/models"
+	"todos/repository"
+	"todos/repository/pgtest"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	pgtest.Stop()
+	os.Exit(code)
+}
+
+// receiver records what it is sent and answers every request with status
+type receiver struct {
+	*httptest.Server
+	requests atomic.Int32
+	header   atomic.Pointer[http.Header]
+	body     atomic.Pointer[[]byte]
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	r := new(receiver)
+	r.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		header := request.Header.Clone()
+		r.header.Store(&header)
+		r.body.Store(&body)
+		r.requests.Add(1)
+		rw.WriteHeader(status)
+		io.WriteString(rw, http.StatusText(status))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func testDispatcher(db *sql.DB, allowPrivate bool) *Dispatcher {
+	return NewDispatcher(db, config.WebhookConfig{MaxAttempts: 8, DisableAfter: 2, Timeout: 5 * time.Second, PollInterval: time.Second, AllowPrivateTargets: allowPrivate})
+}
+
+func TestAttemptIsSigned(t *testing.T) {
+	r := newReceiver(t, http.StatusNoContent)
+	delivery := &models.WebhookDelivery{Id: 7, EventType: events.TodoCreated, Payload: []byte(`{"type":"todo.created"}`), URL: r.URL, Secret: "whsec_test"}
+	attempt := testDispatcher(nil, true).attempt(context.Background(), delivery)
+	if !attempt.Success || attempt.ResponseStatus == nil || *attempt.ResponseStatus != http.StatusNoContent {
+		t.Fatalf("attempt = %+v", attempt)
+	}
+	header, body := *r.header.Load(), *r.body.Load()
+	if string(body) != string(delivery.Payload) {
+		t.Fatalf("receiver got %q, want the payload", body)
+	}
+	if err := Verify(delivery.Secret, header.Get(SignatureHeader), body, time.Minute, time.Now()); err != nil {
+		t.Fatalf("signature %q does not verify: %v", header.Get(SignatureHeader), err)
+	}
+	if err := Verify("whsec_other", header.Get(SignatureHeader), body, time.Minute, time.Now()); err == nil {
+		t.Fatal("the signature verifies with another secret")
+	}
+	if err := Verify(delivery.Secret, header.Get(SignatureHeader), []byte(`{"type":"todo.deleted"}`), time.Minute, time.Now()); err == nil {
+		t.Fatal("the signature verifies against another body")
+	}
+	if header.Get(EventHeader) != events.TodoCreated || header.Get(DeliveryHeader) != "7" {
+		t.Fatalf("event and delivery headers are %q and %q", header.Get(EventHeader), header.Get(DeliveryHeader))
+	}
+}
+
+func TestAttemptFailsOnServerError(t *testing.T) {
+	r := newReceiver(t, http.StatusServiceUnavailable)
+	delivery := &models.WebhookDelivery{Id: 1, EventType: Ping, Payload: []byte(`{}`), URL: r.URL, Secret: "whsec_test"}
+	attempt := testDispatcher(nil, true).attempt(context.Background(), delivery)
+	if attempt.Success || attempt.ResponseStatus == nil || *attempt.ResponseStatus != http.StatusServiceUnavailable ||
+		attempt.ResponseBody != "Service Unavailable" || attempt.Error == "" {
+		t.Fatalf("attempt = %+v", attempt)
+	}
+}
+
+func TestPrivateTargetRefused(t *testing.T) {
+	r := newReceiver(t, http.StatusOK)
+	delivery := &models.WebhookDelivery{Id: 1, EventType: Ping, Payload: []byte(`{}`), URL: r.URL, Secret: "whsec_test"}
+	attempt := testDispatcher(nil, false).attempt(context.Background(), delivery)
+	if attempt.Success || !strings.Contains(attempt.Error, ErrPrivateTarget.Error()) {
+		t.Fatalf("attempt on a loopback target = %+v, want it refused", attempt)
+	}
+	if r.requests.Load() != 0 {
+		t.Fatal("the loopback target was reached")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		retry int
+		base  time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{40, time.Hour},
+	}
+	for _, test := range tests {
+		for range 20 {
+			// within 10% under to 10% over the doubling delay
+			if got := Backoff(test.retry); got < test.base-test.base/10 || got >= test.base+test.base/10 {
+				t.Fatalf("Backoff(%d) = %v, want about %v", test.retry, got, test.base)
+			}
+		}
+	}
+}
+
+func TestDeliverDue(t *testing.T) {
+	db := pgtest.Open(t)
+	ctx := context.Background()
+	if err := repository.CreateUser(ctx, db, &models.User{UserName: "alice", Email: "alice@example.com", HashedPassword: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := repository.FetchUserWithUserID(ctx, db, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newReceiver(t, http.StatusBadGateway)
+	webhook, err := repository.CreateWebhook(ctx, db, user.Id, r.URL, "whsec_test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := testDispatcher(db, true)
+	send := func(id string) {
+		t.Helper()
+		if err := d.Send(ctx, user.Id, "", events.Event{Id: id, Type: events.TodoCreated, UserId: user.Id, At: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		d.deliverDue(ctx)
+	}
+
+	// a 5xx leaves the delivery pending, due again after the first backoff
+	send("event-1")
+	deliveries, err := repository.ListWebhookDeliveries(ctx, db, webhook.Id, user.Id, 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("listed %d deliveries: %v", len(deliveries), err)
+	}
+	first := deliveries[0]
+	if first.Status != models.DeliveryPending || first.Attempts != 1 || first.ResponseStatus == nil || *first.ResponseStatus != http.StatusBadGateway {
+		t.Fatalf("delivery after a 502 = %+v", first)
+	}
+	if wait := time.Until(first.NextAttemptAt); wait < 20*time.Second || wait > 34*time.Second {
+		t.Fatalf("the retry is due in %v, want about 30s", wait)
+	}
+
+	// the second consecutive failure reaches DisableAfter
+	send("event-2")
+	webhooks, err := repository.ListWebhooks(ctx, db, user.Id)
+	if err != nil || len(webhooks) != 1 {
+		t.Fatalf("listed %d webhooks: %v", len(webhooks), err)
+	}
+	if webhooks[0].Active || webhooks[0].DisabledAt == nil || webhooks[0].FailureCount != 2 {
+		t.Fatalf("webhook after two failures = %+v, want it disabled", webhooks[0])
+	}
+	if deliveries, _ = repository.ListWebhookDeliveries(ctx, db, webhook.Id, user.Id, 10); deliveries[len(deliveries)-1].Status != models.DeliveryFailed {
+		t.Fatalf("the pending retry of a disabled webhook is %s", deliveries[len(deliveries)-1].Status)
+	}
+	if r.requests.Load() != 2 {
+		t.Fatalf("the receiver got %d requests, want 2", r.requests.Load())
+	}
+}